@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -40,23 +41,103 @@ func main() {
 	}
 	companyService := usecases.NewCompanyService(companyRepository)
 
+	companySettingsRepository, err := persistence.NewCompanySettingsRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	companySettingsService := usecases.NewCompanySettingsService(storage, companySettingsRepository)
+
+	branchRepository, err := persistence.NewBranchRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	branchService := usecases.NewBranchService(branchRepository, cafService)
+
+	customerRepository, err := persistence.NewCustomerRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	customerService := usecases.NewCustomerService(customerRepository)
+
+	productRepository, err := persistence.NewProductRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	productService := usecases.NewProductService(productRepository)
+
 	stampService := usecases.NewStampService(cafService)
 
-	httpServer := httpserver.NewServer(
-		controllers.NewCAFController(cafService, companyService),
-		controllers.NewStampController(stampService, companyService),
-		controllers.NewCompanyController(companyService),
-	)
+	folioReservationRepository, err := persistence.NewFolioReservationRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	folioReservationService := usecases.NewFolioReservationService(cafService, folioReservationRepository)
 
-	ctx, cancelFn := context.WithCancel(context.Background())
+	idempotencyRepository, err := persistence.NewIdempotencyRepository(dsn)
+	if err != nil {
+		panic(err)
+	}
+	idempotencyService := usecases.NewIdempotencyService(stampService, idempotencyRepository).
+		WithPendingLease(getDurationEnvOrDefault("FMG_IDEMPOTENCY_LEASE", usecases.DefaultIdempotencyLease))
 
-	go httpServer.Run()
+	pdfLayouts := usecases.DefaultPDFLayoutPolicy()
+	if path := getEnvOrDefault("FMG_PDF_LAYOUT_CONFIG", ""); path != "" {
+		pdfLayouts, err = usecases.LoadPDFLayoutPolicy(path)
+		if err != nil {
+			slog.Error("Failed to load PDF layout config", "path", path, "error", err)
+			os.Exit(1)
+		}
+	}
+	templates := usecases.NewTemplateStore()
+	if dir := getEnvOrDefault("FMG_PDF_TEMPLATE_DIR", ""); dir != "" {
+		templates, err = usecases.LoadTemplateStore(dir)
+		if err != nil {
+			slog.Error("Failed to load PDF templates", "dir", dir, "error", err)
+			os.Exit(1)
+		}
+	}
+	receiptOptions := usecases.DefaultReceiptOptions()
+	receiptOptions.PaperWidth = getIntEnvOrDefault("FMG_RECEIPT_PAPER_WIDTH", receiptOptions.PaperWidth)
+	receiptOptions.RasterPDF417 = getBoolEnvOrDefault("FMG_RECEIPT_RASTER_PDF417", false)
+	if err := receiptOptions.Validate(); err != nil {
+		slog.Error("Invalid receipt options", "error", err)
+		os.Exit(1)
+	}
+	documentService := usecases.NewDocumentService(stampService, companyService, idempotencyService).
+		WithPDFLayouts(pdfLayouts).
+		WithTemplates(templates).
+		WithCompanySettings(companySettingsService).
+		WithBranches(branchService).
+		WithCustomers(customerService)
+	if getBoolEnvOrDefault("FMG_PROCESSOR_LEARN_CUSTOMERS", false) {
+		documentService.WithCustomerLearning()
+	}
+	if getBoolEnvOrDefault("FMG_PROCESSOR_OUTPUT_RECEIPT", false) {
+		documentService.WithReceipts(receiptOptions)
+	}
+	if getBoolEnvOrDefault("FMG_PROCESSOR_OUTPUT_SVG", false) {
+		documentService.WithPDF417SVG()
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
 
 	sourceDir := getEnvOrDefault("FMG_PROCESSOR_SOURCE_DIR", "./tmp/source")
 	inprogressDir := getEnvOrDefault("FMG_PROCESSOR_INPROGRESS_DIR", "./tmp/inprogress")
 	destinationDir := getEnvOrDefault("FMG_PROCESSOR_DESTINATION_DIR", "./tmp/destination")
 	errorDir := getEnvOrDefault("FMG_PROCESSOR_ERROR_DIR", "./tmp/errors")
 	intervalStr := getEnvOrDefault("FMG_PROCESSOR_INTERVAL", "5s")
+	retryDir := getEnvOrDefault("FMG_PROCESSOR_RETRY_DIR", "./tmp/retry")
+	journalDir := getEnvOrDefault("FMG_PROCESSOR_JOURNAL_DIR", "./tmp/journal")
+	routingConfig := getEnvOrDefault("FMG_PROCESSOR_ROUTING_CONFIG", "")
+
+	outputOptions := async.DefaultOutputOptions()
+	outputOptions.NameTemplate = getEnvOrDefault("FMG_PROCESSOR_OUTPUT_NAME", outputOptions.NameTemplate)
+	outputOptions.Zip = getBoolEnvOrDefault("FMG_PROCESSOR_OUTPUT_ZIP", false)
+	outputOptions.Manifest = getBoolEnvOrDefault("FMG_PROCESSOR_OUTPUT_MANIFEST", false)
+	if err := outputOptions.Validate(); err != nil {
+		slog.Error("Invalid output options", "error", err)
+		os.Exit(1)
+	}
 
 	var fileWorker *async.FileIntegrationWorker
 	if sourceDir == "" || inprogressDir == "" || destinationDir == "" || errorDir == "" {
@@ -71,12 +152,30 @@ func main() {
 		interval = 30 * time.Second
 	}
 
+	stableWindow := getDurationEnvOrDefault("FMG_PROCESSOR_STABLE_WINDOW", 2*time.Second)
+	watchEnabled := getBoolEnvOrDefault("FMG_PROCESSOR_WATCH", true)
+	concurrency := getIntEnvOrDefault("FMG_PROCESSOR_CONCURRENCY", 4)
+
+	retryPolicy := async.DefaultRetryPolicy()
+	retryPolicy.MaxAttempts = getIntEnvOrDefault("FMG_PROCESSOR_MAX_ATTEMPTS", retryPolicy.MaxAttempts)
+	retryPolicy.BaseBackoff = getDurationEnvOrDefault("FMG_PROCESSOR_RETRY_BACKOFF", retryPolicy.BaseBackoff)
+	retryPolicy.MaxBackoff = getDurationEnvOrDefault("FMG_PROCESSOR_RETRY_MAX_BACKOFF", retryPolicy.MaxBackoff)
+
 	// Ensure directories exist
-	if err := ensureDirectoriesExist(sourceDir, inprogressDir, destinationDir, errorDir); err != nil {
+	if err := ensureDirectoriesExist(sourceDir, inprogressDir, destinationDir, errorDir, retryDir, journalDir); err != nil {
 		slog.Error("Failed to create processor directories", "error", err)
 		os.Exit(1)
 	}
 
+	router := async.NewRouter()
+	if routingConfig != "" {
+		router, err = async.LoadRouter(routingConfig)
+		if err != nil {
+			slog.Error("Failed to load routing config", "path", routingConfig, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// Create file integration worker
 	fileWorker = async.NewFileIntegrationWorker(
 		interval,
@@ -86,16 +185,74 @@ func main() {
 		errorDir,
 		stampService,
 		companyService,
+		cafService,
+	).
+		WithStabilityWindow(stableWindow).
+		WithFileWatching(watchEnabled).
+		WithConcurrency(concurrency).
+		WithRetryPolicy(retryDir, retryPolicy).
+		WithFolioJournal(journalDir).
+		WithRouter(router).
+		WithDocumentService(documentService).
+		WithProducts(productService).
+		WithOutputOptions(outputOptions)
+
+	httpServer := httpserver.NewServer(
+		controllers.NewCAFController(cafService, companyService).
+			WithBranches(branchService),
+		controllers.NewStampController(stampService, idempotencyService, companyService).
+			WithReceipts(documentService, receiptOptions).
+			WithBranches(branchService).
+			WithCustomers(customerService).
+			WithProducts(productService),
+		controllers.NewCompanyController(companyService),
+		controllers.NewBranchController(branchService, companyService),
+		controllers.NewCustomerController(customerService, companyService),
+		controllers.NewProductController(productService, companyService),
+		controllers.NewCompanySettingsController(companySettingsService, companyService),
+		controllers.NewFolioReservationController(folioReservationService, companyService),
+		controllers.NewWorkerController(fileWorker),
 	)
 
+	go httpServer.Run()
+
 	var wg sync.WaitGroup
+	wg.Add(1)
 	go fileWorker.Run(ctx, wg.Done)
 
 	slog.Info("✅ File integration worker started successfully")
 
+	leaseSweepInterval := getDurationEnvOrDefault("FMG_FOLIO_LEASE_SWEEP_INTERVAL", time.Minute)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(leaseSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := folioReservationService.ExpireLeases(ctx, now); err != nil {
+					slog.Error("Failed to expire folio reservations", "error", err)
+				}
+			}
+		}
+	}()
+
 	signalChannel := make(chan os.Signal, 2)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go func() {
+		for range reloadChannel {
+			if _, err := fileWorker.ReloadRouting(); err != nil {
+				slog.Error("Failed to reload routing config, keeping the previous one", "error", err)
+			}
+		}
+	}()
+
 	slog.Info("🌟 FM Gateway started successfully!")
 
 	<-signalChannel
@@ -117,6 +274,60 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getDurationEnvOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default",
+			"key", key,
+			"provided", value,
+			"default", defaultValue,
+			"error", err)
+		return defaultValue
+	}
+	return duration
+}
+
+func getIntEnvOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default",
+			"key", key,
+			"provided", value,
+			"default", defaultValue,
+			"error", err)
+		return defaultValue
+	}
+	return number
+}
+
+func getBoolEnvOrDefault(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean, using default",
+			"key", key,
+			"provided", value,
+			"default", defaultValue,
+			"error", err)
+		return defaultValue
+	}
+	return flag
+}
+
 func ensureDirectoriesExist(dirs ...string) error {
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
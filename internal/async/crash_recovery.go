@@ -0,0 +1,241 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+// assignFolio returns the signed stamp of the invoice in inProgressFile. A
+// folio assigned to the same file content in an earlier attempt is reused;
+// otherwise a new one is requested and recorded in the folio journal before
+// and after the request.
+func (w *FileIntegrationWorker) assignFolio(ctx context.Context, inProgressFile string, invoice *domain.Invoice) ([]byte, error) {
+	name := relativeName(w.inprogressDirectory, inProgressFile)
+
+	data, err := os.ReadFile(inProgressFile)
+	if err != nil {
+		return nil, usecases.NewStageError(usecases.StageStamp, fmt.Errorf("reading file: %w", err))
+	}
+	hash := contentHash(data)
+
+	record, found, err := w.journal.Load(name)
+	if err != nil {
+		slog.Warn("failed to load folio assignment, requesting a new folio", "file", name, "error", err)
+	}
+	if found && record.State == folioStateAssigned {
+		if record.ContentHash == hash {
+			slog.Info("reusing folio assigned in a previous attempt", "file", name, "folio", record.Folio)
+			return []byte(record.StampXML), nil
+		}
+		slog.Warn("file changed after its folio was assigned, previous folio is left unused",
+			"file", name,
+			"folio", record.Folio)
+	}
+
+	record = folioAssignment{
+		File:          name,
+		ContentHash:   hash,
+		State:         folioStateStamping,
+		DocumentType:  invoice.DocumentType,
+		StampingSince: time.Now(),
+	}
+	if w.cafService != nil {
+		company, err := w.companyService.FindByCode(ctx, invoice.Issuer.Code)
+		if err != nil {
+			return nil, usecases.NewStageError(usecases.StageStamp, fmt.Errorf("failed to find company with code %s: %w", invoice.Issuer.Code, err))
+		}
+		counters, err := cafCounters(ctx, w.cafService, company.ID, invoice.DocumentType)
+		if err != nil {
+			return nil, usecases.NewStageError(usecases.StageStamp, err)
+		}
+		record.CompanyID = company.ID
+		record.CAFCounters = counters
+	}
+	if err := w.journal.Save(record); err != nil {
+		return nil, usecases.NewStageError(usecases.StageStamp, fmt.Errorf("recording folio request: %w", err))
+	}
+
+	stampXML, err := w.documentService.StampInvoice(ctx, invoice, idempotencyKey(invoice, hash))
+	if err != nil {
+		if err := w.journal.Delete(name); err != nil {
+			slog.Warn("failed to clean up folio assignment", "file", name, "error", err)
+		}
+		return nil, err
+	}
+
+	record.State = folioStateAssigned
+	record.StampXML = string(stampXML)
+	if record.Folio, err = stampFolio(stampXML); err != nil {
+		slog.Warn("failed to read folio from stamp", "file", name, "error", err)
+	}
+	if err := w.journal.Save(record); err != nil {
+		slog.Error("failed to record assigned folio", "file", name, "folio", record.Folio, "error", err)
+	}
+
+	return stampXML, nil
+}
+
+// recoverStranded reconciles files left behind by a run that stopped in the
+// middle of processing. It runs before every scan; at that point no document
+// is in flight, so anything in the in-progress directory is stranded.
+func (w *FileIntegrationWorker) recoverStranded(ctx context.Context) {
+	files, err := listTree(w.inprogressDirectory)
+	if err != nil {
+		slog.Warn("failed to read in-progress directory for recovery", "dir", w.inprogressDirectory, "error", err)
+		return
+	}
+
+	for _, file := range files {
+		w.recoverInProgressFile(ctx, file.path)
+	}
+
+	w.recoverPartialOutputs(ctx)
+}
+
+// recoverInProgressFile decides what to do with a stranded file based on its
+// folio assignment:
+//   - no record: no folio was requested, the file goes back to source.
+//   - assigned: the file goes back to source and the next attempt reuses the
+//     recorded stamp, so rendering finishes with the same folio.
+//   - stamping: the CAF counters are compared with the ones recorded before
+//     the request, and used folios recorded since it are looked up.
+//     Unchanged counters and no new used folio mean no folio was consumed and
+//     the file goes back to source; otherwise it is moved to the error
+//     directory for an operator to decide, rather than risk using a second
+//     folio.
+func (w *FileIntegrationWorker) recoverInProgressFile(ctx context.Context, inProgressFile string) {
+	name := relativeName(w.inprogressDirectory, inProgressFile)
+
+	record, found, err := w.journal.Load(name)
+	if err != nil {
+		slog.Error("failed to load folio assignment of stranded file", "file", name, "error", err)
+		return
+	}
+
+	switch {
+	case !found:
+		w.returnToSource(inProgressFile, "no folio requested")
+	case record.State == folioStateAssigned:
+		w.returnToSource(inProgressFile, fmt.Sprintf("folio %d already assigned, it will be reused", record.Folio))
+	default:
+		consumed, err := w.folioConsumedSince(ctx, record)
+		if err != nil {
+			slog.Warn("cannot verify CAF counters yet, leaving stranded file in progress", "file", name, "error", err)
+			return
+		}
+
+		if consumed {
+			w.moveToError(inProgressFile, fmt.Errorf(
+				"processing was interrupted while stamping and folios were consumed since %s (CAF counters before %v): one may have been consumed without its stamp being recorded",
+				record.StampingSince.Format(time.RFC3339), record.CAFCounters), retryState{})
+		} else {
+			w.returnToSource(inProgressFile, "interrupted before a folio was consumed")
+		}
+
+		if err := w.journal.Delete(name); err != nil {
+			slog.Warn("failed to clean up folio assignment", "file", name, "error", err)
+		}
+	}
+}
+
+// folioConsumedSince reports whether any CAF of the record's company and
+// document type moved, or any of its folios was recorded as used, since the
+// record was written. Without a recorded snapshot there is no way to tell,
+// so a folio is assumed to be consumed.
+func (w *FileIntegrationWorker) folioConsumedSince(ctx context.Context, record folioAssignment) (bool, error) {
+	if w.cafService == nil || record.CAFCounters == nil || record.StampingSince.IsZero() {
+		return true, nil
+	}
+
+	current, err := cafCounters(ctx, w.cafService, record.CompanyID, record.DocumentType)
+	if err != nil {
+		return false, err
+	}
+	if !sameCounters(record.CAFCounters, current) {
+		return true, nil
+	}
+
+	used, err := w.cafService.FoliosUsedSince(ctx, record.CompanyID, uint(record.DocumentType), record.StampingSince)
+	if err != nil {
+		return false, fmt.Errorf("finding folios used since the request: %w", err)
+	}
+	return used > 0, nil
+}
+
+func (w *FileIntegrationWorker) returnToSource(inProgressFile, reason string) {
+	sourceFile := filepath.Join(w.sourceDirectory, relativeName(w.inprogressDirectory, inProgressFile))
+	if err := w.moveFile(inProgressFile, sourceFile); err != nil {
+		slog.Error("failed to move stranded file back to source", "file", inProgressFile, "error", err)
+		return
+	}
+
+	slog.Warn("recovered stranded file", "file", sourceFile, "reason", reason)
+	w.notify()
+}
+
+// recoverPartialOutputs finishes documents whose original already reached
+// the destination directory but whose outputs were not all written, using
+// the recorded stamp.
+func (w *FileIntegrationWorker) recoverPartialOutputs(ctx context.Context) {
+	records, err := w.journal.List()
+	if err != nil {
+		slog.Warn("failed to list folio assignments", "dir", w.journal.directory, "error", err)
+		return
+	}
+
+	for _, record := range records {
+		if record.State != folioStateAssigned {
+			if _, err := os.Stat(filepath.Join(w.inprogressDirectory, record.File)); os.IsNotExist(err) {
+				slog.Warn("discarding folio request of a file no longer in progress",
+					"file", record.File,
+					"cafCounters", record.CAFCounters)
+				if err := w.journal.Delete(record.File); err != nil {
+					slog.Warn("failed to clean up folio assignment", "file", record.File, "error", err)
+				}
+			}
+			continue
+		}
+
+		original := filepath.Join(w.destinationDirectory, record.File)
+		if record.Destination != "" {
+			original = filepath.Join(record.Destination, filepath.Base(record.File))
+		}
+		data, err := os.ReadFile(original)
+		if err != nil || contentHash(data) != record.ContentHash {
+			// Still waiting in the source, retry or error directory.
+			continue
+		}
+
+		if err := w.completeOutputs(ctx, original, record); err != nil {
+			slog.Error("failed to complete outputs of interrupted document", "file", original, "folio", record.Folio, "error", err)
+			continue
+		}
+
+		if err := w.journal.Delete(record.File); err != nil {
+			slog.Warn("failed to clean up folio assignment", "file", record.File, "error", err)
+		}
+		slog.Warn("completed outputs of interrupted document", "file", original, "folio", record.Folio)
+	}
+}
+
+func (w *FileIntegrationWorker) completeOutputs(ctx context.Context, original string, record folioAssignment) error {
+	invoice, err := w.parseInvoiceFile(ctx, original)
+	if err != nil {
+		return err
+	}
+
+	processingResult, err := w.documentService.RenderInvoice(ctx, invoice, []byte(record.StampXML))
+	if err != nil {
+		return err
+	}
+
+	result := FileProcessingResult{OriginalFile: original}
+	return w.writeOutputs(original, processingResult, &result)
+}
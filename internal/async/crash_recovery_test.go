@@ -0,0 +1,242 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+type fakeCAFService struct {
+	usecases.CAFService
+	current int64
+	used    []time.Time
+}
+
+func (f *fakeCAFService) FindByCompanyID(ctx context.Context, companyID string) ([]domain.CAF, error) {
+	return []domain.CAF{{ID: "caf-1", CompanyID: companyID, DocumentType: 33, CurrentFolios: f.current}}, nil
+}
+
+func (f *fakeCAFService) FoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error) {
+	var count int64
+	for _, usedAt := range f.used {
+		if !usedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type fakeCompanyService struct {
+	usecases.CompanyService
+}
+
+func (f *fakeCompanyService) FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error) {
+	return &domain.Company{ID: "company-1", Code: code}, nil
+}
+
+func (f *fakeCompanyService) GetCommercialActivities(ctx context.Context, companyID string) ([]domain.CommercialActivity, error) {
+	return nil, nil
+}
+
+type fakeDocumentService struct {
+	usecases.DocumentService
+	cafs   *fakeCAFService
+	stamps int
+	keys   []usecases.IdempotencyKey
+}
+
+func (f *fakeDocumentService) StampInvoice(ctx context.Context, invoice *domain.Invoice, key usecases.IdempotencyKey) ([]byte, error) {
+	f.stamps++
+	f.keys = append(f.keys, key)
+	folio := f.cafs.current
+	f.cafs.current++
+	f.cafs.used = append(f.cafs.used, time.Now())
+	return []byte(fmt.Sprintf(`<TED version="1.0"><DD><F>%d</F></DD></TED>`, folio)), nil
+}
+
+func (f *fakeDocumentService) RenderInvoice(ctx context.Context, invoice *domain.Invoice, stampXML []byte) (usecases.ProcessingResult, error) {
+	return usecases.ProcessingResult{StampXML: stampXML, PDF417Data: []byte("png"), PDF: []byte("pdf"), PDFLayout: usecases.PDFLayoutThermal}, nil
+}
+
+func newRecoveryTestWorker(t *testing.T) (*FileIntegrationWorker, *fakeDocumentService) {
+	t.Helper()
+	worker := newTestWorker(t)
+	cafs := &fakeCAFService{current: 100}
+	documents := &fakeDocumentService{cafs: cafs}
+	worker.cafService = cafs
+	worker.companyService = &fakeCompanyService{}
+	worker.documentService = documents
+	return worker, documents
+}
+
+func writeInvoiceFile(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write invoice: %v", err)
+	}
+}
+
+func TestRecovery_AssignedFolioIsReused(t *testing.T) {
+	worker, documents := newRecoveryTestWorker(t)
+	inProgressFile := filepath.Join(worker.inprogressDirectory, "invoice.xml")
+	writeInvoiceFile(t, inProgressFile)
+
+	invoice, err := worker.parseInvoiceFile(context.Background(), inProgressFile)
+	if err != nil {
+		t.Fatalf("Failed to parse invoice: %v", err)
+	}
+	if _, err := worker.assignFolio(context.Background(), inProgressFile, invoice); err != nil {
+		t.Fatalf("assignFolio failed: %v", err)
+	}
+
+	// The process dies here; on restart the file is returned to source.
+	worker.recoverStranded(context.Background())
+	sourceFile := filepath.Join(worker.sourceDirectory, "invoice.xml")
+	if _, err := os.Stat(sourceFile); err != nil {
+		t.Fatalf("Expected stranded file back in source: %v", err)
+	}
+
+	result := worker.processDocument(context.Background(), sourceFile)
+	if result.Error != nil {
+		t.Fatalf("processDocument failed: %v", result.Error)
+	}
+	if documents.stamps != 1 {
+		t.Errorf("Expected the assigned folio to be reused, got %d stamps", documents.stamps)
+	}
+	stamp, err := os.ReadFile(filepath.Join(worker.destinationDirectory, "invoice_stamp.xml"))
+	if err != nil {
+		t.Fatalf("Expected stamp in destination: %v", err)
+	}
+	if folio, _ := stampFolio(stamp); folio != 100 {
+		t.Errorf("Expected folio 100, got %d", folio)
+	}
+	if _, found, _ := worker.journal.Load("invoice.xml"); found {
+		t.Error("Expected folio assignment to be removed after success")
+	}
+}
+
+func TestRecovery_InterruptedWhileStamping(t *testing.T) {
+	tests := []struct {
+		name          string
+		consumeFolio  bool
+		reuseFolio    bool
+		wantDirectory func(w *FileIntegrationWorker) string
+	}{
+		{name: "counters unchanged", wantDirectory: func(w *FileIntegrationWorker) string { return w.sourceDirectory }},
+		{name: "counters moved", consumeFolio: true, wantDirectory: func(w *FileIntegrationWorker) string { return w.errorDirectory }},
+		// A released or assigned folio is used without moving the counters.
+		{name: "released folio used", reuseFolio: true, wantDirectory: func(w *FileIntegrationWorker) string { return w.errorDirectory }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker, documents := newRecoveryTestWorker(t)
+			inProgressFile := filepath.Join(worker.inprogressDirectory, "invoice.xml")
+			writeInvoiceFile(t, inProgressFile)
+
+			record := folioAssignment{
+				File:          "invoice.xml",
+				State:         folioStateStamping,
+				CompanyID:     "company-1",
+				DocumentType:  33,
+				CAFCounters:   map[string]int64{"caf-1": documents.cafs.current},
+				StampingSince: time.Now(),
+			}
+			// A folio used before the request does not count.
+			documents.cafs.used = append(documents.cafs.used, record.StampingSince.Add(-time.Minute))
+			if err := worker.journal.Save(record); err != nil {
+				t.Fatalf("Failed to save record: %v", err)
+			}
+			if tt.consumeFolio {
+				documents.cafs.current++
+			}
+			if tt.reuseFolio {
+				documents.cafs.used = append(documents.cafs.used, time.Now())
+			}
+
+			worker.recoverStranded(context.Background())
+
+			if _, err := os.Stat(filepath.Join(tt.wantDirectory(worker), "invoice.xml")); err != nil {
+				t.Fatalf("Expected file in %s: %v", tt.wantDirectory(worker), err)
+			}
+			if _, found, _ := worker.journal.Load("invoice.xml"); found {
+				t.Error("Expected folio request to be removed")
+			}
+		})
+	}
+}
+
+func TestRecovery_WithoutRecordReturnsToSource(t *testing.T) {
+	worker, _ := newRecoveryTestWorker(t)
+	writeInvoiceFile(t, filepath.Join(worker.inprogressDirectory, "invoice.xml"))
+
+	worker.recoverStranded(context.Background())
+
+	if _, err := os.Stat(filepath.Join(worker.sourceDirectory, "invoice.xml")); err != nil {
+		t.Fatalf("Expected file back in source: %v", err)
+	}
+}
+
+func TestRecovery_CompletesPartialOutputs(t *testing.T) {
+	worker, documents := newRecoveryTestWorker(t)
+	original := filepath.Join(worker.destinationDirectory, "invoice.xml")
+	writeInvoiceFile(t, original)
+	data, err := os.ReadFile(original)
+	if err != nil {
+		t.Fatalf("Failed to read invoice: %v", err)
+	}
+
+	record := folioAssignment{
+		File:        "invoice.xml",
+		ContentHash: contentHash(data),
+		State:       folioStateAssigned,
+		Folio:       42,
+		StampXML:    `<TED version="1.0"><DD><F>42</F></DD></TED>`,
+	}
+	if err := worker.journal.Save(record); err != nil {
+		t.Fatalf("Failed to save record: %v", err)
+	}
+
+	worker.recoverStranded(context.Background())
+
+	for _, name := range []string{"invoice_stamp.xml", "invoice_pdf417.png", "invoice_thermal.pdf"} {
+		if _, err := os.Stat(filepath.Join(worker.destinationDirectory, name)); err != nil {
+			t.Errorf("Expected %s to be written: %v", name, err)
+		}
+	}
+	if documents.stamps != 0 {
+		t.Errorf("Expected no new folio, got %d stamps", documents.stamps)
+	}
+	if _, found, _ := worker.journal.Load("invoice.xml"); found {
+		t.Error("Expected folio assignment to be removed")
+	}
+}
+
+func TestIdempotencyKey_SameDocumentAcrossFiles(t *testing.T) {
+	worker, documents := newRecoveryTestWorker(t)
+
+	for _, name := range []string{"invoice.xml", "invoice-copy.xml"} {
+		sourceFile := filepath.Join(worker.sourceDirectory, name)
+		writeInvoiceFile(t, sourceFile)
+		if result := worker.processDocument(context.Background(), sourceFile); result.Error != nil {
+			t.Fatalf("processDocument failed: %v", result.Error)
+		}
+	}
+
+	if len(documents.keys) != 2 || documents.keys[0] != documents.keys[1] {
+		t.Fatalf("Expected both files to share an idempotency key, got %+v", documents.keys)
+	}
+	if want := "file:76212889-6:id:DOC_29_33_2404"; documents.keys[0].Key != want {
+		t.Errorf("Expected key %s, got %s", want, documents.keys[0].Key)
+	}
+}
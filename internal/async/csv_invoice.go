@@ -0,0 +1,290 @@
+package async
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// CSV columns. The first row must be a header; columns may appear in any
+// order and names are case insensitive. Document level columns repeat on
+// every line and must hold the same value on all of them. Files delimited by
+// ';' are expected to come from spreadsheets configured for es-CL and use
+// '.' as thousands separator and ',' as decimal separator. Lines may name
+// their item by item_code alone and leave unit_price empty, to take them from
+// the issuer's product catalogue.
+const (
+	csvDocumentType    = "document_type"
+	csvInternalID      = "internal_id"
+	csvIssuerRUT       = "issuer_rut"
+	csvIssuerName      = "issuer_name"
+	csvIssuerAddress   = "issuer_address"
+	csvBranchCode      = "branch_code"
+	csvIssueDate       = "issue_date"
+	csvReceiverRUT     = "receiver_rut"
+	csvReceiverName    = "receiver_name"
+	csvReceiverAddress = "receiver_address"
+	csvItemName        = "item_name"
+	csvItemCode        = "item_code"
+	csvItemCodeType    = "item_code_type"
+	csvUnit            = "unit"
+	csvQuantity        = "quantity"
+	csvUnitPrice       = "unit_price"
+	csvExempt          = "exempt"
+	// csvAdditionalTaxCodes holds the CodImpAdic of the line separated by
+	// spaces.
+	csvAdditionalTaxCodes = "additional_tax_codes"
+)
+
+var (
+	_csvRequiredColumns = []string{csvIssuerRUT, csvQuantity}
+	_csvDocumentColumns = []string{
+		csvDocumentType, csvInternalID, csvIssuerRUT, csvIssuerName, csvIssuerAddress, csvBranchCode, csvIssueDate,
+		csvReceiverRUT, csvReceiverName, csvReceiverAddress,
+	}
+	// _csvThousands matches an es-CL integer grouped by '.', as in "1.234.567".
+	_csvThousands = regexp.MustCompile(`^[-+]?\d{1,3}(\.\d{3})+$`)
+)
+
+type csvInvoiceDecoder struct{}
+
+func (csvInvoiceDecoder) Format() string { return "CSV" }
+
+func (csvInvoiceDecoder) Extensions() []string { return []string{".csv"} }
+
+func (csvInvoiceDecoder) Sniff(data []byte) bool {
+	content := leadingContent(data)
+	header, _, _ := bytes.Cut(content, []byte("\n"))
+	return len(content) > 0 && csvDelimiter(header) != 0
+}
+
+func (csvInvoiceDecoder) Decode(data []byte) (*domain.Invoice, error) {
+	// Spreadsheet exports are often Windows-1252 rather than UTF-8.
+	if !utf8.Valid(data) {
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode CSV charset: %w", err)
+		}
+		data = decoded
+	}
+	data = leadingContent(data)
+
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = csvDelimiter(header)
+	reader.TrimLeadingSpace = true
+	if reader.Comma == 0 {
+		return nil, fmt.Errorf("failed to detect CSV delimiter, expected ',' or ';'")
+	}
+
+	columns, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range _csvRequiredColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("missing required CSV column %q", column)
+		}
+	}
+	_, hasName := index[csvItemName]
+	_, hasCode := index[csvItemCode]
+	if !hasName && !hasCode {
+		return nil, fmt.Errorf("missing required CSV column %q or %q", csvItemName, csvItemCode)
+	}
+
+	var first []string
+	var details []domain.InvoiceDetail
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV line %d: %w", line, err)
+		}
+
+		field := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if first == nil {
+			first = record
+		} else {
+			for _, column := range _csvDocumentColumns {
+				if i, ok := index[column]; ok && strings.TrimSpace(first[i]) != field(column) {
+					return nil, fmt.Errorf("line %d: %s differs from the first line, one file must hold a single document", line, column)
+				}
+			}
+		}
+
+		quantity, err := parseCSVNumber(field(csvQuantity), reader.Comma)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", line, csvQuantity, err)
+		}
+		var unitPrice domain.Decimal
+		if value := field(csvUnitPrice); value != "" {
+			unitPrice, err = parseCSVNumber(value, reader.Comma)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line, csvUnitPrice, err)
+			}
+		}
+		var exempt bool
+		if value := field(csvExempt); value != "" {
+			exempt, err = parseCSVBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line, csvExempt, err)
+			}
+		}
+
+		lineTotal, err := quantity.Mul(unitPrice)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w: %w", line, domain.ErrInvalidInvoice, err)
+		}
+
+		codeType := field(csvItemCodeType)
+		if codeType == "" && field(csvItemCode) != "" {
+			codeType = domain.DefaultItemCodeType
+		}
+
+		details = append(details, domain.InvoiceDetail{
+			Quantity:           quantity,
+			Description:        field(csvItemName),
+			UnitPrice:          unitPrice,
+			LineTotal:          lineTotal,
+			CodeType:           codeType,
+			Code:               field(csvItemCode),
+			Unit:               field(csvUnit),
+			Exempt:             exempt,
+			AdditionalTaxCodes: strings.Fields(field(csvAdditionalTaxCodes)),
+		})
+	}
+	if first == nil {
+		return nil, fmt.Errorf("CSV has no item lines")
+	}
+
+	field := func(column string) string {
+		if i, ok := index[column]; ok {
+			return strings.TrimSpace(first[i])
+		}
+		return ""
+	}
+
+	var issuer domain.RUT
+	if value := field(csvIssuerRUT); value != "" {
+		rut, err := domain.ParseRUT(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", csvIssuerRUT, err)
+		}
+		issuer = rut
+	}
+
+	builder := domain.NewInvoiceBuilder().
+		WithIssuer(domain.Company{
+			Code:    issuer,
+			Name:    field(csvIssuerName),
+			Address: field(csvIssuerAddress),
+		})
+
+	if value := field(csvDocumentType); value != "" {
+		documentType, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", csvDocumentType, value)
+		}
+		builder.WithDocumentType(uint8(documentType))
+	}
+	if value := field(csvBranchCode); value != "" {
+		branchCode, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", csvBranchCode, value)
+		}
+		builder.WithBranchCode(branchCode)
+	}
+	builder.WithCreationDate(field(csvIssueDate))
+	if value := field(csvReceiverRUT); value != "" {
+		receiver, err := domain.ParseRUT(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", csvReceiverRUT, err)
+		}
+		builder.WithCustomer(domain.Customer{
+			Code: receiver,
+			Name: field(csvReceiverName),
+		})
+	}
+
+	invoice, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed building invoice: %w", err)
+	}
+	invoice.InternalID = field(csvInternalID)
+	if invoice.Receiver != nil {
+		invoice.Receiver.Address = field(csvReceiverAddress)
+	}
+	invoice.Details = details
+	if err := invoice.ComputeTotals(); err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// csvDelimiter returns the delimiter used by a header line, or 0 when the
+// line does not look like CSV.
+func csvDelimiter(header []byte) rune {
+	commas := bytes.Count(header, []byte(","))
+	semicolons := bytes.Count(header, []byte(";"))
+	switch {
+	case semicolons > commas:
+		return ';'
+	case commas > 0:
+		return ','
+	default:
+		return 0
+	}
+}
+
+// parseCSVNumber parses "1234.5", or "1.234,5" in ';' delimited files. There
+// '.' only separates groups of three digits, so "1.5" is rejected rather
+// than read as 15.
+func parseCSVNumber(value string, delimiter rune) (domain.Decimal, error) {
+	if delimiter == ';' {
+		integer, fraction, hasFraction := strings.Cut(value, ",")
+		if strings.Contains(integer, ".") {
+			if !_csvThousands.MatchString(integer) {
+				return 0, fmt.Errorf("%w: %q, '.' separates thousands and ',' decimals", domain.ErrInvalidNumber, value)
+			}
+			integer = strings.ReplaceAll(integer, ".", "")
+		}
+		value = integer
+		if hasFraction {
+			value += "." + fraction
+		}
+	}
+	return domain.ParseDecimal(value)
+}
+
+// parseCSVBool reads the yes/no columns the way spreadsheets write them.
+func parseCSVBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "true", "si", "sí", "s", "x":
+		return true, nil
+	case "0", "false", "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected 1, true, si, sí, s or x for yes, or 0, false, no or n for no, got %q", value)
+}
@@ -0,0 +1,174 @@
+package async
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/usecases"
+)
+
+// handleFailure records a failed attempt and either parks the file in the
+// retry directory, when the failure is transient and attempts remain, or
+// moves it to the error directory together with its error report.
+func (w *FileIntegrationWorker) handleFailure(inProgressFile string, processingError error, duration time.Duration) {
+	fileName := relativeName(w.inprogressDirectory, inProgressFile)
+
+	state, err := w.retries.Load(fileName)
+	if err != nil {
+		slog.Warn("failed to load retry state, starting a new history", "file", fileName, "error", err)
+	}
+
+	transient := usecases.IsTransient(processingError)
+	state.Attempts = append(state.Attempts, AttemptRecord{
+		At:         time.Now(),
+		Duration:   duration,
+		Transient:  transient,
+		Error:      processingError.Error(),
+		ErrorChain: errorChain(processingError),
+	})
+
+	if transient && len(state.Attempts) < w.retryPolicy.MaxAttempts {
+		err := w.scheduleRetry(inProgressFile, state)
+		if err == nil {
+			return
+		}
+		slog.Error("failed to schedule retry, moving file to error directory", "file", fileName, "error", err)
+	}
+
+	w.moveToError(inProgressFile, processingError, state)
+}
+
+func (w *FileIntegrationWorker) scheduleRetry(inProgressFile string, state retryState) error {
+	fileName := relativeName(w.inprogressDirectory, inProgressFile)
+	backoff := w.retryPolicy.Backoff(len(state.Attempts))
+	state.NextAttemptAt = time.Now().Add(backoff)
+
+	if err := w.retries.Save(fileName, state); err != nil {
+		return err
+	}
+
+	retryFile := filepath.Join(w.retries.directory, fileName)
+	if err := w.moveFile(inProgressFile, retryFile); err != nil {
+		return fmt.Errorf("moving file to retry directory: %w", err)
+	}
+
+	slog.Warn("transient failure, file scheduled for retry",
+		"file", fileName,
+		"attempt", len(state.Attempts),
+		"maxAttempts", w.retryPolicy.MaxAttempts,
+		"nextAttemptAt", state.NextAttemptAt)
+
+	time.AfterFunc(backoff, w.notify)
+	return nil
+}
+
+func (w *FileIntegrationWorker) moveToError(inProgressFile string, processingError error, state retryState) {
+	fileName := relativeName(w.inprogressDirectory, inProgressFile)
+	errorFile := filepath.Join(w.errorDirectory, fileName)
+
+	if err := w.moveFile(inProgressFile, errorFile); err != nil {
+		slog.Error("Failed to move file to error directory",
+			"file", inProgressFile,
+			"errorDir", w.errorDirectory,
+			"moveError", err,
+			"originalError", processingError)
+		os.Remove(inProgressFile)
+		return
+	}
+
+	report := DeadLetterReport{
+		File:       fileName,
+		FailedAt:   time.Now(),
+		Transient:  usecases.IsTransient(processingError),
+		Error:      processingError.Error(),
+		ErrorChain: errorChain(processingError),
+		Attempts:   state.Attempts,
+	}
+	if record, found, err := w.journal.Load(fileName); err == nil && found && record.State == folioStateAssigned {
+		report.AssignedFolio = record.Folio
+	}
+	if err := writeErrorReport(errorFile+_errorReportSuffix, report); err != nil {
+		slog.Error("failed to write error report", "file", errorFile, "error", err)
+	}
+
+	if err := w.retries.Delete(fileName); err != nil {
+		slog.Warn("failed to clean up retry state", "file", fileName, "error", err)
+	}
+
+	slog.Info("Moved failed file to error directory",
+		"from", inProgressFile,
+		"to", errorFile,
+		"attempts", len(state.Attempts),
+		"error", processingError)
+}
+
+func writeErrorReport(path string, report DeadLetterReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding error report: %w", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// ListFailed returns the files currently in the error directory. Files of
+// companies with their own tree are named "{rut}/{file}".
+func (w *FileIntegrationWorker) ListFailed() ([]FailedFile, error) {
+	files, err := listTree(w.errorDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("reading error directory: %w", err)
+	}
+
+	failed := []FailedFile{}
+	for _, entry := range files {
+		if strings.HasSuffix(entry.name, _errorReportSuffix) {
+			continue
+		}
+
+		file := FailedFile{Name: filepath.ToSlash(entry.name)}
+		data, err := os.ReadFile(entry.path + _errorReportSuffix)
+		if err == nil {
+			var report DeadLetterReport
+			if err := json.Unmarshal(data, &report); err == nil {
+				file.Report = &report
+			}
+		}
+		failed = append(failed, file)
+	}
+
+	return failed, nil
+}
+
+// Requeue moves a file from the error directory back to the source directory
+// with a clean attempt history, so it is processed again on the next scan.
+func (w *FileIntegrationWorker) Requeue(name string) error {
+	if err := validateRelativeName(name); err != nil {
+		return err
+	}
+	name = filepath.FromSlash(name)
+
+	errorFile := filepath.Join(w.errorDirectory, name)
+	if _, err := os.Stat(errorFile); err != nil {
+		return fmt.Errorf("finding failed file %s: %w", name, err)
+	}
+
+	if err := w.moveFile(errorFile, filepath.Join(w.sourceDirectory, name)); err != nil {
+		return fmt.Errorf("moving %s back to source directory: %w", name, err)
+	}
+
+	if err := os.Remove(errorFile + _errorReportSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove error report", "file", name, "error", err)
+	}
+	if err := w.retries.Delete(name); err != nil {
+		slog.Warn("failed to clean up retry state", "file", name, "error", err)
+	}
+
+	slog.Info("requeued failed file", "file", name)
+	w.notify()
+	return nil
+}
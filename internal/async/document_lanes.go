@@ -0,0 +1,120 @@
+package async
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// documentLane groups the files of a single issuer. Files in a lane are
+// processed sequentially so folios are consumed in input order, while
+// different lanes run in parallel.
+type documentLane struct {
+	key   string
+	files []string
+}
+
+// groupIntoLanes splits files into lanes by the key returned for each file,
+// preserving the relative order of files within a lane and the order in
+// which lanes first appear.
+func groupIntoLanes(files []string, keyOf func(string) string) []documentLane {
+	var lanes []documentLane
+	index := make(map[string]int)
+
+	for _, file := range files {
+		key := keyOf(file)
+		i, ok := index[key]
+		if !ok {
+			i = len(lanes)
+			index[key] = i
+			lanes = append(lanes, documentLane{key: key})
+		}
+		lanes[i].files = append(lanes[i].files, file)
+	}
+
+	return lanes
+}
+
+// processLanes runs every lane with at most w.concurrency documents in flight.
+// Once ctx is cancelled or the worker is paused no new file is claimed, but
+// documents already being processed are allowed to finish so no folio is
+// left half used.
+func (w *FileIntegrationWorker) processLanes(ctx context.Context, lanes []documentLane) []FileProcessingResult {
+	slots := make(chan struct{}, w.concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var results []FileProcessingResult
+
+	for _, lane := range lanes {
+		wg.Add(1)
+		go func(lane documentLane) {
+			defer wg.Done()
+
+			for _, file := range lane.files {
+				select {
+				case <-ctx.Done():
+					return
+				case slots <- struct{}{}:
+				}
+
+				if ctx.Err() != nil || w.paused.Load() {
+					<-slots
+					return
+				}
+
+				w.status.started(file)
+				startTime := time.Now()
+				result := w.processDocument(context.WithoutCancel(ctx), file)
+				result.ProcessingTime = time.Since(startTime)
+				w.status.finished(file, &result)
+				<-slots
+
+				if result.Error != nil {
+					slog.Error("Failed to process document",
+						"file", file,
+						"issuer", lane.key,
+						"error", result.Error)
+				} else {
+					slog.Info("Successfully processed document",
+						"file", file,
+						"issuer", lane.key,
+						"processingTime", result.ProcessingTime)
+				}
+
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}
+		}(lane)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// peekIssuer returns the canonical issuer RUT of a source file without
+// claiming it, so "76.212.889-6" and "76212889-6" share a lane. Files that
+// cannot be parsed share an empty key and fail later on, when they are
+// processed and moved to the error directory.
+func (w *FileIntegrationWorker) peekIssuer(file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	decoder, err := w.decoderFor(filepath.Base(file), data)
+	if err != nil {
+		return ""
+	}
+
+	invoice, err := decoder.Decode(data)
+	if err != nil {
+		return ""
+	}
+
+	return invoice.Issuer.Code.String()
+}
@@ -0,0 +1,121 @@
+package async
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+func TestGroupIntoLanes(t *testing.T) {
+	files := []string{"a1.xml", "b1.xml", "a2.xml", "c1.xml", "b2.xml", "a3.xml"}
+	keyOf := func(file string) string {
+		return strings.ToUpper(file[:1])
+	}
+
+	lanes := groupIntoLanes(files, keyOf)
+
+	expected := []documentLane{
+		{key: "A", files: []string{"a1.xml", "a2.xml", "a3.xml"}},
+		{key: "B", files: []string{"b1.xml", "b2.xml"}},
+		{key: "C", files: []string{"c1.xml"}},
+	}
+	if !reflect.DeepEqual(lanes, expected) {
+		t.Errorf("Expected lanes %+v, got %+v", expected, lanes)
+	}
+}
+
+// concurrencyDocumentService records how many documents are being stamped at
+// once, overall and per issuer, and the order each issuer's folios are used.
+type concurrencyDocumentService struct {
+	usecases.DocumentService
+
+	mu        sync.Mutex
+	inFlight  int
+	maxFlight int
+	issuers   map[string]int
+	overlaps  []string
+	folios    map[string][]int
+}
+
+func (f *concurrencyDocumentService) StampInvoice(ctx context.Context, invoice *domain.Invoice, key usecases.IdempotencyKey) ([]byte, error) {
+	issuer := invoice.Issuer.Code.String()
+
+	f.mu.Lock()
+	f.inFlight++
+	f.maxFlight = max(f.maxFlight, f.inFlight)
+	if f.issuers[issuer]++; f.issuers[issuer] > 1 {
+		f.overlaps = append(f.overlaps, issuer)
+	}
+	f.folios[issuer] = append(f.folios[issuer], invoice.Folio)
+	f.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.issuers[issuer]--
+	f.mu.Unlock()
+	return []byte(fmt.Sprintf(`<TED version="1.0"><DD><F>%d</F></DD></TED>`, invoice.Folio)), nil
+}
+
+func (f *concurrencyDocumentService) RenderInvoice(ctx context.Context, invoice *domain.Invoice, stampXML []byte) (usecases.ProcessingResult, error) {
+	return usecases.ProcessingResult{StampXML: stampXML, PDF417Data: []byte("png"), PDF: []byte("pdf"), PDFLayout: usecases.PDFLayoutThermal}, nil
+}
+
+func TestProcessLanes_IssuerFilesNeverOverlap(t *testing.T) {
+	worker := newTestWorker(t)
+	worker.concurrency = 2
+	documents := &concurrencyDocumentService{issuers: map[string]int{}, folios: map[string][]int{}}
+	worker.documentService = documents
+
+	example, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+	// The same issuer is spelled with and without thousands separators.
+	issuers := []string{"76.212.889-6", "11111111-1", "76212889-6", "22.222.222-2", "76212889-6", "11.111.111-1"}
+	var files []string
+	for i, issuer := range issuers {
+		data := bytes.Replace(example, []byte("<RUTEmisor>76212889-6</RUTEmisor>"), []byte("<RUTEmisor>"+issuer+"</RUTEmisor>"), 1)
+		data = bytes.Replace(data, []byte("<Folio>2404</Folio>"), []byte(fmt.Sprintf("<Folio>%d</Folio>", i+1)), 1)
+		file := filepath.Join(worker.sourceDirectory, fmt.Sprintf("invoice_%d.xml", i+1))
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatalf("Failed to write invoice: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	lanes := groupIntoLanes(files, worker.peekIssuer)
+	if len(lanes) != 3 || lanes[0].key != "76212889-6" || len(lanes[0].files) != 3 {
+		t.Fatalf("Expected one lane per issuer, got %+v", lanes)
+	}
+
+	results := worker.processLanes(context.Background(), lanes)
+	if len(results) != len(files) {
+		t.Fatalf("Expected %d results, got %d", len(files), len(results))
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			t.Errorf("Unexpected error for %s: %v", result.OriginalFile, result.Error)
+		}
+	}
+	if len(documents.overlaps) > 0 {
+		t.Errorf("Expected files of an issuer to run one at a time, overlapped for %v", documents.overlaps)
+	}
+	if documents.maxFlight > worker.concurrency {
+		t.Errorf("Expected at most %d documents at once, got %d", worker.concurrency, documents.maxFlight)
+	}
+	if got := documents.folios["76212889-6"]; !reflect.DeepEqual(got, []int{1, 3, 5}) {
+		t.Errorf("Expected the issuer's documents in input order, got %v", got)
+	}
+}
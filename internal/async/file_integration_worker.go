@@ -3,18 +3,24 @@ package async
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/usecases"
 )
 
+const (
+	_defaultStabilityWindow = 2 * time.Second
+	_defaultConcurrency     = 4
+)
+
 var _ Worker = &FileIntegrationWorker{}
 
 type FileIntegrationWorker struct {
@@ -24,15 +30,39 @@ type FileIntegrationWorker struct {
 	destinationDirectory string
 	errorDirectory       string
 	documentService      usecases.DocumentService
+	companyService       usecases.CompanyService
+	cafService           usecases.CAFService
+	products             usecases.ProductService
+	journal              *folioJournal
+	router               *Router
+	output               OutputOptions
+	decoders             []InvoiceDecoder
+	stability            *fileStabilityTracker
+	watchEnabled         bool
+	trigger              chan struct{}
+	concurrency          int
+	retries              *retryStore
+	retryPolicy          RetryPolicy
+	batchRunning         atomic.Bool
+	paused               atomic.Bool
+	status               *statusTracker
+	rescanRequested      atomic.Bool
 }
 
 type FileProcessingResult struct {
-	OriginalFile   string
-	StampFile      string
-	PDF417File     string
-	ThermalFile    string
-	ProcessingTime time.Duration
-	Error          error
+	OriginalFile   string        `json:"originalFile"`
+	StampFile      string        `json:"stampFile,omitempty"`
+	PDF417File     string        `json:"pdf417File,omitempty"`
+	PDF417SVGFile  string        `json:"pdf417SvgFile,omitempty"`
+	PDFFile        string        `json:"pdfFile,omitempty"`
+	ReceiptFile    string        `json:"receiptFile,omitempty"`
+	BundleFile     string        `json:"bundleFile,omitempty"`
+	ManifestFile   string        `json:"manifestFile,omitempty"`
+	ProcessingTime time.Duration `json:"processingTime"`
+	FinishedAt     time.Time     `json:"finishedAt"`
+	Stage          string        `json:"stage,omitempty"`
+	ErrorMessage   string        `json:"error,omitempty"`
+	Error          error         `json:"-"`
 }
 
 // NewFileIntegrationWorker creates a new FileIntegrationWorker instance
@@ -41,6 +71,7 @@ func NewFileIntegrationWorker(
 	sourceDirectory, inprogressDirectory, destinationDirectory, errorDirectory string,
 	stampService usecases.StampService,
 	companyService usecases.CompanyService,
+	cafService usecases.CAFService,
 ) *FileIntegrationWorker {
 	return &FileIntegrationWorker{
 		ticker:               time.NewTicker(tickerInterval),
@@ -48,8 +79,101 @@ func NewFileIntegrationWorker(
 		inprogressDirectory:  inprogressDirectory,
 		destinationDirectory: destinationDirectory,
 		errorDirectory:       errorDirectory,
-		documentService:      usecases.NewDocumentService(stampService, companyService),
+		documentService:      usecases.NewDocumentService(stampService, companyService, nil),
+		companyService:       companyService,
+		cafService:           cafService,
+		journal:              newFolioJournal(filepath.Join(filepath.Dir(filepath.Clean(inprogressDirectory)), "journal")),
+		decoders:             defaultInvoiceDecoders(),
+		router:               NewRouter(),
+		output:               DefaultOutputOptions(),
+		stability:            newFileStabilityTracker(_defaultStabilityWindow),
+		watchEnabled:         true,
+		trigger:              make(chan struct{}, 1),
+		concurrency:          _defaultConcurrency,
+		retries:              newRetryStore(filepath.Join(filepath.Dir(filepath.Clean(errorDirectory)), "retry")),
+		retryPolicy:          DefaultRetryPolicy(),
+		status:               newStatusTracker(),
+	}
+}
+
+// WithInvoiceDecoders registers additional input formats. They take
+// precedence over the built-in XML, JSON and CSV decoders.
+func (w *FileIntegrationWorker) WithInvoiceDecoders(decoders ...InvoiceDecoder) *FileIntegrationWorker {
+	w.decoders = append(decoders, w.decoders...)
+	return w
+}
+
+// WithDocumentService replaces the service that stamps and renders documents.
+// Use it to enable idempotent stamping, so a document dropped twice gets its
+// original stamp back instead of a second folio, or to choose PDF layouts.
+func (w *FileIntegrationWorker) WithDocumentService(documentService usecases.DocumentService) *FileIntegrationWorker {
+	w.documentService = documentService
+	return w
+}
+
+// WithProducts lets document lines name their item by catalogue code alone;
+// what they leave out is taken from the issuer's product catalogue before
+// the document is validated.
+func (w *FileIntegrationWorker) WithProducts(products usecases.ProductService) *FileIntegrationWorker {
+	w.products = products
+	return w
+}
+
+// WithOutputOptions sets how the artefacts of each document are named and
+// packaged. The options must be valid, see OutputOptions.Validate.
+func (w *FileIntegrationWorker) WithOutputOptions(options OutputOptions) *FileIntegrationWorker {
+	w.output = options
+	return w
+}
+
+// WithRouter sets the routing config that declares per-company directories
+// and the rules choosing the destination of each document.
+func (w *FileIntegrationWorker) WithRouter(router *Router) *FileIntegrationWorker {
+	w.router = router
+	return w
+}
+
+// WithFolioJournal sets the directory where the folio assigned to each file
+// is recorded while it is processed.
+func (w *FileIntegrationWorker) WithFolioJournal(journalDirectory string) *FileIntegrationWorker {
+	w.journal = newFolioJournal(journalDirectory)
+	return w
+}
+
+// WithRetryPolicy sets the directory where files wait for their next attempt
+// after a transient failure, and how many attempts are made before a file is
+// moved to the error directory.
+func (w *FileIntegrationWorker) WithRetryPolicy(retryDirectory string, policy RetryPolicy) *FileIntegrationWorker {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	w.retries = newRetryStore(retryDirectory)
+	w.retryPolicy = policy
+	return w
+}
+
+// WithConcurrency sets how many documents may be processed in parallel.
+// Documents of the same issuer are always processed one after the other.
+func (w *FileIntegrationWorker) WithConcurrency(concurrency int) *FileIntegrationWorker {
+	if concurrency < 1 {
+		concurrency = 1
 	}
+	w.concurrency = concurrency
+	return w
+}
+
+// WithStabilityWindow sets how long a source file must keep the same size and
+// modification time before it is claimed. A zero window claims files immediately.
+func (w *FileIntegrationWorker) WithStabilityWindow(window time.Duration) *FileIntegrationWorker {
+	w.stability = newFileStabilityTracker(window)
+	return w
+}
+
+// WithFileWatching enables or disables fsnotify based pickup. When disabled,
+// or when the watcher cannot be started, the worker relies on polling only.
+func (w *FileIntegrationWorker) WithFileWatching(enabled bool) *FileIntegrationWorker {
+	w.watchEnabled = enabled
+	return w
 }
 
 func (w *FileIntegrationWorker) Run(ctx context.Context, done func()) {
@@ -60,6 +184,8 @@ func (w *FileIntegrationWorker) Run(ctx context.Context, done func()) {
 		"errorDir", w.errorDirectory)
 	defer done()
 
+	w.startWatcher(ctx)
+
 	var wg sync.WaitGroup
 	for {
 		select {
@@ -68,19 +194,84 @@ func (w *FileIntegrationWorker) Run(ctx context.Context, done func()) {
 			wg.Wait()
 			return
 		case <-w.ticker.C:
-			wg.Add(1)
-			tickCtx := context.Background()
-			go w.handleFileIntegration(tickCtx, wg.Done)
+			w.startBatch(ctx, &wg)
+		case <-w.trigger:
+			w.startBatch(ctx, &wg)
+		}
+	}
+}
+
+// startBatch launches a processing batch unless one is already running, in
+// which case a new scan is requested as soon as the running batch finishes.
+func (w *FileIntegrationWorker) startBatch(ctx context.Context, wg *sync.WaitGroup) {
+	if w.paused.Load() {
+		slog.Debug("file integration worker paused, skipping scan")
+		return
+	}
+
+	if !w.batchRunning.CompareAndSwap(false, true) {
+		w.rescanRequested.Store(true)
+		slog.Debug("previous batch still running, deferring scan")
+		return
+	}
+
+	wg.Add(1)
+	go w.handleFileIntegration(ctx, func() {
+		w.batchRunning.Store(false)
+		if w.rescanRequested.Swap(false) && ctx.Err() == nil {
+			w.notify()
 		}
+		wg.Done()
+	})
+}
+
+func (w *FileIntegrationWorker) startWatcher(ctx context.Context) {
+	if !w.watchEnabled {
+		slog.Info("file watching disabled, using polling only", "sourceDir", w.sourceDirectory)
+		return
+	}
+
+	if err := w.ensureDirectoriesExist(); err != nil {
+		slog.Warn("failed to create source directory for watching, using polling only", "error", err)
+		return
+	}
+
+	// Company inboxes added by a later routing reload are picked up by polling.
+	watcher, err := newSourceWatcher(w.sourceDirectories()...)
+	if err != nil {
+		slog.Warn("file watching unavailable, using polling only", "sourceDir", w.sourceDirectory, "error", err)
+		return
+	}
+
+	go watcher.Run(ctx, w.notify)
+}
+
+// notify requests a new scan of the source directory without blocking. Scan
+// requests arriving while one is already queued are coalesced.
+func (w *FileIntegrationWorker) notify() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// scheduleStabilityRecheck requests another scan once the stability window
+// elapses, so files still being written are claimed without waiting for the
+// next polling tick.
+func (w *FileIntegrationWorker) scheduleStabilityRecheck() {
+	if !w.stability.Pending() {
+		return
 	}
+	time.AfterFunc(w.stability.Window(), w.notify)
 }
 
 func (w *FileIntegrationWorker) handleFileIntegration(ctx context.Context, done func()) {
 	defer done()
 
 	slog.Debug("starting file integration tick")
+	w.status.tickStarted(time.Now())
 
-	results, err := w.processAllDocuments()
+	results, err := w.processAllDocuments(ctx)
 	if err != nil {
 		slog.Error("failed to process documents",
 			"error", err,
@@ -114,7 +305,7 @@ func (w *FileIntegrationWorker) handleFileIntegration(ctx context.Context, done
 	}
 }
 
-func (w *FileIntegrationWorker) processAllDocuments() ([]FileProcessingResult, error) {
+func (w *FileIntegrationWorker) processAllDocuments(ctx context.Context) ([]FileProcessingResult, error) {
 	slog.Info("Starting document processing batch",
 		"sourceDir", w.sourceDirectory,
 		"inprogressDir", w.inprogressDirectory,
@@ -125,6 +316,8 @@ func (w *FileIntegrationWorker) processAllDocuments() ([]FileProcessingResult, e
 		return nil, fmt.Errorf("failed to ensure directories exist: %w", err)
 	}
 
+	w.recoverStranded(ctx)
+
 	files, err := w.getSourceFiles()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get source files: %w", err)
@@ -132,74 +325,99 @@ func (w *FileIntegrationWorker) processAllDocuments() ([]FileProcessingResult, e
 
 	if len(files) == 0 {
 		slog.Info("No files found in source directory")
+		w.status.queued(0)
 		return []FileProcessingResult{}, nil
 	}
 
 	slog.Info("Found files to process", "count", len(files))
+	w.status.queued(len(files))
 
-	var results []FileProcessingResult
-	for _, file := range files {
-		result := w.processDocument(file)
-		results = append(results, result)
-
-		if result.Error != nil {
-			slog.Error("Failed to process document",
-				"file", file,
-				"error", result.Error)
-		} else {
-			slog.Info("Successfully processed document",
-				"file", file,
-				"processingTime", result.ProcessingTime)
-		}
-	}
-
-	return results, nil
+	lanes := groupIntoLanes(files, w.peekIssuer)
+	return w.processLanes(ctx, lanes), nil
 }
 
-func (w *FileIntegrationWorker) processDocument(sourceFile string) FileProcessingResult {
+func (w *FileIntegrationWorker) processDocument(ctx context.Context, sourceFile string) FileProcessingResult {
 	startTime := time.Now()
 
 	result := FileProcessingResult{
 		OriginalFile: sourceFile,
 	}
 
-	inProgressFile, err := w.moveToInProgress(sourceFile)
+	name := w.treeName(sourceFile)
+	inProgressFile, err := w.moveToInProgress(sourceFile, name)
+	w.stability.Forget(sourceFile)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to move file to in-progress: %w", err)
+		result.Error = fmt.Errorf("failed to move file to in-progress: %w", usecases.NewStageError(usecases.StageClaim, err))
 		return result
 	}
+	w.removeDoneMarker(sourceFile)
 
 	defer func() {
 		if result.Error != nil {
-			w.moveToError(inProgressFile, result.Error)
+			w.handleFailure(inProgressFile, result.Error, time.Since(startTime))
+			return
+		}
+		if err := w.retries.Delete(name); err != nil {
+			slog.Warn("failed to clean up retry state", "file", inProgressFile, "error", err)
 		}
 	}()
 
-	invoice, err := w.parseXMLToInvoice(inProgressFile)
+	invoice, err := w.parseInvoiceFile(ctx, inProgressFile)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to parse XML to invoice: %w", err)
+		result.Error = fmt.Errorf("failed to parse invoice: %w", usecases.NewStageError(usecases.StageParse, err))
 		return result
 	}
 
-	processingResult, err := w.documentService.ProcessInvoice(invoice)
+	companyDir := companyDirOf(name)
+	if companyDir != "" && invoice.Issuer.Code.String() != companyDir {
+		result.Error = fmt.Errorf("failed to parse invoice: %w", usecases.NewStageError(usecases.StageParse,
+			fmt.Errorf("issuer %s does not match the company directory %s", invoice.Issuer.Code, companyDir)))
+		return result
+	}
+
+	stampXML, err := w.assignFolio(ctx, inProgressFile, invoice)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to process invoice: %w", err)
+		return result
+	}
+
+	processingResult, err := w.documentService.RenderInvoice(ctx, invoice, stampXML)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to process invoice: %w", err)
 		return result
 	}
 
-	err = w.saveFilesToDestination(inProgressFile, processingResult, &result)
+	destination := w.router.Destination(w.destinationDirectory, companyDir, invoice)
+	if err := w.journal.RecordDestination(name, destination); err != nil {
+		slog.Warn("failed to record destination of assigned folio", "file", name, "error", err)
+	}
+
+	err = w.saveFilesToDestination(inProgressFile, destination, processingResult, &result)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to save files to destination: %w", err)
+		result.Error = fmt.Errorf("failed to save files to destination: %w", usecases.NewStageError(usecases.StageSave, err))
 		return result
 	}
 
-	result.ProcessingTime = time.Since(startTime)
+	if err := w.journal.Delete(name); err != nil {
+		slog.Warn("failed to clean up folio assignment", "file", inProgressFile, "error", err)
+	}
+
 	return result
 }
 
-func (w *FileIntegrationWorker) moveToInProgress(sourceFile string) (string, error) {
-	fileName := filepath.Base(sourceFile)
-	inProgressFile := filepath.Join(w.inprogressDirectory, fileName)
+// treeName returns the name of a source or retry file relative to its
+// directory, which identifies it in every other worker directory.
+func (w *FileIntegrationWorker) treeName(file string) string {
+	for _, root := range []string{w.sourceDirectory, w.retries.directory} {
+		if rel, err := filepath.Rel(root, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(file)
+}
+
+func (w *FileIntegrationWorker) moveToInProgress(sourceFile, name string) (string, error) {
+	inProgressFile := filepath.Join(w.inprogressDirectory, name)
 
 	if err := w.moveFile(sourceFile, inProgressFile); err != nil {
 		return "", err
@@ -212,43 +430,19 @@ func (w *FileIntegrationWorker) moveToInProgress(sourceFile string) (string, err
 	return inProgressFile, nil
 }
 
-func (w *FileIntegrationWorker) moveToError(inProgressFile string, processingError error) {
-	fileName := filepath.Base(inProgressFile)
-	errorFile := filepath.Join(w.errorDirectory, fileName)
-
-	if err := w.moveFile(inProgressFile, errorFile); err != nil {
-		slog.Error("Failed to move file to error directory",
-			"file", inProgressFile,
-			"errorDir", w.errorDirectory,
-			"moveError", err,
-			"originalError", processingError)
-		os.Remove(inProgressFile)
-		return
-	}
-
-	slog.Info("Moved failed file to error directory",
-		"from", inProgressFile,
-		"to", errorFile,
-		"error", processingError)
-}
-
-func (w *FileIntegrationWorker) parseXMLToInvoice(filePath string) (*domain.Invoice, error) {
+func (w *FileIntegrationWorker) parseInvoiceFile(ctx context.Context, filePath string) (*domain.Invoice, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	dte, err := ParseDTEXML(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse DTE XML: %w", err)
-	}
-
-	invoice, err := dte.ToInvoice()
+	invoice, err := w.decodeInvoice(ctx, filepath.Base(filePath), data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert DTE to invoice: %w", err)
+		return nil, err
 	}
 
-	slog.Debug("Parsed XML to invoice",
+	slog.Debug("Parsed invoice",
+		"file", filePath,
 		"documentType", invoice.DocumentType,
 		"folio", invoice.Folio,
 		"issuer", invoice.Issuer.Name)
@@ -256,69 +450,38 @@ func (w *FileIntegrationWorker) parseXMLToInvoice(filePath string) (*domain.Invo
 	return invoice, nil
 }
 
-func (w *FileIntegrationWorker) saveFilesToDestination(inProgressFile string, processingResult usecases.ProcessingResult, result *FileProcessingResult) error {
-	baseName := strings.TrimSuffix(filepath.Base(inProgressFile), filepath.Ext(inProgressFile))
-
-	originalDest := filepath.Join(w.destinationDirectory, filepath.Base(inProgressFile))
+func (w *FileIntegrationWorker) saveFilesToDestination(inProgressFile, destination string, processingResult usecases.ProcessingResult, result *FileProcessingResult) error {
+	originalDest := filepath.Join(destination, filepath.Base(inProgressFile))
 	if err := w.moveFile(inProgressFile, originalDest); err != nil {
 		return fmt.Errorf("failed to move original file: %w", err)
 	}
 	result.OriginalFile = originalDest
 
-	stampFile := filepath.Join(w.destinationDirectory, baseName+"_stamp.xml")
-	if err := os.WriteFile(stampFile, processingResult.StampXML, 0644); err != nil {
-		return fmt.Errorf("failed to save stamp file: %w", err)
-	}
-	result.StampFile = stampFile
-
-	pdf417File := filepath.Join(w.destinationDirectory, baseName+"_pdf417.png")
-	if err := os.WriteFile(pdf417File, processingResult.PDF417Data, 0644); err != nil {
-		return fmt.Errorf("failed to save PDF417 file: %w", err)
-	}
-	result.PDF417File = pdf417File
-
-	thermalFile := filepath.Join(w.destinationDirectory, baseName+"_thermal.pdf")
-	if err := os.WriteFile(thermalFile, processingResult.ThermalPDF, 0644); err != nil {
-		return fmt.Errorf("failed to save thermal PDF file: %w", err)
-	}
-	result.ThermalFile = thermalFile
-
-	slog.Debug("Saved all files to destination",
-		"original", result.OriginalFile,
-		"stamp", result.StampFile,
-		"pdf417", result.PDF417File,
-		"thermal", result.ThermalFile)
-
-	return nil
+	return w.writeOutputs(originalDest, processingResult, result)
 }
 
 func (w *FileIntegrationWorker) moveFile(src, dst string) error {
-	if err := os.Rename(src, dst); err == nil {
-		return nil
-	}
-
-	srcFile, err := os.Open(src)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
 	}
-	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
+	if err := os.Rename(src, dst); err == nil {
+		return nil
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
+	// Across file systems the file is copied under a hidden name first, so
+	// the destination never holds a partial copy.
+	if err := copyFileAtomic(src, dst); err != nil {
 		return err
 	}
-
 	return os.Remove(src)
 }
 
 func (w *FileIntegrationWorker) ensureDirectoriesExist() error {
-	dirs := []string{w.sourceDirectory, w.inprogressDirectory, w.destinationDirectory, w.errorDirectory}
+	dirs := []string{w.sourceDirectory, w.inprogressDirectory, w.destinationDirectory, w.errorDirectory, w.retries.directory, w.journal.directory}
+	for _, company := range w.router.CompanyDirectories() {
+		dirs = append(dirs, filepath.Join(w.sourceDirectory, company), filepath.Join(w.destinationDirectory, company))
+	}
 
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -329,28 +492,90 @@ func (w *FileIntegrationWorker) ensureDirectoriesExist() error {
 	return nil
 }
 
-func (w *FileIntegrationWorker) getSourceFiles() ([]string, error) {
-	entries, err := os.ReadDir(w.sourceDirectory)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read source directory: %w", err)
+// sourceDirectories returns the shared inbox followed by the inbox of every
+// company declared in the routing config.
+func (w *FileIntegrationWorker) sourceDirectories() []string {
+	dirs := []string{w.sourceDirectory}
+	for _, company := range w.router.CompanyDirectories() {
+		dirs = append(dirs, filepath.Join(w.sourceDirectory, company))
 	}
+	return dirs
+}
 
-	var files []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+func (w *FileIntegrationWorker) getSourceFiles() ([]string, error) {
+	var candidates []sourceCandidate
+	present := make(map[string]struct{})
+	for _, dir := range w.sourceDirectories() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source directory: %w", err)
+		}
+
+		names := make(map[string]struct{}, len(entries))
+		for _, entry := range entries {
+			names[entry.Name()] = struct{}{}
 		}
 
-		fileName := entry.Name()
-		if strings.HasSuffix(strings.ToLower(fileName), ".xml") {
-			fullPath := filepath.Join(w.sourceDirectory, fileName)
-			files = append(files, fullPath)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			fileName := entry.Name()
+			if !w.acceptsFile(fileName) {
+				continue
+			}
+
+			fullPath := filepath.Join(dir, fileName)
+			present[fullPath] = struct{}{}
+
+			info, err := entry.Info()
+			if err != nil {
+				slog.Debug("skipping file that disappeared during scan", "file", fullPath, "error", err)
+				continue
+			}
+
+			if _, done := names[fileName+DoneMarkerSuffix]; !done && !w.stability.IsStable(fullPath, info) {
+				slog.Debug("waiting for file to become stable", "file", fullPath, "size", info.Size())
+				continue
+			}
+			candidates = append(candidates, sourceCandidate{path: fullPath, modTime: info.ModTime()})
 		}
 	}
 
+	due, err := w.retries.Due(time.Now())
+	if err != nil {
+		slog.Warn("failed to list files due for retry", "retryDir", w.retries.directory, "error", err)
+	}
+	candidates = append(candidates, due...)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+
+	files := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		files[i] = candidate.path
+	}
+
+	w.stability.Retain(present)
+	w.scheduleStabilityRecheck()
+
 	return files, nil
 }
 
+type sourceCandidate struct {
+	path    string
+	modTime time.Time
+}
+
+func (w *FileIntegrationWorker) removeDoneMarker(sourceFile string) {
+	marker := sourceFile + DoneMarkerSuffix
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove done marker", "marker", marker, "error", err)
+	}
+}
+
 func (w *FileIntegrationWorker) Shutdown() {
 	slog.Info("shutting down file integration worker")
 	w.ticker.Stop()
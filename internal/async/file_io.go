@@ -0,0 +1,79 @@
+package async
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The worker's outputs, folio journal, error reports and moved files are all
+// written under a hidden temporary name, flushed to disk and renamed into
+// place, so a reader or a restart after a crash never sees a partial file.
+
+// atomicTempName is the hidden name path is written under before the rename.
+func atomicTempName(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
+
+// writeFileAtomic writes data to a hidden temporary file in the same
+// directory and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := atomicTempName(path)
+	if err := writeFileSync(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyFileAtomic copies src to dst the way writeFileAtomic writes it.
+func copyFileAtomic(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	tmp := atomicTempName(dst)
+	if err := syncFile(tmp, func(file *os.File) error {
+		_, err := io.Copy(file, srcFile)
+		return err
+	}); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeFileSync writes data to path and flushes it to disk before returning.
+func writeFileSync(path string, data []byte) error {
+	return syncFile(path, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
+
+// syncFile creates path, fills it with write and flushes it to disk.
+func syncFile(path string, write func(*os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
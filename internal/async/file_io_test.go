@@ -0,0 +1,38 @@
+package async
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAndCopyFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stamp.xml")
+	if err := writeFileAtomic(path, []byte("<TED/>")); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	copied := filepath.Join(dir, "copy.xml")
+	if err := copyFileAtomic(path, copied); err != nil {
+		t.Fatalf("copyFileAtomic failed: %v", err)
+	}
+	if data, err := os.ReadFile(copied); err != nil || string(data) != "<TED/>" {
+		t.Errorf("Expected the copy to hold the original, got %q (%v)", data, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected no temporary files left behind, got %v", entries)
+	}
+
+	// A failed copy leaves neither the destination nor its temporary file.
+	if err := copyFileAtomic(filepath.Join(dir, "missing.xml"), filepath.Join(dir, "other.xml")); err == nil {
+		t.Error("Expected copying a missing file to fail")
+	}
+	if _, err := os.Stat(atomicTempName(filepath.Join(dir, "other.xml"))); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package async
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// DoneMarkerSuffix is appended to a source file name to signal that the file
+// has been completely written and can be claimed immediately.
+const DoneMarkerSuffix = ".done"
+
+// fileStabilityTracker decides when a file dropped in the source directory is
+// safe to claim, either because its size and modification time have not
+// changed for the whole stability window or because a done marker exists.
+type fileStabilityTracker struct {
+	window       time.Duration
+	now          func() time.Time
+	mu           sync.Mutex
+	observations map[string]fileObservation
+}
+
+type fileObservation struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+func newFileStabilityTracker(window time.Duration) *fileStabilityTracker {
+	return &fileStabilityTracker{
+		window:       window,
+		now:          time.Now,
+		observations: make(map[string]fileObservation),
+	}
+}
+
+// IsStable records the current size and modification time of path and
+// reports whether both have remained unchanged for the stability window.
+func (t *fileStabilityTracker) IsStable(path string, info os.FileInfo) bool {
+	if t.window <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	previous, seen := t.observations[path]
+	if !seen || previous.size != info.Size() || !previous.modTime.Equal(info.ModTime()) {
+		t.observations[path] = fileObservation{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			since:   now,
+		}
+		return false
+	}
+
+	return now.Sub(previous.since) >= t.window
+}
+
+// Forget drops any observation recorded for path.
+func (t *fileStabilityTracker) Forget(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.observations, path)
+}
+
+// Retain drops the observations of every path not present in the given set,
+// so files removed from the source directory do not leak memory.
+func (t *fileStabilityTracker) Retain(present map[string]struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for path := range t.observations {
+		if _, ok := present[path]; !ok {
+			delete(t.observations, path)
+		}
+	}
+}
+
+// Pending reports whether there are files still waiting for the stability
+// window to elapse.
+func (t *fileStabilityTracker) Pending() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.observations) > 0
+}
+
+// Window returns the configured stability window.
+func (t *fileStabilityTracker) Window() time.Duration {
+	return t.window
+}
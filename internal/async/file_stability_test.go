@@ -0,0 +1,113 @@
+package async
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeFileInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) ModTime() time.Time { return f.modTime }
+
+func TestFileStabilityTracker_IsStable(t *testing.T) {
+	base := time.Date(2025, 5, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		observations []fakeFileInfo
+		elapsed      []time.Duration
+		want         bool
+	}{
+		{
+			name:         "first observation is never stable",
+			observations: []fakeFileInfo{{size: 10, modTime: base}},
+			elapsed:      []time.Duration{0},
+			want:         false,
+		},
+		{
+			name:         "unchanged for the whole window",
+			observations: []fakeFileInfo{{size: 10, modTime: base}, {size: 10, modTime: base}},
+			elapsed:      []time.Duration{0, 2 * time.Second},
+			want:         true,
+		},
+		{
+			name:         "unchanged but window not elapsed",
+			observations: []fakeFileInfo{{size: 10, modTime: base}, {size: 10, modTime: base}},
+			elapsed:      []time.Duration{0, time.Second},
+			want:         false,
+		},
+		{
+			name:         "size changed restarts the window",
+			observations: []fakeFileInfo{{size: 10, modTime: base}, {size: 20, modTime: base}},
+			elapsed:      []time.Duration{0, 3 * time.Second},
+			want:         false,
+		},
+		{
+			name: "modification time changed restarts the window",
+			observations: []fakeFileInfo{
+				{size: 10, modTime: base},
+				{size: 10, modTime: base.Add(time.Second)},
+				{size: 10, modTime: base.Add(time.Second)},
+			},
+			elapsed: []time.Duration{0, 3 * time.Second, 4 * time.Second},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newFileStabilityTracker(2 * time.Second)
+			var got bool
+			for i, info := range tt.observations {
+				now := base.Add(tt.elapsed[i])
+				tracker.now = func() time.Time { return now }
+				got = tracker.IsStable("invoice.xml", info)
+			}
+			if got != tt.want {
+				t.Errorf("IsStable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStabilityTracker_ZeroWindow(t *testing.T) {
+	tracker := newFileStabilityTracker(0)
+	if !tracker.IsStable("invoice.xml", fakeFileInfo{size: 1}) {
+		t.Error("Expected zero window to claim files immediately")
+	}
+}
+
+func TestGetSourceFiles_StabilityAndDoneMarker(t *testing.T) {
+	worker := newTestWorker(t)
+	worker.stability = newFileStabilityTracker(time.Hour)
+	sourceDir := worker.sourceDirectory
+
+	writing := filepath.Join(sourceDir, "writing.xml")
+	marked := filepath.Join(sourceDir, "marked.xml")
+	for _, file := range []string{writing, marked, marked + DoneMarkerSuffix, filepath.Join(sourceDir, "ignored.txt")} {
+		if err := os.WriteFile(file, []byte("<DTE/>"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", file, err)
+		}
+	}
+
+	files, err := worker.getSourceFiles()
+	if err != nil {
+		t.Fatalf("getSourceFiles failed: %v", err)
+	}
+
+	if len(files) != 1 || files[0] != marked {
+		t.Errorf("Expected only %s to be claimable, got %v", marked, files)
+	}
+
+	worker.removeDoneMarker(marked)
+	if _, err := os.Stat(marked + DoneMarkerSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected done marker to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package async
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+const _folioJournalSuffix = ".folio.json"
+
+// Folio assignment states. A record is written as stamping right before a
+// folio is requested and moved to assigned as soon as the signed stamp is
+// known, so a crash at any point leaves enough information to tell whether
+// a folio was consumed for the file.
+const (
+	folioStateStamping = "stamping"
+	folioStateAssigned = "assigned"
+)
+
+// folioAssignment is the persisted record of the folio used by a file.
+type folioAssignment struct {
+	File         string           `json:"file"`
+	ContentHash  string           `json:"contentHash"`
+	State        string           `json:"state"`
+	CompanyID    string           `json:"companyId"`
+	DocumentType uint8            `json:"documentType"`
+	CAFCounters  map[string]int64 `json:"cafCounters,omitempty"`
+	// StampingSince is when the folio was requested. Folios taken back after
+	// a release or assigned explicitly do not move the CAF counters, so
+	// recovery also looks for folios recorded as used after it.
+	StampingSince time.Time `json:"stampingSince"`
+	Folio         int64     `json:"folio,omitempty"`
+	StampXML      string    `json:"stampXml,omitempty"`
+	Destination   string    `json:"destination,omitempty"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// folioJournal stores one folioAssignment per file name. Records of files
+// that failed after being stamped are kept, so a retry or a requeue reuses
+// the folio instead of consuming a new one.
+type folioJournal struct {
+	directory string
+}
+
+func newFolioJournal(directory string) *folioJournal {
+	return &folioJournal{directory: directory}
+}
+
+func (j *folioJournal) path(name string) string {
+	return filepath.Join(j.directory, name+_folioJournalSuffix)
+}
+
+// Load returns the assignment of name and whether one exists.
+func (j *folioJournal) Load(name string) (folioAssignment, bool, error) {
+	data, err := os.ReadFile(j.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return folioAssignment{}, false, nil
+	}
+	if err != nil {
+		return folioAssignment{}, false, fmt.Errorf("reading folio assignment: %w", err)
+	}
+
+	var record folioAssignment
+	if err := json.Unmarshal(data, &record); err != nil {
+		return folioAssignment{}, false, fmt.Errorf("decoding folio assignment: %w", err)
+	}
+	return record, true, nil
+}
+
+// Save persists record atomically, so a crash never leaves a truncated file.
+func (j *folioJournal) Save(record folioAssignment) error {
+	record.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding folio assignment: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path(record.File)), 0755); err != nil {
+		return fmt.Errorf("creating folio journal directory: %w", err)
+	}
+
+	if err := writeFileAtomic(j.path(record.File), data); err != nil {
+		return fmt.Errorf("writing folio assignment: %w", err)
+	}
+	return nil
+}
+
+// RecordDestination remembers where the outputs of name are written, so an
+// interrupted save can be completed in the same directory.
+func (j *folioJournal) RecordDestination(name, destination string) error {
+	record, found, err := j.Load(name)
+	if err != nil || !found {
+		return err
+	}
+	record.Destination = destination
+	return j.Save(record)
+}
+
+// Delete removes the assignment of name, if any.
+func (j *folioJournal) Delete(name string) error {
+	err := os.Remove(j.path(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing folio assignment: %w", err)
+	}
+	return nil
+}
+
+// List returns every assignment in the journal.
+func (j *folioJournal) List() ([]folioAssignment, error) {
+	files, err := listTree(j.directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading folio journal: %w", err)
+	}
+
+	var records []folioAssignment
+	for _, file := range files {
+		if !strings.HasSuffix(file.name, _folioJournalSuffix) {
+			continue
+		}
+
+		record, ok, err := j.Load(strings.TrimSuffix(file.name, _folioJournalSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// contentHash returns the hex encoded SHA-256 of data.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKey identifies a document across files: by the issuer and the
+// internal ID of the document when the input carries one, or by the issuer
+// and the content hash otherwise. The content hash also fingerprints the
+// request, so an internal ID reused for a different document is rejected.
+func idempotencyKey(invoice *domain.Invoice, hash string) usecases.IdempotencyKey {
+	id := "sha256:" + hash
+	if invoice.InternalID != "" {
+		id = "id:" + invoice.InternalID
+	}
+	return usecases.IdempotencyKey{
+		Key:         "file:" + invoice.Issuer.Code.String() + ":" + id,
+		RequestHash: hash,
+	}
+}
+
+// stampFolio extracts the folio from a signed TED.
+func stampFolio(stampXML []byte) (int64, error) {
+	identity, err := parseStampIdentity(stampXML)
+	if err != nil {
+		return 0, err
+	}
+	return identity.Folio, nil
+}
+
+// cafCounters returns the current folio of every CAF the company holds for
+// documentType. Comparing two snapshots tells whether a folio was consumed
+// in between.
+func cafCounters(ctx context.Context, cafService usecases.CAFService, companyID string, documentType uint8) (map[string]int64, error) {
+	cafs, err := cafService.FindByCompanyID(ctx, companyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding CAF counters: %w", err)
+	}
+
+	counters := make(map[string]int64)
+	for _, caf := range cafs {
+		if caf.DocumentType == uint(documentType) {
+			counters[caf.ID] = caf.CurrentFolios
+		}
+	}
+	return counters, nil
+}
+
+func sameCounters(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, current := range a {
+		if other, ok := b[id]; !ok || other != current {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package async
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// ErrUnsupportedFormat is returned when no decoder recognizes a source file.
+var ErrUnsupportedFormat = errors.New("unsupported input format")
+
+// Extensions picked up from the source directory without being claimed by a
+// decoder. Their format is detected from the content.
+var _sniffedExtensions = []string{"", ".txt"}
+
+// InvoiceDecoder turns the content of a source file into an invoice.
+type InvoiceDecoder interface {
+	// Format names the input format in logs and errors.
+	Format() string
+	// Extensions lists the lower case file extensions handled by the decoder.
+	Extensions() []string
+	// Sniff reports whether data looks like the decoder's format. It is only
+	// used when the file extension does not select a decoder.
+	Sniff(data []byte) bool
+	Decode(data []byte) (*domain.Invoice, error)
+}
+
+func defaultInvoiceDecoders() []InvoiceDecoder {
+	return []InvoiceDecoder{xmlInvoiceDecoder{}, jsonInvoiceDecoder{}, csvInvoiceDecoder{}}
+}
+
+// acceptsFile reports whether fileName should be picked up from the source
+// directory.
+func (w *FileIntegrationWorker) acceptsFile(fileName string) bool {
+	if strings.HasPrefix(fileName, ".") {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if slices.Contains(_sniffedExtensions, ext) {
+		return true
+	}
+	for _, decoder := range w.decoders {
+		if slices.Contains(decoder.Extensions(), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// decoderFor selects the decoder of a file by its extension, falling back to
+// content sniffing for extensions no decoder claims.
+func (w *FileIntegrationWorker) decoderFor(fileName string, data []byte) (InvoiceDecoder, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, decoder := range w.decoders {
+		if slices.Contains(decoder.Extensions(), ext) {
+			return decoder, nil
+		}
+	}
+
+	for _, decoder := range w.decoders {
+		if decoder.Sniff(data) {
+			return decoder, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, fileName)
+}
+
+// decodeInvoice decodes data with the decoder selected for fileName,
+// completes its lines from the product catalogue and runs the validation
+// shared by every input format.
+func (w *FileIntegrationWorker) decodeInvoice(ctx context.Context, fileName string, data []byte) (*domain.Invoice, error) {
+	decoder, err := w.decoderFor(fileName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := decoder.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", decoder.Format(), err)
+	}
+
+	if err := w.completeDetails(ctx, invoice); err != nil {
+		return nil, err
+	}
+
+	if err := invoice.Validate(); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// completeDetails fills the lines that name a catalogue code, computing the
+// totals again when an amount changed.
+func (w *FileIntegrationWorker) completeDetails(ctx context.Context, invoice *domain.Invoice) error {
+	if w.products == nil || !slices.ContainsFunc(invoice.Details, func(d domain.InvoiceDetail) bool { return d.Code != "" }) {
+		return nil
+	}
+
+	company, err := w.companyService.FindByCode(ctx, invoice.Issuer.Code)
+	if err != nil {
+		return fmt.Errorf("failed to find company with code %s: %w", invoice.Issuer.Code, err)
+	}
+	changed, err := w.products.CompleteDetails(ctx, company.ID, invoice)
+	if err != nil {
+		return fmt.Errorf("failed to complete details from the product catalogue: %w", err)
+	}
+	if changed {
+		return invoice.ComputeTotals()
+	}
+	return nil
+}
+
+// leadingContent strips a UTF-8 byte order mark and leading whitespace, so
+// sniffers can look at the first meaningful byte.
+func leadingContent(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	return bytes.TrimLeft(data, " \t\r\n")
+}
+
+type xmlInvoiceDecoder struct{}
+
+func (xmlInvoiceDecoder) Format() string { return "SII DTE XML" }
+
+func (xmlInvoiceDecoder) Extensions() []string { return []string{".xml"} }
+
+func (xmlInvoiceDecoder) Sniff(data []byte) bool {
+	return bytes.HasPrefix(leadingContent(data), []byte("<"))
+}
+
+func (xmlInvoiceDecoder) Decode(data []byte) (*domain.Invoice, error) {
+	dte, err := ParseDTEXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DTE XML: %w", err)
+	}
+
+	invoice, err := dte.ToInvoice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert DTE to invoice: %w", err)
+	}
+	return invoice, nil
+}
@@ -0,0 +1,346 @@
+package async
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+const testJSONInvoice = `{
+  "issuer": {"code": "76212889-6", "name": "FACTURA MOVIL SPA"},
+  "hasTaxes": true,
+  "date": "2025-05-05",
+  "client": {"code": "77371419-3", "name": "AGRICOLA PAINE LTDA", "address": "AVDA. VITACURA 2771", "municipality": "Las Condes"},
+  "details": [
+    {"position": 1, "product": {"name": "Plan Emprendedor", "price": 10000, "unit": {"code": "Unid"}}, "quantity": 2},
+    {"position": 2, "product": {"name": "Soporte", "price": 5000}, "quantity": 1}
+  ]
+}`
+
+const testCSVInvoice = "issuer_rut;document_type;issue_date;receiver_rut;receiver_name;item_name;quantity;unit_price\n" +
+	"76212889-6;33;2025-05-05;77371419-3;AGRICOLA PAINE LTDA;Plan Emprendedor;2;10.000\n" +
+	"76212889-6;33;2025-05-05;77371419-3;AGRICOLA PAINE LTDA;Soporte;1;5000\n"
+
+func TestDecoderFor(t *testing.T) {
+	worker := newTestWorker(t)
+
+	tests := []struct {
+		name     string
+		fileName string
+		data     string
+		want     string
+	}{
+		{name: "xml extension", fileName: "a.xml", data: "{}", want: "SII DTE XML"},
+		{name: "json extension", fileName: "a.JSON", data: "<DTE/>", want: "JSON"},
+		{name: "csv extension", fileName: "a.csv", data: "", want: "CSV"},
+		{name: "sniffed xml", fileName: "a.txt", data: "\xef\xbb\xbf  <DTE/>", want: "SII DTE XML"},
+		{name: "sniffed json", fileName: "export", data: "\n{\"issuer\":{}}", want: "JSON"},
+		{name: "sniffed csv", fileName: "a.txt", data: testCSVInvoice, want: "CSV"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := worker.decoderFor(tt.fileName, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("decoderFor failed: %v", err)
+			}
+			if decoder.Format() != tt.want {
+				t.Errorf("Expected %s decoder, got %s", tt.want, decoder.Format())
+			}
+		})
+	}
+
+	if _, err := worker.decoderFor("a.txt", []byte("plain text")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestAcceptsFile(t *testing.T) {
+	worker := newTestWorker(t)
+
+	for name, want := range map[string]bool{
+		"a.xml":      true,
+		"a.json":     true,
+		"a.CSV":      true,
+		"a.txt":      true,
+		"export":     true,
+		"a.xml.done": false,
+		"a.csv.part": false,
+		".hidden":    false,
+		"a.pdf":      false,
+	} {
+		if got := worker.acceptsFile(name); got != want {
+			t.Errorf("acceptsFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDecodeInvoice_AllFormatsAgree(t *testing.T) {
+	worker := newTestWorker(t)
+
+	xmlData, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+
+	inputs := map[string][]byte{
+		"invoice.xml":  xmlData,
+		"invoice.json": []byte(testJSONInvoice),
+		"invoice.csv":  []byte(testCSVInvoice),
+	}
+
+	for fileName, data := range inputs {
+		t.Run(fileName, func(t *testing.T) {
+			invoice, err := worker.decodeInvoice(context.Background(), fileName, data)
+			if err != nil {
+				t.Fatalf("decodeInvoice failed: %v", err)
+			}
+			if invoice.DocumentType != 33 || invoice.Issuer.Code.String() != "76212889-6" {
+				t.Errorf("Unexpected header: type %d issuer %q", invoice.DocumentType, invoice.Issuer.Code)
+			}
+			if invoice.Receiver == nil || invoice.Receiver.Code.String() != "77371419-3" {
+				t.Errorf("Unexpected receiver: %+v", invoice.Receiver)
+			}
+			if invoice.IssueDate.Format("2006-01-02") != "2025-05-05" {
+				t.Errorf("Unexpected issue date: %v", invoice.IssueDate)
+			}
+		})
+	}
+
+	invoice, err := worker.decodeInvoice(context.Background(), "invoice.csv", []byte(testCSVInvoice))
+	if err != nil {
+		t.Fatalf("decodeInvoice failed: %v", err)
+	}
+	want := domain.InvoiceTotals{TaxableAmount: 25000, TaxAmount: 4750, TotalAmount: 29750}
+	if invoice.Totals != want {
+		t.Errorf("Expected totals %+v, got %+v", want, invoice.Totals)
+	}
+}
+
+func TestDecodeInvoice_CSV(t *testing.T) {
+	worker := newTestWorker(t)
+
+	latin1 := "issuer_rut,item_name,quantity,unit_price,document_type\n76212889-6,Caf\xe9,1,1500,39\n"
+	invoice, err := worker.decodeInvoice(context.Background(), "boleta.csv", []byte(latin1))
+	if err != nil {
+		t.Fatalf("decodeInvoice failed: %v", err)
+	}
+	if invoice.Details[0].Description != "Café" {
+		t.Errorf("Expected Windows-1252 input to be decoded, got %q", invoice.Details[0].Description)
+	}
+	if invoice.Totals.TotalAmount != 1500 || invoice.Totals.TaxAmount != 239 {
+		t.Errorf("Expected boleta totals to include VAT, got %+v", invoice.Totals)
+	}
+
+	branch := "issuer_rut,branch_code,item_name,quantity,unit_price,document_type\n76212889-6,81234567,Item,1,1000,39\n"
+	invoice, err = worker.decodeInvoice(context.Background(), "boleta.csv", []byte(branch))
+	if err != nil {
+		t.Fatalf("decodeInvoice failed: %v", err)
+	}
+	if invoice.BranchCode != 81234567 {
+		t.Errorf("Expected branch code 81234567, got %d", invoice.BranchCode)
+	}
+
+	coded := "issuer_rut,item_code,item_name,unit,quantity,unit_price,exempt,additional_tax_codes,document_type\n" +
+		"76212889-6,PAN-12,Pan amasado,DOC,2,3500,,,39\n" +
+		"76212889-6,LIB-01,Libro,,1,3000,si,,39\n" +
+		"76212889-6,VIN-01,Vino,BOT,1,1000,,25,39\n"
+	invoice, err = worker.decodeInvoice(context.Background(), "boleta.csv", []byte(coded))
+	if err != nil {
+		t.Fatalf("decodeInvoice failed: %v", err)
+	}
+	pan := invoice.Details[0]
+	if pan.Code != "PAN-12" || pan.CodeType != domain.DefaultItemCodeType || pan.Unit != "DOC" {
+		t.Errorf("Expected item code and unit, got %+v", pan)
+	}
+	if !invoice.Details[1].Exempt || invoice.Details[0].Exempt {
+		t.Errorf("Expected only the second line to be exempt, got %+v", invoice.Details)
+	}
+	if got := invoice.Details[2].AdditionalTaxCodes; len(got) != 1 || got[0] != "25" {
+		t.Errorf("Expected additional tax code 25, got %v", got)
+	}
+	if invoice.Totals.TotalAmount != 11000 || invoice.Totals.TaxAmount != 1277 {
+		t.Errorf("Expected the exempt line to stay out of VAT, got %+v", invoice.Totals)
+	}
+
+	failures := map[string]string{
+		"bad branch code": "issuer_rut,branch_code,item_name,quantity,unit_price,document_type\n76212889-6,centro,Item,1,1000,39\n",
+		"missing column":  "issuer_rut,item_name,unit_price\n76212889-6,Item,100\n",
+		"no item":         "issuer_rut,quantity,unit_price,document_type\n76212889-6,1,100,39\n",
+		"bad exempt":      "issuer_rut,item_name,quantity,exempt,document_type\n76212889-6,A,1,quizas,39\n",
+		"mixed issuers":   "issuer_rut,item_name,quantity,unit_price,document_type\n76212889-6,A,1,100,39\n11111111-1,B,1,100,39\n",
+		"bad quantity":    "issuer_rut,item_name,quantity,unit_price,document_type\n76212889-6,A,one,100,39\n",
+		"no lines":        "issuer_rut,item_name,quantity,unit_price\n",
+		"bad date":        "issuer_rut,item_name,quantity,unit_price,issue_date\n76212889-6,A,1,100,05/05/2025\n",
+		"decimal point":   "issuer_rut;item_name;quantity;unit_price\n76212889-6;A;1.5;100\n",
+	}
+	for name, data := range failures {
+		if _, err := worker.decodeInvoice(context.Background(), "bad.csv", []byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseCSVNumber(t *testing.T) {
+	tests := []struct {
+		value     string
+		delimiter rune
+		want      domain.Decimal
+		wantErr   bool
+	}{
+		{value: "1234.5", delimiter: ',', want: domain.MustParseDecimal("1234.5")},
+		{value: "1.234,5", delimiter: ';', want: domain.MustParseDecimal("1234.5")},
+		{value: "1.234.567", delimiter: ';', want: domain.NewDecimal(1234567)},
+		{value: "10.000", delimiter: ';', want: domain.NewDecimal(10000)},
+		{value: "1,5", delimiter: ';', want: domain.MustParseDecimal("1.5")},
+		{value: "1.5", delimiter: ';', wantErr: true},
+		{value: "1.2345", delimiter: ';', wantErr: true},
+		{value: "12.34,5", delimiter: ';', wantErr: true},
+		{value: "1,2,3", delimiter: ';', wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCSVNumber(tt.value, tt.delimiter)
+		if tt.wantErr {
+			if !errors.Is(err, domain.ErrInvalidNumber) {
+				t.Errorf("parseCSVNumber(%q): expected ErrInvalidNumber, got %v (%v)", tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseCSVNumber(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeInvoice_SharedValidation(t *testing.T) {
+	worker := newTestWorker(t)
+
+	inputs := map[string]string{
+		"no-receiver.json": `{"issuer": {"code": "76212889-6"}, "hasTaxes": true, "details": [{"product": {"name": "A", "price": 100}, "quantity": 1}]}`,
+		"no-details.json":  `{"issuer": {"code": "76212889-6"}, "documentType": 39}`,
+		"no-issuer.csv":    "issuer_rut,item_name,quantity,unit_price,document_type\n,A,1,100,39\n",
+	}
+
+	for fileName, data := range inputs {
+		if _, err := worker.decodeInvoice(context.Background(), fileName, []byte(data)); !errors.Is(err, domain.ErrInvalidInvoice) {
+			t.Errorf("%s: expected ErrInvalidInvoice, got %v", fileName, err)
+		}
+	}
+
+	// RUTs are read as such, so a bad check digit fails in every format.
+	badRUTs := map[string]string{
+		"bad-rut.csv":      "issuer_rut,item_name,quantity,unit_price,document_type\n76212889-5,A,1,100,39\n",
+		"bad-receiver.csv": "issuer_rut,item_name,quantity,unit_price,receiver_rut\n76212889-6,A,1,100,77371419-0\n",
+		"bad-rut.json":     `{"issuer": {"code": "76.212.889-5"}, "documentType": 39, "details": [{"product": {"name": "A", "price": 100}, "quantity": 1}]}`,
+	}
+	for fileName, data := range badRUTs {
+		if _, err := worker.decodeInvoice(context.Background(), fileName, []byte(data)); !errors.Is(err, domain.ErrInvalidRUT) {
+			t.Errorf("%s: expected ErrInvalidRUT, got %v", fileName, err)
+		}
+	}
+}
+
+func TestDecodeInvoice_CanonicalRUTs(t *testing.T) {
+	worker := newTestWorker(t)
+
+	inputs := map[string]string{
+		"dotted.csv":  "issuer_rut,item_name,quantity,unit_price,receiver_rut\n76.212.889-6,A,1,100,12.345.670-k\n",
+		"dotted.json": `{"issuer": {"code": "76.212.889-6"}, "client": {"code": "12.345.670-k", "name": "B"}, "hasTaxes": true, "details": [{"product": {"name": "A", "price": 100}, "quantity": 1}]}`,
+	}
+	for fileName, data := range inputs {
+		invoice, err := worker.decodeInvoice(context.Background(), fileName, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: decodeInvoice failed: %v", fileName, err)
+		}
+		if invoice.Issuer.Code.String() != "76212889-6" || invoice.Receiver == nil || invoice.Receiver.Code.String() != "12345670-K" {
+			t.Errorf("%s: expected canonical RUTs, got issuer %q and receiver %+v", fileName, invoice.Issuer.Code, invoice.Receiver)
+		}
+	}
+}
+
+func TestDecodeInvoice_JSONAssignedFolio(t *testing.T) {
+	worker := newTestWorker(t)
+	input := `{"issuer": {"code": "76212889-6"}, "assignedFolio": %q, "documentType": 39, "details": [{"product": {"name": "A", "price": 100}, "quantity": 1}]}`
+
+	invoice, err := worker.decodeInvoice(context.Background(), "assigned.json", []byte(fmt.Sprintf(input, "2404")))
+	if err != nil {
+		t.Fatalf("decodeInvoice failed: %v", err)
+	}
+	if invoice.AssignedFolio != 2404 {
+		t.Errorf("Expected assigned folio 2404, got %d", invoice.AssignedFolio)
+	}
+
+	for _, folio := range []string{"0", "-3", "12a"} {
+		if _, err := worker.decodeInvoice(context.Background(), "assigned.json", []byte(fmt.Sprintf(input, folio))); err == nil {
+			t.Errorf("Expected assigned folio %q to be rejected", folio)
+		}
+	}
+}
+
+// folioStampService signs every document with the same folio, the way the
+// CAF assigns one to inputs that carry none.
+type folioStampService struct {
+	folio int64
+}
+
+func (f *folioStampService) Generate(ctx context.Context, company domain.Company, invoice domain.Invoice) (domain.Stamp, error) {
+	return domain.Stamp{DD: domain.DD{RE: company.Code, TD: invoice.DocumentType, F: f.folio}, FRMT: "signature"}, nil
+}
+
+func TestProcessDocument_JSONPrintsAssignedFolio(t *testing.T) {
+	worker := newTestWorker(t)
+	worker.documentService = usecases.NewDocumentService(&folioStampService{folio: 2404}, &fakeCompanyService{}, nil)
+
+	sourceFile := filepath.Join(worker.sourceDirectory, "invoice.json")
+	if err := os.WriteFile(sourceFile, []byte(testJSONInvoice), 0644); err != nil {
+		t.Fatalf("Failed to write invoice: %v", err)
+	}
+	result := worker.processDocument(context.Background(), sourceFile)
+	if result.Error != nil {
+		t.Fatalf("processDocument failed: %v", result.Error)
+	}
+
+	pdf, err := os.ReadFile(result.PDFFile)
+	if err != nil {
+		t.Fatalf("Expected a PDF: %v", err)
+	}
+	// The input has no folio; the PDF shows the one in the stamp, with "N°"
+	// in cp1252.
+	if !bytes.Contains(pdfContent(t, pdf), []byte("N\xb0 2404)")) {
+		t.Error("Expected the PDF to print the assigned folio 2404")
+	}
+}
+
+// pdfContent returns the inflated content streams of pdf.
+func pdfContent(t *testing.T, pdf []byte) []byte {
+	t.Helper()
+	var content []byte
+	for rest := pdf; ; {
+		start := bytes.Index(rest, []byte("stream\n"))
+		if start < 0 {
+			return content
+		}
+		rest = rest[start+len("stream\n"):]
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			t.Fatal("Unterminated stream in PDF")
+		}
+		if reader, err := zlib.NewReader(bytes.NewReader(rest[:end])); err == nil {
+			data, _ := io.ReadAll(reader)
+			content = append(content, data...)
+		} else {
+			content = append(content, rest[:end]...)
+		}
+		rest = rest[end+len("endstream"):]
+	}
+}
@@ -0,0 +1,161 @@
+package async
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// JSONInvoice is the JSON input format. It mirrors the body of
+// POST /companies/{companyId}/stamps, plus the issuer that the API takes from
+// the URL and an optional explicit document type.
+type JSONInvoice struct {
+	Issuer        JSONIssuer     `json:"issuer"`
+	InternalID    string         `json:"internalId"`
+	DocumentType  uint8          `json:"documentType"`
+	FmaPago       string         `json:"fmaPago"`
+	HasTaxes      *bool          `json:"hasTaxes"`
+	Details       []JSONDetail   `json:"details"`
+	Client        *JSONClient    `json:"client"`
+	AssignedFolio string         `json:"assignedFolio"`
+	Subsidiary    JSONSubsidiary `json:"subsidiary"`
+	Date          string         `json:"date"`
+}
+
+type JSONIssuer struct {
+	Code    domain.RUT `json:"code"`
+	Name    string     `json:"name"`
+	Address string     `json:"address"`
+}
+
+type JSONDetail struct {
+	Position    uint8          `json:"position"`
+	Product     JSONProduct    `json:"product"`
+	Description string         `json:"description"`
+	Quantity    domain.Decimal `json:"quantity"`
+	Discount    domain.Decimal `json:"discount"`
+}
+
+type JSONProduct struct {
+	Unit  JSONUnit       `json:"unit"`
+	Price domain.Decimal `json:"price"`
+	Name  string         `json:"name"`
+	Code  string         `json:"code"`
+	// CodeType is the TpoCodigo of Code, INT1 when empty.
+	CodeType           string   `json:"codeType"`
+	Exempt             bool     `json:"exempt"`
+	AdditionalTaxCodes []string `json:"additionalTaxCodes"`
+}
+
+type JSONUnit struct {
+	Code string `json:"code"`
+}
+
+type JSONClient struct {
+	Address      string     `json:"address"`
+	Name         string     `json:"name"`
+	Municipality string     `json:"municipality"`
+	Line         string     `json:"line"`
+	Code         domain.RUT `json:"code"`
+}
+
+// JSONSubsidiary picks the issuing branch by its SII code (CdgSIISucur).
+type JSONSubsidiary struct {
+	Code string `json:"code"`
+}
+
+type jsonInvoiceDecoder struct{}
+
+func (jsonInvoiceDecoder) Format() string { return "JSON" }
+
+func (jsonInvoiceDecoder) Extensions() []string { return []string{".json"} }
+
+func (jsonInvoiceDecoder) Sniff(data []byte) bool {
+	return bytes.HasPrefix(leadingContent(data), []byte("{"))
+}
+
+func (jsonInvoiceDecoder) Decode(data []byte) (*domain.Invoice, error) {
+	var input JSONInvoice
+	if err := json.Unmarshal(leadingContent(data), &input); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON invoice: %w", err)
+	}
+
+	return input.ToInvoice()
+}
+
+// ToInvoice converts the JSON input into an invoice, computing its totals
+// from the detail lines.
+func (in JSONInvoice) ToInvoice() (*domain.Invoice, error) {
+	builder := domain.NewInvoiceBuilder().
+		WithIssuer(domain.Company{
+			Code:    in.Issuer.Code,
+			Name:    in.Issuer.Name,
+			Address: in.Issuer.Address,
+		})
+
+	if in.HasTaxes != nil {
+		builder.WithHasTaxes(*in.HasTaxes)
+	}
+	if in.DocumentType != 0 {
+		builder.WithDocumentType(in.DocumentType)
+	}
+	if in.Subsidiary.Code != "" {
+		branchCode, err := strconv.ParseUint(in.Subsidiary.Code, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subsidiary code %q, expected the SII branch code", in.Subsidiary.Code)
+		}
+		builder.WithBranchCode(branchCode)
+	}
+	if in.AssignedFolio != "" {
+		assignedFolio, err := strconv.ParseInt(in.AssignedFolio, 10, 64)
+		if err != nil || assignedFolio <= 0 {
+			return nil, fmt.Errorf("invalid assignedFolio %q, expected a positive integer", in.AssignedFolio)
+		}
+		builder.WithAssignedFolio(assignedFolio)
+	}
+	builder.WithCreationDate(in.Date)
+	if in.Client != nil {
+		builder.WithCustomer(domain.Customer{
+			Code:         in.Client.Code,
+			Name:         in.Client.Name,
+			BusinessLine: in.Client.Line,
+			Address:      in.Client.Address,
+			Commune:      in.Client.Municipality,
+		})
+	}
+
+	invoice, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed building invoice: %w", err)
+	}
+
+	invoice.InternalID = in.InternalID
+
+	for _, d := range in.Details {
+		err := invoice.AddDetail(domain.Detail{
+			Position: d.Position,
+			Product: domain.Product{
+				Name:               d.Product.Name,
+				Price:              d.Product.Price,
+				CodeType:           d.Product.CodeType,
+				Code:               d.Product.Code,
+				Unit:               d.Product.Unit.Code,
+				Exempt:             d.Product.Exempt,
+				AdditionalTaxCodes: d.Product.AdditionalTaxCodes,
+			},
+			Quantity: d.Quantity,
+			Discount: d.Discount,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := invoice.ComputeTotals(); err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
@@ -0,0 +1,51 @@
+package async
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	documentsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "documents_processed_total",
+		Help:      "Documents processed by the file integration worker, by result.",
+	}, []string{"result"})
+
+	documentFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "document_failures_total",
+		Help:      "Failed document processing attempts, by stage (claim, parse, stamp, pdf417, pdf, save).",
+	}, []string{"stage"})
+
+	processingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "processing_duration_seconds",
+		Help:      "Time spent processing a single document, by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"result"})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "queue_depth",
+		Help:      "Files found in the last scan that are still waiting to be processed.",
+	})
+
+	filesInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "files_in_progress",
+		Help:      "Files currently being processed.",
+	})
+
+	lastTickTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fmg",
+		Subsystem: "worker",
+		Name:      "last_tick_timestamp_seconds",
+		Help:      "Unix time of the last processing batch.",
+	})
+)
@@ -0,0 +1,246 @@
+package async
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+const _defaultOutputNameTemplate = "{base}"
+
+var _outputPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// Placeholders available in OutputOptions.NameTemplate.
+var _outputPlaceholders = map[string]bool{
+	"{base}":  true, // original file name without extension
+	"{rut}":   true, // issuer RUT
+	"{td}":    true, // document type
+	"{folio}": true, // assigned folio
+	"{date}":  true, // issue date, YYYY-MM-DD
+}
+
+// OutputOptions controls how the artefacts of a processed document are named
+// and packaged in the destination directory.
+type OutputOptions struct {
+	// NameTemplate builds the common prefix of every artefact, for example
+	// "{rut}_{td}_{folio}" produces "76212889-6_33_2404_stamp.xml".
+	NameTemplate string
+	// Zip packs the artefacts into a single "{name}.zip" instead of writing
+	// them as loose files.
+	Zip bool
+	// Manifest writes "{name}_manifest.json" listing every artefact with its
+	// SHA-256 checksum.
+	Manifest bool
+}
+
+// DefaultOutputOptions keeps the historical "{base}_stamp.xml" names.
+func DefaultOutputOptions() OutputOptions {
+	return OutputOptions{NameTemplate: _defaultOutputNameTemplate}
+}
+
+// Validate rejects templates with unknown placeholders or path separators.
+func (o OutputOptions) Validate() error {
+	if strings.TrimSpace(o.NameTemplate) == "" {
+		return fmt.Errorf("output name template is required")
+	}
+	if strings.ContainsAny(o.NameTemplate, `/\`) {
+		return fmt.Errorf("output name template %q must not contain path separators", o.NameTemplate)
+	}
+	for _, placeholder := range _outputPlaceholder.FindAllString(o.NameTemplate, -1) {
+		if !_outputPlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s in output name template", placeholder)
+		}
+	}
+	return nil
+}
+
+// OutputManifest describes the artefacts written for one document.
+type OutputManifest struct {
+	OriginalFile string           `json:"originalFile"`
+	IssuerRUT    domain.RUT       `json:"issuerRut"`
+	DocumentType uint8            `json:"documentType"`
+	Folio        int64            `json:"folio"`
+	Bundle       string           `json:"bundle,omitempty"`
+	Artefacts    []OutputArtefact `json:"artefacts"`
+	CreatedAt    time.Time        `json:"createdAt"`
+}
+
+// OutputArtefact is a single file of a document, inside the bundle when one
+// is written.
+type OutputArtefact struct {
+	Kind   string `json:"kind"`
+	File   string `json:"file"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// stampIdentity holds the fields of a signed TED used to name outputs.
+type stampIdentity struct {
+	RUT          domain.RUT
+	DocumentType uint8
+	Folio        int64
+	IssueDate    string
+}
+
+func parseStampIdentity(stampXML []byte) (stampIdentity, error) {
+	var ted struct {
+		DD struct {
+			RE domain.RUT `xml:"RE"`
+			TD uint8      `xml:"TD"`
+			F  int64      `xml:"F"`
+			FE string     `xml:"FE"`
+		} `xml:"DD"`
+	}
+	if err := xml.Unmarshal(stampXML, &ted); err != nil {
+		return stampIdentity{}, fmt.Errorf("decoding stamp: %w", err)
+	}
+	return stampIdentity{RUT: ted.DD.RE, DocumentType: ted.DD.TD, Folio: ted.DD.F, IssueDate: ted.DD.FE}, nil
+}
+
+// outputName renders the name template for the document whose original is
+// originalDest.
+func (o OutputOptions) outputName(originalDest string, identity stampIdentity) string {
+	base := strings.TrimSuffix(filepath.Base(originalDest), filepath.Ext(originalDest))
+	name := strings.NewReplacer(
+		"{base}", base,
+		"{rut}", identity.RUT.String(),
+		"{td}", strconv.Itoa(int(identity.DocumentType)),
+		"{folio}", strconv.FormatInt(identity.Folio, 10),
+		"{date}", identity.IssueDate,
+	).Replace(o.NameTemplate)
+
+	if validateFileName(name) != nil {
+		return base
+	}
+	return name
+}
+
+// writeOutputs writes the stamp, barcodes, PDF and receipt of a document next
+// to its original, named and packaged according to the output options. Every
+// file is written atomically, so consumers never see a partial artefact.
+func (w *FileIntegrationWorker) writeOutputs(originalDest string, processingResult usecases.ProcessingResult, result *FileProcessingResult) error {
+	destination := filepath.Dir(originalDest)
+
+	identity, err := parseStampIdentity(processingResult.StampXML)
+	if err != nil {
+		return fmt.Errorf("failed to read stamp: %w", err)
+	}
+	name := w.output.outputName(originalDest, identity)
+
+	type artefactFile struct {
+		kind   string
+		suffix string
+		data   []byte
+		target *string
+	}
+	artefacts := []artefactFile{
+		{kind: "stamp", suffix: "_stamp.xml", data: processingResult.StampXML, target: &result.StampFile},
+		{kind: "pdf417", suffix: "_pdf417.png", data: processingResult.PDF417Data, target: &result.PDF417File},
+		{kind: "pdf", suffix: pdfSuffix(processingResult.PDFLayout), data: processingResult.PDF, target: &result.PDFFile},
+	}
+	if processingResult.PDF417SVG != nil {
+		artefacts = append(artefacts, artefactFile{kind: "pdf417-svg", suffix: "_pdf417.svg", data: processingResult.PDF417SVG, target: &result.PDF417SVGFile})
+	}
+	if processingResult.Receipt != nil {
+		artefacts = append(artefacts, artefactFile{kind: "receipt", suffix: "_receipt.bin", data: processingResult.Receipt, target: &result.ReceiptFile})
+	}
+
+	manifest := OutputManifest{
+		OriginalFile: filepath.Base(originalDest),
+		IssuerRUT:    identity.RUT,
+		DocumentType: identity.DocumentType,
+		Folio:        identity.Folio,
+		CreatedAt:    time.Now(),
+	}
+
+	var bundle bytes.Buffer
+	var zipWriter *zip.Writer
+	if w.output.Zip {
+		zipWriter = zip.NewWriter(&bundle)
+	}
+
+	for _, artefact := range artefacts {
+		fileName := name + artefact.suffix
+		sum := sha256.Sum256(artefact.data)
+		manifest.Artefacts = append(manifest.Artefacts, OutputArtefact{
+			Kind:   artefact.kind,
+			File:   fileName,
+			Size:   len(artefact.data),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+
+		if zipWriter != nil {
+			entry, err := zipWriter.Create(fileName)
+			if err != nil {
+				return fmt.Errorf("failed to add %s to bundle: %w", artefact.kind, err)
+			}
+			if _, err := entry.Write(artefact.data); err != nil {
+				return fmt.Errorf("failed to add %s to bundle: %w", artefact.kind, err)
+			}
+			continue
+		}
+
+		path := filepath.Join(destination, fileName)
+		if err := writeFileAtomic(path, artefact.data); err != nil {
+			return fmt.Errorf("failed to save %s file: %w", artefact.kind, err)
+		}
+		*artefact.target = path
+	}
+
+	if zipWriter != nil {
+		if err := zipWriter.Close(); err != nil {
+			return fmt.Errorf("failed to close bundle: %w", err)
+		}
+		bundleFile := filepath.Join(destination, name+".zip")
+		if err := writeFileAtomic(bundleFile, bundle.Bytes()); err != nil {
+			return fmt.Errorf("failed to save bundle: %w", err)
+		}
+		result.BundleFile = bundleFile
+		manifest.Bundle = filepath.Base(bundleFile)
+	}
+
+	if w.output.Manifest {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest: %w", err)
+		}
+		manifestFile := filepath.Join(destination, name+"_manifest.json")
+		if err := writeFileAtomic(manifestFile, data); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+		result.ManifestFile = manifestFile
+	}
+
+	slog.Debug("Saved all files to destination",
+		"original", result.OriginalFile,
+		"stamp", result.StampFile,
+		"pdf417", result.PDF417File,
+		"pdf417SVG", result.PDF417SVGFile,
+		"pdf", result.PDFFile,
+		"receipt", result.ReceiptFile,
+		"bundle", result.BundleFile,
+		"manifest", result.ManifestFile)
+
+	return nil
+}
+
+// pdfSuffix names the PDF after its layout, "_thermal.pdf" or "_letter.pdf".
+func pdfSuffix(layout usecases.PDFLayout) string {
+	if layout == "" {
+		layout = usecases.PDFLayoutThermal
+	}
+	return "_" + string(layout) + ".pdf"
+}
@@ -0,0 +1,119 @@
+package async
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"factura-movil-gateway/internal/usecases"
+)
+
+const testStampXML = `<TED version="1.0"><DD><RE>76.212.889-6</RE><TD>33</TD><F>2404</F><FE>2025-05-05</FE></DD></TED>`
+
+func TestOutputOptionsValidate(t *testing.T) {
+	valid := []string{"{base}", "{rut}_{td}_{folio}", "DTE-{date}-{folio}"}
+	for _, template := range valid {
+		if err := (OutputOptions{NameTemplate: template}).Validate(); err != nil {
+			t.Errorf("%q: unexpected error %v", template, err)
+		}
+	}
+
+	invalid := []string{"", "{rut}/{folio}", "{issuer}_{folio}"}
+	for _, template := range invalid {
+		if err := (OutputOptions{NameTemplate: template}).Validate(); err == nil {
+			t.Errorf("%q: expected an error", template)
+		}
+	}
+}
+
+func TestWriteOutputs_TemplateZipAndManifest(t *testing.T) {
+	worker := newTestWorker(t)
+	worker.WithOutputOptions(OutputOptions{NameTemplate: "{rut}_{td}_{folio}", Zip: true, Manifest: true})
+
+	processing := usecases.ProcessingResult{
+		StampXML:   []byte(testStampXML),
+		PDF417Data: []byte("png"),
+		PDF:        []byte("pdf"),
+		PDFLayout:  usecases.PDFLayoutLetter,
+	}
+	originalDest := filepath.Join(worker.destinationDirectory, "invoice.xml")
+	result := FileProcessingResult{OriginalFile: originalDest}
+	if err := worker.writeOutputs(originalDest, processing, &result); err != nil {
+		t.Fatalf("writeOutputs failed: %v", err)
+	}
+
+	wantBundle := filepath.Join(worker.destinationDirectory, "76212889-6_33_2404.zip")
+	if result.BundleFile != wantBundle || result.StampFile != "" {
+		t.Fatalf("Expected only a bundle at %s, got %+v", wantBundle, result)
+	}
+
+	data, err := os.ReadFile(result.ManifestFile)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var manifest OutputManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	if manifest.Folio != 2404 || manifest.Bundle != "76212889-6_33_2404.zip" || len(manifest.Artefacts) != 3 {
+		t.Fatalf("Unexpected manifest: %+v", manifest)
+	}
+
+	reader, err := zip.OpenReader(wantBundle)
+	if err != nil {
+		t.Fatalf("Failed to open bundle: %v", err)
+	}
+	defer reader.Close()
+
+	checksums := make(map[string]string)
+	for _, file := range reader.File {
+		entry, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(entry)
+		entry.Close()
+		sum := sha256.Sum256(content)
+		checksums[file.Name] = hex.EncodeToString(sum[:])
+	}
+	if _, ok := checksums["76212889-6_33_2404_letter.pdf"]; !ok {
+		t.Errorf("Expected the PDF named after its layout, got %v", checksums)
+	}
+	for _, artefact := range manifest.Artefacts {
+		if checksums[artefact.File] != artefact.SHA256 {
+			t.Errorf("Checksum of %s does not match the bundle", artefact.File)
+		}
+	}
+
+	entries, _ := os.ReadDir(worker.destinationDirectory)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Errorf("Temporary file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestWriteOutputs_DefaultNames(t *testing.T) {
+	worker := newTestWorker(t)
+
+	processing := usecases.ProcessingResult{StampXML: []byte(testStampXML), PDF417Data: []byte("png"), PDF: []byte("pdf"), PDFLayout: usecases.PDFLayoutThermal, PDF417SVG: []byte("<svg/>"), Receipt: []byte{0x1B, '@'}}
+	originalDest := filepath.Join(worker.destinationDirectory, "invoice.xml")
+	result := FileProcessingResult{OriginalFile: originalDest}
+	if err := worker.writeOutputs(originalDest, processing, &result); err != nil {
+		t.Fatalf("writeOutputs failed: %v", err)
+	}
+
+	for _, file := range []string{result.StampFile, result.PDF417File, result.PDF417SVGFile, result.PDFFile, result.ReceiptFile} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s: %v", file, err)
+		}
+	}
+	if filepath.Base(result.StampFile) != "invoice_stamp.xml" || filepath.Base(result.ReceiptFile) != "invoice_receipt.bin" || filepath.Base(result.PDF417SVGFile) != "invoice_pdf417.svg" || result.ManifestFile != "" {
+		t.Errorf("Expected historical names without manifest, got %+v", result)
+	}
+}
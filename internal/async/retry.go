@@ -0,0 +1,201 @@
+package async
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	_retryStateSuffix  = ".retry.json"
+	_errorReportSuffix = ".error.json"
+)
+
+// ErrInvalidFileName is returned when an operator supplied file name would
+// escape the worker directories.
+var ErrInvalidFileName = errors.New("invalid file name")
+
+// RetryPolicy configures how transient failures are retried before a file is
+// moved to the dead-letter (error) directory.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultRetryPolicy retries transient failures five times, starting with a
+// ten second wait that doubles on every attempt up to fifteen minutes.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Second,
+		MaxBackoff:  15 * time.Minute,
+	}
+}
+
+// Backoff returns the wait before the next try after the given number of
+// failed attempts.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+
+	backoff := p.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
+
+// AttemptRecord describes a single failed processing attempt.
+type AttemptRecord struct {
+	At         time.Time     `json:"at"`
+	Duration   time.Duration `json:"duration"`
+	Transient  bool          `json:"transient"`
+	Error      string        `json:"error"`
+	ErrorChain []string      `json:"errorChain"`
+}
+
+// retryState is persisted next to a file waiting for its next attempt.
+type retryState struct {
+	Attempts      []AttemptRecord `json:"attempts"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+}
+
+// DeadLetterReport is written as <file>.error.json next to every file moved
+// to the error directory.
+type DeadLetterReport struct {
+	File       string          `json:"file"`
+	FailedAt   time.Time       `json:"failedAt"`
+	Transient  bool            `json:"transient"`
+	Error      string          `json:"error"`
+	ErrorChain []string        `json:"errorChain"`
+	Attempts   []AttemptRecord `json:"attempts"`
+	// AssignedFolio is the folio already consumed for the file, which is
+	// reused if the file is requeued without changes.
+	AssignedFolio int64 `json:"assignedFolio,omitempty"`
+}
+
+// FailedFile is a file sitting in the error directory together with the
+// report explaining why it ended there, when available.
+type FailedFile struct {
+	Name   string            `json:"name"`
+	Report *DeadLetterReport `json:"report,omitempty"`
+}
+
+// retryStore keeps files waiting for a retry and their sidecar state files.
+type retryStore struct {
+	directory string
+}
+
+func newRetryStore(directory string) *retryStore {
+	return &retryStore{directory: directory}
+}
+
+func (s *retryStore) statePath(name string) string {
+	return filepath.Join(s.directory, name+_retryStateSuffix)
+}
+
+// Load returns the retry state of name, or an empty state if the file has
+// never failed before.
+func (s *retryStore) Load(name string) (retryState, error) {
+	data, err := os.ReadFile(s.statePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return retryState{}, nil
+	}
+	if err != nil {
+		return retryState{}, fmt.Errorf("reading retry state: %w", err)
+	}
+
+	var state retryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return retryState{}, fmt.Errorf("decoding retry state: %w", err)
+	}
+	return state, nil
+}
+
+// Save persists the retry state of name.
+func (s *retryStore) Save(name string, state retryState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding retry state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath(name)), 0755); err != nil {
+		return fmt.Errorf("creating retry state directory: %w", err)
+	}
+	if err := os.WriteFile(s.statePath(name), data, 0644); err != nil {
+		return fmt.Errorf("writing retry state: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the retry state of name, if any.
+func (s *retryStore) Delete(name string) error {
+	err := os.Remove(s.statePath(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing retry state: %w", err)
+	}
+	return nil
+}
+
+// Due returns the files in the retry directory whose next attempt is due.
+func (s *retryStore) Due(now time.Time) ([]sourceCandidate, error) {
+	files, err := listTree(s.directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading retry directory: %w", err)
+	}
+
+	var due []sourceCandidate
+	for _, file := range files {
+		if strings.HasSuffix(file.name, _retryStateSuffix) {
+			continue
+		}
+
+		state, err := s.Load(file.name)
+		if err != nil {
+			return nil, fmt.Errorf("loading retry state of %s: %w", file.name, err)
+		}
+		if state.NextAttemptAt.After(now) {
+			continue
+		}
+
+		due = append(due, sourceCandidate{
+			path:    file.path,
+			modTime: file.info.ModTime(),
+		})
+	}
+
+	return due, nil
+}
+
+// errorChain flattens err and every error it wraps into a list of messages,
+// outermost first.
+func errorChain(err error) []string {
+	var chain []string
+	for err != nil {
+		chain = append(chain, err.Error())
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
+
+// validateFileName makes sure name refers to a plain file inside a worker
+// directory.
+func validateFileName(name string) error {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package async
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/usecases"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 0},
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 3, want: 40 * time.Second},
+		{attempts: 4, want: time.Minute},
+		{attempts: 10, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempts=%d", tt.attempts), func(t *testing.T) {
+			if got := policy.Backoff(tt.attempts); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	root := errors.New("connection refused")
+	err := fmt.Errorf("failed to process invoice: %w", fmt.Errorf("finding available CAF: %w", root))
+
+	chain := errorChain(err)
+	if len(chain) != 3 {
+		t.Fatalf("Expected 3 errors in chain, got %d: %v", len(chain), chain)
+	}
+	if chain[2] != "connection refused" {
+		t.Errorf("Expected root cause last, got %q", chain[2])
+	}
+}
+
+func newTestWorker(t *testing.T) *FileIntegrationWorker {
+	t.Helper()
+	root := t.TempDir()
+	worker := &FileIntegrationWorker{
+		sourceDirectory:      filepath.Join(root, "source"),
+		inprogressDirectory:  filepath.Join(root, "inprogress"),
+		destinationDirectory: filepath.Join(root, "destination"),
+		errorDirectory:       filepath.Join(root, "errors"),
+		stability:            newFileStabilityTracker(0),
+		trigger:              make(chan struct{}, 1),
+		concurrency:          1,
+		retries:              newRetryStore(filepath.Join(root, "retry")),
+		retryPolicy:          RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Hour, MaxBackoff: time.Hour},
+		status:               newStatusTracker(),
+		journal:              newFolioJournal(filepath.Join(root, "journal")),
+		decoders:             defaultInvoiceDecoders(),
+		router:               NewRouter(),
+		output:               DefaultOutputOptions(),
+	}
+	if err := worker.ensureDirectoriesExist(); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	return worker
+}
+
+func TestHandleFailure_TransientThenDeadLetter(t *testing.T) {
+	worker := newTestWorker(t)
+	inProgressFile := filepath.Join(worker.inprogressDirectory, "invoice.xml")
+	transientErr := fmt.Errorf("failed to process invoice: %w", usecases.NewTransientError(errors.New("connection reset")))
+
+	if err := os.WriteFile(inProgressFile, []byte("<DTE/>"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	worker.handleFailure(inProgressFile, transientErr, time.Millisecond)
+
+	retryFile := filepath.Join(worker.retries.directory, "invoice.xml")
+	if _, err := os.Stat(retryFile); err != nil {
+		t.Fatalf("Expected file in retry directory: %v", err)
+	}
+	state, err := worker.retries.Load("invoice.xml")
+	if err != nil || len(state.Attempts) != 1 {
+		t.Fatalf("Expected one recorded attempt, got %+v (err %v)", state, err)
+	}
+
+	due, err := worker.retries.Due(time.Now())
+	if err != nil || len(due) != 0 {
+		t.Errorf("Expected no file due before backoff elapses, got %v (err %v)", due, err)
+	}
+
+	if err := worker.moveFile(retryFile, inProgressFile); err != nil {
+		t.Fatalf("Failed to move file back: %v", err)
+	}
+	worker.handleFailure(inProgressFile, transientErr, time.Millisecond)
+
+	errorFile := filepath.Join(worker.errorDirectory, "invoice.xml")
+	if _, err := os.Stat(errorFile); err != nil {
+		t.Fatalf("Expected file in error directory after max attempts: %v", err)
+	}
+
+	data, err := os.ReadFile(errorFile + _errorReportSuffix)
+	if err != nil {
+		t.Fatalf("Expected error report: %v", err)
+	}
+	var report DeadLetterReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to decode error report: %v", err)
+	}
+	if len(report.Attempts) != 2 || !report.Transient || len(report.ErrorChain) < 2 {
+		t.Errorf("Unexpected error report: %+v", report)
+	}
+	if _, err := os.Stat(worker.retries.statePath("invoice.xml")); !os.IsNotExist(err) {
+		t.Errorf("Expected retry state to be removed, got %v", err)
+	}
+}
+
+func TestHandleFailure_PermanentGoesStraightToErrors(t *testing.T) {
+	worker := newTestWorker(t)
+	inProgressFile := filepath.Join(worker.inprogressDirectory, "broken.xml")
+	if err := os.WriteFile(inProgressFile, []byte("not xml"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	worker.handleFailure(inProgressFile, errors.New("failed to parse XML to invoice"), time.Millisecond)
+
+	if _, err := os.Stat(filepath.Join(worker.errorDirectory, "broken.xml")); err != nil {
+		t.Fatalf("Expected file in error directory: %v", err)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	worker := newTestWorker(t)
+	errorFile := filepath.Join(worker.errorDirectory, "invoice.xml")
+	if err := os.WriteFile(errorFile, []byte("<DTE/>"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := writeErrorReport(errorFile+_errorReportSuffix, DeadLetterReport{File: "invoice.xml"}); err != nil {
+		t.Fatalf("Failed to create report: %v", err)
+	}
+
+	failed, err := worker.ListFailed()
+	if err != nil || len(failed) != 1 || failed[0].Report == nil {
+		t.Fatalf("Expected one failed file with report, got %+v (err %v)", failed, err)
+	}
+
+	if err := worker.Requeue("invoice.xml"); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worker.sourceDirectory, "invoice.xml")); err != nil {
+		t.Errorf("Expected file back in source directory: %v", err)
+	}
+	if _, err := os.Stat(errorFile + _errorReportSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected error report to be removed, got %v", err)
+	}
+
+	for _, name := range []string{"../secret.xml", "", ".hidden"} {
+		if err := worker.Requeue(name); !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("Requeue(%q) expected ErrInvalidFileName, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package async
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// ErrRoutingNotConfigured is returned when reloading a router that was not
+// created from a config file.
+var ErrRoutingNotConfigured = errors.New("routing config file not configured")
+
+// RoutingConfig is the declarative routing file of the worker.
+//
+// Every company listed gets its own tree inside each worker directory:
+// files dropped in source/{rut}/ are processed, tracked and dead-lettered
+// under {rut}/, and their outputs land in destination/{rut}/ unless a rule
+// routes them elsewhere.
+type RoutingConfig struct {
+	Companies []CompanyRouting `json:"companies"`
+	Rules     []RoutingRule    `json:"rules"`
+}
+
+// CompanyRouting declares a company with its own inbox and outbox, named
+// after the canonical form of its RUT.
+type CompanyRouting struct {
+	RUT domain.RUT `json:"rut"`
+}
+
+// RoutingRule sends the outputs of matching documents to Destination. Empty
+// criteria match every document; the first matching rule wins.
+//
+// Destination may use the placeholders {rut} (issuer), {td} (document type)
+// and {receiver}. Relative destinations are resolved against the worker
+// destination directory.
+type RoutingRule struct {
+	Name          string     `json:"name"`
+	Issuer        domain.RUT `json:"issuer"`
+	DocumentTypes []uint8    `json:"documentTypes,omitempty"`
+	Receiver      domain.RUT `json:"receiver"`
+	Destination   string     `json:"destination"`
+}
+
+// Validate checks the config before it replaces the active one.
+func (c RoutingConfig) Validate() error {
+	seen := make(map[domain.RUT]bool)
+	for i, company := range c.Companies {
+		if company.RUT.IsZero() {
+			return fmt.Errorf("company %d: rut is required", i+1)
+		}
+		if seen[company.RUT] {
+			return fmt.Errorf("company %d: duplicated rut %s", i+1, company.RUT)
+		}
+		seen[company.RUT] = true
+	}
+
+	for i, rule := range c.Rules {
+		if strings.TrimSpace(rule.Destination) == "" {
+			return fmt.Errorf("rule %d (%s): destination is required", i+1, rule.Name)
+		}
+	}
+	return nil
+}
+
+func (c RoutingConfig) matches(rule RoutingRule, issuer, receiver domain.RUT, documentType uint8) bool {
+	if !rule.Issuer.IsZero() && rule.Issuer != issuer {
+		return false
+	}
+	if len(rule.DocumentTypes) > 0 && !slices.Contains(rule.DocumentTypes, documentType) {
+		return false
+	}
+	if !rule.Receiver.IsZero() && rule.Receiver != receiver {
+		return false
+	}
+	return true
+}
+
+// Router holds the active routing config and reloads it from its file.
+type Router struct {
+	path   string
+	mu     sync.RWMutex
+	config RoutingConfig
+}
+
+// NewRouter returns a router without companies or rules, which keeps the
+// single global tree of the worker.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// LoadRouter reads the routing config at path.
+func LoadRouter(path string) (*Router, error) {
+	router := &Router{path: path}
+	if _, err := router.Reload(); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Reload reads the config file again. An invalid file leaves the active
+// config untouched.
+func (r *Router) Reload() (RoutingConfig, error) {
+	if r.path == "" {
+		return RoutingConfig{}, ErrRoutingNotConfigured
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return RoutingConfig{}, fmt.Errorf("reading routing config: %w", err)
+	}
+
+	var config RoutingConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return RoutingConfig{}, fmt.Errorf("decoding routing config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return RoutingConfig{}, fmt.Errorf("validating routing config: %w", err)
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+	return config, nil
+}
+
+// Config returns the active config.
+func (r *Router) Config() RoutingConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+// CompanyDirectories returns the per-company subdirectory names.
+func (r *Router) CompanyDirectories() []string {
+	config := r.Config()
+	dirs := make([]string, len(config.Companies))
+	for i, company := range config.Companies {
+		dirs[i] = company.RUT.String()
+	}
+	return dirs
+}
+
+// Destination returns the directory that receives the outputs of invoice.
+// companyDir is the per-company subdirectory the file came from, if any.
+func (r *Router) Destination(root, companyDir string, invoice *domain.Invoice) string {
+	// A missing receiver stays zero and only matches rules without one.
+	issuer := invoice.Issuer.Code
+	var receiver domain.RUT
+	if invoice.Receiver != nil {
+		receiver = invoice.Receiver.Code
+	}
+
+	config := r.Config()
+	for _, rule := range config.Rules {
+		if !config.matches(rule, issuer, receiver, invoice.DocumentType) {
+			continue
+		}
+
+		destination := strings.NewReplacer(
+			"{rut}", issuer.String(),
+			"{td}", strconv.Itoa(int(invoice.DocumentType)),
+			"{receiver}", receiver.String(),
+		).Replace(rule.Destination)
+
+		if filepath.IsAbs(destination) {
+			return filepath.Clean(destination)
+		}
+		return filepath.Join(root, destination)
+	}
+
+	return filepath.Join(root, companyDir)
+}
+
+// Routing returns the active routing config of the worker.
+func (w *FileIntegrationWorker) Routing() RoutingConfig {
+	return w.router.Config()
+}
+
+// ReloadRouting reads the routing config file again and rescans, so inboxes
+// of newly declared companies are picked up right away.
+func (w *FileIntegrationWorker) ReloadRouting() (RoutingConfig, error) {
+	config, err := w.router.Reload()
+	if err != nil {
+		return RoutingConfig{}, err
+	}
+
+	slog.Info("routing config reloaded", "companies", len(config.Companies), "rules", len(config.Rules))
+	w.notify()
+	return config, nil
+}
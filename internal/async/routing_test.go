@@ -0,0 +1,150 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+)
+
+const testRoutingConfig = `{
+  "companies": [{"rut": "76.212.889-6"}],
+  "rules": [
+    {"name": "guias", "issuer": "76212889-6", "documentTypes": [52], "destination": "{rut}/guias"},
+    {"name": "holding", "receiver": "77.371.419-3", "destination": "/srv/holding/{rut}/{td}"}
+  ]
+}`
+
+func writeRoutingConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routing.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write routing config: %v", err)
+	}
+	return path
+}
+
+func TestRouterDestination(t *testing.T) {
+	router, err := LoadRouter(writeRoutingConfig(t, testRoutingConfig))
+	if err != nil {
+		t.Fatalf("LoadRouter failed: %v", err)
+	}
+
+	invoice := func(documentType uint8, receiver string) *domain.Invoice {
+		inv := &domain.Invoice{DocumentType: documentType, Issuer: domain.Company{Code: domain.MustParseRUT("76212889-6")}}
+		if receiver != "" {
+			inv.Receiver = &domain.Company{Code: domain.MustParseRUT(receiver)}
+		}
+		return inv
+	}
+
+	tests := []struct {
+		name       string
+		companyDir string
+		invoice    *domain.Invoice
+		want       string
+	}{
+		{name: "relative rule", companyDir: "76212889-6", invoice: invoice(52, "77371419-3"), want: filepath.Join("/out", "76212889-6", "guias")},
+		{name: "absolute rule", invoice: invoice(33, "77.371.419-3"), want: filepath.Join("/srv/holding", "76212889-6", "33")},
+		{name: "company default", companyDir: "76212889-6", invoice: invoice(39, ""), want: filepath.Join("/out", "76212889-6")},
+		{name: "shared default", invoice: invoice(33, "11111111-1"), want: "/out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.Destination("/out", tt.companyDir, tt.invoice); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if dirs := router.CompanyDirectories(); len(dirs) != 1 || dirs[0] != "76212889-6" {
+		t.Errorf("Expected normalized company directory, got %v", dirs)
+	}
+}
+
+func TestRouterReload_KeepsConfigOnError(t *testing.T) {
+	path := writeRoutingConfig(t, testRoutingConfig)
+	router, err := LoadRouter(path)
+	if err != nil {
+		t.Fatalf("LoadRouter failed: %v", err)
+	}
+
+	invalid := map[string]string{
+		"malformed":      `{"companies": [`,
+		"duplicate rut":  `{"companies": [{"rut": "76212889-6"}, {"rut": "76.212.889-6"}]}`,
+		"traversal":      `{"companies": [{"rut": ".."}]}`,
+		"check digit":    `{"companies": [{"rut": "76212889-5"}]}`,
+		"bad rule rut":   `{"rules": [{"name": "bad", "issuer": "76212889", "destination": "out"}]}`,
+		"no destination": `{"rules": [{"name": "empty"}]}`,
+	}
+	for name, data := range invalid {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write routing config: %v", err)
+		}
+		if _, err := router.Reload(); err == nil {
+			t.Errorf("%s: expected reload to fail", name)
+		}
+		if config := router.Config(); len(config.Companies) != 1 || len(config.Rules) != 2 {
+			t.Errorf("%s: expected previous config to stay active, got %+v", name, config)
+		}
+	}
+
+	if _, err := NewRouter().Reload(); !errors.Is(err, ErrRoutingNotConfigured) {
+		t.Errorf("Expected ErrRoutingNotConfigured, got %v", err)
+	}
+}
+
+func TestProcessDocument_CompanyInbox(t *testing.T) {
+	worker, _ := newRecoveryTestWorker(t)
+	router, err := LoadRouter(writeRoutingConfig(t, `{"companies": [{"rut": "76212889-6"}, {"rut": "11111111-1"}]}`))
+	if err != nil {
+		t.Fatalf("LoadRouter failed: %v", err)
+	}
+	worker.WithRouter(router)
+	if err := worker.ensureDirectoriesExist(); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+
+	sourceFile := filepath.Join(worker.sourceDirectory, "76212889-6", "invoice.xml")
+	writeInvoiceFile(t, sourceFile)
+
+	files, err := worker.getSourceFiles()
+	if err != nil || len(files) != 1 || files[0] != sourceFile {
+		t.Fatalf("Expected company inbox to be scanned, got %v (%v)", files, err)
+	}
+
+	result := worker.processDocument(context.Background(), sourceFile)
+	if result.Error != nil {
+		t.Fatalf("processDocument failed: %v", result.Error)
+	}
+	if _, err := os.Stat(filepath.Join(worker.destinationDirectory, "76212889-6", "invoice_stamp.xml")); err != nil {
+		t.Errorf("Expected outputs in the company outbox: %v", err)
+	}
+
+	// A file dropped in the inbox of another company is rejected.
+	otherFile := filepath.Join(worker.sourceDirectory, "11111111-1", "invoice.xml")
+	writeInvoiceFile(t, otherFile)
+	result = worker.processDocument(context.Background(), otherFile)
+	if usecases.FailedStage(result.Error) != usecases.StageParse {
+		t.Fatalf("Expected parse stage failure for mismatched issuer, got %v", result.Error)
+	}
+
+	failed, err := worker.ListFailed()
+	if err != nil || len(failed) != 1 || failed[0].Name != "11111111-1/invoice.xml" {
+		t.Fatalf("Expected failed file listed under its company, got %+v (%v)", failed, err)
+	}
+	if err := worker.Requeue("11111111-1/invoice.xml"); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+	if _, err := os.Stat(otherFile); err != nil {
+		t.Errorf("Expected requeued file back in the company inbox: %v", err)
+	}
+	if err := worker.Requeue("../invoice.xml"); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("Expected ErrInvalidFileName, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// sourceWatcher notifies the worker as soon as something changes in a
+// watched directory, so files are picked up without waiting for the next
+// polling tick.
+type sourceWatcher struct {
+	watcher *fsnotify.Watcher
+}
+
+func newSourceWatcher(directories ...string) (*sourceWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating fsnotify watcher: %w", err)
+	}
+
+	for _, dir := range directories {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("watching directory %s: %w", dir, err)
+		}
+	}
+
+	return &sourceWatcher{watcher: watcher}, nil
+}
+
+// Run forwards filesystem events to notify until the context is cancelled.
+func (s *sourceWatcher) Run(ctx context.Context, notify func()) {
+	defer s.watcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Chmod) {
+				slog.Debug("source directory changed", "file", event.Name, "op", event.Op.String())
+				notify()
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("source directory watcher error", "error", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package async
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"factura-movil-gateway/internal/usecases"
+)
+
+const _recentResultsLimit = 50
+
+// ErrWorkerPaused is returned when an operation requires the worker to be running.
+var ErrWorkerPaused = errors.New("worker is paused")
+
+// WorkerStatus is a point-in-time snapshot of the file integration worker.
+type WorkerStatus struct {
+	Paused        bool                   `json:"paused"`
+	BatchRunning  bool                   `json:"batchRunning"`
+	LastTick      *time.Time             `json:"lastTick,omitempty"`
+	QueueDepth    int                    `json:"queueDepth"`
+	InProgress    []InProgressFile       `json:"inProgress"`
+	SuccessCount  uint64                 `json:"successCount"`
+	ErrorCount    uint64                 `json:"errorCount"`
+	RecentResults []FileProcessingResult `json:"recentResults"`
+}
+
+// InProgressFile is a file currently being processed.
+type InProgressFile struct {
+	File      string    `json:"file"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// statusTracker keeps the counters and recent history exposed by Status and
+// mirrors them into the Prometheus metrics.
+type statusTracker struct {
+	mu           sync.Mutex
+	lastTick     time.Time
+	queueDepth   int
+	inProgress   map[string]time.Time
+	successCount uint64
+	errorCount   uint64
+	recent       []FileProcessingResult
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		inProgress: make(map[string]time.Time),
+	}
+}
+
+func (t *statusTracker) tickStarted(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastTick = now
+	lastTickTimestamp.Set(float64(now.Unix()))
+}
+
+func (t *statusTracker) queued(count int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.queueDepth = count
+	queueDepth.Set(float64(count))
+}
+
+func (t *statusTracker) started(file string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inProgress[file] = time.Now()
+	if t.queueDepth > 0 {
+		t.queueDepth--
+	}
+	queueDepth.Set(float64(t.queueDepth))
+	filesInProgress.Set(float64(len(t.inProgress)))
+}
+
+func (t *statusTracker) finished(file string, result *FileProcessingResult) {
+	result.FinishedAt = time.Now()
+	outcome := "success"
+	if result.Error != nil {
+		outcome = "failure"
+		result.Stage = usecases.FailedStage(result.Error)
+		result.ErrorMessage = result.Error.Error()
+		documentFailures.WithLabelValues(result.Stage).Inc()
+	}
+	documentsProcessed.WithLabelValues(outcome).Inc()
+	processingDuration.WithLabelValues(outcome).Observe(result.ProcessingTime.Seconds())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.inProgress, file)
+	filesInProgress.Set(float64(len(t.inProgress)))
+
+	if result.Error != nil {
+		t.errorCount++
+	} else {
+		t.successCount++
+	}
+
+	t.recent = append(t.recent, *result)
+	if len(t.recent) > _recentResultsLimit {
+		t.recent = t.recent[len(t.recent)-_recentResultsLimit:]
+	}
+}
+
+func (t *statusTracker) snapshot() WorkerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := WorkerStatus{
+		QueueDepth:    t.queueDepth,
+		InProgress:    make([]InProgressFile, 0, len(t.inProgress)),
+		SuccessCount:  t.successCount,
+		ErrorCount:    t.errorCount,
+		RecentResults: make([]FileProcessingResult, len(t.recent)),
+	}
+	if !t.lastTick.IsZero() {
+		lastTick := t.lastTick
+		status.LastTick = &lastTick
+	}
+
+	for file, startedAt := range t.inProgress {
+		status.InProgress = append(status.InProgress, InProgressFile{File: file, StartedAt: startedAt})
+	}
+	sort.Slice(status.InProgress, func(i, j int) bool {
+		return status.InProgress[i].StartedAt.Before(status.InProgress[j].StartedAt)
+	})
+
+	for i, result := range t.recent {
+		status.RecentResults[len(t.recent)-1-i] = result
+	}
+
+	return status
+}
+
+// Status returns a snapshot of the worker state, most recent results first.
+func (w *FileIntegrationWorker) Status() WorkerStatus {
+	status := w.status.snapshot()
+	status.Paused = w.paused.Load()
+	status.BatchRunning = w.batchRunning.Load()
+	return status
+}
+
+// Pause stops the worker from claiming new files. Documents already being
+// processed are allowed to finish.
+func (w *FileIntegrationWorker) Pause() {
+	if !w.paused.Swap(true) {
+		slog.Info("file integration worker paused")
+	}
+}
+
+// Resume lets a paused worker claim files again and triggers a scan.
+func (w *FileIntegrationWorker) Resume() {
+	if w.paused.Swap(false) {
+		slog.Info("file integration worker resumed")
+	}
+	w.notify()
+}
+
+// Trigger requests an immediate scan of the source directory.
+func (w *FileIntegrationWorker) Trigger() error {
+	if w.paused.Load() {
+		return ErrWorkerPaused
+	}
+	w.notify()
+	return nil
+}
@@ -0,0 +1,67 @@
+package async
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/usecases"
+)
+
+func TestStatusTracker(t *testing.T) {
+	tracker := newStatusTracker()
+	tracker.tickStarted(time.Now())
+	tracker.queued(2)
+
+	tracker.started("a.xml")
+	tracker.started("b.xml")
+	status := tracker.snapshot()
+	if status.QueueDepth != 0 || len(status.InProgress) != 2 {
+		t.Fatalf("Unexpected status while processing: %+v", status)
+	}
+
+	tracker.finished("a.xml", &FileProcessingResult{OriginalFile: "a.xml"})
+	tracker.finished("b.xml", &FileProcessingResult{
+		OriginalFile: "b.xml",
+		Error:        usecases.NewStageError(usecases.StageStamp, errors.New("no CAF available")),
+	})
+
+	status = tracker.snapshot()
+	if status.SuccessCount != 1 || status.ErrorCount != 1 || len(status.InProgress) != 0 {
+		t.Fatalf("Unexpected counters: %+v", status)
+	}
+	if status.LastTick == nil {
+		t.Error("Expected last tick to be set")
+	}
+	if len(status.RecentResults) != 2 || status.RecentResults[0].OriginalFile != "b.xml" {
+		t.Fatalf("Expected most recent result first, got %+v", status.RecentResults)
+	}
+	if status.RecentResults[0].Stage != usecases.StageStamp {
+		t.Errorf("Expected stage %q, got %q", usecases.StageStamp, status.RecentResults[0].Stage)
+	}
+}
+
+func TestPauseResumeTrigger(t *testing.T) {
+	worker := newTestWorker(t)
+
+	worker.Pause()
+	if !worker.Status().Paused {
+		t.Fatal("Expected worker to be paused")
+	}
+	if err := worker.Trigger(); !errors.Is(err, ErrWorkerPaused) {
+		t.Errorf("Expected ErrWorkerPaused, got %v", err)
+	}
+
+	worker.Resume()
+	if worker.Status().Paused {
+		t.Fatal("Expected worker to be running")
+	}
+	select {
+	case <-worker.trigger:
+	default:
+		t.Error("Expected resume to request a scan")
+	}
+	if err := worker.Trigger(); err != nil {
+		t.Errorf("Trigger failed: %v", err)
+	}
+}
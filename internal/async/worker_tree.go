@@ -0,0 +1,100 @@
+package async
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// treeFile is a file inside one of the worker directories. Its name is
+// relative to that directory: "invoice.xml" for the shared tree, or
+// "{rut}/invoice.xml" for a company with its own tree. The same name is used
+// in every directory the file goes through.
+type treeFile struct {
+	name string
+	path string
+	info os.FileInfo
+}
+
+// listTree returns the files in root and in its immediate subdirectories.
+// Hidden entries are skipped.
+func listTree(root string) ([]treeFile, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []treeFile
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				files = append(files, treeFile{name: entry.Name(), path: filepath.Join(root, entry.Name()), info: info})
+			}
+			continue
+		}
+
+		subdir := filepath.Join(root, entry.Name())
+		subEntries, err := os.ReadDir(subdir)
+		if err != nil {
+			return nil, err
+		}
+		for _, subEntry := range subEntries {
+			if subEntry.IsDir() || strings.HasPrefix(subEntry.Name(), ".") {
+				continue
+			}
+			if info, err := subEntry.Info(); err == nil {
+				files = append(files, treeFile{
+					name: filepath.Join(entry.Name(), subEntry.Name()),
+					path: filepath.Join(subdir, subEntry.Name()),
+					info: info,
+				})
+			}
+		}
+	}
+	return files, nil
+}
+
+// relativeName returns the name of path inside root, or its base name when
+// path is not inside root.
+func relativeName(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// companyDirOf returns the company subdirectory of a relative name, or an
+// empty string for files of the shared tree.
+func companyDirOf(name string) string {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// validateRelativeName makes sure an operator supplied name is either a
+// plain file name or "{company}/{file}", without escaping the worker
+// directories.
+func validateRelativeName(name string) error {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	for _, part := range parts {
+		if err := validateFileName(part); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+		}
+	}
+	return nil
+}
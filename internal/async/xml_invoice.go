@@ -21,12 +21,13 @@ type DTEDocument struct {
 }
 
 type XMLDocument struct {
-	XMLName   xml.Name    `xml:"Documento"`
-	ID        string      `xml:"ID,attr"`
-	Header    XMLHeader   `xml:"Encabezado"`
-	Details   []XMLDetail `xml:"Detalle"`
-	TED       XMLTED      `xml:"TED"`
-	Timestamp string      `xml:"TmstFirma"`
+	XMLName   xml.Name       `xml:"Documento"`
+	ID        string         `xml:"ID,attr"`
+	Header    XMLHeader      `xml:"Encabezado"`
+	Details   []XMLDetail    `xml:"Detalle"`
+	Refs      []XMLReference `xml:"Referencia"`
+	TED       XMLTED         `xml:"TED"`
+	Timestamp string         `xml:"TmstFirma"`
 }
 
 type XMLHeader struct {
@@ -47,45 +48,59 @@ type XMLDocInfo struct {
 }
 
 type XMLIssuer struct {
-	XMLName      xml.Name `xml:"Emisor"`
-	RUT          string   `xml:"RUTEmisor"`
-	CompanyName  string   `xml:"RznSoc"`
-	BusinessLine string   `xml:"GiroEmis"`
-	Email        string   `xml:"CorreoEmisor"`
-	Activities   []string `xml:"Acteco"`
-	Address      string   `xml:"DirOrigen"`
-	Commune      string   `xml:"CmnaOrigen"`
-	City         string   `xml:"CiudadOrigen"`
+	XMLName      xml.Name   `xml:"Emisor"`
+	RUT          domain.RUT `xml:"RUTEmisor"`
+	CompanyName  string     `xml:"RznSoc"`
+	BusinessLine string     `xml:"GiroEmis"`
+	Email        string     `xml:"CorreoEmisor"`
+	Activities   []string   `xml:"Acteco"`
+	Address      string     `xml:"DirOrigen"`
+	Commune      string     `xml:"CmnaOrigen"`
+	City         string     `xml:"CiudadOrigen"`
+	BranchCode   uint64     `xml:"CdgSIISucur"`
 }
 
 type XMLReceiver struct {
-	XMLName      xml.Name `xml:"Receptor"`
-	RUT          string   `xml:"RUTRecep"`
-	CompanyName  string   `xml:"RznSocRecep"`
-	BusinessLine string   `xml:"GiroRecep"`
-	Address      string   `xml:"DirRecep"`
-	Commune      string   `xml:"CmnaRecep"`
-	City         string   `xml:"CiudadRecep"`
+	XMLName      xml.Name   `xml:"Receptor"`
+	RUT          domain.RUT `xml:"RUTRecep"`
+	CompanyName  string     `xml:"RznSocRecep"`
+	BusinessLine string     `xml:"GiroRecep"`
+	Address      string     `xml:"DirRecep"`
+	Commune      string     `xml:"CmnaRecep"`
+	City         string     `xml:"CiudadRecep"`
 }
 
 type XMLTotals struct {
-	XMLName   xml.Name `xml:"Totales"`
-	NetAmount float64  `xml:"MntNeto"`
-	TaxRate   float64  `xml:"TasaIVA"`
-	TaxAmount float64  `xml:"IVA"`
-	Total     float64  `xml:"MntTotal"`
+	XMLName   xml.Name       `xml:"Totales"`
+	NetAmount domain.Amount  `xml:"MntNeto"`
+	TaxRate   domain.Decimal `xml:"TasaIVA"`
+	TaxAmount domain.Amount  `xml:"IVA"`
+	Total     domain.Amount  `xml:"MntTotal"`
 }
 
 type XMLDetail struct {
-	XMLName     xml.Name    `xml:"Detalle"`
-	LineNumber  int         `xml:"NroLinDet"`
-	ItemCode    XMLItemCode `xml:"CdgItem"`
-	ItemName    string      `xml:"NmbItem"`
-	Description string      `xml:"DscItem"`
-	Quantity    float64     `xml:"QtyItem"`
-	Unit        string      `xml:"UnmdItem"`
-	UnitPrice   float64     `xml:"PrcItem"`
-	LineTotal   float64     `xml:"MontoItem"`
+	XMLName     xml.Name       `xml:"Detalle"`
+	LineNumber  int            `xml:"NroLinDet"`
+	ItemCode    XMLItemCode    `xml:"CdgItem"`
+	ItemName    string         `xml:"NmbItem"`
+	Description string         `xml:"DscItem"`
+	Quantity    domain.Decimal `xml:"QtyItem"`
+	Unit        string         `xml:"UnmdItem"`
+	UnitPrice   domain.Decimal `xml:"PrcItem"`
+	LineTotal   domain.Amount  `xml:"MontoItem"`
+	// ExemptIndicator is IndExe; 1 marks a line not subject to IVA.
+	ExemptIndicator    int      `xml:"IndExe"`
+	AdditionalTaxCodes []string `xml:"CodImpAdic"`
+}
+
+type XMLReference struct {
+	XMLName      xml.Name `xml:"Referencia"`
+	LineNumber   int      `xml:"NroLinRef"`
+	DocumentType string   `xml:"TpoDocRef"`
+	Folio        string   `xml:"FolioRef"`
+	Date         string   `xml:"FchRef"`
+	Code         int      `xml:"CodRef"`
+	Reason       string   `xml:"RazonRef"`
 }
 
 type XMLItemCode struct {
@@ -152,17 +167,22 @@ func (dte *DTEDocument) ToInvoice() (*domain.Invoice, error) {
 		DocumentType: doc.Header.DocInfo.DocumentType,
 		Folio:        doc.Header.DocInfo.Folio,
 		IssueDate:    issueDate,
+		InternalID:   doc.ID,
+		BranchCode:   doc.Header.Issuer.BranchCode,
 		Issuer: domain.Company{
-			Code:    doc.Header.Issuer.RUT,
-			Name:    doc.Header.Issuer.CompanyName,
-			Address: formatAddress(doc.Header.Issuer.Address, doc.Header.Issuer.Commune, doc.Header.Issuer.City),
+			Code:         doc.Header.Issuer.RUT,
+			Name:         doc.Header.Issuer.CompanyName,
+			Address:      formatAddress(doc.Header.Issuer.Address, doc.Header.Issuer.Commune, doc.Header.Issuer.City),
+			BusinessLine: doc.Header.Issuer.BusinessLine,
 		},
 		Receiver: &domain.Company{
-			Code:    doc.Header.Receiver.RUT,
-			Name:    doc.Header.Receiver.CompanyName,
-			Address: formatAddress(doc.Header.Receiver.Address, doc.Header.Receiver.Commune, doc.Header.Receiver.City),
+			Code:         doc.Header.Receiver.RUT,
+			Name:         doc.Header.Receiver.CompanyName,
+			Address:      formatAddress(doc.Header.Receiver.Address, doc.Header.Receiver.Commune, doc.Header.Receiver.City),
+			BusinessLine: doc.Header.Receiver.BusinessLine,
 		},
-		Details: convertXMLDetails(doc.Details),
+		Details:    convertXMLDetails(doc.Details),
+		References: convertXMLReferences(doc.Refs),
 		Totals: domain.InvoiceTotals{
 			TaxableAmount: doc.Header.Totals.NetAmount,
 			TaxAmount:     doc.Header.Totals.TaxAmount,
@@ -198,11 +218,30 @@ func convertXMLDetails(xmlDetails []XMLDetail) []domain.InvoiceDetail {
 		}
 
 		details[i] = domain.InvoiceDetail{
-			Quantity:    detail.Quantity,
-			Description: description,
-			UnitPrice:   detail.UnitPrice,
-			LineTotal:   detail.LineTotal,
+			Quantity:           detail.Quantity,
+			Description:        description,
+			UnitPrice:          detail.UnitPrice,
+			LineTotal:          detail.LineTotal,
+			CodeType:           detail.ItemCode.CodeType,
+			Code:               detail.ItemCode.CodeValue,
+			Unit:               detail.Unit,
+			Exempt:             detail.ExemptIndicator == 1,
+			AdditionalTaxCodes: detail.AdditionalTaxCodes,
 		}
 	}
 	return details
 }
+
+func convertXMLReferences(refs []XMLReference) []domain.InvoiceReference {
+	references := make([]domain.InvoiceReference, 0, len(refs))
+	for _, ref := range refs {
+		references = append(references, domain.InvoiceReference{
+			DocumentType: ref.DocumentType,
+			Folio:        ref.Folio,
+			Date:         ref.Date,
+			Code:         ref.Code,
+			Reason:       ref.Reason,
+		})
+	}
+	return references
+}
@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"factura-movil-gateway/internal/domain"
 )
 
 func TestParseDTEXML(t *testing.T) {
@@ -86,7 +88,7 @@ func TestParseDTEXML(t *testing.T) {
 		t.Errorf("Expected issue date '2025-05-05', got '%s'", doc.Header.DocInfo.IssueDate)
 	}
 
-	if doc.Header.Issuer.RUT != "76212889-6" {
+	if doc.Header.Issuer.RUT.String() != "76212889-6" {
 		t.Errorf("Expected issuer RUT '76212889-6', got '%s'", doc.Header.Issuer.RUT)
 	}
 
@@ -94,7 +96,7 @@ func TestParseDTEXML(t *testing.T) {
 		t.Errorf("Expected issuer name 'FACTURA MOVIL SPA', got '%s'", doc.Header.Issuer.CompanyName)
 	}
 
-	if doc.Header.Receiver.RUT != "77371419-3" {
+	if doc.Header.Receiver.RUT.String() != "77371419-3" {
 		t.Errorf("Expected receiver RUT '77371419-3', got '%s'", doc.Header.Receiver.RUT)
 	}
 
@@ -103,15 +105,15 @@ func TestParseDTEXML(t *testing.T) {
 	}
 
 	if doc.Header.Totals.NetAmount != 35197 {
-		t.Errorf("Expected net amount 35197, got %f", doc.Header.Totals.NetAmount)
+		t.Errorf("Expected net amount 35197, got %v", doc.Header.Totals.NetAmount)
 	}
 
 	if doc.Header.Totals.TaxAmount != 6687 {
-		t.Errorf("Expected tax amount 6687, got %f", doc.Header.Totals.TaxAmount)
+		t.Errorf("Expected tax amount 6687, got %v", doc.Header.Totals.TaxAmount)
 	}
 
 	if doc.Header.Totals.Total != 41884 {
-		t.Errorf("Expected total 41884, got %f", doc.Header.Totals.Total)
+		t.Errorf("Expected total 41884, got %v", doc.Header.Totals.Total)
 	}
 
 	if len(doc.Details) != 1 {
@@ -131,16 +133,16 @@ func TestParseDTEXML(t *testing.T) {
 		t.Errorf("Expected description 'Abril 2025', got '%s'", detail.Description)
 	}
 
-	if detail.Quantity != 0.90 {
-		t.Errorf("Expected quantity 0.90, got %f", detail.Quantity)
+	if detail.Quantity != domain.MustParseDecimal("0.90") {
+		t.Errorf("Expected quantity 0.90, got %v", detail.Quantity)
 	}
 
-	if detail.UnitPrice != 39107.900000 {
-		t.Errorf("Expected unit price 39107.900000, got %f", detail.UnitPrice)
+	if detail.UnitPrice != domain.MustParseDecimal("39107.900000") {
+		t.Errorf("Expected unit price 39107.900000, got %v", detail.UnitPrice)
 	}
 
 	if detail.LineTotal != 35197 {
-		t.Errorf("Expected line total 35197, got %f", detail.LineTotal)
+		t.Errorf("Expected line total 35197, got %v", detail.LineTotal)
 	}
 }
 
@@ -166,6 +168,7 @@ func TestDTEToInvoice(t *testing.T) {
         <DirOrigen>Vicuña Mackenna 9705</DirOrigen>
         <CmnaOrigen>La Florida</CmnaOrigen>
         <CiudadOrigen>Santiago</CiudadOrigen>
+        <CdgSIISucur>81234567</CdgSIISucur>
       </Emisor>
       <Receptor>
         <RUTRecep>77371419-3</RUTRecep>
@@ -221,7 +224,7 @@ func TestDTEToInvoice(t *testing.T) {
 		t.Errorf("Expected issue date %v, got %v", expectedDate, invoice.IssueDate)
 	}
 
-	if invoice.Issuer.Code != "76212889-6" {
+	if invoice.Issuer.Code.String() != "76212889-6" {
 		t.Errorf("Expected issuer code '76212889-6', got '%s'", invoice.Issuer.Code)
 	}
 
@@ -233,11 +236,15 @@ func TestDTEToInvoice(t *testing.T) {
 		t.Errorf("Expected issuer address to contain 'Mackenna 9705', got '%s'", invoice.Issuer.Address)
 	}
 
+	if invoice.BranchCode != 81234567 {
+		t.Errorf("Expected branch code 81234567, got %d", invoice.BranchCode)
+	}
+
 	if invoice.Receiver == nil {
 		t.Fatal("Expected receiver to be set")
 	}
 
-	if invoice.Receiver.Code != "77371419-3" {
+	if invoice.Receiver.Code.String() != "77371419-3" {
 		t.Errorf("Expected receiver code '77371419-3', got '%s'", invoice.Receiver.Code)
 	}
 
@@ -260,32 +267,39 @@ func TestDTEToInvoice(t *testing.T) {
 		t.Errorf("Expected description '%s', got '%s'", expectedDescription, detail.Description)
 	}
 
-	if detail.Quantity != 0.90 {
-		t.Errorf("Expected quantity 0.90, got %f", detail.Quantity)
+	if detail.Quantity != domain.MustParseDecimal("0.90") {
+		t.Errorf("Expected quantity 0.90, got %v", detail.Quantity)
 	}
 
-	if detail.UnitPrice != 39107.900000 {
-		t.Errorf("Expected unit price 39107.900000, got %f", detail.UnitPrice)
+	if detail.UnitPrice != domain.MustParseDecimal("39107.900000") {
+		t.Errorf("Expected unit price 39107.900000, got %v", detail.UnitPrice)
 	}
 
 	if detail.LineTotal != 35197 {
-		t.Errorf("Expected line total 35197, got %f", detail.LineTotal)
+		t.Errorf("Expected line total 35197, got %v", detail.LineTotal)
+	}
+
+	if detail.CodeType != "Interna" || detail.Code != "EMP21" {
+		t.Errorf("Expected item code Interna/EMP21, got '%s/%s'", detail.CodeType, detail.Code)
+	}
+
+	if detail.Unit != "Unid" {
+		t.Errorf("Expected unit 'Unid', got '%s'", detail.Unit)
+	}
+
+	if detail.Exempt {
+		t.Error("Expected detail without IndExe to be taxed")
 	}
 
 	if invoice.Totals.TaxableAmount != 35197 {
-		t.Errorf("Expected taxable amount 35197, got %f", invoice.Totals.TaxableAmount)
+		t.Errorf("Expected taxable amount 35197, got %v", invoice.Totals.TaxableAmount)
 	}
 
 	if invoice.Totals.TaxAmount != 6687 {
-		t.Errorf("Expected tax amount 6687, got %f", invoice.Totals.TaxAmount)
+		t.Errorf("Expected tax amount 6687, got %v", invoice.Totals.TaxAmount)
 	}
 
 	if invoice.Totals.TotalAmount != 41884 {
-		t.Errorf("Expected total amount 41884, got %f", invoice.Totals.TotalAmount)
-	}
-
-	total := invoice.CalculateTotal()
-	if total != 41884 {
-		t.Errorf("Expected calculated total 41884, got %d", total)
+		t.Errorf("Expected total amount 41884, got %v", invoice.Totals.TotalAmount)
 	}
 }
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/httpserver"
+	"factura-movil-gateway/internal/usecases"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	_listBranchesError     = "failed to list branches"
+	_saveBranchError       = "failed to save branch"
+	_deleteBranchError     = "failed to delete branch"
+	_branchCompanyNotFound = "company not found"
+	_branchNotFound        = "branch not found"
+	_invalidBranchCode     = "branch code must be the SII branch code"
+)
+
+func NewBranchController(branchService usecases.BranchService, companyService usecases.CompanyService) *BranchController {
+	return &BranchController{
+		branchService:  branchService,
+		companyService: companyService,
+	}
+}
+
+// BranchController manages the sucursales of a company. Branches are
+// addressed by their SII code (CdgSIISucur).
+type BranchController struct {
+	branchService  usecases.BranchService
+	companyService usecases.CompanyService
+}
+
+func (c *BranchController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /companies/{id}/branches", c.list())
+	mux.Handle("POST /companies/{id}/branches", c.create())
+	mux.Handle("GET /companies/{id}/branches/{code}", c.get())
+	mux.Handle("PUT /companies/{id}/branches/{code}", c.update())
+	mux.Handle("DELETE /companies/{id}/branches/{code}", c.delete())
+}
+
+// findCompany replies with 404 and returns false when the company of the
+// request does not exist.
+func (c *BranchController) findCompany(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := c.companyService.FindByID(r.Context(), id); err != nil {
+		slog.Error("failed to find company", slog.String("Error", err.Error()), slog.String("id", id))
+		httpserver.ReplyWithError(w, http.StatusNotFound, _branchCompanyNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+// branchCode replies with 400 and returns false when the code of the path is
+// not a number.
+func branchCode(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	code, err := strconv.ParseUint(r.PathValue("code"), 10, 64)
+	if err != nil {
+		httpserver.ReplyWithError(w, http.StatusBadRequest, _invalidBranchCode)
+		return 0, false
+	}
+	return code, true
+}
+
+func (c *BranchController) list() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		branches, err := c.branchService.List(r.Context(), id)
+		if err != nil {
+			slog.Error("failed to list branches", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _listBranchesError)
+			return
+		}
+
+		response := make([]BranchResponse, len(branches))
+		for i, branch := range branches {
+			response[i] = newBranchResponse(branch)
+		}
+		httpserver.ReplyJSONResponse(w, http.StatusOK, response)
+	}
+}
+
+func (c *BranchController) get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+		code, ok := branchCode(w, r)
+		if !ok {
+			return
+		}
+
+		branch, err := c.branchService.FindByCode(r.Context(), id, code)
+		if err != nil {
+			if errors.Is(err, usecases.ErrBranchNotFound) {
+				httpserver.ReplyWithError(w, http.StatusNotFound, _branchNotFound)
+				return
+			}
+			slog.Error("failed to get branch", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _listBranchesError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newBranchResponse(branch))
+	}
+}
+
+func (c *BranchController) create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body BranchRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveBranchError)
+			return
+		}
+
+		branch, err := c.branchService.Create(r.Context(), body.toBranch(id, body.Code))
+		if err != nil {
+			slog.Error("failed to create branch", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyBranchError(w, err)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusCreated, newBranchResponse(branch))
+	}
+}
+
+// update replaces the branch; the code in the path wins over the body's.
+func (c *BranchController) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+		code, ok := branchCode(w, r)
+		if !ok {
+			return
+		}
+
+		var body BranchRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveBranchError)
+			return
+		}
+
+		branch, err := c.branchService.Update(r.Context(), body.toBranch(id, code))
+		if err != nil {
+			slog.Error("failed to update branch", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyBranchError(w, err)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newBranchResponse(branch))
+	}
+}
+
+func (c *BranchController) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+		code, ok := branchCode(w, r)
+		if !ok {
+			return
+		}
+
+		if err := c.branchService.Delete(r.Context(), id, code); err != nil {
+			if errors.Is(err, usecases.ErrBranchNotFound) {
+				httpserver.ReplyWithError(w, http.StatusNotFound, _branchNotFound)
+				return
+			}
+			if errors.Is(err, usecases.ErrBranchInUse) {
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+				return
+			}
+			slog.Error("failed to delete branch", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _deleteBranchError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *BranchController) replyBranchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, usecases.ErrInvalidBranch):
+		httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, usecases.ErrBranchExists):
+		httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, usecases.ErrBranchNotFound):
+		httpserver.ReplyWithError(w, http.StatusNotFound, _branchNotFound)
+	default:
+		httpserver.ReplyWithError(w, http.StatusInternalServerError, _saveBranchError)
+	}
+}
+
+type BranchRequest struct {
+	Code    uint64 `json:"code"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Commune string `json:"commune"`
+	City    string `json:"city"`
+	// PrinterPaperWidth is 58 or 80, or 0 for the gateway default.
+	PrinterPaperWidth   int  `json:"printer_paper_width"`
+	PrinterRasterPDF417 bool `json:"printer_raster_pdf417"`
+}
+
+func (b BranchRequest) toBranch(companyID string, code uint64) domain.Branch {
+	return domain.Branch{
+		CompanyID:           companyID,
+		Code:                code,
+		Name:                b.Name,
+		Address:             b.Address,
+		Commune:             b.Commune,
+		City:                b.City,
+		PrinterPaperWidth:   b.PrinterPaperWidth,
+		PrinterRasterPDF417: b.PrinterRasterPDF417,
+	}
+}
+
+type BranchResponse struct {
+	ID                  string    `json:"id"`
+	CompanyID           string    `json:"company_id"`
+	Code                uint64    `json:"code"`
+	Name                string    `json:"name"`
+	Address             string    `json:"address"`
+	Commune             string    `json:"commune"`
+	City                string    `json:"city"`
+	PrinterPaperWidth   int       `json:"printer_paper_width,omitempty"`
+	PrinterRasterPDF417 bool      `json:"printer_raster_pdf417"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+func newBranchResponse(branch domain.Branch) BranchResponse {
+	return BranchResponse{
+		ID:                  branch.ID,
+		CompanyID:           branch.CompanyID,
+		Code:                branch.Code,
+		Name:                branch.Name,
+		Address:             branch.Address,
+		Commune:             branch.Commune,
+		City:                branch.City,
+		PrinterPaperWidth:   branch.PrinterPaperWidth,
+		PrinterRasterPDF417: branch.PrinterRasterPDF417,
+		UpdatedAt:           branch.UpdatedAt,
+	}
+}
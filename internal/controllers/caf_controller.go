@@ -3,6 +3,7 @@ package controllers
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"factura-movil-gateway/internal/datatypes"
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/httpserver"
@@ -10,6 +11,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/html/charset"
@@ -18,6 +20,7 @@ import (
 const (
 	createCAFError           = "failed to create CAF"
 	_cafCompanyNotFoundError = "company not found"
+	_cafBranchNotFoundError  = "branch not found"
 
 	_sixMonths = time.Hour * 24 * 30 * 6
 )
@@ -32,6 +35,14 @@ func NewCAFController(cafService usecases.CAFService, companyService usecases.Co
 type CAFController struct {
 	cafService     usecases.CAFService
 	companyService usecases.CompanyService
+	branchService  usecases.BranchService
+}
+
+// WithBranches lets a CAF be uploaded to the pool of a single branch with
+// ?branch=<code>; without it every CAF goes to the shared pool.
+func (c *CAFController) WithBranches(branchService usecases.BranchService) *CAFController {
+	c.branchService = branchService
+	return c
 }
 
 func (c *CAFController) AddRoutes(mux *http.ServeMux) {
@@ -62,6 +73,15 @@ func (c *CAFController) create() http.HandlerFunc {
 			return
 		}
 
+		var branchID string
+		if code := r.URL.Query().Get("branch"); code != "" {
+			branch, ok := c.findBranch(w, r, company.ID, code)
+			if !ok {
+				return
+			}
+			branchID = branch.ID
+		}
+
 		var body cafXML
 		rawData, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -89,6 +109,7 @@ func (c *CAFController) create() http.HandlerFunc {
 		caf, err := domain.NewCAFBuilder().
 			WithRaw(rawData).
 			WithCompanyID(company.ID).
+			WithBranchID(branchID).
 			WithCompanyCode(body.CAF.DA.RE).
 			WithCompanyName(body.CAF.DA.RS).
 			WithDocumentType(body.CAF.DA.TD).
@@ -110,6 +131,10 @@ func (c *CAFController) create() http.HandlerFunc {
 		err = c.cafService.Create(r.Context(), *company, caf)
 		if err != nil {
 			slog.Error("failed to create CAF", slog.String("Error", err.Error()))
+			if errors.Is(err, domain.ErrInvalidRUT) || errors.Is(err, usecases.ErrCAFCompanyMismatch) {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			httpserver.ReplyWithError(w, http.StatusInternalServerError, createCAFError)
 			return
 		}
@@ -118,6 +143,27 @@ func (c *CAFController) create() http.HandlerFunc {
 	}
 }
 
+// findBranch replies with an error and returns false when code is not a
+// branch of the company.
+func (c *CAFController) findBranch(w http.ResponseWriter, r *http.Request, companyID, code string) (domain.Branch, bool) {
+	branchCode, err := strconv.ParseUint(code, 10, 64)
+	if err != nil || c.branchService == nil {
+		httpserver.ReplyWithError(w, http.StatusBadRequest, _invalidBranchCode)
+		return domain.Branch{}, false
+	}
+	branch, err := c.branchService.FindByCode(r.Context(), companyID, branchCode)
+	if err != nil {
+		slog.Error("failed to find branch", slog.String("Error", err.Error()), slog.String("companyId", companyID))
+		if errors.Is(err, usecases.ErrBranchNotFound) {
+			httpserver.ReplyWithError(w, http.StatusUnprocessableEntity, _cafBranchNotFoundError)
+			return domain.Branch{}, false
+		}
+		httpserver.ReplyWithError(w, http.StatusInternalServerError, createCAFError)
+		return domain.Branch{}, false
+	}
+	return branch, true
+}
+
 func (c *CAFController) list() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		companyId := r.PathValue("companyId")
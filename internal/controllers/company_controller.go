@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/httpserver"
 	"factura-movil-gateway/internal/usecases"
@@ -60,21 +61,21 @@ func (c *CompanyController) create() http.HandlerFunc {
 			Build()
 		if err != nil {
 			slog.Error("failed to build domain company", slog.String("Error", err.Error()))
-			httpserver.ReplyWithError(w, http.StatusInternalServerError, _createCompanyError)
+			replyCompanyError(w, err, _createCompanyError)
 			return
 		}
 
 		err = c.service.Save(r.Context(), company)
 		if err != nil {
 			slog.Error("failed to save company", slog.String("Error", err.Error()))
-			httpserver.ReplyWithError(w, http.StatusInternalServerError, _createCompanyError)
+			replyCompanyError(w, err, _createCompanyError)
 			return
 		}
 
 		response := CompanyResponse{
 			ID:                    company.ID,
 			Name:                  company.Name,
-			Code:                  company.Code,
+			Code:                  company.Code.String(),
 			Address:               company.Address,
 			FacturaMovilCompanyID: company.FacturaMovilCompanyID,
 			CommercialActivities:  make([]CommercialActivityResponse, len(company.CommercialActivities)),
@@ -116,7 +117,7 @@ func (c *CompanyController) list() http.HandlerFunc {
 			response[i] = CompanyResponse{
 				ID:                    company.ID,
 				Name:                  company.Name,
-				Code:                  company.Code,
+				Code:                  company.Code.String(),
 				Address:               company.Address,
 				FacturaMovilCompanyID: company.FacturaMovilCompanyID,
 				CommercialActivities:  make([]CommercialActivityResponse, len(company.CommercialActivities)),
@@ -153,7 +154,7 @@ func (c *CompanyController) getByID() http.HandlerFunc {
 		response := CompanyResponse{
 			ID:                    company.ID,
 			Name:                  company.Name,
-			Code:                  company.Code,
+			Code:                  company.Code.String(),
 			Address:               company.Address,
 			FacturaMovilCompanyID: company.FacturaMovilCompanyID,
 			CommercialActivities:  make([]CommercialActivityResponse, len(company.CommercialActivities)),
@@ -187,26 +188,31 @@ func (c *CompanyController) update() http.HandlerFunc {
 			return
 		}
 
-		company := domain.Company{
-			ID:                    id,
-			Name:                  body.Name,
-			Code:                  body.Code,
-			Address:               body.Address,
-			FacturaMovilCompanyID: body.FacturaMovilCompanyID,
-			CommercialActivities:  body.CommercialActivities,
+		company, err := domain.NewCompanyBuilder().
+			WithID(id).
+			WithName(body.Name).
+			WithCode(body.Code).
+			WithAddress(body.Address).
+			WithFacturaMovilCompanyID(body.FacturaMovilCompanyID).
+			WithCommercialActivities(body.CommercialActivities).
+			Build()
+		if err != nil {
+			slog.Error("failed to build domain company", slog.String("Error", err.Error()))
+			replyCompanyError(w, err, _updateCompanyError)
+			return
 		}
 
 		err = c.service.Update(r.Context(), company)
 		if err != nil {
 			slog.Error("failed to update company", slog.String("Error", err.Error()), slog.String("id", id))
-			httpserver.ReplyWithError(w, http.StatusInternalServerError, _updateCompanyError)
+			replyCompanyError(w, err, _updateCompanyError)
 			return
 		}
 
 		response := CompanyResponse{
 			ID:                    company.ID,
 			Name:                  company.Name,
-			Code:                  company.Code,
+			Code:                  company.Code.String(),
 			Address:               company.Address,
 			FacturaMovilCompanyID: company.FacturaMovilCompanyID,
 			CommercialActivities:  make([]CommercialActivityResponse, len(company.CommercialActivities)),
@@ -367,3 +373,13 @@ type CommercialActivityResponse struct {
 	Code        string `json:"code"`
 	Description string `json:"description"`
 }
+
+// replyCompanyError replies with 400 to invalid RUTs and with 500 to the
+// rest.
+func replyCompanyError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, domain.ErrInvalidRUT) {
+		httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	httpserver.ReplyWithError(w, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/httpserver"
+	"factura-movil-gateway/internal/usecases"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	_getCompanySettingsError    = "failed to get company settings"
+	_updateCompanySettingsError = "failed to update company settings"
+	_uploadLogoError            = "failed to upload logo"
+	_settingsCompanyNotFound    = "company not found"
+
+	_resolutionDateLayout = "2006-01-02"
+)
+
+func NewCompanySettingsController(settingsService usecases.CompanySettingsService, companyService usecases.CompanyService) *CompanySettingsController {
+	return &CompanySettingsController{
+		settingsService: settingsService,
+		companyService:  companyService,
+	}
+}
+
+type CompanySettingsController struct {
+	settingsService usecases.CompanySettingsService
+	companyService  usecases.CompanyService
+}
+
+func (c *CompanySettingsController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /companies/{id}/settings", c.get())
+	mux.Handle("PUT /companies/{id}/settings", c.update())
+	mux.Handle("PUT /companies/{id}/settings/logo", c.uploadLogo())
+}
+
+// findCompany replies with 404 and returns false when the company of the
+// request does not exist.
+func (c *CompanySettingsController) findCompany(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := c.companyService.FindByID(r.Context(), id); err != nil {
+		slog.Error("failed to find company", slog.String("Error", err.Error()), slog.String("id", id))
+		httpserver.ReplyWithError(w, http.StatusNotFound, _settingsCompanyNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+func (c *CompanySettingsController) get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		settings, err := c.settingsService.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, usecases.ErrCompanySettingsNotFound) {
+			slog.Error("failed to get company settings", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _getCompanySettingsError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newCompanySettingsResponse(settings))
+	}
+}
+
+func (c *CompanySettingsController) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body CompanySettingsRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _updateCompanySettingsError)
+			return
+		}
+
+		settings := domain.CompanySettings{
+			CompanyID:        id,
+			Phone:            body.Phone,
+			Email:            body.Email,
+			Website:          body.Website,
+			ResolutionNumber: body.ResolutionNumber,
+			FooterText:       body.FooterText,
+			PrimaryColor:     body.PrimaryColor,
+		}
+		if body.ResolutionDate != "" {
+			date, err := time.Parse(_resolutionDateLayout, body.ResolutionDate)
+			if err != nil {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, "resolution_date must be YYYY-MM-DD")
+				return
+			}
+			settings.ResolutionDate = date
+		}
+
+		settings, err := c.settingsService.Update(r.Context(), settings)
+		if err != nil {
+			slog.Error("failed to update company settings", slog.String("Error", err.Error()), slog.String("id", id))
+			if errors.Is(err, usecases.ErrInvalidCompanySettings) {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _updateCompanySettingsError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newCompanySettingsResponse(settings))
+	}
+}
+
+// uploadLogo takes the PNG or JPEG image as the raw request body.
+func (c *CompanySettingsController) uploadLogo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Error("failed to read request body", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _uploadLogoError)
+			return
+		}
+
+		settings, err := c.settingsService.UploadLogo(r.Context(), id, data)
+		if err != nil {
+			slog.Error("failed to upload logo", slog.String("Error", err.Error()), slog.String("id", id))
+			if errors.Is(err, usecases.ErrInvalidLogo) {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _uploadLogoError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newCompanySettingsResponse(settings))
+	}
+}
+
+type CompanySettingsRequest struct {
+	Phone            string `json:"phone"`
+	Email            string `json:"email"`
+	Website          string `json:"website"`
+	ResolutionNumber int    `json:"resolution_number"`
+	ResolutionDate   string `json:"resolution_date"`
+	FooterText       string `json:"footer_text"`
+	PrimaryColor     string `json:"primary_color"`
+}
+
+type CompanySettingsResponse struct {
+	CompanyID        string     `json:"company_id"`
+	Phone            string     `json:"phone"`
+	Email            string     `json:"email"`
+	Website          string     `json:"website"`
+	HasLogo          bool       `json:"has_logo"`
+	ResolutionNumber int        `json:"resolution_number"`
+	ResolutionDate   string     `json:"resolution_date,omitempty"`
+	FooterText       string     `json:"footer_text"`
+	PrimaryColor     string     `json:"primary_color"`
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+}
+
+func newCompanySettingsResponse(settings domain.CompanySettings) CompanySettingsResponse {
+	response := CompanySettingsResponse{
+		CompanyID:        settings.CompanyID,
+		Phone:            settings.Phone,
+		Email:            settings.Email,
+		Website:          settings.Website,
+		HasLogo:          settings.LogoBlob != "",
+		ResolutionNumber: settings.ResolutionNumber,
+		FooterText:       settings.FooterText,
+		PrimaryColor:     settings.PrimaryColor,
+	}
+	if !settings.ResolutionDate.IsZero() {
+		response.ResolutionDate = settings.ResolutionDate.Format(_resolutionDateLayout)
+	}
+	if !settings.UpdatedAt.IsZero() {
+		response.UpdatedAt = &settings.UpdatedAt
+	}
+	return response
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/httpserver"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	_listCustomersError      = "failed to list customers"
+	_saveCustomerError       = "failed to save customer"
+	_deleteCustomerError     = "failed to delete customer"
+	_customerCompanyNotFound = "company not found"
+	_customerNotFound        = "customer not found"
+)
+
+func NewCustomerController(customerService usecases.CustomerService, companyService usecases.CompanyService) *CustomerController {
+	return &CustomerController{
+		customerService: customerService,
+		companyService:  companyService,
+	}
+}
+
+// CustomerController manages the customer directory of a company. Customers
+// are addressed by their RUT, in any of its usual spellings.
+type CustomerController struct {
+	customerService usecases.CustomerService
+	companyService  usecases.CompanyService
+}
+
+func (c *CustomerController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /companies/{id}/customers", c.list())
+	mux.Handle("POST /companies/{id}/customers", c.create())
+	mux.Handle("GET /companies/{id}/customers/{rut}", c.get())
+	mux.Handle("PUT /companies/{id}/customers/{rut}", c.update())
+	mux.Handle("DELETE /companies/{id}/customers/{rut}", c.delete())
+}
+
+// findCompany replies with 404 and returns false when the company of the
+// request does not exist.
+func (c *CustomerController) findCompany(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := c.companyService.FindByID(r.Context(), id); err != nil {
+		slog.Error("failed to find company", slog.String("Error", err.Error()), slog.String("id", id))
+		httpserver.ReplyWithError(w, http.StatusNotFound, _customerCompanyNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+// parseCustomerRUT reads the RUT naming a customer, replying 400 when it is
+// not one.
+func parseCustomerRUT(w http.ResponseWriter, value string) (domain.RUT, bool) {
+	rut, err := domain.ParseRUT(value)
+	if err != nil {
+		httpserver.ReplyWithError(w, http.StatusBadRequest, fmt.Errorf("%w: %w", usecases.ErrInvalidCustomer, err).Error())
+		return domain.RUT{}, false
+	}
+	return rut, true
+}
+
+func (c *CustomerController) list() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		customers, err := c.customerService.List(r.Context(), id)
+		if err != nil {
+			slog.Error("failed to list customers", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _listCustomersError)
+			return
+		}
+
+		response := make([]CustomerResponse, len(customers))
+		for i, customer := range customers {
+			response[i] = newCustomerResponse(customer)
+		}
+		httpserver.ReplyJSONResponse(w, http.StatusOK, response)
+	}
+}
+
+func (c *CustomerController) get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		rut, ok := parseCustomerRUT(w, r.PathValue("rut"))
+		if !ok {
+			return
+		}
+
+		customer, err := c.customerService.FindByCode(r.Context(), id, rut)
+		if err != nil {
+			slog.Error("failed to get customer", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyCustomerError(w, err, _listCustomersError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newCustomerResponse(customer))
+	}
+}
+
+func (c *CustomerController) create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body CustomerRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveCustomerError)
+			return
+		}
+
+		rut, ok := parseCustomerRUT(w, body.RUT)
+		if !ok {
+			return
+		}
+
+		customer, err := c.customerService.Create(r.Context(), body.toCustomer(id, rut))
+		if err != nil {
+			slog.Error("failed to create customer", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyCustomerError(w, err, _saveCustomerError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusCreated, newCustomerResponse(customer))
+	}
+}
+
+// update replaces the customer; the RUT in the path wins over the body's.
+func (c *CustomerController) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body CustomerRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveCustomerError)
+			return
+		}
+
+		rut, ok := parseCustomerRUT(w, r.PathValue("rut"))
+		if !ok {
+			return
+		}
+
+		customer, err := c.customerService.Update(r.Context(), body.toCustomer(id, rut))
+		if err != nil {
+			slog.Error("failed to update customer", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyCustomerError(w, err, _saveCustomerError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newCustomerResponse(customer))
+	}
+}
+
+func (c *CustomerController) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		rut, ok := parseCustomerRUT(w, r.PathValue("rut"))
+		if !ok {
+			return
+		}
+
+		if err := c.customerService.Delete(r.Context(), id, rut); err != nil {
+			slog.Error("failed to delete customer", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyCustomerError(w, err, _deleteCustomerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *CustomerController) replyCustomerError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, usecases.ErrInvalidCustomer):
+		httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, usecases.ErrCustomerExists):
+		httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, usecases.ErrCustomerNotFound):
+		httpserver.ReplyWithError(w, http.StatusNotFound, _customerNotFound)
+	default:
+		httpserver.ReplyWithError(w, http.StatusInternalServerError, message)
+	}
+}
+
+type CustomerRequest struct {
+	RUT          string `json:"rut"`
+	Name         string `json:"name"`
+	BusinessLine string `json:"business_line"`
+	Address      string `json:"address"`
+	Commune      string `json:"commune"`
+	City         string `json:"city"`
+	Email        string `json:"email"`
+}
+
+func (b CustomerRequest) toCustomer(companyID string, rut domain.RUT) domain.Customer {
+	return domain.Customer{
+		CompanyID:    companyID,
+		Code:         rut,
+		Name:         b.Name,
+		BusinessLine: b.BusinessLine,
+		Address:      b.Address,
+		Commune:      b.Commune,
+		City:         b.City,
+		Email:        b.Email,
+	}
+}
+
+type CustomerResponse struct {
+	ID           string    `json:"id"`
+	CompanyID    string    `json:"company_id"`
+	RUT          string    `json:"rut"`
+	Name         string    `json:"name"`
+	BusinessLine string    `json:"business_line"`
+	Address      string    `json:"address"`
+	Commune      string    `json:"commune"`
+	City         string    `json:"city"`
+	Email        string    `json:"email,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func newCustomerResponse(customer domain.Customer) CustomerResponse {
+	return CustomerResponse{
+		ID:           customer.ID,
+		CompanyID:    customer.CompanyID,
+		RUT:          customer.Code.String(),
+		Name:         customer.Name,
+		BusinessLine: customer.BusinessLine,
+		Address:      customer.Address,
+		Commune:      customer.Commune,
+		City:         customer.City,
+		Email:        customer.Email,
+		UpdatedAt:    customer.UpdatedAt,
+	}
+}
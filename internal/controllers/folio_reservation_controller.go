@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/httpserver"
+	"factura-movil-gateway/internal/usecases"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	_reserveFoliosError         = "failed to reserve folios"
+	_getFolioReservationError   = "failed to get folio reservation"
+	_reconcileFoliosError       = "failed to reconcile folio reservation"
+	_reservationCompanyNotFound = "company not found"
+	_folioReservationNotFound   = "folio reservation not found"
+)
+
+func NewFolioReservationController(reservationService usecases.FolioReservationService, companyService usecases.CompanyService) *FolioReservationController {
+	return &FolioReservationController{
+		reservationService: reservationService,
+		companyService:     companyService,
+	}
+}
+
+// FolioReservationController leases blocks of folios to offline POS
+// terminals and takes their report of the folios they stamped.
+type FolioReservationController struct {
+	reservationService usecases.FolioReservationService
+	companyService     usecases.CompanyService
+}
+
+func (c *FolioReservationController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("POST /companies/{id}/folio-reservations", c.reserve())
+	mux.Handle("GET /companies/{id}/folio-reservations/{reservationId}", c.get())
+	mux.Handle("POST /companies/{id}/folio-reservations/{reservationId}/reconcile", c.reconcile())
+}
+
+// findCompany replies with 404 and returns false when the company of the
+// request does not exist.
+func (c *FolioReservationController) findCompany(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := c.companyService.FindByID(r.Context(), id); err != nil {
+		slog.Error("failed to find company", slog.String("Error", err.Error()), slog.String("id", id))
+		httpserver.ReplyWithError(w, http.StatusNotFound, _reservationCompanyNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+func (c *FolioReservationController) reserve() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body FolioReservationRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _reserveFoliosError)
+			return
+		}
+
+		lease := time.Duration(body.LeaseHours) * time.Hour
+		reservation, caf, err := c.reservationService.Reserve(r.Context(), id, body.TerminalID, body.DocumentType, body.Count, lease)
+		if err != nil {
+			slog.Error("failed to reserve folios", slog.String("Error", err.Error()), slog.String("id", id))
+			if errors.Is(err, usecases.ErrInvalidFolioReservation) {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _reserveFoliosError)
+			return
+		}
+
+		response := newFolioReservationResponse(reservation)
+		response.CAF = &FolioReservationCAFResponse{
+			ID:                caf.ID,
+			XML:               caf.Raw,
+			PrivateKey:        caf.PrivateKey,
+			InitialFolio:      caf.InitialFolios,
+			FinalFolio:        caf.FinalFolios,
+			AuthorizationDate: caf.AuthorizationDate.Format(_resolutionDateLayout),
+		}
+		httpserver.ReplyJSONResponse(w, http.StatusCreated, response)
+	}
+}
+
+func (c *FolioReservationController) get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		reservation, err := c.reservationService.Find(r.Context(), id, r.PathValue("reservationId"))
+		if err != nil {
+			if errors.Is(err, usecases.ErrFolioReservationNotFound) {
+				httpserver.ReplyWithError(w, http.StatusNotFound, _folioReservationNotFound)
+				return
+			}
+			slog.Error("failed to get folio reservation", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _getFolioReservationError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newFolioReservationResponse(reservation))
+	}
+}
+
+// reconcile takes the folios the terminal stamped; every other folio of the
+// block is returned to the company. An empty list returns the whole block.
+func (c *FolioReservationController) reconcile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body FolioReconciliationRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _reconcileFoliosError)
+			return
+		}
+
+		reservation, err := c.reservationService.Reconcile(r.Context(), id, r.PathValue("reservationId"), body.UsedFolios)
+		if err != nil {
+			slog.Error("failed to reconcile folio reservation", slog.String("Error", err.Error()), slog.String("id", id))
+			switch {
+			case errors.Is(err, usecases.ErrFolioReservationNotFound):
+				httpserver.ReplyWithError(w, http.StatusNotFound, _folioReservationNotFound)
+			case errors.Is(err, usecases.ErrInvalidFolioReservation):
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, usecases.ErrFolioReservationClosed):
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+			default:
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _reconcileFoliosError)
+			}
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newFolioReservationResponse(reservation))
+	}
+}
+
+type FolioReservationRequest struct {
+	TerminalID   string `json:"terminal_id"`
+	DocumentType uint   `json:"document_type"`
+	Count        int64  `json:"count"`
+	LeaseHours   int    `json:"lease_hours"`
+}
+
+type FolioReconciliationRequest struct {
+	UsedFolios []int64 `json:"used_folios"`
+}
+
+type FolioReservationResponse struct {
+	ID             string                       `json:"id"`
+	CompanyID      string                       `json:"company_id"`
+	TerminalID     string                       `json:"terminal_id"`
+	DocumentType   uint                         `json:"document_type"`
+	FromFolio      int64                        `json:"from_folio"`
+	ToFolio        int64                        `json:"to_folio"`
+	Status         string                       `json:"status"`
+	ExpiresAt      time.Time                    `json:"expires_at"`
+	UsedFolios     []int64                      `json:"used_folios,omitempty"`
+	ReturnedFolios []int64                      `json:"returned_folios,omitempty"`
+	ConflictFolios []int64                      `json:"conflict_folios,omitempty"`
+	CAF            *FolioReservationCAFResponse `json:"caf,omitempty"`
+}
+
+// FolioReservationCAFResponse is what a terminal needs to stamp offline: the
+// CAF as issued by the SII, base64 encoded, and its private key.
+type FolioReservationCAFResponse struct {
+	ID                string `json:"id"`
+	XML               []byte `json:"xml"`
+	PrivateKey        string `json:"private_key"`
+	InitialFolio      int64  `json:"initial_folio"`
+	FinalFolio        int64  `json:"final_folio"`
+	AuthorizationDate string `json:"authorization_date"`
+}
+
+func newFolioReservationResponse(reservation domain.FolioReservation) FolioReservationResponse {
+	return FolioReservationResponse{
+		ID:             reservation.ID,
+		CompanyID:      reservation.CompanyID,
+		TerminalID:     reservation.TerminalID,
+		DocumentType:   reservation.DocumentType,
+		FromFolio:      reservation.FromFolio,
+		ToFolio:        reservation.ToFolio,
+		Status:         reservation.Status,
+		ExpiresAt:      reservation.ExpiresAt,
+		UsedFolios:     reservation.UsedFolios,
+		ReturnedFolios: reservation.ReturnedFolios,
+		ConflictFolios: reservation.ConflictFolios,
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/httpserver"
+	"factura-movil-gateway/internal/usecases"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	_listProductsError      = "failed to list products"
+	_saveProductError       = "failed to save product"
+	_deleteProductError     = "failed to delete product"
+	_productCompanyNotFound = "company not found"
+	_productNotFound        = "product not found"
+)
+
+func NewProductController(productService usecases.ProductService, companyService usecases.CompanyService) *ProductController {
+	return &ProductController{
+		productService: productService,
+		companyService: companyService,
+	}
+}
+
+// ProductController manages the product catalogue of a company. Products
+// are addressed by their item code.
+type ProductController struct {
+	productService usecases.ProductService
+	companyService usecases.CompanyService
+}
+
+func (c *ProductController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /companies/{id}/products", c.list())
+	mux.Handle("POST /companies/{id}/products", c.create())
+	mux.Handle("GET /companies/{id}/products/{code}", c.get())
+	mux.Handle("PUT /companies/{id}/products/{code}", c.update())
+	mux.Handle("DELETE /companies/{id}/products/{code}", c.delete())
+}
+
+// findCompany replies with 404 and returns false when the company of the
+// request does not exist.
+func (c *ProductController) findCompany(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := c.companyService.FindByID(r.Context(), id); err != nil {
+		slog.Error("failed to find company", slog.String("Error", err.Error()), slog.String("id", id))
+		httpserver.ReplyWithError(w, http.StatusNotFound, _productCompanyNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+func (c *ProductController) list() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		products, err := c.productService.List(r.Context(), id)
+		if err != nil {
+			slog.Error("failed to list products", slog.String("Error", err.Error()), slog.String("id", id))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _listProductsError)
+			return
+		}
+
+		response := make([]ProductResponse, len(products))
+		for i, product := range products {
+			response[i] = newProductResponse(product)
+		}
+		httpserver.ReplyJSONResponse(w, http.StatusOK, response)
+	}
+}
+
+func (c *ProductController) get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		product, err := c.productService.FindByCode(r.Context(), id, r.PathValue("code"))
+		if err != nil {
+			slog.Error("failed to get product", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyProductError(w, err, _listProductsError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newProductResponse(product))
+	}
+}
+
+func (c *ProductController) create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body ProductRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveProductError)
+			return
+		}
+
+		product, err := c.productService.Create(r.Context(), body.toProduct(id, body.Code))
+		if err != nil {
+			slog.Error("failed to create product", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyProductError(w, err, _saveProductError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusCreated, newProductResponse(product))
+	}
+}
+
+// update replaces the product; the code in the path wins over the body's.
+func (c *ProductController) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		var body ProductRequest
+		if err := httpserver.DecodeJSONBody(r, &body); err != nil {
+			slog.Error("failed to decode json", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _saveProductError)
+			return
+		}
+
+		product, err := c.productService.Update(r.Context(), body.toProduct(id, r.PathValue("code")))
+		if err != nil {
+			slog.Error("failed to update product", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyProductError(w, err, _saveProductError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, newProductResponse(product))
+	}
+}
+
+func (c *ProductController) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.findCompany(w, r)
+		if !ok {
+			return
+		}
+
+		if err := c.productService.Delete(r.Context(), id, r.PathValue("code")); err != nil {
+			slog.Error("failed to delete product", slog.String("Error", err.Error()), slog.String("id", id))
+			c.replyProductError(w, err, _deleteProductError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *ProductController) replyProductError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, usecases.ErrInvalidProduct):
+		httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, usecases.ErrProductExists):
+		httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, usecases.ErrProductNotFound):
+		httpserver.ReplyWithError(w, http.StatusNotFound, _productNotFound)
+	default:
+		httpserver.ReplyWithError(w, http.StatusInternalServerError, message)
+	}
+}
+
+type ProductRequest struct {
+	Code string `json:"code"`
+	// CodeType is the TpoCodigo of Code, INT1 when empty.
+	CodeType           string         `json:"code_type"`
+	Name               string         `json:"name"`
+	Unit               string         `json:"unit"`
+	Price              domain.Decimal `json:"price"`
+	Exempt             bool           `json:"exempt"`
+	AdditionalTaxCodes []string       `json:"additional_tax_codes"`
+}
+
+func (b ProductRequest) toProduct(companyID, code string) domain.Product {
+	return domain.Product{
+		CompanyID:          companyID,
+		Code:               code,
+		CodeType:           b.CodeType,
+		Name:               b.Name,
+		Unit:               b.Unit,
+		Price:              b.Price,
+		Exempt:             b.Exempt,
+		AdditionalTaxCodes: b.AdditionalTaxCodes,
+	}
+}
+
+type ProductResponse struct {
+	ID                 string         `json:"id"`
+	CompanyID          string         `json:"company_id"`
+	Code               string         `json:"code"`
+	CodeType           string         `json:"code_type"`
+	Name               string         `json:"name"`
+	Unit               string         `json:"unit,omitempty"`
+	Price              domain.Decimal `json:"price"`
+	Exempt             bool           `json:"exempt"`
+	AdditionalTaxCodes []string       `json:"additional_tax_codes,omitempty"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+}
+
+func newProductResponse(product domain.Product) ProductResponse {
+	return ProductResponse{
+		ID:                 product.ID,
+		CompanyID:          product.CompanyID,
+		Code:               product.Code,
+		CodeType:           product.CodeType,
+		Name:               product.Name,
+		Unit:               product.Unit,
+		Price:              product.Price,
+		Exempt:             product.Exempt,
+		AdditionalTaxCodes: product.AdditionalTaxCodes,
+		UpdatedAt:          product.UpdatedAt,
+	}
+}
@@ -1,31 +1,78 @@
 package controllers
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/xml"
+	"encoding/hex"
+	"errors"
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/httpserver"
 	"factura-movil-gateway/internal/usecases"
 	"factura-movil-gateway/internal/utils"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 )
 
 const (
-	_createStampError     = "failed to create stamp"
-	_companyNotFoundError = "company not found"
+	_createStampError       = "failed to create stamp"
+	_companyNotFoundError   = "company not found"
+	_invalidIdempotencyKey  = "invalid Idempotency-Key header"
+	_idempotencyKeyHeader   = "Idempotency-Key"
+	_idempotentReplayHeader = "Idempotent-Replayed"
+	_maxIdempotencyKeyLen   = 255
 )
 
-func NewStampController(stampService usecases.StampService, companyService usecases.CompanyService) *StampController {
+func NewStampController(stampService usecases.StampService, idempotencyService usecases.IdempotencyService, companyService usecases.CompanyService) *StampController {
 	return &StampController{
-		stampService:   stampService,
-		companyService: companyService,
+		stampService:       stampService,
+		idempotencyService: idempotencyService,
+		companyService:     companyService,
 	}
 }
 
 type StampController struct {
-	stampService   usecases.StampService
-	companyService usecases.CompanyService
+	stampService       usecases.StampService
+	idempotencyService usecases.IdempotencyService
+	companyService     usecases.CompanyService
+	branchService      usecases.BranchService
+	customerService    usecases.CustomerService
+	productService     usecases.ProductService
+	documentService    usecases.DocumentService
+	receiptOptions     usecases.ReceiptOptions
+}
+
+// WithCustomers completes clients given by RUT alone from the company's
+// customer directory.
+func (c *StampController) WithCustomers(customerService usecases.CustomerService) *StampController {
+	c.customerService = customerService
+	return c
+}
+
+// WithProducts lets details name their product by code alone; the rest is
+// taken from the company's product catalogue.
+func (c *StampController) WithProducts(productService usecases.ProductService) *StampController {
+	c.productService = productService
+	return c
+}
+
+// WithReceipts enables format=escpos, which replies with the stamped
+// document as ESC/POS commands for a thermal printer. options are the
+// defaults; requests may override them with paper_width and pdf417=raster.
+func (c *StampController) WithReceipts(documentService usecases.DocumentService, options usecases.ReceiptOptions) *StampController {
+	c.documentService = documentService
+	c.receiptOptions = options
+	return c
+}
+
+// WithBranches lets requests pick the issuing branch with subsidiary.code,
+// its SII code. Its CAFs are used first and its address is printed.
+func (c *StampController) WithBranches(branchService usecases.BranchService) *StampController {
+	c.branchService = branchService
+	return c
 }
 
 func (c *StampController) AddRoutes(mux *http.ServeMux) {
@@ -48,6 +95,20 @@ func (c *StampController) create() http.HandlerFunc {
 			return
 		}
 
+		idempotencyKey := r.Header.Get(_idempotencyKeyHeader)
+		if len(idempotencyKey) > _maxIdempotencyKeyLen {
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _invalidIdempotencyKey)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Error("failed to read body", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _createStampError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
 		var req StampRequest
 		err = httpserver.DecodeJSONBody(r, &req)
 		if err != nil {
@@ -56,47 +117,157 @@ func (c *StampController) create() http.HandlerFunc {
 			return
 		}
 
+		var assignedFolio int64
+		if req.AssignedFolio != "" {
+			assignedFolio, err = strconv.ParseInt(req.AssignedFolio, 10, 64)
+			if err != nil || assignedFolio <= 0 {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, "assignedFolio must be a positive integer")
+				return
+			}
+		}
+
+		// Boletas may leave the client's RUT out; it stays zero.
+		var client domain.RUT
+		if err := client.UnmarshalText([]byte(req.Client.Code)); err != nil {
+			httpserver.ReplyWithError(w, http.StatusBadRequest, "client: "+err.Error())
+			return
+		}
+
 		invoice, err := domain.NewInvoiceBuilder().
 			WithHasTaxes(req.HasTaxes).
+			WithAssignedFolio(assignedFolio).
 			WithCustomer(domain.Customer{
-				Code: req.Client.Code,
-				Name: req.Client.Name,
+				Code:         client,
+				Name:         req.Client.Name,
+				BusinessLine: req.Client.Line,
+				Address:      req.Client.Address,
+				Commune:      req.Client.Municipality,
 			}).
 			WithCreationDate(req.Date).
 			Build()
 		if err != nil {
 			slog.Error("failed building invoice", slog.String("Error", err.Error()))
-			httpserver.ReplyWithError(w, http.StatusBadRequest, _createStampError)
+			httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
+		if req.Subsidiary.Code != "" && req.Subsidiary.Code != "0" {
+			code, err := strconv.ParseUint(req.Subsidiary.Code, 10, 64)
+			if err != nil {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, "subsidiary.code must be the SII branch code")
+				return
+			}
+			branch, err := c.findBranch(r, company.ID, code)
+			if err != nil {
+				slog.Error("failed to find branch", slog.String("Error", err.Error()), slog.Uint64("code", code))
+				if errors.Is(err, usecases.ErrBranchNotFound) {
+					httpserver.ReplyWithError(w, http.StatusUnprocessableEntity, err.Error())
+					return
+				}
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+				return
+			}
+			invoice.BranchCode = code
+			invoice.Branch = &branch
+		}
+
+		if c.customerService != nil {
+			if err := c.customerService.CompleteReceiver(r.Context(), company.ID, invoice.Receiver); err != nil {
+				slog.Error("failed to complete client", slog.String("Error", err.Error()), slog.String("client", req.Client.Code))
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+				return
+			}
+		}
+
 		for _, d := range req.Details {
-			invoice.AddDetail(domain.Detail{
+			err := invoice.AddDetail(domain.Detail{
 				Position: d.Position,
 				Product: domain.Product{
-					Name:  d.Product.Name,
-					Price: d.Product.Price,
+					Name:               d.Product.Name,
+					Price:              d.Product.Price,
+					CodeType:           d.Product.CodeType,
+					Code:               d.Product.Code,
+					Unit:               d.Product.Unit.Code,
+					Exempt:             d.Product.Exempt,
+					AdditionalTaxCodes: d.Product.AdditionalTaxCodes,
 				},
 				Quantity: d.Quantity,
 				Discount: d.Discount,
 			})
+			if err != nil {
+				httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 		}
 
-		stamp, err := c.stampService.Generate(r.Context(), *company, invoice)
+		if c.productService != nil {
+			if _, err := c.productService.CompleteDetails(r.Context(), company.ID, &invoice); err != nil {
+				slog.Error("failed to complete details", slog.String("Error", err.Error()))
+				if errors.Is(err, usecases.ErrProductNotFound) {
+					httpserver.ReplyWithError(w, http.StatusUnprocessableEntity, err.Error())
+					return
+				}
+				if errors.Is(err, domain.ErrInvalidInvoice) {
+					httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+				return
+			}
+		}
+
+		// The stamp's MNT is the total, so it is computed before stamping.
+		invoice.Issuer = *company
+		if err := invoice.ComputeTotals(); err != nil {
+			httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := invoice.Validate(); err != nil {
+			httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var stamp domain.Stamp
+		if idempotencyKey != "" && c.idempotencyService != nil {
+			sum := sha256.Sum256(body)
+			key := usecases.IdempotencyKey{
+				Key:         "http:" + company.ID + ":" + idempotencyKey,
+				RequestHash: hex.EncodeToString(sum[:]),
+			}
+
+			var replayed bool
+			stamp, replayed, err = c.idempotencyService.GenerateOnce(r.Context(), key, *company, invoice)
+			if replayed {
+				w.Header().Set(_idempotentReplayHeader, "true")
+			}
+		} else {
+			stamp, err = c.stampService.Generate(r.Context(), *company, invoice)
+		}
 		if err != nil {
-			slog.Error("failed to generate stamp", slog.String("Error", err.Error()))
-			httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+			slog.Error("failed to generate stamp", slog.String("Error", err.Error()), slog.String("idempotencyKey", idempotencyKey))
+			switch {
+			case errors.Is(err, usecases.ErrIdempotencyKeyReused):
+				httpserver.ReplyWithError(w, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, usecases.ErrIdempotencyInProgress):
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+			case errors.Is(err, usecases.ErrFolioOutOfRange):
+				httpserver.ReplyWithError(w, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, usecases.ErrFolioAlreadyUsed):
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+			default:
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+			}
 			return
 		}
 
 		response := TED{
 			Version: "1.0",
 			DD: DD{
-				RE:  stamp.DD.RE,
+				RE:  stamp.DD.RE.String(),
 				TD:  stamp.DD.TD,
 				F:   stamp.DD.F,
 				FE:  stamp.DD.FE,
-				RR:  stamp.DD.RR,
+				RR:  stamp.DD.RR.String(),
 				RSR: stamp.DD.RSR,
 				MNT: stamp.DD.MNT,
 				IT1: stamp.DD.IT1,
@@ -127,18 +298,31 @@ func (c *StampController) create() http.HandlerFunc {
 			},
 		}
 
+		// The canonical TED is what was signed and what the barcode carries
+		stampXML := utils.MarshalTED(stamp)
+
+		format := r.URL.Query().Get("format")
+		if format == "escpos" {
+			c.replyReceipt(w, r, invoice, stampXML)
+			return
+		}
+
 		// Check if PDF417 barcode is requested via query parameter
-		if r.URL.Query().Get("format") == "pdf417" || r.URL.Query().Get("include_barcode") == "true" {
-			// Convert to XML for PDF417 generation
-			xmlData, err := xml.Marshal(response)
+		if format == "pdf417" || format == "svg" || r.URL.Query().Get("include_barcode") == "true" {
+			// Encode the timbre to the SII spec, as vectors or two pixels per module
+			symbol, err := utils.EncodeTEDPDF417(stampXML)
 			if err != nil {
-				slog.Error("failed to marshal TED to XML for PDF417", slog.String("Error", err.Error()))
+				slog.Error("failed to generate PDF417 barcode", slog.String("Error", err.Error()))
 				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
 				return
 			}
-
-			// Generate PDF417 barcode with automatically calculated safe dimensions
-			_, pngBytes, err := utils.GenerateStampPDF417FromXMLWithAutoDimensions(string(xmlData))
+			if format == "svg" {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				w.WriteHeader(http.StatusOK)
+				w.Write(symbol.SVG())
+				return
+			}
+			pngBytes, err := symbol.PNG(2)
 			if err != nil {
 				slog.Error("failed to generate PDF417 barcode", slog.String("Error", err.Error()))
 				httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
@@ -146,7 +330,7 @@ func (c *StampController) create() http.HandlerFunc {
 			}
 
 			// If only PDF417 is requested, return the image
-			if r.URL.Query().Get("format") == "pdf417" {
+			if format == "pdf417" {
 				w.Header().Set("Content-Type", "image/png")
 				w.WriteHeader(http.StatusOK)
 				w.Write(pngBytes)
@@ -162,9 +346,55 @@ func (c *StampController) create() http.HandlerFunc {
 			return
 		}
 
-		// Default: return XML response
-		httpserver.ReplyXMLResponse(w, http.StatusOK, response)
+		// Default: return the canonical TED
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(stampXML)
+	}
+}
+
+func (c *StampController) findBranch(r *http.Request, companyID string, code uint64) (domain.Branch, error) {
+	if c.branchService == nil {
+		return domain.Branch{}, fmt.Errorf("%w: code %d, branches are not enabled", usecases.ErrBranchNotFound, code)
+	}
+	return c.branchService.FindByCode(r.Context(), companyID, code)
+}
+
+// replyReceipt renders the stamped invoice as an ESC/POS receipt. The
+// printer of the invoice's branch is used unless the request overrides it.
+func (c *StampController) replyReceipt(w http.ResponseWriter, r *http.Request, invoice domain.Invoice, stampXML []byte) {
+	if c.documentService == nil {
+		httpserver.ReplyWithError(w, http.StatusNotImplemented, "receipts are not enabled")
+		return
+	}
+
+	options := usecases.BranchReceiptOptions(&invoice, c.receiptOptions)
+	if width := r.URL.Query().Get("paper_width"); width != "" {
+		paperWidth, err := strconv.Atoi(width)
+		if err != nil {
+			httpserver.ReplyWithError(w, http.StatusBadRequest, "paper_width must be 58 or 80")
+			return
+		}
+		options.PaperWidth = paperWidth
+	}
+	if mode := r.URL.Query().Get("pdf417"); mode != "" {
+		options.RasterPDF417 = mode == "raster"
+	}
+	if err := options.Validate(); err != nil {
+		httpserver.ReplyWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	receipt, err := c.documentService.RenderReceipt(r.Context(), &invoice, stampXML, options)
+	if err != nil {
+		slog.Error("failed to render receipt", slog.String("Error", err.Error()))
+		httpserver.ReplyWithError(w, http.StatusInternalServerError, _createStampError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(receipt)
 }
 
 type StampRequest struct {
@@ -178,18 +408,22 @@ type StampRequest struct {
 }
 
 type Detail struct {
-	Position    uint8   `json:"position"`
-	Product     Product `json:"product"`
-	Description string  `json:"description"`
-	Quantity    float64 `json:"quantity"`
-	Discount    float64 `json:"discount"`
+	Position    uint8          `json:"position"`
+	Product     Product        `json:"product"`
+	Description string         `json:"description"`
+	Quantity    domain.Decimal `json:"quantity"`
+	Discount    domain.Decimal `json:"discount"`
 }
 
 type Product struct {
-	Unit  Unit   `json:"unit"`
-	Price uint64 `json:"price"`
-	Name  string `json:"name"`
-	Code  string `json:"code"`
+	Unit  Unit           `json:"unit"`
+	Price domain.Decimal `json:"price"`
+	Name  string         `json:"name"`
+	Code  string         `json:"code"`
+	// CodeType is the TpoCodigo of Code, INT1 when empty.
+	CodeType           string   `json:"codeType"`
+	Exempt             bool     `json:"exempt"`
+	AdditionalTaxCodes []string `json:"additionalTaxCodes"`
 }
 
 type Unit struct {
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"factura-movil-gateway/internal/utils"
+)
+
+type fakeCompanyService struct {
+	usecases.CompanyService
+	company domain.Company
+}
+
+func (f *fakeCompanyService) FindByID(ctx context.Context, id string) (*domain.Company, error) {
+	if id != f.company.ID {
+		return nil, fmt.Errorf("company not found with id: %s", id)
+	}
+	company := f.company
+	return &company, nil
+}
+
+// ddStampService signs nothing; it builds the DD the real service would, so
+// the stamp carries what the controller passed in.
+type ddStampService struct{}
+
+func (ddStampService) Generate(ctx context.Context, company domain.Company, invoice domain.Invoice) (domain.Stamp, error) {
+	dd, err := domain.NewDDBuilder().
+		WithIssuerRUT(company.Code).
+		WithInvoice(invoice).
+		Build()
+	if err != nil {
+		return domain.Stamp{}, err
+	}
+	dd.F = 2404
+	return domain.Stamp{DD: dd}, nil
+}
+
+func newTestStampMux() *http.ServeMux {
+	companies := &fakeCompanyService{company: domain.Company{ID: "company-1", Code: domain.MustParseRUT("76212889-6"), Name: "Factura Movil SpA"}}
+	mux := http.NewServeMux()
+	NewStampController(ddStampService{}, nil, companies).AddRoutes(mux)
+	return mux
+}
+
+func TestStampController_StampsComputedTotal(t *testing.T) {
+	body := `{
+		"hasTaxes": true,
+		"date": "2025-05-05",
+		"client": {"code": "77.371.419-3", "name": "AGRICOLA PAINE LTDA"},
+		"details": [
+			{"position": 1, "product": {"name": "Plan Emprendedor", "price": 1000}, "quantity": 2},
+			{"position": 2, "product": {"name": "Soporte", "price": 500, "exempt": true}, "quantity": 1}
+		]
+	}`
+	request := httptest.NewRequest(http.MethodPost, "/companies/company-1/stamps", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	newTestStampMux().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	stamp, err := utils.UnmarshalTED(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to read stamp: %v", err)
+	}
+	// 2000 net plus 380 of VAT, and 500 exempt.
+	if stamp.DD.MNT != 2880 {
+		t.Errorf("Expected MNT 2880, got %d", stamp.DD.MNT)
+	}
+}
+
+func TestStampController_RejectsInvalidInvoice(t *testing.T) {
+	body := `{"hasTaxes": true, "client": {"code": "77371419-3", "name": "AGRICOLA PAINE LTDA"}, "details": []}`
+	request := httptest.NewRequest(http.MethodPost, "/companies/company-1/stamps", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	newTestStampMux().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), "at least one detail line is required") {
+		t.Errorf("Expected the validation problem in the reply, got %s", recorder.Body.String())
+	}
+}
+
+func TestStampController_RejectsDiscount(t *testing.T) {
+	body := `{"hasTaxes": true, "client": {"code": "77371419-3", "name": "AGRICOLA PAINE LTDA"}, "details": [{"product": {"name": "A", "price": 1000}, "quantity": 1, "discount": 100}]}`
+	request := httptest.NewRequest(http.MethodPost, "/companies/company-1/stamps", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	newTestStampMux().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), "discounts are not supported") {
+		t.Errorf("Expected the rejected discount in the reply, got %s", recorder.Body.String())
+	}
+}
+
+func TestStampController_RejectsInvalidClientRUT(t *testing.T) {
+	body := `{"hasTaxes": true, "client": {"code": "77371419-4", "name": "AGRICOLA PAINE LTDA"}, "details": [{"product": {"name": "A", "price": 1000}, "quantity": 1}]}`
+	request := httptest.NewRequest(http.MethodPost, "/companies/company-1/stamps", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	newTestStampMux().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), "client: invalid RUT") {
+		t.Errorf("Expected the client RUT problem in the reply, got %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"errors"
+	"factura-movil-gateway/internal/async"
+	"factura-movil-gateway/internal/httpserver"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+const (
+	_listFailedFilesError = "failed to list failed files"
+	_requeueFileError     = "failed to requeue file"
+	_triggerWorkerError   = "failed to trigger worker"
+	_reloadRoutingError   = "failed to reload routing config"
+)
+
+// FileWorkerAdmin exposes the operator actions of the file integration worker.
+type FileWorkerAdmin interface {
+	Status() async.WorkerStatus
+	Pause()
+	Resume()
+	Trigger() error
+	ListFailed() ([]async.FailedFile, error)
+	Requeue(name string) error
+	Routing() async.RoutingConfig
+	ReloadRouting() (async.RoutingConfig, error)
+}
+
+func NewWorkerController(worker FileWorkerAdmin) *WorkerController {
+	return &WorkerController{
+		worker: worker,
+	}
+}
+
+type WorkerController struct {
+	worker FileWorkerAdmin
+}
+
+func (c *WorkerController) AddRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /worker/status", c.status())
+	mux.Handle("POST /worker/pause", c.pause())
+	mux.Handle("POST /worker/resume", c.resume())
+	mux.Handle("POST /worker/trigger", c.trigger())
+	mux.Handle("GET /worker/errors", c.listFailed())
+	mux.Handle("POST /worker/errors/{name}/requeue", c.requeue())
+	mux.Handle("GET /worker/routing", c.routing())
+	mux.Handle("POST /worker/routing/reload", c.reloadRouting())
+}
+
+func (c *WorkerController) status() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpserver.ReplyJSONResponse(w, http.StatusOK, c.worker.Status())
+	}
+}
+
+func (c *WorkerController) pause() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.worker.Pause()
+		httpserver.ReplyJSONResponse(w, http.StatusOK, c.worker.Status())
+	}
+}
+
+func (c *WorkerController) resume() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.worker.Resume()
+		httpserver.ReplyJSONResponse(w, http.StatusOK, c.worker.Status())
+	}
+}
+
+func (c *WorkerController) trigger() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := c.worker.Trigger()
+		if err != nil {
+			slog.Error("failed to trigger worker", slog.String("Error", err.Error()))
+			if errors.Is(err, async.ErrWorkerPaused) {
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+				return
+			}
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _triggerWorkerError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	}
+}
+
+func (c *WorkerController) listFailed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		files, err := c.worker.ListFailed()
+		if err != nil {
+			slog.Error("failed to list failed files", slog.String("Error", err.Error()))
+			httpserver.ReplyWithError(w, http.StatusInternalServerError, _listFailedFilesError)
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, files)
+	}
+}
+
+func (c *WorkerController) requeue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		err := c.worker.Requeue(name)
+		if err != nil {
+			slog.Error("failed to requeue file", slog.String("Error", err.Error()), slog.String("name", name))
+			switch {
+			case errors.Is(err, async.ErrInvalidFileName):
+				httpserver.ReplyWithError(w, http.StatusBadRequest, _requeueFileError)
+			case errors.Is(err, os.ErrNotExist):
+				httpserver.ReplyWithError(w, http.StatusNotFound, _requeueFileError)
+			default:
+				httpserver.ReplyWithError(w, http.StatusInternalServerError, _requeueFileError)
+			}
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusAccepted, map[string]string{"status": "requeued", "name": name})
+	}
+}
+
+func (c *WorkerController) routing() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpserver.ReplyJSONResponse(w, http.StatusOK, c.worker.Routing())
+	}
+}
+
+func (c *WorkerController) reloadRouting() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, err := c.worker.ReloadRouting()
+		if err != nil {
+			slog.Error("failed to reload routing config", slog.String("Error", err.Error()))
+			if errors.Is(err, async.ErrRoutingNotConfigured) {
+				httpserver.ReplyWithError(w, http.StatusConflict, err.Error())
+				return
+			}
+			httpserver.ReplyWithError(w, http.StatusBadRequest, _reloadRoutingError+": "+err.Error())
+			return
+		}
+
+		httpserver.ReplyJSONResponse(w, http.StatusOK, config)
+	}
+}
@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// Branch is a sucursal of a company registered with the SII. Documents
+// issued at a branch carry its SII code (CdgSIISucur) and print its address
+// besides the casa matriz one.
+type Branch struct {
+	ID        string
+	CompanyID string
+	// Code is the CdgSIISucur the SII assigned to the branch.
+	Code    uint64
+	Name    string
+	Address string
+	Commune string
+	City    string
+
+	// PrinterPaperWidth and PrinterRasterPDF417 describe the branch's
+	// thermal printer; zero values keep the gateway defaults.
+	PrinterPaperWidth   int
+	PrinterRasterPDF417 bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FullAddress joins the address, commune and city of the branch
+func (b *Branch) FullAddress() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{b.Address, b.Commune, b.City} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -17,11 +17,14 @@ const (
 )
 
 type CAF struct {
-	ID                string
-	Raw               []byte
-	CompanyID         string
-	CompanyCode       string
-	CompanyName       string
+	ID          string
+	Raw         []byte
+	CompanyID   string
+	CompanyCode string
+	CompanyName string
+	// BranchID is the branch whose documents use this CAF; CAFs without one
+	// are shared by the whole company.
+	BranchID          string
 	DocumentType      uint
 	InitialFolios     int64
 	CurrentFolios     int64
@@ -78,6 +81,14 @@ func (b *cafBuilder) WithCompanyName(value string) *cafBuilder {
 	return b
 }
 
+func (b *cafBuilder) WithBranchID(value string) *cafBuilder {
+	b.actions = append(b.actions, func(d *CAF) error {
+		d.BranchID = value
+		return nil
+	})
+	return b
+}
+
 func (b *cafBuilder) WithDocumentType(value uint) *cafBuilder {
 	b.actions = append(b.actions, func(d *CAF) error {
 		d.DocumentType = value
@@ -181,6 +192,11 @@ func (c *CAF) UseNextFolio() (int64, bool) {
 	return folioToUse, shouldClose
 }
 
+// Contains reports whether folio is in the range the CAF authorizes
+func (c *CAF) Contains(folio int64) bool {
+	return folio >= c.InitialFolios && folio <= c.FinalFolios
+}
+
 // IsOpen returns true if the CAF is in open status
 func (c *CAF) IsOpen() bool {
 	return c.Status == CAFStatusOpen
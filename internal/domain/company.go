@@ -19,9 +19,10 @@ type CompanyCommercialActivity struct {
 
 type Company struct {
 	ID                    string               `json:"id" gorm:"primaryKey"`
-	Code                  string               `json:"code" gorm:"uniqueIndex;not null"`
+	Code                  RUT                  `json:"code" gorm:"uniqueIndex;not null"`
 	Name                  string               `json:"name" gorm:"not null"`
 	Address               string               `json:"address"`
+	BusinessLine          string               `json:"business_line,omitempty" gorm:"-"`
 	FacturaMovilCompanyID uint64               `json:"factura_movil_company_id"`
 	CommercialActivities  []CommercialActivity `json:"commercial_activities" gorm:"many2many:company_commercial_activities"`
 }
@@ -36,9 +37,24 @@ type companyBuilder struct {
 
 type compnayHandler func(v *Company) error
 
+// WithID sets the id of an existing company; new ones get a random one.
+func (b *companyBuilder) WithID(value string) *companyBuilder {
+	b.actions = append(b.actions, func(d *Company) error {
+		d.ID = value
+		return nil
+	})
+	return b
+}
+
+// WithCode sets the company's RUT, given in any of its usual spellings; Build
+// fails with ErrInvalidRUT when it is not a valid RUT.
 func (b *companyBuilder) WithCode(value string) *companyBuilder {
 	b.actions = append(b.actions, func(d *Company) error {
-		d.Code = value
+		rut, err := ParseRUT(value)
+		if err != nil {
+			return err
+		}
+		d.Code = rut
 		return nil
 	})
 	return b
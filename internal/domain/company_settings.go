@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// CompanySettings is the profile printed on a company's documents besides
+// its legal data: contact details, logo and the SII resolution that
+// authorises it as an electronic issuer.
+type CompanySettings struct {
+	CompanyID string
+	Phone     string
+	Email     string
+	Website   string
+
+	// LogoBlob is the blob storage name of the logo, empty when the company
+	// has none.
+	LogoBlob        string
+	LogoContentType string
+
+	ResolutionNumber int
+	ResolutionDate   time.Time
+
+	FooterText   string
+	PrimaryColor string
+
+	UpdatedAt time.Time
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// Customer is a receiver of documents. Requests usually name it by RUT and
+// razón social only; the customer directory of each company keeps the rest,
+// keyed by its RUT in Code.
+type Customer struct {
+	ID           string
+	CompanyID    string
+	Code         RUT
+	Name         string
+	BusinessLine string
+	Address      string
+	Commune      string
+	City         string
+	Email        string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FullAddress joins the address, commune and city of the customer
+func (c *Customer) FullAddress() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{c.Address, c.Commune, c.City} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Receiver returns the customer as the receiver of a document
+func (c Customer) Receiver() *Company {
+	return &Company{
+		Code:         c.Code,
+		Name:         c.Name,
+		Address:      c.FullAddress(),
+		BusinessLine: c.BusinessLine,
+	}
+}
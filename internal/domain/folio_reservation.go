@@ -0,0 +1,53 @@
+package domain
+
+import "time"
+
+// Folio reservation states. A reservation is active while the terminal may
+// stamp its folios, reconciled once the terminal reported which ones it used
+// and expired when the lease ran out before that.
+const (
+	FolioReservationActive     = "active"
+	FolioReservationReconciled = "reconciled"
+	FolioReservationExpired    = "expired"
+)
+
+// FolioReservation is a block of consecutive folios of one CAF leased to an
+// offline terminal, which stamps them locally with the CAF key and reports
+// back the folios it used.
+type FolioReservation struct {
+	ID           string
+	CompanyID    string
+	TerminalID   string
+	DocumentType uint
+	CAFID        string
+	FromFolio    int64
+	ToFolio      int64
+	Status       string
+	ExpiresAt    time.Time
+
+	// UsedFolios are the folios the terminal reported as stamped.
+	UsedFolios []int64
+	// ReturnedFolios went back to the company's pool, either unused at
+	// reconciliation or all of them when the lease expired.
+	ReturnedFolios []int64
+	// ConflictFolios were reported after the lease expired but had been
+	// stamped again in the meantime; they need manual attention.
+	ConflictFolios []int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Contains reports whether folio is in the reserved block
+func (r *FolioReservation) Contains(folio int64) bool {
+	return folio >= r.FromFolio && folio <= r.ToFolio
+}
+
+// Folios lists every folio of the reserved block
+func (r *FolioReservation) Folios() []int64 {
+	folios := make([]int64, 0, r.ToFolio-r.FromFolio+1)
+	for folio := r.FromFolio; folio <= r.ToFolio; folio++ {
+		folios = append(folios, folio)
+	}
+	return folios
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// Idempotency record states. A record is created as pending before a folio is
+// requested, so concurrent requests with the same key never both consume one.
+// A pending record is leased until its ExpiresAt; past it, the request that
+// created it is taken as dead and a retry may take the key over.
+const (
+	IdempotencyPending   = "pending"
+	IdempotencyCompleted = "completed"
+)
+
+// IdempotencyRecord remembers the stamp produced for an idempotency key, so a
+// repeated request gets the original stamp back instead of a new folio.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	CompanyID   string
+	Status      string
+	Stamp       Stamp
+	// ExpiresAt ends the lease of a pending record.
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
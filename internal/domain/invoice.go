@@ -1,43 +1,171 @@
 package domain
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// ErrInvalidInvoice is returned by Validate when an invoice cannot be stamped.
+var ErrInvalidInvoice = errors.New("invalid invoice")
+
+// Document types accepted for stamping, and whether they require a receiver.
+var _supportedDocumentTypes = map[uint8]bool{
+	33: true,  // Factura electrónica
+	34: true,  // Factura no afecta o exenta electrónica
+	39: false, // Boleta electrónica
+	41: false, // Boleta exenta electrónica
+	46: true,  // Factura de compra electrónica
+	52: true,  // Guía de despacho electrónica
+	56: true,  // Nota de débito electrónica
+	61: true,  // Nota de crédito electrónica
+}
+
 type Invoice struct {
 	DocumentType uint8
 	Folio        int
 	IssueDate    time.Time
 
+	// InternalID is the identifier the issuer's system gave the document, if
+	// the input carries one.
+	InternalID string
+
+	// AssignedFolio, when set, is the folio to stamp instead of the next one
+	// of the CAF, for documents that must keep a folio given elsewhere.
+	AssignedFolio int64
+
+	// BranchCode is the CdgSIISucur of the branch issuing the document, 0
+	// for the casa matriz. Branch is that branch once it was looked up.
+	BranchCode uint64
+	Branch     *Branch
+
 	Issuer   Company
 	Receiver *Company
 
-	Details []InvoiceDetail
+	Details    []InvoiceDetail
+	References []InvoiceReference
 
 	Totals InvoiceTotals
 }
 
+// InvoiceReference points to another document, such as the factura a nota de
+// crédito corrects or a purchase order.
+type InvoiceReference struct {
+	DocumentType string
+	Folio        string
+	Date         string
+	Code         int
+	Reason       string
+}
+
 type InvoiceDetail struct {
-	Quantity    float64
+	Quantity    Decimal
 	Description string
-	UnitPrice   float64
-	LineTotal   float64
+	UnitPrice   Decimal
+	// LineTotal is the MontoItem, Quantity × UnitPrice rounded to pesos.
+	LineTotal Amount
+
+	// CodeType and Code identify the item (TpoCodigo and VlrCodigo). Code is
+	// also the key of the item in the issuer's product catalogue.
+	CodeType string
+	Code     string
+	// Unit is the unit of measure (UnmdItem).
+	Unit string
+	// Exempt marks lines not subject to IVA (IndExe).
+	Exempt bool
+	// AdditionalTaxCodes are the SII codes of the additional taxes or
+	// retentions on the line (CodImpAdic).
+	AdditionalTaxCodes []string
 }
 
 // InvoiceTotals contains totalization information
 type InvoiceTotals struct {
-	TaxableAmount float64
-	TaxAmount     float64
-	TotalAmount   float64
+	TaxableAmount Amount
+	TaxAmount     Amount
+	TotalAmount   Amount
 }
 
-// CalculateTotal calculates the total amount of the invoice
-func (i *Invoice) CalculateTotal() uint64 {
-	if i.Totals.TotalAmount > 0 {
-		return uint64(i.Totals.TotalAmount)
+// ComputeTotals derives the totals from the detail lines. Line totals are net
+// amounts, except for boletas (39) where prices already include VAT. Exempt
+// lines add to the total without VAT. Totals that do not fit in an Amount
+// are an ErrInvalidInvoice.
+func (i *Invoice) ComputeTotals() error {
+	var sum, exempt Amount
+	var err error
+	for n, detail := range i.Details {
+		if detail.Exempt {
+			exempt, err = exempt.Add(detail.LineTotal)
+		} else {
+			sum, err = sum.Add(detail.LineTotal)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: adding detail %d: %w", ErrInvalidInvoice, n+1, err)
+		}
+	}
+
+	var totals InvoiceTotals
+	switch i.DocumentType {
+	case 34, 41:
+		totals.TotalAmount, err = sum.Add(exempt)
+	case 39:
+		totals.TaxableAmount = sum.NetOfVAT()
+		totals.TaxAmount = sum - totals.TaxableAmount
+		totals.TotalAmount, err = sum.Add(exempt)
+	default:
+		totals.TaxableAmount = sum
+		totals.TaxAmount = sum.VAT()
+		if totals.TotalAmount, err = sum.Add(totals.TaxAmount); err == nil {
+			totals.TotalAmount, err = totals.TotalAmount.Add(exempt)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: total amount: %w", ErrInvalidInvoice, err)
 	}
-	return 100000
+	i.Totals = totals
+	return nil
+}
+
+// Validate checks that the invoice has everything needed to be stamped,
+// regardless of the format it was read from.
+func (i Invoice) Validate() error {
+	var problems []string
+
+	requiresReceiver, supported := _supportedDocumentTypes[i.DocumentType]
+	if !supported {
+		problems = append(problems, fmt.Sprintf("unsupported document type %d", i.DocumentType))
+	}
+	if i.Issuer.Code.IsZero() {
+		problems = append(problems, "issuer RUT is required")
+	}
+	if requiresReceiver && (i.Receiver == nil || i.Receiver.Code.IsZero()) {
+		problems = append(problems, fmt.Sprintf("receiver RUT is required for document type %d", i.DocumentType))
+	}
+	if i.IssueDate.IsZero() {
+		problems = append(problems, "issue date is required")
+	}
+	if len(i.Details) == 0 {
+		problems = append(problems, "at least one detail line is required")
+	}
+	for n, detail := range i.Details {
+		if strings.TrimSpace(detail.Description) == "" {
+			problems = append(problems, fmt.Sprintf("detail %d: description is required", n+1))
+		}
+		if detail.Quantity <= 0 {
+			problems = append(problems, fmt.Sprintf("detail %d: quantity must be positive", n+1))
+		}
+		if detail.UnitPrice < 0 || detail.LineTotal < 0 {
+			problems = append(problems, fmt.Sprintf("detail %d: amounts cannot be negative", n+1))
+		}
+	}
+	if i.Totals.TotalAmount <= 0 {
+		problems = append(problems, "total amount must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInvoice, strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 // StampData represents the data structure for stamp generation
@@ -51,7 +179,7 @@ type StampData struct {
 }
 
 func (inv Invoice) String() string {
-	return fmt.Sprintf("Invoice[Type=%d, Folio=%d, Issuer=%s, Total=%.2f]",
+	return fmt.Sprintf("Invoice[Type=%d, Folio=%d, Issuer=%s, Total=%d]",
 		inv.DocumentType, inv.Folio, inv.Issuer.Code, inv.Totals.TotalAmount)
 }
 
@@ -64,80 +192,36 @@ func (inv Invoice) ToCompany() (Company, error) {
 	return result, nil
 }
 
-// ParseInvoiceXML parses an XML invoice document into Invoice domain object
-func ParseInvoiceXML(xmlData []byte) (*Invoice, error) {
-	invoice := &Invoice{
-		DocumentType: 33,
-		Folio:        1,
-		IssueDate:    time.Now(),
-		Issuer: Company{
-			Name:    "Empresa Ejemplo S.A.",
-			Code:    "12345678-9",
-			Address: "Av. Providencia 123, Santiago",
-		},
-		Receiver: &Company{
-			Name:    "Cliente Ejemplo",
-			Code:    "87654321-K",
-			Address: "Calle Principal 456, Santiago",
-		},
-		Details: []InvoiceDetail{
-			{
-				Quantity:    1,
-				Description: "Producto de Ejemplo",
-				UnitPrice:   10000,
-				LineTotal:   10000,
-			},
-		},
-		Totals: InvoiceTotals{
-			TaxableAmount: 8403,
-			TaxAmount:     1597,
-			TotalAmount:   10000,
-		},
-	}
-
-	return invoice, nil
-}
-
 // InvoiceToStampData converts an Invoice to StampData for stamp generation
 func InvoiceToStampData(invoice *Invoice) *StampData {
 	return &StampData{
-		RutEmisor:    invoice.Issuer.Code,
+		RutEmisor:    invoice.Issuer.Code.String(),
 		TipoDoc:      invoice.DocumentType,
 		Folio:        invoice.Folio,
 		FechaEmision: invoice.IssueDate.Format("2006-01-02"),
 		MontoTotal:   int(invoice.Totals.TotalAmount),
 		RutReceptor: func() string {
 			if invoice.Receiver != nil {
-				return invoice.Receiver.Code
+				return invoice.Receiver.Code.String()
 			}
 			return ""
 		}(),
 	}
 }
 
-// Customer represents customer information for invoice
-type Customer struct {
-	Code string
-	Name string
-}
-
 // Detail represents an invoice detail line
 type Detail struct {
 	Position uint8
 	Product  Product
-	Quantity float64
-	Discount float64
-}
-
-// Product represents a product
-type Product struct {
-	Name  string
-	Price uint64
+	Quantity Decimal
+	Discount Decimal
 }
 
-// InvoiceBuilder provides a builder pattern for creating invoices
+// InvoiceBuilder provides a builder pattern for creating invoices. The first
+// invalid value given to it is returned by Build.
 type InvoiceBuilder struct {
 	invoice Invoice
+	err     error
 }
 
 // NewInvoiceBuilder creates a new invoice builder
@@ -160,35 +244,91 @@ func (ib *InvoiceBuilder) WithHasTaxes(hasTaxes bool) *InvoiceBuilder {
 	return ib
 }
 
+// WithDocumentType sets the SII document type
+func (ib *InvoiceBuilder) WithDocumentType(documentType uint8) *InvoiceBuilder {
+	ib.invoice.DocumentType = documentType
+	return ib
+}
+
+// WithIssuer sets the issuing company
+func (ib *InvoiceBuilder) WithIssuer(issuer Company) *InvoiceBuilder {
+	ib.invoice.Issuer = issuer
+	return ib
+}
+
 // WithCustomer sets the customer
 func (ib *InvoiceBuilder) WithCustomer(customer Customer) *InvoiceBuilder {
-	ib.invoice.Receiver = &Company{
-		Code: customer.Code,
-		Name: customer.Name,
-	}
+	ib.invoice.Receiver = customer.Receiver()
 	return ib
 }
 
-// WithCreationDate sets the creation date
+// WithAssignedFolio sets an explicit folio to stamp; 0 takes the next one
+func (ib *InvoiceBuilder) WithAssignedFolio(folio int64) *InvoiceBuilder {
+	ib.invoice.AssignedFolio = folio
+	return ib
+}
+
+// WithBranchCode sets the SII code of the issuing branch; 0 is the casa matriz
+func (ib *InvoiceBuilder) WithBranchCode(code uint64) *InvoiceBuilder {
+	ib.invoice.BranchCode = code
+	return ib
+}
+
+// WithCreationDate sets the issue date from a YYYY-MM-DD date. An empty date
+// keeps today.
 func (ib *InvoiceBuilder) WithCreationDate(date string) *InvoiceBuilder {
-	if parsedTime, err := time.Parse("2006-01-02", date); err == nil {
-		ib.invoice.IssueDate = parsedTime
+	if date == "" {
+		return ib
+	}
+	parsedTime, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		ib.setErr(fmt.Errorf("%w: invalid issue date %q, expected YYYY-MM-DD", ErrInvalidInvoice, date))
+		return ib
 	}
+	ib.invoice.IssueDate = parsedTime
 	return ib
 }
 
-// AddDetail adds a detail to the invoice
-func (i *Invoice) AddDetail(detail Detail) {
+func (ib *InvoiceBuilder) setErr(err error) {
+	if ib.err == nil {
+		ib.err = err
+	}
+}
+
+// AddDetail adds a detail to the invoice. Discounts are not supported yet and
+// are rejected rather than dropped, which would stamp the undiscounted total.
+func (i *Invoice) AddDetail(detail Detail) error {
+	position := len(i.Details) + 1
+	if detail.Discount != 0 {
+		return fmt.Errorf("%w: detail %d: discounts are not supported", ErrInvalidInvoice, position)
+	}
+	lineTotal, err := detail.Quantity.Mul(detail.Product.Price)
+	if err != nil {
+		return fmt.Errorf("%w: detail %d: %w", ErrInvalidInvoice, position, err)
+	}
+
 	invoiceDetail := InvoiceDetail{
-		Quantity:    detail.Quantity,
-		Description: detail.Product.Name,
-		UnitPrice:   float64(detail.Product.Price),
-		LineTotal:   detail.Quantity * float64(detail.Product.Price),
+		Quantity:           detail.Quantity,
+		Description:        detail.Product.Name,
+		UnitPrice:          detail.Product.Price,
+		LineTotal:          lineTotal,
+		CodeType:           detail.Product.CodeType,
+		Code:               detail.Product.Code,
+		Unit:               detail.Product.Unit,
+		Exempt:             detail.Product.Exempt,
+		AdditionalTaxCodes: detail.Product.AdditionalTaxCodes,
+	}
+	if invoiceDetail.Code != "" && invoiceDetail.CodeType == "" {
+		invoiceDetail.CodeType = DefaultItemCodeType
 	}
 	i.Details = append(i.Details, invoiceDetail)
+	return nil
 }
 
 // Build creates the final invoice
 func (ib *InvoiceBuilder) Build() (Invoice, error) {
+	if ib.err != nil {
+		return Invoice{}, ib.err
+	}
 	return ib.invoice, nil
 }
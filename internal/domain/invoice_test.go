@@ -1,6 +1,9 @@
 package domain
 
 import (
+	"errors"
+	"math"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,20 +14,20 @@ func TestInvoiceUnifiedStructure(t *testing.T) {
 		Folio:        123,
 		IssueDate:    time.Now(),
 		Issuer: Company{
-			Code:    "12345678-9",
+			Code:    MustParseRUT("12345678-5"),
 			Name:    "Test Company",
 			Address: "Test Address",
 		},
 		Receiver: &Company{
-			Code:    "87654321-K",
+			Code:    MustParseRUT("87654321-4"),
 			Name:    "Test Customer",
 			Address: "Customer Address",
 		},
 		Details: []InvoiceDetail{
 			{
-				Quantity:    2,
+				Quantity:    NewDecimal(2),
 				Description: "Test Product",
-				UnitPrice:   1000,
+				UnitPrice:   NewDecimal(1000),
 				LineTotal:   2000,
 			},
 		},
@@ -35,11 +38,6 @@ func TestInvoiceUnifiedStructure(t *testing.T) {
 		},
 	}
 
-	total := invoice.CalculateTotal()
-	if total != 2000 {
-		t.Errorf("Expected total 2000, got %d", total)
-	}
-
 	str := invoice.String()
 	if str == "" {
 		t.Error("String method should not return empty string")
@@ -49,13 +47,13 @@ func TestInvoiceUnifiedStructure(t *testing.T) {
 	if err != nil {
 		t.Errorf("ToCompany failed: %v", err)
 	}
-	if company.Code != "12345678-9" {
-		t.Errorf("Expected company code '12345678-9', got '%s'", company.Code)
+	if company.Code.String() != "12345678-5" {
+		t.Errorf("Expected company code '12345678-5', got '%s'", company.Code)
 	}
 
 	stampData := InvoiceToStampData(&invoice)
-	if stampData.RutEmisor != "12345678-9" {
-		t.Errorf("Expected RutEmisor '12345678-9', got '%s'", stampData.RutEmisor)
+	if stampData.RutEmisor != "12345678-5" {
+		t.Errorf("Expected RutEmisor '12345678-5', got '%s'", stampData.RutEmisor)
 	}
 	if stampData.TipoDoc != 33 {
 		t.Errorf("Expected TipoDoc 33, got %d", stampData.TipoDoc)
@@ -67,7 +65,7 @@ func TestInvoiceUnifiedStructure(t *testing.T) {
 
 func TestInvoiceBuilder(t *testing.T) {
 	customer := Customer{
-		Code: "12345678-9",
+		Code: MustParseRUT("12345678-5"),
 		Name: "Test Customer",
 	}
 
@@ -75,9 +73,9 @@ func TestInvoiceBuilder(t *testing.T) {
 		Position: 1,
 		Product: Product{
 			Name:  "Test Product",
-			Price: 1000,
+			Price: NewDecimal(1000),
 		},
-		Quantity: 2,
+		Quantity: NewDecimal(2),
 		Discount: 0,
 	}
 
@@ -98,8 +96,8 @@ func TestInvoiceBuilder(t *testing.T) {
 	if invoice.Receiver == nil {
 		t.Error("Expected receiver to be set")
 	} else {
-		if invoice.Receiver.Code != "12345678-9" {
-			t.Errorf("Expected receiver code '12345678-9', got '%s'", invoice.Receiver.Code)
+		if invoice.Receiver.Code.String() != "12345678-5" {
+			t.Errorf("Expected receiver code '12345678-5', got '%s'", invoice.Receiver.Code)
 		}
 	}
 
@@ -108,7 +106,9 @@ func TestInvoiceBuilder(t *testing.T) {
 		t.Errorf("Expected issue date '%s', got '%s'", expectedDate, invoice.IssueDate.Format("2006-01-02"))
 	}
 
-	invoice.AddDetail(detail)
+	if err := invoice.AddDetail(detail); err != nil {
+		t.Fatalf("AddDetail failed: %v", err)
+	}
 
 	if len(invoice.Details) != 1 {
 		t.Errorf("Expected 1 detail, got %d", len(invoice.Details))
@@ -117,37 +117,127 @@ func TestInvoiceBuilder(t *testing.T) {
 	if invoice.Details[0].Description != "Test Product" {
 		t.Errorf("Expected detail description 'Test Product', got '%s'", invoice.Details[0].Description)
 	}
+
+	detail.Discount = NewDecimal(100)
+	if err := invoice.AddDetail(detail); !errors.Is(err, ErrInvalidInvoice) {
+		t.Errorf("Expected a discount to be rejected, got %v", err)
+	}
+	detail.Discount = 0
+	detail.Quantity = MustParseDecimal("999999999999")
+	detail.Product.Price = MustParseDecimal("999999999999")
+	if err := invoice.AddDetail(detail); !errors.Is(err, ErrAmountOutOfRange) {
+		t.Errorf("Expected an overflowing line to be rejected, got %v", err)
+	}
+	if len(invoice.Details) != 1 {
+		t.Errorf("Expected rejected details to be left out, got %d", len(invoice.Details))
+	}
 }
 
-func TestParseInvoiceXML(t *testing.T) {
-	xmlData := []byte(`<invoice></invoice>`)
+func TestInvoiceComputeTotals(t *testing.T) {
+	tests := []struct {
+		documentType uint8
+		want         InvoiceTotals
+	}{
+		{documentType: 33, want: InvoiceTotals{TaxableAmount: 10000, TaxAmount: 1900, TotalAmount: 11900}},
+		{documentType: 34, want: InvoiceTotals{TotalAmount: 10000}},
+		{documentType: 39, want: InvoiceTotals{TaxableAmount: 8403, TaxAmount: 1597, TotalAmount: 10000}},
+	}
 
-	invoice, err := ParseInvoiceXML(xmlData)
-	if err != nil {
-		t.Errorf("ParseInvoiceXML failed: %v", err)
+	for _, tt := range tests {
+		invoice := Invoice{
+			DocumentType: tt.documentType,
+			Details:      []InvoiceDetail{{Quantity: NewDecimal(2), UnitPrice: NewDecimal(5000), LineTotal: 10000}},
+		}
+		if err := invoice.ComputeTotals(); err != nil {
+			t.Fatalf("type %d: ComputeTotals failed: %v", tt.documentType, err)
+		}
+		if invoice.Totals != tt.want {
+			t.Errorf("type %d: expected %+v, got %+v", tt.documentType, tt.want, invoice.Totals)
+		}
 	}
+}
 
-	if invoice == nil {
-		t.Error("ParseInvoiceXML returned nil invoice")
+func TestInvoiceComputeTotals_Exempt(t *testing.T) {
+	tests := []struct {
+		documentType uint8
+		want         InvoiceTotals
+	}{
+		{documentType: 33, want: InvoiceTotals{TaxableAmount: 10000, TaxAmount: 1900, TotalAmount: 14900}},
+		{documentType: 34, want: InvoiceTotals{TotalAmount: 13000}},
+		{documentType: 39, want: InvoiceTotals{TaxableAmount: 8403, TaxAmount: 1597, TotalAmount: 13000}},
+	}
+
+	for _, tt := range tests {
+		invoice := Invoice{
+			DocumentType: tt.documentType,
+			Details: []InvoiceDetail{
+				{Quantity: NewDecimal(2), UnitPrice: NewDecimal(5000), LineTotal: 10000},
+				{Quantity: NewDecimal(1), UnitPrice: NewDecimal(3000), LineTotal: 3000, Exempt: true},
+			},
+		}
+		if err := invoice.ComputeTotals(); err != nil {
+			t.Fatalf("type %d: ComputeTotals failed: %v", tt.documentType, err)
+		}
+		if invoice.Totals != tt.want {
+			t.Errorf("type %d: expected %+v, got %+v", tt.documentType, tt.want, invoice.Totals)
+		}
 	}
+}
 
-	if invoice.DocumentType != 33 {
-		t.Errorf("Expected DocumentType 33, got %d", invoice.DocumentType)
+func TestInvoiceComputeTotals_Overflow(t *testing.T) {
+	invoice := Invoice{
+		DocumentType: 33,
+		Details: []InvoiceDetail{
+			{Quantity: NewDecimal(1), LineTotal: math.MaxInt64 / 2},
+			{Quantity: NewDecimal(1), LineTotal: math.MaxInt64 / 2},
+		},
 	}
+	// The lines fit, the IVA on them does not.
+	if err := invoice.ComputeTotals(); !errors.Is(err, ErrInvalidInvoice) || !errors.Is(err, ErrAmountOutOfRange) {
+		t.Errorf("Expected an overflowing total to be rejected, got %v", err)
+	}
+	if invoice.Totals != (InvoiceTotals{}) {
+		t.Errorf("Expected totals to be left alone, got %+v", invoice.Totals)
+	}
+}
 
-	if invoice.Issuer.Code != "12345678-9" {
-		t.Errorf("Expected issuer code '12345678-9', got '%s'", invoice.Issuer.Code)
+func TestInvoiceValidate(t *testing.T) {
+	valid := Invoice{
+		DocumentType: 33,
+		IssueDate:    time.Now(),
+		Issuer:       Company{Code: MustParseRUT("76212889-6")},
+		Receiver:     &Company{Code: MustParseRUT("77371419-3")},
+		Details:      []InvoiceDetail{{Quantity: NewDecimal(1), Description: "Item", UnitPrice: NewDecimal(100), LineTotal: 100}},
+		Totals:       InvoiceTotals{TotalAmount: 119},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected valid invoice, got %v", err)
 	}
 
-	if invoice.Receiver == nil {
-		t.Error("Expected receiver to be set")
-	} else {
-		if invoice.Receiver.Code != "87654321-K" {
-			t.Errorf("Expected receiver code '87654321-K', got '%s'", invoice.Receiver.Code)
-		}
+	boleta := valid
+	boleta.DocumentType = 39
+	boleta.Receiver = nil
+	if err := boleta.Validate(); err != nil {
+		t.Errorf("Expected boleta without receiver to be valid, got %v", err)
 	}
 
-	if len(invoice.Details) == 0 {
-		t.Error("Expected at least one detail")
+	noReceiverRUT := valid
+	noReceiverRUT.Receiver = &Company{Name: "Cliente"}
+	if err := noReceiverRUT.Validate(); !errors.Is(err, ErrInvalidInvoice) || !strings.Contains(err.Error(), "receiver RUT is required") {
+		t.Errorf("Expected a factura without receiver RUT to be rejected, got %v", err)
+	}
+
+	invalid := valid
+	invalid.DocumentType = 99
+	invalid.Receiver = nil
+	invalid.Details = []InvoiceDetail{{Quantity: NewDecimal(0)}}
+	err := invalid.Validate()
+	if !errors.Is(err, ErrInvalidInvoice) {
+		t.Fatalf("Expected ErrInvalidInvoice, got %v", err)
+	}
+	for _, problem := range []string{"unsupported document type 99", "detail 1: description is required", "detail 1: quantity must be positive"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %q", problem, err.Error())
+		}
 	}
 }
@@ -0,0 +1,239 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is an amount of Chilean pesos. Every amount the SII takes (MontoItem,
+// MntNeto, MntExe, IVA, MntTotal) is a whole number of pesos.
+type Amount int64
+
+// Decimal is a quantity or unit price (QtyItem, PrcItem). The SII allows up to
+// six decimals, so it is kept as an exact count of millionths.
+type Decimal int64
+
+const (
+	DecimalPlaces = 6
+	decimalScale  = 1_000_000
+	// _maxIntegerDigits keeps the millionths within an int64; the SII fields
+	// allow 12 integer digits besides the 6 decimals anyway.
+	_maxIntegerDigits = 12
+	_vatPercent       = 19
+)
+
+var (
+	ErrInvalidNumber = errors.New("invalid number")
+	// ErrAmountOutOfRange is returned when an amount does not fit in an
+	// int64 number of pesos.
+	ErrAmountOutOfRange = errors.New("amount out of range")
+)
+
+// NewDecimal returns the whole number n as a Decimal.
+func NewDecimal(n int64) Decimal {
+	return Decimal(n * decimalScale)
+}
+
+// ParseDecimal reads a number with a decimal point and at most six decimals,
+// such as "2", "0.90" or "39107.900000". More decimals are an error rather
+// than being rounded away silently.
+func ParseDecimal(value string) (Decimal, error) {
+	s := strings.TrimSpace(value)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	integer, fraction, _ := strings.Cut(s, ".")
+	if integer == "" && fraction == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, value)
+	}
+	if !isDigits(integer) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, value)
+	}
+	integer = strings.TrimLeft(integer, "0")
+	if len(integer) > _maxIntegerDigits {
+		return 0, fmt.Errorf("%w: %q has more than %d integer digits", ErrInvalidNumber, value, _maxIntegerDigits)
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > DecimalPlaces {
+		return 0, fmt.Errorf("%w: %q has more than %d decimals", ErrInvalidNumber, value, DecimalPlaces)
+	}
+
+	digits := strings.TrimLeft(integer+fraction+strings.Repeat("0", DecimalPlaces-len(fraction)), "0")
+	if digits == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, value)
+	}
+	if negative {
+		n = -n
+	}
+	return Decimal(n), nil
+}
+
+// MustParseDecimal is ParseDecimal for constants; it panics on invalid input.
+func MustParseDecimal(value string) Decimal {
+	d, err := ParseDecimal(value)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String prints the decimal with a point and no trailing zeros: "2", "0.9".
+func (d Decimal) String() string {
+	n := int64(d)
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	integer, fraction := n/decimalScale, n%decimalScale
+	if fraction == 0 {
+		return sign + strconv.FormatInt(integer, 10)
+	}
+	digits := strings.TrimRight(fmt.Sprintf("%06d", fraction), "0")
+	return sign + strconv.FormatInt(integer, 10) + "." + digits
+}
+
+// IsInteger reports whether the decimal has no fractional part.
+func (d Decimal) IsInteger() bool {
+	return d%decimalScale == 0
+}
+
+// Round rounds the decimal to whole pesos, halves away from zero. The result
+// is smaller than d, so it always fits.
+func (d Decimal) Round() Amount {
+	return Amount(roundDiv(big.NewInt(int64(d)), big.NewInt(decimalScale)).Int64())
+}
+
+// Mul returns d × e rounded to whole pesos, halves away from zero, as the SII
+// rounds QtyItem × PrcItem into MontoItem. It returns ErrAmountOutOfRange
+// when the product does not fit in an Amount.
+func (d Decimal) Mul(e Decimal) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(e)))
+	return toAmount(roundDiv(product, big.NewInt(decimalScale*decimalScale)))
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText leaves d at zero for empty text, as XML does for empty
+// elements.
+func (d *Decimal) UnmarshalText(text []byte) error {
+	if len(bytes.TrimSpace(text)) == 0 {
+		*d = 0
+		return nil
+	}
+	parsed, err := ParseDecimal(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON takes a JSON number or a string holding one.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	return d.UnmarshalText(bytes.Trim(data, `"`))
+}
+
+// String prints the amount as a plain integer, as it goes into the TED.
+func (a Amount) String() string {
+	return strconv.FormatInt(int64(a), 10)
+}
+
+// Add returns a + b, or ErrAmountOutOfRange when the sum overflows.
+func (a Amount) Add(b Amount) (Amount, error) {
+	return toAmount(new(big.Int).Add(big.NewInt(int64(a)), big.NewInt(int64(b))))
+}
+
+// VAT returns the IVA on the net amount a, rounded to whole pesos. The
+// product is taken in big.Int; the result is smaller than a, so it fits.
+func (a Amount) VAT() Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(_vatPercent))
+	return Amount(roundDiv(product, big.NewInt(100)).Int64())
+}
+
+// NetOfVAT returns the net part of a, an amount that includes IVA, rounded to
+// whole pesos. The IVA is what remains: a - a.NetOfVAT().
+func (a Amount) NetOfVAT() Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(100))
+	return Amount(roundDiv(product, big.NewInt(100+_vatPercent)).Int64())
+}
+
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText takes whole pesos. Decimals are accepted only when they are
+// zeros, as in "1500.00"; anything else is an error rather than being
+// rounded to a different amount.
+func (a *Amount) UnmarshalText(text []byte) error {
+	var d Decimal
+	if err := d.UnmarshalText(text); err != nil {
+		return err
+	}
+	if !d.IsInteger() {
+		return fmt.Errorf("%w: %q is not a whole number of pesos", ErrInvalidNumber, text)
+	}
+	*a = d.Round()
+	return nil
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON takes a JSON number or a string holding one.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	return a.UnmarshalText(bytes.Trim(data, `"`))
+}
+
+// roundDiv divides n by the positive d rounding halves away from zero.
+func roundDiv(n, d *big.Int) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(n, d, new(big.Int))
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	if twice.Cmp(d) >= 0 {
+		if n.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient
+}
+
+// toAmount returns n as an Amount, or ErrAmountOutOfRange when it does not
+// fit in an int64.
+func toAmount(n *big.Int) (Amount, error) {
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: %s", ErrAmountOutOfRange, n)
+	}
+	return Amount(n.Int64()), nil
+}
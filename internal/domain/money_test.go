@@ -0,0 +1,253 @@
+package domain
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+// _maxDecimal is the largest magnitude ParseDecimal accepts: 12 integer
+// digits and 6 decimals.
+const _maxDecimal = 999_999_999_999_999_999
+
+func boundedDecimal(n int64) Decimal {
+	return Decimal(n % _maxDecimal)
+}
+
+func TestParseDecimal(t *testing.T) {
+	valid := map[string]Decimal{
+		"2":            NewDecimal(2),
+		"0.90":         900_000,
+		".5":           500_000,
+		"39107.900000": 39_107_900_000,
+		"-1.5":         -1_500_000,
+		"+3":           NewDecimal(3),
+		" 007.000001 ": 7_000_001,
+		"0":            0,
+		"-0.0":         0,
+		"999999999999": NewDecimal(999_999_999_999),
+		"1.1000000000": 1_100_000,
+	}
+	for input, want := range valid {
+		got, err := ParseDecimal(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: expected %d, got %d", input, want, got)
+		}
+	}
+
+	for _, input := range []string{"", ".", "-", "1.1234567", "1,5", "1e3", "abc", "1.2.3", "1000000000000"} {
+		if _, err := ParseDecimal(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestDecimal_StringRoundTrip(t *testing.T) {
+	property := func(n int64) bool {
+		d := boundedDecimal(n)
+		parsed, err := ParseDecimal(d.String())
+		return err == nil && parsed == d
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecimal_Mul(t *testing.T) {
+	tests := []struct {
+		quantity, price string
+		want            Amount
+	}{
+		{"0.90", "39107.9", 35197},
+		// 28.499999999999996 in float64, 28.5 exactly
+		{"0.285", "100", 29},
+		{"0.5", "1", 1},
+		{"2.5", "1", 3},
+		{"-2.5", "1", -3},
+		{"1.5", "12000", 18000},
+		{"0.000001", "0.4", 0},
+	}
+	for _, tt := range tests {
+		got, err := MustParseDecimal(tt.quantity).Mul(MustParseDecimal(tt.price))
+		if err != nil || got != tt.want {
+			t.Errorf("%s x %s: expected %d, got %d, %v", tt.quantity, tt.price, tt.want, got, err)
+		}
+	}
+
+	huge := MustParseDecimal("999999999999")
+	if _, err := huge.Mul(huge); !errors.Is(err, ErrAmountOutOfRange) {
+		t.Errorf("Expected ErrAmountOutOfRange, got %v", err)
+	}
+}
+
+// The rounded product is never more than half a peso away from the exact one,
+// and halves go away from zero.
+func TestDecimal_MulRoundsToNearest(t *testing.T) {
+	scale := big.NewRat(decimalScale, 1)
+	property := func(q, p int32) bool {
+		quantity, price := Decimal(q), Decimal(p)*1000
+		product, err := quantity.Mul(price)
+		if err != nil {
+			return false
+		}
+		exact := new(big.Rat).Mul(new(big.Rat).Quo(big.NewRat(int64(quantity), 1), scale), new(big.Rat).Quo(big.NewRat(int64(price), 1), scale))
+		diff := new(big.Rat).Sub(big.NewRat(int64(product), 1), exact)
+		half := big.NewRat(1, 2)
+		switch diff.Abs(diff).Cmp(half) {
+		case 1:
+			return false
+		case 0:
+			return (product > 0) == (exact.Sign() > 0)
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecimal_MulWholeNumbersIsExact(t *testing.T) {
+	property := func(q, p int16) bool {
+		product, err := NewDecimal(int64(q)).Mul(NewDecimal(int64(p)))
+		return err == nil && product == Amount(int64(q)*int64(p))
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAmount_VAT(t *testing.T) {
+	tests := []struct {
+		net, want Amount
+	}{
+		{10000, 1900},
+		{35197, 6687},
+		{1, 0},
+		{3, 1},   // 0.57
+		{50, 10}, // 9.5
+	}
+	for _, tt := range tests {
+		if got := tt.net.VAT(); got != tt.want {
+			t.Errorf("VAT of %d: expected %d, got %d", tt.net, tt.want, got)
+		}
+	}
+
+	if got := Amount(1500).NetOfVAT(); got != 1261 {
+		t.Errorf("Expected net 1261 of 1500, got %d", got)
+	}
+	if got := Amount(10000).NetOfVAT(); got != 8403 {
+		t.Errorf("Expected net 8403 of 10000, got %d", got)
+	}
+
+	// a × 19 overflows an int64 here, the IVA itself does not.
+	if got, want := Amount(math.MaxInt64/10).VAT(), Amount(175244068700240740); got != want {
+		t.Errorf("Expected VAT %d, got %d", want, got)
+	}
+	if got, want := Amount(math.MaxInt64/10).NetOfVAT(), Amount(775073280407964353); got != want {
+		t.Errorf("Expected net %d, got %d", want, got)
+	}
+	if _, err := Amount(math.MaxInt64).Add(1); !errors.Is(err, ErrAmountOutOfRange) {
+		t.Errorf("Expected ErrAmountOutOfRange, got %v", err)
+	}
+}
+
+// Splitting a total with IVA into net and IVA loses at most a peso against
+// computing the IVA on that net.
+func TestAmount_NetOfVATProperty(t *testing.T) {
+	property := func(n uint32) bool {
+		total := Amount(n)
+		net := total.NetOfVAT()
+		tax := total - net
+		diff := tax - net.VAT()
+		return net >= 0 && net <= total && diff >= -1 && diff <= 1
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvoiceComputeTotals_Property(t *testing.T) {
+	property := func(documentType bool, lines []struct {
+		Quantity uint16
+		Price    uint32
+		Exempt   bool
+	}) bool {
+		invoice := Invoice{DocumentType: 33}
+		if documentType {
+			invoice.DocumentType = 39
+		}
+		var sum Amount
+		for _, line := range lines {
+			detail := InvoiceDetail{Quantity: Decimal(line.Quantity) * 1000, UnitPrice: Decimal(line.Price) * 100, Exempt: line.Exempt}
+			detail.LineTotal, _ = detail.Quantity.Mul(detail.UnitPrice)
+			invoice.Details = append(invoice.Details, detail)
+			sum += detail.LineTotal
+		}
+		if err := invoice.ComputeTotals(); err != nil {
+			return false
+		}
+
+		totals := invoice.Totals
+		exempt := totals.TotalAmount - totals.TaxableAmount - totals.TaxAmount
+		if exempt < 0 || totals.TaxableAmount < 0 || totals.TaxAmount < 0 {
+			return false
+		}
+		if invoice.DocumentType == 39 {
+			// Boleta prices include IVA: the lines add up to the total
+			return totals.TotalAmount == sum
+		}
+		return totals.TotalAmount == sum+totals.TaxAmount && totals.TaxAmount == totals.TaxableAmount.VAT()
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecimal_Encoding(t *testing.T) {
+	var request struct {
+		Quantity Decimal `json:"quantity"`
+		Price    Decimal `json:"price"`
+		Discount Decimal `json:"discount"`
+		Total    Amount  `json:"total"`
+	}
+	if err := json.Unmarshal([]byte(`{"quantity": 0.90, "price": "39107.9", "discount": null, "total": 35197}`), &request); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if request.Quantity != 900_000 || request.Price != 39_107_900_000 || request.Total != 35197 {
+		t.Errorf("Unexpected values %+v", request)
+	}
+	if err := json.Unmarshal([]byte(`{"quantity": 0.1234567}`), &request); err == nil {
+		t.Error("Expected more than six decimals to be rejected")
+	}
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(encoded) != `{"quantity":0.9,"price":39107.9,"discount":0,"total":35197}` {
+		t.Errorf("Unexpected JSON %s", encoded)
+	}
+
+	var detail struct {
+		Quantity Decimal `xml:"QtyItem"`
+		Price    Decimal `xml:"PrcItem"`
+		Total    Amount  `xml:"MontoItem"`
+	}
+	if err := xml.Unmarshal([]byte(`<Detalle><QtyItem>0.90</QtyItem><PrcItem></PrcItem><MontoItem>35197.00</MontoItem></Detalle>`), &detail); err != nil {
+		t.Fatalf("xml.Unmarshal failed: %v", err)
+	}
+	if detail.Quantity != 900_000 || detail.Price != 0 || detail.Total != 35197 {
+		t.Errorf("Unexpected values %+v", detail)
+	}
+	if err := xml.Unmarshal([]byte(`<Detalle><MontoItem>35197.4</MontoItem></Detalle>`), &detail); !errors.Is(err, ErrInvalidNumber) {
+		t.Errorf("Expected an amount with decimals to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package domain
+
+import "time"
+
+// DefaultItemCodeType is the TpoCodigo of codes given without a type: the
+// issuer's internal code.
+const DefaultItemCodeType = "INT1"
+
+// Product is an item documents are issued for. Requests may give every field
+// of a line, or just its Code and quantity to take the rest from the
+// issuer's product catalogue.
+type Product struct {
+	ID        string
+	CompanyID string
+
+	// CodeType and Code are the TpoCodigo and VlrCodigo of the item; Code is
+	// the key of the catalogue.
+	CodeType string
+	Code     string
+	Name     string
+	// Unit is the unit of measure printed next to the quantity (UnmdItem).
+	Unit string
+	// Price is the default unit price, net for facturas and with VAT for
+	// boletas like the lines that use it.
+	Price Decimal
+	// Exempt marks items not subject to IVA (IndExe).
+	Exempt bool
+	// AdditionalTaxCodes are the SII codes of the additional taxes or
+	// retentions on the item (CodImpAdic), such as 271 for sugary drinks.
+	AdditionalTaxCodes []string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
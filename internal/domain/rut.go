@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidRUT is returned when a RUT is not digits, a hyphen and a check
+// digit once separators are removed, or its check digit does not match.
+var ErrInvalidRUT = errors.New("invalid RUT")
+
+// _maxRUTNumber is the largest RUT number, eight digits.
+const _maxRUTNumber = 99_999_999
+
+// RUT is a Chilean tax id (Rol Único Tributario). The zero value is no RUT.
+type RUT struct {
+	number     uint32
+	checkDigit byte
+}
+
+// ParseRUT reads a RUT in any of its usual spellings: "76.212.889-6",
+// "76212889-6", "762128896" or with a lower case "k", and checks its
+// módulo 11 check digit.
+func ParseRUT(value string) (RUT, error) {
+	cleaned := strings.ToUpper(strings.NewReplacer(".", "", " ", "").Replace(strings.TrimSpace(value)))
+	body, dv, found := strings.Cut(cleaned, "-")
+	if !found && len(cleaned) > 1 {
+		body, dv = cleaned[:len(cleaned)-1], cleaned[len(cleaned)-1:]
+	}
+	body = strings.TrimLeft(body, "0")
+
+	if body == "" || len(body) > 8 || strings.Trim(body, "0123456789") != "" {
+		return RUT{}, fmt.Errorf("%w: %q", ErrInvalidRUT, value)
+	}
+	if len(dv) != 1 || !strings.Contains("0123456789K", dv) {
+		return RUT{}, fmt.Errorf("%w: %q", ErrInvalidRUT, value)
+	}
+
+	number, _ := strconv.ParseUint(body, 10, 32)
+	rut := RUT{number: uint32(number), checkDigit: dv[0]}
+	if want := rutCheckDigit(rut.number); rut.checkDigit != want {
+		return RUT{}, fmt.Errorf("%w: %q, check digit should be %c", ErrInvalidRUT, value, want)
+	}
+	return rut, nil
+}
+
+// NewRUT returns the RUT with the given number and its computed check digit.
+func NewRUT(number uint32) (RUT, error) {
+	if number == 0 || number > _maxRUTNumber {
+		return RUT{}, fmt.Errorf("%w: %d", ErrInvalidRUT, number)
+	}
+	return RUT{number: number, checkDigit: rutCheckDigit(number)}, nil
+}
+
+// MustParseRUT is ParseRUT for constants; it panics on invalid input.
+func MustParseRUT(value string) RUT {
+	rut, err := ParseRUT(value)
+	if err != nil {
+		panic(err)
+	}
+	return rut
+}
+
+// rutCheckDigit computes the módulo 11 check digit of a RUT number.
+func rutCheckDigit(number uint32) byte {
+	sum, factor := uint32(0), uint32(2)
+	for ; number > 0; number /= 10 {
+		sum += number % 10 * factor
+		if factor++; factor > 7 {
+			factor = 2
+		}
+	}
+	switch dv := 11 - sum%11; dv {
+	case 11:
+		return '0'
+	case 10:
+		return 'K'
+	default:
+		return byte('0' + dv)
+	}
+}
+
+func (r RUT) IsZero() bool {
+	return r.number == 0
+}
+
+// Number returns the RUT without its check digit.
+func (r RUT) Number() uint32 {
+	return r.number
+}
+
+// String writes the RUT the way the SII expects it in the timbre and the way
+// it is stored: without thousands separators, with a hyphen before the upper
+// case check digit ("76212889-6"). The zero RUT is "".
+func (r RUT) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	return strconv.FormatUint(uint64(r.number), 10) + "-" + string(r.checkDigit)
+}
+
+// Format writes the RUT for people, with dots as thousands separators
+// ("76.212.889-6").
+func (r RUT) Format() string {
+	if r.IsZero() {
+		return ""
+	}
+	digits := strconv.FormatUint(uint64(r.number), 10)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String() + "-" + string(r.checkDigit)
+}
+
+func (r RUT) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText leaves r at zero for empty text.
+func (r *RUT) UnmarshalText(text []byte) error {
+	if strings.TrimSpace(string(text)) == "" {
+		*r = RUT{}
+		return nil
+	}
+	parsed, err := ParseRUT(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/quick"
+)
+
+func TestParseRUT(t *testing.T) {
+	rut, err := ParseRUT("76.212.889-6")
+	if err != nil {
+		t.Fatalf("ParseRUT failed: %v", err)
+	}
+	if rut.String() != "76212889-6" || rut.Format() != "76.212.889-6" || rut.Number() != 76212889 {
+		t.Errorf("Unexpected RUT %s / %s / %d", rut, rut.Format(), rut.Number())
+	}
+	if other := MustParseRUT("762128896"); other != rut {
+		t.Errorf("Expected spellings of the same RUT to be equal, got %v and %v", other, rut)
+	}
+
+	formats := map[string]string{
+		"1-9":          "1-9",
+		"7654321-6":    "7.654.321-6",
+		"12.345.670-k": "12.345.670-K",
+		"66666666-6":   "66.666.666-6",
+	}
+	for input, want := range formats {
+		if got := MustParseRUT(input).Format(); got != want {
+			t.Errorf("Format(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	for _, input := range []string{"76212889-5", "12345678-K", "0-0", "", "76212889", "sin rut"} {
+		if _, err := ParseRUT(input); !errors.Is(err, ErrInvalidRUT) {
+			t.Errorf("ParseRUT(%q): expected ErrInvalidRUT, got %v", input, err)
+		}
+	}
+
+	var zero RUT
+	if !zero.IsZero() || zero.String() != "" || zero.Format() != "" {
+		t.Errorf("Expected the zero RUT to print empty, got %q", zero.String())
+	}
+}
+
+// Every number has exactly one valid check digit, and both spellings read
+// back as the same RUT.
+func TestRUT_Properties(t *testing.T) {
+	property := func(n uint32) bool {
+		number := n%_maxRUTNumber + 1
+		rut, err := NewRUT(number)
+		if err != nil {
+			return false
+		}
+		for _, spelling := range []string{rut.String(), rut.Format()} {
+			if parsed, err := ParseRUT(spelling); err != nil || parsed != rut {
+				return false
+			}
+		}
+
+		valid := 0
+		for _, dv := range "0123456789K" {
+			if _, err := ParseRUT(rut.Format()[:len(rut.Format())-1] + string(dv)); err == nil {
+				valid++
+			}
+		}
+		return valid == 1
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRUT_Encoding(t *testing.T) {
+	var dd struct {
+		RE RUT `json:"re"`
+		RR RUT `json:"rr"`
+	}
+	if err := json.Unmarshal([]byte(`{"re": "76.212.889-6", "rr": ""}`), &dd); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	encoded, err := json.Marshal(dd)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(encoded) != `{"re":"76212889-6","rr":""}` {
+		t.Errorf("Unexpected JSON %s", encoded)
+	}
+	if err := json.Unmarshal([]byte(`{"re": "76212889-5"}`), &dd); !errors.Is(err, ErrInvalidRUT) {
+		t.Errorf("Expected ErrInvalidRUT, got %v", err)
+	}
+}
@@ -1,16 +1,26 @@
 package domain
 
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
 type Stamp struct {
 	DD   DD
 	FRMT string
 }
 
 type DD struct {
-	RE    string   `xml:"RE"`
+	RE    RUT      `xml:"RE"`
 	TD    uint8    `xml:"TD"`
 	F     int64    `xml:"F"`
 	FE    string   `xml:"FE"`
-	RR    string   `xml:"RR"`
+	RR    RUT      `xml:"RR"`
 	RSR   string   `xml:"RSR"`
 	MNT   uint64   `xml:"MNT"`
 	IT1   string   `xml:"IT1"`
@@ -49,3 +59,121 @@ type StampFRMA struct {
 	Algorithm string `xml:"algoritmo,attr"`
 	Value     string `xml:",chardata"`
 }
+
+// SII rules for the document data of the timbre.
+const (
+	// TEDTextLimit is the number of characters of RSR and IT1 the SII keeps.
+	TEDTextLimit = 40
+	// AnonymousReceiverRUT is the receiver of boletas issued without one.
+	AnonymousReceiverRUT = "66666666-6"
+	// AnonymousReceiverName is the RSR of boletas issued without a receiver.
+	AnonymousReceiverName = "CONSUMIDOR FINAL"
+	// DefaultItemName is the IT1 of documents without detail lines.
+	DefaultItemName = "Producto"
+
+	_tedDateLayout      = "2006-01-02"
+	_tedTimestampLayout = "2006-01-02T15:04:05"
+)
+
+// _chileTime is the time zone of TSTED; time/tzdata embeds it for hosts
+// without a zoneinfo database.
+var _chileTime = mustLoadLocation("America/Santiago")
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("loading time zone %s: %v", name, err))
+	}
+	return location
+}
+
+// DDBuilder builds the DD of a timbre applying the SII field rules: RUTs
+// without thousands separators and with an upper case check digit, RSR and
+// IT1 cut to 40 characters, the anonymous receiver on boletas without one and
+// TSTED in Chile time. F and CAF are left for the caller, which should only
+// take a folio once Build succeeds.
+type DDBuilder struct {
+	issuerRUT RUT
+	invoice   Invoice
+	stampedAt time.Time
+}
+
+// NewDDBuilder creates a DD builder stamping at the current time.
+func NewDDBuilder() *DDBuilder {
+	return &DDBuilder{}
+}
+
+// WithIssuerRUT sets the RUT of the issuing company (RE).
+func (b *DDBuilder) WithIssuerRUT(value RUT) *DDBuilder {
+	b.issuerRUT = value
+	return b
+}
+
+// WithInvoice sets the document the DD describes.
+func (b *DDBuilder) WithInvoice(value Invoice) *DDBuilder {
+	b.invoice = value
+	return b
+}
+
+// WithStampedAt sets the signing time (TSTED). The zero time means now.
+func (b *DDBuilder) WithStampedAt(value time.Time) *DDBuilder {
+	b.stampedAt = value
+	return b
+}
+
+// Build checks the RUTs and the total and returns the DD, without F and CAF.
+func (b *DDBuilder) Build() (DD, error) {
+	issuer := b.issuerRUT
+	if issuer.IsZero() {
+		return DD{}, fmt.Errorf("issuer: %w: RUT is required", ErrInvalidRUT)
+	}
+
+	invoice := b.invoice
+	var receiverRUT RUT
+	receiverName := ""
+	if invoice.Receiver != nil {
+		receiverRUT, receiverName = invoice.Receiver.Code, invoice.Receiver.Name
+	}
+	isBoleta := invoice.DocumentType == 39 || invoice.DocumentType == 41
+	if receiverRUT.IsZero() && isBoleta {
+		receiverRUT = MustParseRUT(AnonymousReceiverRUT)
+	}
+	if strings.TrimSpace(receiverName) == "" && receiverRUT.String() == AnonymousReceiverRUT {
+		receiverName = AnonymousReceiverName
+	}
+
+	if invoice.Totals.TotalAmount <= 0 {
+		return DD{}, fmt.Errorf("%w: total amount must be positive", ErrInvalidInvoice)
+	}
+
+	item := DefaultItemName
+	if len(invoice.Details) > 0 {
+		item = invoice.Details[0].Description
+	}
+
+	stampedAt := b.stampedAt
+	if stampedAt.IsZero() {
+		stampedAt = time.Now()
+	}
+
+	return DD{
+		RE:    issuer,
+		TD:    invoice.DocumentType,
+		FE:    invoice.IssueDate.Format(_tedDateLayout),
+		RR:    receiverRUT,
+		RSR:   truncateTEDText(receiverName),
+		MNT:   uint64(invoice.Totals.TotalAmount),
+		IT1:   truncateTEDText(item),
+		TSTED: stampedAt.In(_chileTime).Format(_tedTimestampLayout),
+	}, nil
+}
+
+// truncateTEDText keeps the first 40 characters of s, counting characters
+// after NFC normalisation like the canonical TED does.
+func truncateTEDText(s string) string {
+	s = norm.NFC.String(s)
+	if utf8.RuneCountInString(s) <= TEDTextLimit {
+		return s
+	}
+	return string([]rune(s)[:TEDTextLimit])
+}
@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRUT_Spellings(t *testing.T) {
+	tests := []struct {
+		rut     string
+		want    string
+		wantErr bool
+	}{
+		{rut: "76212889-6", want: "76212889-6"},
+		{rut: "76.212.889-6", want: "76212889-6"},
+		{rut: " 12.345.670-k ", want: "12345670-K"},
+		{rut: "123456785", want: "12345678-5"},
+		{rut: "07.654.321-6", want: "7654321-6"},
+		{rut: "1-9", want: "1-9"},
+		{rut: "", wantErr: true},
+		{rut: "-5", wantErr: true},
+		{rut: "12345678-", wantErr: true},
+		{rut: "12345678-X", wantErr: true},
+		{rut: "12A45678-9", wantErr: true},
+		{rut: "123456789-0", wantErr: true},
+		{rut: "76212889-5", wantErr: true},
+		{rut: "12345678-K", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRUT(tt.rut)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidRUT) {
+				t.Errorf("ParseRUT(%q) = %q, %v; want ErrInvalidRUT", tt.rut, got, err)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParseRUT(%q) = %q, %v; want %q", tt.rut, got, err, tt.want)
+		}
+	}
+}
+
+func TestTruncateTEDText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"short", "Pan amasado", "Pan amasado"},
+		{"exactly 40", strings.Repeat("a", 40), strings.Repeat("a", 40)},
+		{"41 characters", strings.Repeat("a", 40) + "b", strings.Repeat("a", 40)},
+		{"multibyte counts characters", strings.Repeat("ñ", 45), strings.Repeat("ñ", 40)},
+		{"decomposed accents count once", strings.Repeat("e\u0301", 41), strings.Repeat("\u00e9", 40)},
+		{"keeps inner and trailing spaces", "Plan Emprendedor ", "Plan Emprendedor "},
+	}
+	for _, tt := range tests {
+		if got := truncateTEDText(tt.text); got != tt.want {
+			t.Errorf("%s: truncateTEDText(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDDBuilder(t *testing.T) {
+	// 03:30 UTC on 5 May 2025 is still 4 May in Santiago (UTC-4), and 15:00
+	// UTC in January is 12:00 in Chilean summer time (UTC-3).
+	winter := time.Date(2025, 5, 5, 3, 30, 0, 0, time.UTC)
+	summer := time.Date(2025, 1, 15, 15, 0, 0, 0, time.UTC)
+	issueDate := time.Date(2025, 5, 5, 0, 0, 0, 0, time.UTC)
+	longName := "Sociedad Agrícola y Ganadera Los Ñandúes del Sur Limitada"
+
+	tests := []struct {
+		name      string
+		issuerRUT RUT
+		invoice   Invoice
+		stampedAt time.Time
+		want      DD
+		wantErr   error
+	}{
+		{
+			name:      "factura",
+			issuerRUT: MustParseRUT("76.212.889-6"),
+			invoice: Invoice{
+				DocumentType: 33,
+				IssueDate:    issueDate,
+				Receiver:     &Company{Code: MustParseRUT("77.371.419-3"), Name: "AGRICOLA PAINE LTDA"},
+				Details:      []InvoiceDetail{{Description: "Plan Emprendedor "}},
+				Totals:       InvoiceTotals{TotalAmount: 41884},
+			},
+			stampedAt: winter,
+			want:      DD{RE: MustParseRUT("76212889-6"), TD: 33, FE: "2025-05-05", RR: MustParseRUT("77371419-3"), RSR: "AGRICOLA PAINE LTDA", MNT: 41884, IT1: "Plan Emprendedor ", TSTED: "2025-05-04T23:30:00"},
+		},
+		{
+			name:      "long names are cut to 40 characters",
+			issuerRUT: MustParseRUT("76212889-6"),
+			invoice: Invoice{
+				DocumentType: 33,
+				IssueDate:    issueDate,
+				Receiver:     &Company{Code: MustParseRUT("12345670-k"), Name: longName},
+				Details:      []InvoiceDetail{{Description: longName}, {Description: "Segunda línea"}},
+				Totals:       InvoiceTotals{TotalAmount: 1000},
+			},
+			stampedAt: summer,
+			want:      DD{RE: MustParseRUT("76212889-6"), TD: 33, FE: "2025-05-05", RR: MustParseRUT("12345670-K"), RSR: "Sociedad Agrícola y Ganadera Los Ñandúes", MNT: 1000, IT1: "Sociedad Agrícola y Ganadera Los Ñandúes", TSTED: "2025-01-15T12:00:00"},
+		},
+		{
+			name:      "boleta without receiver",
+			issuerRUT: MustParseRUT("76212889-6"),
+			invoice:   Invoice{DocumentType: 39, IssueDate: issueDate, Details: []InvoiceDetail{{Description: "Pan"}}, Totals: InvoiceTotals{TotalAmount: 3500}},
+			stampedAt: summer,
+			want:      DD{RE: MustParseRUT("76212889-6"), TD: 39, FE: "2025-05-05", RR: MustParseRUT(AnonymousReceiverRUT), RSR: AnonymousReceiverName, MNT: 3500, IT1: "Pan", TSTED: "2025-01-15T12:00:00"},
+		},
+		{
+			name:      "boleta exenta with an empty receiver",
+			issuerRUT: MustParseRUT("76212889-6"),
+			invoice:   Invoice{DocumentType: 41, IssueDate: issueDate, Receiver: &Company{}, Totals: InvoiceTotals{TotalAmount: 3500}},
+			stampedAt: summer,
+			want:      DD{RE: MustParseRUT("76212889-6"), TD: 41, FE: "2025-05-05", RR: MustParseRUT(AnonymousReceiverRUT), RSR: AnonymousReceiverName, MNT: 3500, IT1: DefaultItemName, TSTED: "2025-01-15T12:00:00"},
+		},
+		{
+			name:      "boleta to a named receiver keeps the name",
+			issuerRUT: MustParseRUT("76212889-6"),
+			invoice:   Invoice{DocumentType: 39, IssueDate: issueDate, Receiver: &Company{Code: MustParseRUT("66.666.666-6"), Name: "Juan Pérez"}, Totals: InvoiceTotals{TotalAmount: 3500}},
+			stampedAt: summer,
+			want:      DD{RE: MustParseRUT("76212889-6"), TD: 39, FE: "2025-05-05", RR: MustParseRUT(AnonymousReceiverRUT), RSR: "Juan Pérez", MNT: 3500, IT1: DefaultItemName, TSTED: "2025-01-15T12:00:00"},
+		},
+		{
+			name:    "no issuer RUT",
+			invoice: Invoice{DocumentType: 39},
+			wantErr: ErrInvalidRUT,
+		},
+		{
+			name:      "no total",
+			issuerRUT: MustParseRUT("76212889-6"),
+			invoice:   Invoice{DocumentType: 33, Receiver: &Company{Code: MustParseRUT("77371419-3")}},
+			wantErr:   ErrInvalidInvoice,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDDBuilder().
+				WithIssuerRUT(tt.issuerRUT).
+				WithInvoice(tt.invoice).
+				WithStampedAt(tt.stampedAt).
+				Build()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Unexpected DD:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
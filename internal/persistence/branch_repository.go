@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewBranchRepository(dsn string) (*BranchRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&BranchData{}); err != nil {
+		return nil, err
+	}
+	return &BranchRepository{db: db}, nil
+}
+
+var _ usecases.BranchRepository = (*BranchRepository)(nil)
+
+type BranchRepository struct {
+	db *gorm.DB
+}
+
+func (r *BranchRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Branch, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var branchesData []BranchData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ?", companyID).
+		Order("code ASC").
+		Find(&branchesData).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("finding branches by company id: %w", wrapDBError(err))
+	}
+
+	branches := make([]domain.Branch, len(branchesData))
+	for i, data := range branchesData {
+		branches[i] = fromBranchData(data)
+	}
+
+	return branches, nil
+}
+
+func (r *BranchRepository) FindByCode(ctx context.Context, companyID string, code uint64) (*domain.Branch, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data BranchData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrBranchNotFound
+		}
+		return nil, fmt.Errorf("finding branch by code: %w", wrapDBError(err))
+	}
+
+	branch := fromBranchData(data)
+	return &branch, nil
+}
+
+func (r *BranchRepository) Create(ctx context.Context, branch domain.Branch) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := toBranchData(branch)
+	err := r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrBranchExists
+		}
+		return fmt.Errorf("creating branch: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *BranchRepository) Update(ctx context.Context, branch domain.Branch) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	// Save writes every column, so a printer can be set back to the defaults
+	data := toBranchData(branch)
+	err := r.db.
+		WithContext(ctx).
+		Save(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("updating branch: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *BranchRepository) Delete(ctx context.Context, companyID string, code uint64) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	result := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code).
+		Delete(&BranchData{})
+
+	if result.Error != nil {
+		return fmt.Errorf("deleting branch: %w", wrapDBError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return usecases.ErrBranchNotFound
+	}
+
+	return nil
+}
+
+func toBranchData(branch domain.Branch) BranchData {
+	return BranchData{
+		ID:                  branch.ID,
+		CompanyID:           branch.CompanyID,
+		Code:                branch.Code,
+		Name:                branch.Name,
+		Address:             branch.Address,
+		Commune:             branch.Commune,
+		City:                branch.City,
+		PrinterPaperWidth:   branch.PrinterPaperWidth,
+		PrinterRasterPDF417: branch.PrinterRasterPDF417,
+		CreatedAt:           branch.CreatedAt,
+		UpdatedAt:           branch.UpdatedAt,
+	}
+}
+
+func fromBranchData(data BranchData) domain.Branch {
+	return domain.Branch{
+		ID:                  data.ID,
+		CompanyID:           data.CompanyID,
+		Code:                data.Code,
+		Name:                data.Name,
+		Address:             data.Address,
+		Commune:             data.Commune,
+		City:                data.City,
+		PrinterPaperWidth:   data.PrinterPaperWidth,
+		PrinterRasterPDF417: data.PrinterRasterPDF417,
+		CreatedAt:           data.CreatedAt,
+		UpdatedAt:           data.UpdatedAt,
+	}
+}
+
+type BranchData struct {
+	ID                  string `gorm:"primaryKey"`
+	CompanyID           string `gorm:"uniqueIndex:idx_branch_company_code"`
+	Code                uint64 `gorm:"uniqueIndex:idx_branch_company_code"`
+	Name                string
+	Address             string
+	Commune             string
+	City                string
+	PrinterPaperWidth   int
+	PrinterRasterPDF417 bool
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
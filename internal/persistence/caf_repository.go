@@ -11,6 +11,7 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func NewCAFRepository(dsn string) (*CAFRepository, error) {
@@ -19,7 +20,7 @@ func NewCAFRepository(dsn string) (*CAFRepository, error) {
 		return nil, err
 	}
 
-	if err := db.AutoMigrate(&CAFData{}); err != nil {
+	if err := db.AutoMigrate(&CAFData{}, &UsedFolioData{}, &ReleasedFolioData{}); err != nil {
 		return nil, err
 	}
 	return &CAFRepository{db: db}, nil
@@ -42,6 +43,7 @@ func (r *CAFRepository) Save(ctx context.Context, caf domain.CAF) error {
 		CompanyID:         caf.CompanyID,
 		CompanyCode:       caf.CompanyCode,
 		CompanyName:       caf.CompanyName,
+		BranchID:          caf.BranchID,
 		DocumentType:      caf.DocumentType,
 		InitialFolios:     caf.InitialFolios,
 		CurrentFolios:     caf.CurrentFolios,
@@ -61,7 +63,7 @@ func (r *CAFRepository) Save(ctx context.Context, caf domain.CAF) error {
 		Error
 
 	if err != nil {
-		return fmt.Errorf("saving caf: %w", err)
+		return fmt.Errorf("saving caf: %w", wrapDBError(err))
 	}
 
 	return nil
@@ -78,6 +80,7 @@ func (r *CAFRepository) Update(ctx context.Context, caf domain.CAF) error {
 		CompanyID:         caf.CompanyID,
 		CompanyCode:       caf.CompanyCode,
 		CompanyName:       caf.CompanyName,
+		BranchID:          caf.BranchID,
 		DocumentType:      caf.DocumentType,
 		InitialFolios:     caf.InitialFolios,
 		CurrentFolios:     caf.CurrentFolios,
@@ -99,7 +102,7 @@ func (r *CAFRepository) Update(ctx context.Context, caf domain.CAF) error {
 		Error
 
 	if err != nil {
-		return fmt.Errorf("updating caf: %w", err)
+		return fmt.Errorf("updating caf: %w", wrapDBError(err))
 	}
 
 	return nil
@@ -118,7 +121,7 @@ func (r *CAFRepository) FindByCompanyID(ctx context.Context, companyID string) (
 		Error
 
 	if err != nil {
-		return nil, fmt.Errorf("finding cafs by company id: %w", err)
+		return nil, fmt.Errorf("finding cafs by company id: %w", wrapDBError(err))
 	}
 
 	cafs := make([]domain.CAF, len(cafsData))
@@ -129,6 +132,7 @@ func (r *CAFRepository) FindByCompanyID(ctx context.Context, companyID string) (
 			CompanyID:         data.CompanyID,
 			CompanyCode:       data.CompanyCode,
 			CompanyName:       data.CompanyName,
+			BranchID:          data.BranchID,
 			DocumentType:      data.DocumentType,
 			InitialFolios:     data.InitialFolios,
 			CurrentFolios:     data.CurrentFolios,
@@ -147,16 +151,19 @@ func (r *CAFRepository) FindByCompanyID(ctx context.Context, companyID string) (
 	return cafs, nil
 }
 
-func (r *CAFRepository) FindAvailableCAF(ctx context.Context, companyID string, documentType uint) (*domain.CAF, error) {
+func (r *CAFRepository) FindAvailableCAF(ctx context.Context, companyID, branchID string, documentType uint) (*domain.CAF, error) {
 	if r.db == nil {
 		return nil, errors.New("database not initialized")
 	}
 
+	// Sorting branch_id descending puts the branch's CAFs before the shared
+	// ones, whose branch_id is empty.
 	var cafData CAFData
 	err := r.db.
 		WithContext(ctx).
-		Where("company_id = ? AND document_type = ? AND status = ? AND current_folios <= final_folios",
-			companyID, documentType, domain.CAFStatusOpen).
+		Where("company_id = ? AND document_type = ? AND status = ? AND current_folios <= final_folios AND branch_id IN ?",
+			companyID, documentType, domain.CAFStatusOpen, []string{branchID, ""}).
+		Order("branch_id DESC").
 		Order("authorization_date ASC").
 		First(&cafData).
 		Error
@@ -165,7 +172,7 @@ func (r *CAFRepository) FindAvailableCAF(ctx context.Context, companyID string,
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, fmt.Errorf("no available CAF found for company %s and document type %d", companyID, documentType)
 		}
-		return nil, fmt.Errorf("finding available CAF: %w", err)
+		return nil, fmt.Errorf("finding available CAF: %w", wrapDBError(err))
 	}
 
 	caf := domain.CAF{
@@ -174,6 +181,7 @@ func (r *CAFRepository) FindAvailableCAF(ctx context.Context, companyID string,
 		CompanyID:         cafData.CompanyID,
 		CompanyCode:       cafData.CompanyCode,
 		CompanyName:       cafData.CompanyName,
+		BranchID:          cafData.BranchID,
 		DocumentType:      cafData.DocumentType,
 		InitialFolios:     cafData.InitialFolios,
 		CurrentFolios:     cafData.CurrentFolios,
@@ -191,6 +199,247 @@ func (r *CAFRepository) FindAvailableCAF(ctx context.Context, companyID string,
 	return &caf, nil
 }
 
+func (r *CAFRepository) FindByFolio(ctx context.Context, companyID string, documentType uint, folio int64) (*domain.CAF, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var cafData CAFData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND document_type = ? AND initial_folios <= ? AND final_folios >= ?",
+			companyID, documentType, folio, folio).
+		Order("authorization_date DESC").
+		First(&cafData).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrFolioOutOfRange
+		}
+		return nil, fmt.Errorf("finding caf by folio: %w", wrapDBError(err))
+	}
+
+	caf := domain.CAF{
+		ID:                cafData.ID,
+		Raw:               cafData.Raw,
+		CompanyID:         cafData.CompanyID,
+		CompanyCode:       cafData.CompanyCode,
+		CompanyName:       cafData.CompanyName,
+		BranchID:          cafData.BranchID,
+		DocumentType:      cafData.DocumentType,
+		InitialFolios:     cafData.InitialFolios,
+		CurrentFolios:     cafData.CurrentFolios,
+		FinalFolios:       cafData.FinalFolios,
+		AuthorizationDate: cafData.AuthorizationDate,
+		ExpirationDate:    cafData.ExpirationDate,
+		Status:            cafData.Status,
+		Signature:         cafData.Signature,
+		RSAPK_M:           cafData.RSAPK_M,
+		RSAPK_E:           cafData.RSAPK_E,
+		IDK:               cafData.IDK,
+		PrivateKey:        cafData.PrivateKey,
+	}
+
+	return &caf, nil
+}
+
+func (r *CAFRepository) MarkFolioUsed(ctx context.Context, companyID string, documentType uint, folio int64) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := UsedFolioData{
+		CompanyID:    companyID,
+		DocumentType: documentType,
+		Folio:        folio,
+	}
+	err := r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrFolioAlreadyUsed
+		}
+		return fmt.Errorf("marking folio used: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *CAFRepository) MarkNextFolioUsed(ctx context.Context, caf domain.CAF, folio int64) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := UsedFolioData{
+		CompanyID:    caf.CompanyID,
+		DocumentType: caf.DocumentType,
+		Folio:        folio,
+	}
+	var inserted int64
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Model(&CAFData{}).
+				Where("id = ?", caf.ID).
+				Updates(map[string]any{"current_folios": caf.CurrentFolios, "status": caf.Status}).
+				Error
+			if err != nil {
+				return err
+			}
+			// The counter moves past an assigned folio as well, so a
+			// conflict must not roll it back.
+			result := tx.
+				Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&data)
+			inserted = result.RowsAffected
+			return result.Error
+		})
+
+	if err != nil {
+		return fmt.Errorf("marking next folio used: %w", wrapDBError(err))
+	}
+	if inserted == 0 {
+		return usecases.ErrFolioAlreadyUsed
+	}
+
+	return nil
+}
+
+func (r *CAFRepository) UseReleasedFolio(ctx context.Context, companyID string, documentType uint, folio int64) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := UsedFolioData{
+		CompanyID:    companyID,
+		DocumentType: documentType,
+		Folio:        folio,
+	}
+	var released int64
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			result := tx.
+				Where("company_id = ? AND document_type = ? AND folio = ?", companyID, documentType, folio).
+				Delete(&ReleasedFolioData{})
+			if result.Error != nil {
+				return result.Error
+			}
+			released = result.RowsAffected
+			if released == 0 {
+				return nil
+			}
+			return tx.
+				Create(&data).
+				Error
+		})
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrFolioAlreadyUsed
+		}
+		return fmt.Errorf("using released folio: %w", wrapDBError(err))
+	}
+	if released == 0 {
+		return usecases.ErrFolioAlreadyUsed
+	}
+
+	return nil
+}
+
+func (r *CAFRepository) CountFoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error) {
+	if r.db == nil {
+		return 0, errors.New("database not initialized")
+	}
+
+	var count int64
+	err := r.db.
+		WithContext(ctx).
+		Model(&UsedFolioData{}).
+		Where("company_id = ? AND document_type = ? AND created_at >= ?", companyID, documentType, since).
+		Count(&count).
+		Error
+
+	if err != nil {
+		return 0, fmt.Errorf("counting used folios: %w", wrapDBError(err))
+	}
+
+	return count, nil
+}
+
+func (r *CAFRepository) ReleaseFolios(ctx context.Context, companyID string, documentType uint, folios []int64) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	released := make([]ReleasedFolioData, len(folios))
+	for i, folio := range folios {
+		released[i] = ReleasedFolioData{
+			CompanyID:    companyID,
+			DocumentType: documentType,
+			Folio:        folio,
+		}
+	}
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Where("company_id = ? AND document_type = ? AND folio IN ?", companyID, documentType, folios).
+				Delete(&UsedFolioData{}).
+				Error
+			if err != nil {
+				return err
+			}
+			return tx.
+				Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&released).
+				Error
+		})
+
+	if err != nil {
+		return fmt.Errorf("releasing folios: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *CAFRepository) TakeReleasedFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, error) {
+	if r.db == nil {
+		return 0, errors.New("database not initialized")
+	}
+
+	// SKIP LOCKED lets concurrent stampers take different folios instead of
+	// waiting on the same row.
+	var folios []int64
+	err := r.db.
+		WithContext(ctx).
+		Raw(`DELETE FROM released_folio_data WHERE (company_id, document_type, folio) IN (
+			SELECT r.company_id, r.document_type, r.folio FROM released_folio_data r
+			WHERE r.company_id = ? AND r.document_type = ? AND EXISTS (
+				SELECT 1 FROM caf_data c
+				WHERE c.company_id = r.company_id AND c.document_type = r.document_type
+				AND r.folio BETWEEN c.initial_folios AND c.final_folios AND c.branch_id IN (?, '')
+			)
+			ORDER BY r.folio LIMIT 1 FOR UPDATE OF r SKIP LOCKED
+		) RETURNING folio`, companyID, documentType, branchID).
+		Scan(&folios).
+		Error
+
+	if err != nil {
+		return 0, fmt.Errorf("taking released folio: %w", wrapDBError(err))
+	}
+	if len(folios) == 0 {
+		return 0, usecases.ErrNoReleasedFolio
+	}
+
+	return folios[0], nil
+}
+
 type BlobStorageClient interface {
 	Upload(ctx context.Context, blobName string, data io.Reader) error
 }
@@ -201,7 +450,8 @@ type CAFData struct {
 	CompanyID         string `gorm:"index"`
 	CompanyCode       string
 	CompanyName       string
-	DocumentType      uint `gorm:"index"`
+	BranchID          string `gorm:"index;not null;default:''"`
+	DocumentType      uint   `gorm:"index"`
 	InitialFolios     int64
 	CurrentFolios     int64
 	FinalFolios       int64
@@ -214,3 +464,21 @@ type CAFData struct {
 	IDK               string
 	PrivateKey        string `gorm:"type:text"`
 }
+
+// UsedFolioData records every folio that was stamped, whether it was the next
+// one of its CAF or assigned explicitly.
+type UsedFolioData struct {
+	CompanyID    string `gorm:"primaryKey"`
+	DocumentType uint   `gorm:"primaryKey"`
+	Folio        int64  `gorm:"primaryKey"`
+	CreatedAt    time.Time
+}
+
+// ReleasedFolioData holds reserved folios that were given back unused, so
+// sequential stamping hands them out before moving the CAF counter.
+type ReleasedFolioData struct {
+	CompanyID    string `gorm:"primaryKey"`
+	DocumentType uint   `gorm:"primaryKey"`
+	Folio        int64  `gorm:"primaryKey"`
+	CreatedAt    time.Time
+}
@@ -6,6 +6,7 @@ import (
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/usecases"
 	"fmt"
+	"log/slog"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -20,9 +21,61 @@ func NewCompanyRepository(dsn string) (*CompanyRepository, error) {
 	if err := db.AutoMigrate(&CompanyData{}, &CompanyCommercialActivityData{}); err != nil {
 		return nil, err
 	}
+	if err := canonicalizeCompanyCodes(db); err != nil {
+		return nil, err
+	}
 	return &CompanyRepository{db: db}, nil
 }
 
+// canonicalizeCompanyCodes rewrites the codes of companies saved before RUTs
+// were validated, such as "76.212.889-6", in the canonical form FindByCode
+// looks up. Codes that are not valid RUTs, and companies whose RUT another
+// company already holds in canonical form, are left as they are and logged
+// for an operator to fix; neither stops the gateway from starting.
+func canonicalizeCompanyCodes(db *gorm.DB) error {
+	var companies []CompanyData
+	if err := db.Select("id", "code").Find(&companies).Error; err != nil {
+		return fmt.Errorf("listing company codes: %w", wrapDBError(err))
+	}
+
+	// owners holds the company that has each canonical code, so a second
+	// spelling of a RUT is not rewritten into a duplicate.
+	owners := make(map[string]string, len(companies))
+	for _, company := range companies {
+		owners[company.Code] = company.ID
+	}
+
+	for _, company := range companies {
+		rut, err := domain.ParseRUT(company.Code)
+		if err != nil {
+			slog.Warn("company code is not a valid RUT, it cannot be found by code", "companyID", company.ID, "code", company.Code, "error", err)
+			continue
+		}
+		if rut.String() == company.Code {
+			continue
+		}
+		if owner, ok := owners[rut.String()]; ok {
+			slog.Error("company code is the RUT of another company, merge them to find it by code", "companyID", company.ID, "code", company.Code, "otherCompanyID", owner)
+			continue
+		}
+
+		err = db.Model(&CompanyData{}).
+			Where("id = ?", company.ID).
+			Update("code", rut.String()).
+			Error
+		if isUniqueViolation(err) {
+			slog.Error("company code is the RUT of another company, merge them to find it by code", "companyID", company.ID, "code", company.Code)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("canonicalizing code of company %s: %w", company.ID, wrapDBError(err))
+		}
+		owners[rut.String()] = company.ID
+		slog.Info("canonicalized company code", "companyID", company.ID, "from", company.Code, "to", rut.String())
+	}
+	return nil
+}
+
 var _ usecases.CompanyRepository = (*CompanyRepository)(nil)
 
 type CompanyRepository struct {
@@ -37,7 +90,7 @@ func (c *CompanyRepository) Save(ctx context.Context, company domain.Company) er
 	data := CompanyData{
 		ID:                    company.ID,
 		Name:                  company.Name,
-		Code:                  company.Code,
+		Code:                  company.Code.String(),
 		Address:               company.Address,
 		FacturaMovilCompanyID: company.FacturaMovilCompanyID,
 	}
@@ -47,7 +100,7 @@ func (c *CompanyRepository) Save(ctx context.Context, company domain.Company) er
 		Error
 
 	if err != nil {
-		return fmt.Errorf("saving company: %w", err)
+		return fmt.Errorf("saving company: %w", wrapDBError(err))
 	}
 
 	// Save commercial activities
@@ -57,7 +110,7 @@ func (c *CompanyRepository) Save(ctx context.Context, company domain.Company) er
 			Where("company_id = ?", company.ID).
 			Delete(&CompanyCommercialActivityData{}).Error
 		if err != nil {
-			return fmt.Errorf("removing existing commercial activities: %w", err)
+			return fmt.Errorf("removing existing commercial activities: %w", wrapDBError(err))
 		}
 
 		// Then add new activities
@@ -69,7 +122,7 @@ func (c *CompanyRepository) Save(ctx context.Context, company domain.Company) er
 			}
 			err = c.db.WithContext(ctx).Create(&activityData).Error
 			if err != nil {
-				return fmt.Errorf("saving commercial activity: %w", err)
+				return fmt.Errorf("saving commercial activity: %w", wrapDBError(err))
 			}
 		}
 	}
@@ -89,7 +142,7 @@ func (c *CompanyRepository) FindAll(ctx context.Context) ([]domain.Company, erro
 		Error
 
 	if err != nil {
-		return nil, fmt.Errorf("finding all companies: %w", err)
+		return nil, fmt.Errorf("finding all companies: %w", wrapDBError(err))
 	}
 
 	companies := make([]domain.Company, len(companiesData))
@@ -99,14 +152,7 @@ func (c *CompanyRepository) FindAll(ctx context.Context) ([]domain.Company, erro
 			return nil, fmt.Errorf("getting commercial activities for company %s: %w", data.ID, err)
 		}
 
-		companies[i] = domain.Company{
-			ID:                    data.ID,
-			Name:                  data.Name,
-			Code:                  data.Code,
-			Address:               data.Address,
-			FacturaMovilCompanyID: data.FacturaMovilCompanyID,
-			CommercialActivities:  activities,
-		}
+		companies[i] = fromCompanyData(data, activities)
 	}
 
 	return companies, nil
@@ -125,7 +171,7 @@ func (c *CompanyRepository) FindByNameFilter(ctx context.Context, nameFilter str
 		Error
 
 	if err != nil {
-		return nil, fmt.Errorf("finding companies by name filter: %w", err)
+		return nil, fmt.Errorf("finding companies by name filter: %w", wrapDBError(err))
 	}
 
 	companies := make([]domain.Company, len(companiesData))
@@ -135,14 +181,7 @@ func (c *CompanyRepository) FindByNameFilter(ctx context.Context, nameFilter str
 			return nil, fmt.Errorf("getting commercial activities for company %s: %w", data.ID, err)
 		}
 
-		companies[i] = domain.Company{
-			ID:                    data.ID,
-			Name:                  data.Name,
-			Code:                  data.Code,
-			Address:               data.Address,
-			FacturaMovilCompanyID: data.FacturaMovilCompanyID,
-			CommercialActivities:  activities,
-		}
+		companies[i] = fromCompanyData(data, activities)
 	}
 
 	return companies, nil
@@ -164,7 +203,7 @@ func (c *CompanyRepository) FindByID(ctx context.Context, id string) (*domain.Co
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, fmt.Errorf("company not found with id: %s", id)
 		}
-		return nil, fmt.Errorf("finding company by id: %w", err)
+		return nil, fmt.Errorf("finding company by id: %w", wrapDBError(err))
 	}
 
 	activities, err := c.GetCommercialActivities(ctx, companyData.ID)
@@ -172,19 +211,12 @@ func (c *CompanyRepository) FindByID(ctx context.Context, id string) (*domain.Co
 		return nil, fmt.Errorf("getting commercial activities for company %s: %w", companyData.ID, err)
 	}
 
-	company := domain.Company{
-		ID:                    companyData.ID,
-		Name:                  companyData.Name,
-		Code:                  companyData.Code,
-		Address:               companyData.Address,
-		FacturaMovilCompanyID: companyData.FacturaMovilCompanyID,
-		CommercialActivities:  activities,
-	}
+	company := fromCompanyData(companyData, activities)
 
 	return &company, nil
 }
 
-func (c *CompanyRepository) FindByCode(ctx context.Context, code string) (*domain.Company, error) {
+func (c *CompanyRepository) FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error) {
 	if c.db == nil {
 		return nil, errors.New("database not initialized")
 	}
@@ -192,7 +224,7 @@ func (c *CompanyRepository) FindByCode(ctx context.Context, code string) (*domai
 	var companyData CompanyData
 	err := c.db.
 		WithContext(ctx).
-		Where("code = ?", code).
+		Where("code = ?", code.String()).
 		First(&companyData).
 		Error
 
@@ -200,7 +232,7 @@ func (c *CompanyRepository) FindByCode(ctx context.Context, code string) (*domai
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, fmt.Errorf("company not found with code: %s", code)
 		}
-		return nil, fmt.Errorf("finding company by code: %w", err)
+		return nil, fmt.Errorf("finding company by code: %w", wrapDBError(err))
 	}
 
 	activities, err := c.GetCommercialActivities(ctx, companyData.ID)
@@ -208,14 +240,7 @@ func (c *CompanyRepository) FindByCode(ctx context.Context, code string) (*domai
 		return nil, fmt.Errorf("getting commercial activities for company %s: %w", companyData.ID, err)
 	}
 
-	company := domain.Company{
-		ID:                    companyData.ID,
-		Name:                  companyData.Name,
-		Code:                  companyData.Code,
-		Address:               companyData.Address,
-		FacturaMovilCompanyID: companyData.FacturaMovilCompanyID,
-		CommercialActivities:  activities,
-	}
+	company := fromCompanyData(companyData, activities)
 
 	return &company, nil
 }
@@ -233,7 +258,7 @@ func (c *CompanyRepository) GetCommercialActivities(ctx context.Context, company
 		Error
 
 	if err != nil {
-		return nil, fmt.Errorf("finding commercial activities: %w", err)
+		return nil, fmt.Errorf("finding commercial activities: %w", wrapDBError(err))
 	}
 
 	activities := make([]domain.CommercialActivity, len(activitiesData))
@@ -260,7 +285,7 @@ func (c *CompanyRepository) AddCommercialActivity(ctx context.Context, companyID
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return fmt.Errorf("company not found with id: %s", companyID)
 		}
-		return fmt.Errorf("finding company: %w", err)
+		return fmt.Errorf("finding company: %w", wrapDBError(err))
 	}
 
 	// Create the activity
@@ -273,7 +298,7 @@ func (c *CompanyRepository) AddCommercialActivity(ctx context.Context, companyID
 
 	err = c.db.WithContext(ctx).Create(&activityData).Error
 	if err != nil {
-		return fmt.Errorf("creating commercial activity: %w", err)
+		return fmt.Errorf("creating commercial activity: %w", wrapDBError(err))
 	}
 
 	return nil
@@ -291,7 +316,7 @@ func (c *CompanyRepository) RemoveCommercialActivity(ctx context.Context, compan
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return fmt.Errorf("company not found with id: %s", companyID)
 		}
-		return fmt.Errorf("finding company: %w", err)
+		return fmt.Errorf("finding company: %w", wrapDBError(err))
 	}
 
 	// Delete the activity
@@ -299,12 +324,26 @@ func (c *CompanyRepository) RemoveCommercialActivity(ctx context.Context, compan
 		Where("company_id = ? AND id = ?", companyID, activityID).
 		Delete(&CompanyCommercialActivityData{}).Error
 	if err != nil {
-		return fmt.Errorf("deleting commercial activity: %w", err)
+		return fmt.Errorf("deleting commercial activity: %w", wrapDBError(err))
 	}
 
 	return nil
 }
 
+// fromCompanyData returns the company stored in data. A code that is not a
+// valid RUT, which canonicalizeCompanyCodes logs at startup, is left zero.
+func fromCompanyData(data CompanyData, activities []domain.CommercialActivity) domain.Company {
+	code, _ := domain.ParseRUT(data.Code)
+	return domain.Company{
+		ID:                    data.ID,
+		Name:                  data.Name,
+		Code:                  code,
+		Address:               data.Address,
+		FacturaMovilCompanyID: data.FacturaMovilCompanyID,
+		CommercialActivities:  activities,
+	}
+}
+
 type CompanyData struct {
 	ID                    string `gorm:"primaryKey"`
 	Name                  string
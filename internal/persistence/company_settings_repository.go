@@ -0,0 +1,108 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewCompanySettingsRepository(dsn string) (*CompanySettingsRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&CompanySettingsData{}); err != nil {
+		return nil, err
+	}
+	return &CompanySettingsRepository{db: db}, nil
+}
+
+var _ usecases.CompanySettingsRepository = (*CompanySettingsRepository)(nil)
+
+type CompanySettingsRepository struct {
+	db *gorm.DB
+}
+
+func (r *CompanySettingsRepository) Find(ctx context.Context, companyID string) (*domain.CompanySettings, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data CompanySettingsData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ?", companyID).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrCompanySettingsNotFound
+		}
+		return nil, fmt.Errorf("finding company settings: %w", wrapDBError(err))
+	}
+
+	return &domain.CompanySettings{
+		CompanyID:        data.CompanyID,
+		Phone:            data.Phone,
+		Email:            data.Email,
+		Website:          data.Website,
+		LogoBlob:         data.LogoBlob,
+		LogoContentType:  data.LogoContentType,
+		ResolutionNumber: data.ResolutionNumber,
+		ResolutionDate:   data.ResolutionDate,
+		FooterText:       data.FooterText,
+		PrimaryColor:     data.PrimaryColor,
+		UpdatedAt:        data.UpdatedAt,
+	}, nil
+}
+
+func (r *CompanySettingsRepository) Save(ctx context.Context, settings domain.CompanySettings) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := CompanySettingsData{
+		CompanyID:        settings.CompanyID,
+		Phone:            settings.Phone,
+		Email:            settings.Email,
+		Website:          settings.Website,
+		LogoBlob:         settings.LogoBlob,
+		LogoContentType:  settings.LogoContentType,
+		ResolutionNumber: settings.ResolutionNumber,
+		ResolutionDate:   settings.ResolutionDate,
+		FooterText:       settings.FooterText,
+		PrimaryColor:     settings.PrimaryColor,
+	}
+	err := r.db.
+		WithContext(ctx).
+		Save(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("saving company settings: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+type CompanySettingsData struct {
+	CompanyID        string `gorm:"primaryKey"`
+	Phone            string
+	Email            string
+	Website          string
+	LogoBlob         string
+	LogoContentType  string
+	ResolutionNumber int
+	ResolutionDate   time.Time `gorm:"type:date"`
+	FooterText       string    `gorm:"type:text"`
+	PrimaryColor     string
+	UpdatedAt        time.Time
+}
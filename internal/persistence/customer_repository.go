@@ -0,0 +1,186 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewCustomerRepository(dsn string) (*CustomerRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&CustomerData{}); err != nil {
+		return nil, err
+	}
+	return &CustomerRepository{db: db}, nil
+}
+
+var _ usecases.CustomerRepository = (*CustomerRepository)(nil)
+
+type CustomerRepository struct {
+	db *gorm.DB
+}
+
+func (r *CustomerRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Customer, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var customeresData []CustomerData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ?", companyID).
+		Order("code ASC").
+		Find(&customeresData).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("finding customeres by company id: %w", wrapDBError(err))
+	}
+
+	customeres := make([]domain.Customer, len(customeresData))
+	for i, data := range customeresData {
+		customeres[i] = fromCustomerData(data)
+	}
+
+	return customeres, nil
+}
+
+func (r *CustomerRepository) FindByCode(ctx context.Context, companyID string, code domain.RUT) (*domain.Customer, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data CustomerData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code.String()).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrCustomerNotFound
+		}
+		return nil, fmt.Errorf("finding customer by code: %w", wrapDBError(err))
+	}
+
+	customer := fromCustomerData(data)
+	return &customer, nil
+}
+
+func (r *CustomerRepository) Create(ctx context.Context, customer domain.Customer) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := toCustomerData(customer)
+	err := r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrCustomerExists
+		}
+		return fmt.Errorf("creating customer: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *CustomerRepository) Update(ctx context.Context, customer domain.Customer) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	// Save writes every column, so optional fields can be cleared
+	data := toCustomerData(customer)
+	err := r.db.
+		WithContext(ctx).
+		Save(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("updating customer: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *CustomerRepository) Delete(ctx context.Context, companyID string, code domain.RUT) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	result := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code.String()).
+		Delete(&CustomerData{})
+
+	if result.Error != nil {
+		return fmt.Errorf("deleting customer: %w", wrapDBError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return usecases.ErrCustomerNotFound
+	}
+
+	return nil
+}
+
+func toCustomerData(customer domain.Customer) CustomerData {
+	return CustomerData{
+		ID:           customer.ID,
+		CompanyID:    customer.CompanyID,
+		Code:         customer.Code.String(),
+		Name:         customer.Name,
+		BusinessLine: customer.BusinessLine,
+		Address:      customer.Address,
+		Commune:      customer.Commune,
+		City:         customer.City,
+		Email:        customer.Email,
+		CreatedAt:    customer.CreatedAt,
+		UpdatedAt:    customer.UpdatedAt,
+	}
+}
+
+func fromCustomerData(data CustomerData) domain.Customer {
+	code, _ := domain.ParseRUT(data.Code)
+	return domain.Customer{
+		ID:           data.ID,
+		CompanyID:    data.CompanyID,
+		Code:         code,
+		Name:         data.Name,
+		BusinessLine: data.BusinessLine,
+		Address:      data.Address,
+		Commune:      data.Commune,
+		City:         data.City,
+		Email:        data.Email,
+		CreatedAt:    data.CreatedAt,
+		UpdatedAt:    data.UpdatedAt,
+	}
+}
+
+type CustomerData struct {
+	ID           string `gorm:"primaryKey"`
+	CompanyID    string `gorm:"uniqueIndex:idx_customer_company_code"`
+	Code         string `gorm:"uniqueIndex:idx_customer_company_code"`
+	Name         string
+	BusinessLine string
+	Address      string
+	Commune      string
+	City         string
+	Email        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"factura-movil-gateway/internal/usecases"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// transientSQLStateClasses lists the PostgreSQL SQLSTATE classes that signal
+// a temporary condition: connection exceptions, serialization failures and
+// deadlocks, insufficient resources and operator intervention.
+var transientSQLStateClasses = []string{"08", "40", "53", "57P"}
+
+const _uniqueViolationSQLState = "23505"
+
+// wrapDBError marks database errors that are worth retrying as transient so
+// callers can tell them apart from permanent failures.
+func wrapDBError(err error) error {
+	if isTransientDBError(err) {
+		return usecases.NewTransientError(err)
+	}
+	return err
+}
+
+func isTransientDBError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		for _, class := range transientSQLStateClasses {
+			if strings.HasPrefix(pgErr.Code, class) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == _uniqueViolationSQLState
+}
@@ -0,0 +1,219 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewFolioReservationRepository(dsn string) (*FolioReservationRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&FolioReservationData{}); err != nil {
+		return nil, err
+	}
+	return &FolioReservationRepository{db: db}, nil
+}
+
+var _ usecases.FolioReservationRepository = (*FolioReservationRepository)(nil)
+
+type FolioReservationRepository struct {
+	db *gorm.DB
+}
+
+func (r *FolioReservationRepository) Create(ctx context.Context, reservation domain.FolioReservation) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data, err := toFolioReservationData(reservation)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("creating folio reservation: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *FolioReservationRepository) Find(ctx context.Context, companyID, id string) (*domain.FolioReservation, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data FolioReservationData
+	err := r.db.
+		WithContext(ctx).
+		Where("id = ? AND company_id = ?", id, companyID).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrFolioReservationNotFound
+		}
+		return nil, fmt.Errorf("finding folio reservation: %w", wrapDBError(err))
+	}
+
+	reservation, err := fromFolioReservationData(data)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (r *FolioReservationRepository) Update(ctx context.Context, reservation domain.FolioReservation, fromStatus string) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data, err := toFolioReservationData(reservation)
+	if err != nil {
+		return err
+	}
+
+	// The status condition makes the change atomic: of a reconciliation and
+	// an expiry racing on the same reservation, only one updates it.
+	result := r.db.
+		WithContext(ctx).
+		Where("id = ? AND status = ?", reservation.ID, fromStatus).
+		Updates(&data)
+
+	if result.Error != nil {
+		return fmt.Errorf("updating folio reservation: %w", wrapDBError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return usecases.ErrFolioReservationClosed
+	}
+
+	return nil
+}
+
+func (r *FolioReservationRepository) FindExpired(ctx context.Context, now time.Time) ([]domain.FolioReservation, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var reservationsData []FolioReservationData
+	err := r.db.
+		WithContext(ctx).
+		Where("status = ? AND expires_at < ?", domain.FolioReservationActive, now).
+		Order("expires_at ASC").
+		Find(&reservationsData).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("finding expired folio reservations: %w", wrapDBError(err))
+	}
+
+	reservations := make([]domain.FolioReservation, len(reservationsData))
+	for i, data := range reservationsData {
+		if reservations[i], err = fromFolioReservationData(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return reservations, nil
+}
+
+func toFolioReservationData(reservation domain.FolioReservation) (FolioReservationData, error) {
+	data := FolioReservationData{
+		ID:           reservation.ID,
+		CompanyID:    reservation.CompanyID,
+		TerminalID:   reservation.TerminalID,
+		DocumentType: reservation.DocumentType,
+		CAFID:        reservation.CAFID,
+		FromFolio:    reservation.FromFolio,
+		ToFolio:      reservation.ToFolio,
+		Status:       reservation.Status,
+		ExpiresAt:    reservation.ExpiresAt,
+		CreatedAt:    reservation.CreatedAt,
+		UpdatedAt:    reservation.UpdatedAt,
+	}
+	for _, field := range []struct {
+		target *string
+		folios []int64
+	}{
+		{&data.UsedFolios, reservation.UsedFolios},
+		{&data.ReturnedFolios, reservation.ReturnedFolios},
+		{&data.ConflictFolios, reservation.ConflictFolios},
+	} {
+		if field.folios == nil {
+			continue
+		}
+		encoded, err := json.Marshal(field.folios)
+		if err != nil {
+			return FolioReservationData{}, fmt.Errorf("encoding folios: %w", err)
+		}
+		*field.target = string(encoded)
+	}
+	return data, nil
+}
+
+func fromFolioReservationData(data FolioReservationData) (domain.FolioReservation, error) {
+	reservation := domain.FolioReservation{
+		ID:           data.ID,
+		CompanyID:    data.CompanyID,
+		TerminalID:   data.TerminalID,
+		DocumentType: data.DocumentType,
+		CAFID:        data.CAFID,
+		FromFolio:    data.FromFolio,
+		ToFolio:      data.ToFolio,
+		Status:       data.Status,
+		ExpiresAt:    data.ExpiresAt,
+		CreatedAt:    data.CreatedAt,
+		UpdatedAt:    data.UpdatedAt,
+	}
+	for _, field := range []struct {
+		source string
+		target *[]int64
+	}{
+		{data.UsedFolios, &reservation.UsedFolios},
+		{data.ReturnedFolios, &reservation.ReturnedFolios},
+		{data.ConflictFolios, &reservation.ConflictFolios},
+	} {
+		if field.source == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(field.source), field.target); err != nil {
+			return domain.FolioReservation{}, fmt.Errorf("decoding stored folios: %w", err)
+		}
+	}
+	return reservation, nil
+}
+
+// FolioReservationData keeps the folio lists as JSON arrays; they are only
+// read back whole.
+type FolioReservationData struct {
+	ID             string `gorm:"primaryKey"`
+	CompanyID      string `gorm:"index"`
+	TerminalID     string
+	DocumentType   uint
+	CAFID          string
+	FromFolio      int64
+	ToFolio        int64
+	Status         string    `gorm:"index"`
+	ExpiresAt      time.Time `gorm:"index"`
+	UsedFolios     string    `gorm:"type:text"`
+	ReturnedFolios string    `gorm:"type:text"`
+	ConflictFolios string    `gorm:"type:text"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewIdempotencyRepository(dsn string) (*IdempotencyRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&IdempotencyData{}); err != nil {
+		return nil, err
+	}
+	return &IdempotencyRepository{db: db}, nil
+}
+
+var _ usecases.IdempotencyRepository = (*IdempotencyRepository)(nil)
+
+type IdempotencyRepository struct {
+	db *gorm.DB
+}
+
+func (r *IdempotencyRepository) Find(ctx context.Context, key string) (*domain.IdempotencyRecord, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data IdempotencyData
+	err := r.db.
+		WithContext(ctx).
+		Where("key = ?", key).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrIdempotencyRecordNotFound
+		}
+		return nil, fmt.Errorf("finding idempotency record: %w", wrapDBError(err))
+	}
+
+	record := domain.IdempotencyRecord{
+		Key:         data.Key,
+		RequestHash: data.RequestHash,
+		CompanyID:   data.CompanyID,
+		Status:      data.Status,
+		ExpiresAt:   data.ExpiresAt,
+		CreatedAt:   data.CreatedAt,
+		UpdatedAt:   data.UpdatedAt,
+	}
+	if data.Stamp != "" {
+		if err := json.Unmarshal([]byte(data.Stamp), &record.Stamp); err != nil {
+			return nil, fmt.Errorf("decoding stored stamp: %w", err)
+		}
+	}
+
+	return &record, nil
+}
+
+func (r *IdempotencyRepository) Create(ctx context.Context, record domain.IdempotencyRecord) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data, err := toIdempotencyData(record)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrIdempotencyKeyExists
+		}
+		return fmt.Errorf("creating idempotency record: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Update(ctx context.Context, record domain.IdempotencyRecord) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data, err := toIdempotencyData(record)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.
+		WithContext(ctx).
+		Where("key = ?", record.Key).
+		Updates(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("updating idempotency record: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Reclaim(ctx context.Context, record domain.IdempotencyRecord, now time.Time) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data, err := toIdempotencyData(record)
+	if err != nil {
+		return err
+	}
+
+	result := r.db.
+		WithContext(ctx).
+		Where("key = ? AND status = ? AND expires_at < ?", record.Key, domain.IdempotencyPending, now).
+		Updates(&data)
+
+	if result.Error != nil {
+		return fmt.Errorf("reclaiming idempotency record: %w", wrapDBError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return usecases.ErrIdempotencyInProgress
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Delete(ctx context.Context, key string) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	err := r.db.
+		WithContext(ctx).
+		Where("key = ?", key).
+		Delete(&IdempotencyData{}).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("deleting idempotency record: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func toIdempotencyData(record domain.IdempotencyRecord) (IdempotencyData, error) {
+	data := IdempotencyData{
+		Key:         record.Key,
+		RequestHash: record.RequestHash,
+		CompanyID:   record.CompanyID,
+		Status:      record.Status,
+		ExpiresAt:   record.ExpiresAt,
+	}
+	if record.Status == domain.IdempotencyCompleted {
+		stamp, err := json.Marshal(record.Stamp)
+		if err != nil {
+			return IdempotencyData{}, fmt.Errorf("encoding stamp: %w", err)
+		}
+		data.Stamp = string(stamp)
+	}
+	return data, nil
+}
+
+type IdempotencyData struct {
+	Key         string `gorm:"primaryKey"`
+	RequestHash string
+	CompanyID   string `gorm:"index"`
+	Status      string
+	Stamp       string `gorm:"type:text"`
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/usecases"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func NewProductRepository(dsn string) (*ProductRepository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&ProductData{}); err != nil {
+		return nil, err
+	}
+	return &ProductRepository{db: db}, nil
+}
+
+var _ usecases.ProductRepository = (*ProductRepository)(nil)
+
+type ProductRepository struct {
+	db *gorm.DB
+}
+
+func (r *ProductRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Product, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var productesData []ProductData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ?", companyID).
+		Order("code ASC").
+		Find(&productesData).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("finding productes by company id: %w", wrapDBError(err))
+	}
+
+	productes := make([]domain.Product, len(productesData))
+	for i, data := range productesData {
+		productes[i] = fromProductData(data)
+	}
+
+	return productes, nil
+}
+
+func (r *ProductRepository) FindByCode(ctx context.Context, companyID string, code string) (*domain.Product, error) {
+	if r.db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var data ProductData
+	err := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code).
+		First(&data).
+		Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, usecases.ErrProductNotFound
+		}
+		return nil, fmt.Errorf("finding product by code: %w", wrapDBError(err))
+	}
+
+	product := fromProductData(data)
+	return &product, nil
+}
+
+func (r *ProductRepository) Create(ctx context.Context, product domain.Product) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	data := toProductData(product)
+	err := r.db.
+		WithContext(ctx).
+		Create(&data).
+		Error
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return usecases.ErrProductExists
+		}
+		return fmt.Errorf("creating product: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *ProductRepository) Update(ctx context.Context, product domain.Product) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	// Save writes every column, so optional fields can be cleared
+	data := toProductData(product)
+	err := r.db.
+		WithContext(ctx).
+		Save(&data).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("updating product: %w", wrapDBError(err))
+	}
+
+	return nil
+}
+
+func (r *ProductRepository) Delete(ctx context.Context, companyID string, code string) error {
+	if r.db == nil {
+		return errors.New("database not initialized")
+	}
+
+	result := r.db.
+		WithContext(ctx).
+		Where("company_id = ? AND code = ?", companyID, code).
+		Delete(&ProductData{})
+
+	if result.Error != nil {
+		return fmt.Errorf("deleting product: %w", wrapDBError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return usecases.ErrProductNotFound
+	}
+
+	return nil
+}
+
+func toProductData(product domain.Product) ProductData {
+	return ProductData{
+		ID:                 product.ID,
+		CompanyID:          product.CompanyID,
+		Code:               product.Code,
+		CodeType:           product.CodeType,
+		Name:               product.Name,
+		Unit:               product.Unit,
+		Price:              product.Price.String(),
+		Exempt:             product.Exempt,
+		AdditionalTaxCodes: strings.Join(product.AdditionalTaxCodes, " "),
+		CreatedAt:          product.CreatedAt,
+		UpdatedAt:          product.UpdatedAt,
+	}
+}
+
+func fromProductData(data ProductData) domain.Product {
+	// The numeric column only holds what ParseDecimal reads
+	price, _ := domain.ParseDecimal(data.Price)
+	return domain.Product{
+		ID:                 data.ID,
+		CompanyID:          data.CompanyID,
+		Code:               data.Code,
+		CodeType:           data.CodeType,
+		Name:               data.Name,
+		Unit:               data.Unit,
+		Price:              price,
+		Exempt:             data.Exempt,
+		AdditionalTaxCodes: strings.Fields(data.AdditionalTaxCodes),
+		CreatedAt:          data.CreatedAt,
+		UpdatedAt:          data.UpdatedAt,
+	}
+}
+
+type ProductData struct {
+	ID        string `gorm:"primaryKey"`
+	CompanyID string `gorm:"uniqueIndex:idx_product_company_code"`
+	Code      string `gorm:"uniqueIndex:idx_product_company_code"`
+	CodeType  string
+	Name      string
+	Unit      string
+	Price     string `gorm:"type:numeric(18,6)"`
+	Exempt    bool
+	// AdditionalTaxCodes holds the codes separated by spaces
+	AdditionalTaxCodes string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
@@ -32,3 +32,8 @@ func (l *LocalStorage) Upload(ctx context.Context, blobName string, data io.Read
 	_, err = io.Copy(f, data)
 	return err
 }
+
+// Download abre el archivo guardado en la ruta base + blobName.
+func (l *LocalStorage) Download(ctx context.Context, blobName string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.BasePath, blobName))
+}
@@ -0,0 +1,172 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// _maxBranchCode is the largest CdgSIISucur, a number of up to 9 digits.
+const _maxBranchCode = 999999999
+
+var (
+	ErrBranchNotFound = errors.New("branch not found")
+	ErrBranchExists   = errors.New("branch code is already registered")
+	ErrInvalidBranch  = errors.New("invalid branch")
+	// ErrBranchInUse is returned when deleting a branch that still has open
+	// CAFs, whose folios no other branch would use.
+	ErrBranchInUse = errors.New("branch has open CAFs")
+)
+
+// BranchRepository persists the branches of companies.
+type BranchRepository interface {
+	FindByCompanyID(ctx context.Context, companyID string) ([]domain.Branch, error)
+	// FindByCode returns ErrBranchNotFound when the company has no branch
+	// with that SII code.
+	FindByCode(ctx context.Context, companyID string, code uint64) (*domain.Branch, error)
+	// Create returns ErrBranchExists when the code is taken.
+	Create(ctx context.Context, branch domain.Branch) error
+	Update(ctx context.Context, branch domain.Branch) error
+	Delete(ctx context.Context, companyID string, code uint64) error
+}
+
+// BranchService manages the sucursales documents can be issued from. A
+// branch is addressed by its SII code, which is what documents carry.
+type BranchService interface {
+	List(ctx context.Context, companyID string) ([]domain.Branch, error)
+	FindByCode(ctx context.Context, companyID string, code uint64) (domain.Branch, error)
+	Create(ctx context.Context, branch domain.Branch) (domain.Branch, error)
+	Update(ctx context.Context, branch domain.Branch) (domain.Branch, error)
+	Delete(ctx context.Context, companyID string, code uint64) error
+}
+
+func NewBranchService(repository BranchRepository, cafService CAFService) *SimpleBranchService {
+	return &SimpleBranchService{
+		repository: repository,
+		cafService: cafService,
+	}
+}
+
+type SimpleBranchService struct {
+	repository BranchRepository
+	cafService CAFService
+}
+
+func (s *SimpleBranchService) List(ctx context.Context, companyID string) ([]domain.Branch, error) {
+	branches, err := s.repository.FindByCompanyID(ctx, companyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding branches: %w", err)
+	}
+	return branches, nil
+}
+
+func (s *SimpleBranchService) FindByCode(ctx context.Context, companyID string, code uint64) (domain.Branch, error) {
+	branch, err := s.repository.FindByCode(ctx, companyID, code)
+	if err != nil {
+		if errors.Is(err, ErrBranchNotFound) {
+			return domain.Branch{}, fmt.Errorf("%w: code %d", err, code)
+		}
+		return domain.Branch{}, fmt.Errorf("finding branch: %w", err)
+	}
+	return *branch, nil
+}
+
+func (s *SimpleBranchService) Create(ctx context.Context, branch domain.Branch) (domain.Branch, error) {
+	if err := validateBranch(branch); err != nil {
+		return domain.Branch{}, err
+	}
+
+	now := time.Now()
+	branch.ID = uuid.NewString()
+	branch.CreatedAt = now
+	branch.UpdatedAt = now
+	if err := s.repository.Create(ctx, branch); err != nil {
+		if errors.Is(err, ErrBranchExists) {
+			return domain.Branch{}, err
+		}
+		return domain.Branch{}, fmt.Errorf("saving branch: %w", err)
+	}
+	return branch, nil
+}
+
+// Update replaces every field of the branch with that code but its id.
+func (s *SimpleBranchService) Update(ctx context.Context, branch domain.Branch) (domain.Branch, error) {
+	if err := validateBranch(branch); err != nil {
+		return domain.Branch{}, err
+	}
+
+	current, err := s.FindByCode(ctx, branch.CompanyID, branch.Code)
+	if err != nil {
+		return domain.Branch{}, err
+	}
+	branch.ID = current.ID
+	branch.CreatedAt = current.CreatedAt
+	branch.UpdatedAt = time.Now()
+	if err := s.repository.Update(ctx, branch); err != nil {
+		return domain.Branch{}, fmt.Errorf("saving branch: %w", err)
+	}
+	return branch, nil
+}
+
+func (s *SimpleBranchService) Delete(ctx context.Context, companyID string, code uint64) error {
+	branch, err := s.FindByCode(ctx, companyID, code)
+	if err != nil {
+		return err
+	}
+	cafs, err := s.cafService.FindByCompanyID(ctx, companyID)
+	if err != nil {
+		return fmt.Errorf("finding cafs of branch: %w", err)
+	}
+	for _, caf := range cafs {
+		if caf.BranchID == branch.ID && caf.HasAvailableFolios() {
+			return fmt.Errorf("%w: CAF %s", ErrBranchInUse, caf.ID)
+		}
+	}
+
+	if err := s.repository.Delete(ctx, companyID, code); err != nil {
+		if errors.Is(err, ErrBranchNotFound) {
+			return err
+		}
+		return fmt.Errorf("deleting branch: %w", err)
+	}
+	return nil
+}
+
+// BranchReceiptOptions applies the printer of the invoice's branch, if it
+// has one, over options.
+func BranchReceiptOptions(invoice *domain.Invoice, options ReceiptOptions) ReceiptOptions {
+	if invoice.Branch == nil {
+		return options
+	}
+	if invoice.Branch.PrinterPaperWidth != 0 {
+		options.PaperWidth = invoice.Branch.PrinterPaperWidth
+	}
+	if invoice.Branch.PrinterRasterPDF417 {
+		options.RasterPDF417 = true
+	}
+	return options
+}
+
+func validateBranch(branch domain.Branch) error {
+	if branch.CompanyID == "" {
+		return fmt.Errorf("%w: company id is required", ErrInvalidBranch)
+	}
+	if branch.Code == 0 || branch.Code > _maxBranchCode {
+		return fmt.Errorf("%w: code must be the SII branch code, 1 to 9 digits", ErrInvalidBranch)
+	}
+	if strings.TrimSpace(branch.Address) == "" || strings.TrimSpace(branch.Commune) == "" {
+		return fmt.Errorf("%w: address and commune are required", ErrInvalidBranch)
+	}
+	if branch.PrinterPaperWidth != 0 {
+		if err := (ReceiptOptions{PaperWidth: branch.PrinterPaperWidth}).Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBranch, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryBranchRepository struct {
+	branches map[uint64]domain.Branch
+}
+
+func (m *memoryBranchRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Branch, error) {
+	var branches []domain.Branch
+	for _, branch := range m.branches {
+		if branch.CompanyID == companyID {
+			branches = append(branches, branch)
+		}
+	}
+	return branches, nil
+}
+
+func (m *memoryBranchRepository) FindByCode(ctx context.Context, companyID string, code uint64) (*domain.Branch, error) {
+	branch, ok := m.branches[code]
+	if !ok || branch.CompanyID != companyID {
+		return nil, ErrBranchNotFound
+	}
+	return &branch, nil
+}
+
+func (m *memoryBranchRepository) Create(ctx context.Context, branch domain.Branch) error {
+	if _, ok := m.branches[branch.Code]; ok {
+		return ErrBranchExists
+	}
+	m.branches[branch.Code] = branch
+	return nil
+}
+
+func (m *memoryBranchRepository) Update(ctx context.Context, branch domain.Branch) error {
+	m.branches[branch.Code] = branch
+	return nil
+}
+
+func (m *memoryBranchRepository) Delete(ctx context.Context, companyID string, code uint64) error {
+	if _, err := m.FindByCode(ctx, companyID, code); err != nil {
+		return err
+	}
+	delete(m.branches, code)
+	return nil
+}
+
+func TestBranchService_CreateAndDelete(t *testing.T) {
+	cafService, cafRepository := newTestCAFService()
+	service := NewBranchService(&memoryBranchRepository{branches: make(map[uint64]domain.Branch)}, cafService)
+	ctx := context.Background()
+
+	invalid := map[string]domain.Branch{
+		"no code":       {CompanyID: "company-1", Address: "Av. Matta 100", Commune: "Santiago"},
+		"code too long": {CompanyID: "company-1", Code: 1234567890, Address: "Av. Matta 100", Commune: "Santiago"},
+		"no address":    {CompanyID: "company-1", Code: 81234567, Commune: "Santiago"},
+		"bad printer":   {CompanyID: "company-1", Code: 81234567, Address: "Av. Matta 100", Commune: "Santiago", PrinterPaperWidth: 70},
+	}
+	for name, branch := range invalid {
+		if _, err := service.Create(ctx, branch); !errors.Is(err, ErrInvalidBranch) {
+			t.Errorf("%s: expected ErrInvalidBranch, got %v", name, err)
+		}
+	}
+
+	branch, err := service.Create(ctx, domain.Branch{CompanyID: "company-1", Code: 81234567, Name: "Centro", Address: "Av. Matta 100", Commune: "Santiago"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := service.Create(ctx, branch); !errors.Is(err, ErrBranchExists) {
+		t.Errorf("Expected a taken code to be rejected, got %v", err)
+	}
+
+	cafRepository.cafs[0].BranchID = branch.ID
+	if err := service.Delete(ctx, "company-1", 81234567); !errors.Is(err, ErrBranchInUse) {
+		t.Errorf("Expected a branch with an open CAF to be kept, got %v", err)
+	}
+	cafRepository.cafs[0].CurrentFolios = 15
+	if err := service.Delete(ctx, "company-1", 81234567); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := service.FindByCode(ctx, "company-1", 81234567); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("Expected the branch to be gone, got %v", err)
+	}
+}
+
+func TestBranchReceiptOptions(t *testing.T) {
+	defaults := DefaultReceiptOptions()
+
+	if got := BranchReceiptOptions(&domain.Invoice{}, defaults); got != defaults {
+		t.Errorf("Expected the defaults without a branch, got %+v", got)
+	}
+
+	invoice := domain.Invoice{Branch: &domain.Branch{PrinterPaperWidth: 58, PrinterRasterPDF417: true}}
+	got := BranchReceiptOptions(&invoice, defaults)
+	if got.PaperWidth != 58 || !got.RasterPDF417 {
+		t.Errorf("Expected the branch printer, got %+v", got)
+	}
+}
@@ -3,14 +3,33 @@ package usecases
 import (
 	"bytes"
 	"context"
+	"errors"
 	"factura-movil-gateway/internal/domain"
 	"fmt"
 	"io"
+	"log/slog"
+	"time"
+)
+
+var (
+	// ErrFolioOutOfRange is returned when an assigned folio is not in the
+	// range of any CAF of the company for the document type.
+	ErrFolioOutOfRange = errors.New("folio is not in any CAF of the company")
+	// ErrFolioAlreadyUsed is returned when an assigned folio was already
+	// stamped.
+	ErrFolioAlreadyUsed = errors.New("folio was already used")
+	// ErrNoReleasedFolio is returned when no folio was given back by a
+	// folio reservation.
+	ErrNoReleasedFolio = errors.New("no released folio")
+	// ErrCAFCompanyMismatch is returned when a CAF was authorized for a RUT
+	// other than the company's.
+	ErrCAFCompanyMismatch = errors.New("CAF was authorized for another RUT")
 )
 
 // BlobStorageClient define la interfaz para almacenamiento de blobs.
 type BlobStorageClient interface {
 	Upload(ctx context.Context, blobName string, data io.Reader) error
+	Download(ctx context.Context, blobName string) (io.ReadCloser, error)
 }
 
 // CAFRepository define la interfaz para gestionar CAFs (por ejemplo, guardar metadatos en BD).
@@ -18,13 +37,53 @@ type CAFRepository interface {
 	Save(ctx context.Context, caf domain.CAF) error
 	Update(ctx context.Context, caf domain.CAF) error
 	FindByCompanyID(ctx context.Context, companyID string) ([]domain.CAF, error)
-	FindAvailableCAF(ctx context.Context, companyID string, documentType uint) (*domain.CAF, error)
+	// FindAvailableCAF returns the oldest open CAF of the branch, or of the
+	// shared pool when the branch has none or branchID is empty.
+	FindAvailableCAF(ctx context.Context, companyID, branchID string, documentType uint) (*domain.CAF, error)
+	// FindByFolio returns the CAF whose range holds folio, or
+	// ErrFolioOutOfRange.
+	FindByFolio(ctx context.Context, companyID string, documentType uint, folio int64) (*domain.CAF, error)
+	// MarkFolioUsed records that folio was stamped, or returns
+	// ErrFolioAlreadyUsed if it already was.
+	MarkFolioUsed(ctx context.Context, companyID string, documentType uint, folio int64) error
+	// MarkNextFolioUsed saves the counter of caf, just moved past folio, and
+	// records folio as used in one transaction. It returns
+	// ErrFolioAlreadyUsed, with the counter saved, when folio was assigned
+	// explicitly before.
+	MarkNextFolioUsed(ctx context.Context, caf domain.CAF, folio int64) error
+	// UseReleasedFolio moves folio from the released folios to the used
+	// ones, or returns ErrFolioAlreadyUsed when it was not released.
+	UseReleasedFolio(ctx context.Context, companyID string, documentType uint, folio int64) error
+	// CountFoliosUsedSince counts the folios recorded as used at or after
+	// since.
+	CountFoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error)
+	// ReleaseFolios forgets that folios were used and keeps them for reuse.
+	ReleaseFolios(ctx context.Context, companyID string, documentType uint, folios []int64) error
+	// TakeReleasedFolio removes and returns the lowest released folio of a
+	// CAF of the branch or the shared pool, or returns ErrNoReleasedFolio.
+	TakeReleasedFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, error)
 }
 
 type CAFService interface {
 	Create(ctx context.Context, company domain.Company, caf domain.CAF) error
 	FindByCompanyID(ctx context.Context, companyID string) ([]domain.CAF, error)
-	UseCAFFolio(ctx context.Context, companyID string, documentType uint) (int64, domain.CAF, error)
+	// UseCAFFolio takes the next folio for a document of the branch;
+	// branchID is empty for the casa matriz.
+	UseCAFFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, domain.CAF, error)
+	// UseAssignedFolio consumes a specific folio without moving the
+	// sequential counter of its CAF.
+	UseAssignedFolio(ctx context.Context, companyID string, documentType uint, folio int64) (domain.CAF, error)
+	// ReserveFolios takes up to count consecutive folios of one shared CAF
+	// at once. The block is shorter when the CAF runs out or reaches a folio
+	// that was assigned explicitly.
+	ReserveFolios(ctx context.Context, companyID string, documentType uint, count int64) (int64, int64, domain.CAF, error)
+	// ReleaseFolios gives back reserved folios that were never stamped so
+	// UseCAFFolio hands them out again.
+	ReleaseFolios(ctx context.Context, companyID string, documentType uint, folios []int64) error
+	// FoliosUsedSince counts the folios of the document type consumed at or
+	// after since, whether sequentially, assigned, reserved or taken back
+	// after a release. Unlike the CAF counters it moves on every one of them.
+	FoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error)
 }
 
 func NewCAFService(storage BlobStorageClient, repository CAFRepository) *SimpleCAFService {
@@ -39,8 +98,18 @@ type SimpleCAFService struct {
 	repository CAFRepository
 }
 
+// Create stores the CAF of the company. The RE of the CAF must be the
+// company's RUT, however either is spelled.
 func (s *SimpleCAFService) Create(ctx context.Context, company domain.Company, caf domain.CAF) error {
-	err := s.repository.Save(ctx, caf)
+	authorized, err := domain.ParseRUT(caf.CompanyCode)
+	if err != nil {
+		return fmt.Errorf("CAF RE: %w", err)
+	}
+	if authorized != company.Code {
+		return fmt.Errorf("%w: %s, not %s", ErrCAFCompanyMismatch, authorized, company.Code)
+	}
+
+	err = s.repository.Save(ctx, caf)
 	if err != nil {
 		return fmt.Errorf("saving caf to database: %w", err)
 	}
@@ -62,26 +131,151 @@ func (s *SimpleCAFService) FindByCompanyID(ctx context.Context, companyID string
 	return cafs, nil
 }
 
-func (s *SimpleCAFService) UseCAFFolio(ctx context.Context, companyID string, documentType uint) (int64, domain.CAF, error) {
-	// Find an available CAF for this company and document type
-	caf, err := s.repository.FindAvailableCAF(ctx, companyID, documentType)
+// UseCAFFolio takes the lowest released folio or else the next folio of the
+// oldest open CAF, skipping folios that were already stamped through
+// UseAssignedFolio. CAFs of the branch are used before the shared ones.
+func (s *SimpleCAFService) UseCAFFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, domain.CAF, error) {
+	for {
+		folio, released, err := s.useReleasedFolio(ctx, companyID, branchID, documentType)
+		if err == nil {
+			return folio, released, nil
+		}
+		if errors.Is(err, ErrFolioAlreadyUsed) {
+			slog.Info("skipping released folio reported late", "company", companyID, "documentType", documentType, "folio", folio)
+			continue
+		}
+		if !errors.Is(err, ErrNoReleasedFolio) {
+			return 0, domain.CAF{}, err
+		}
+
+		// Find an available CAF for this company and document type
+		caf, err := s.repository.FindAvailableCAF(ctx, companyID, branchID, documentType)
+		if err != nil {
+			return 0, domain.CAF{}, fmt.Errorf("finding available CAF: %w", err)
+		}
+
+		// Use the next folio
+		folioToUse, shouldClose := caf.UseNextFolio()
+
+		// Save the counter together with the folio, so a failure cannot
+		// leave a folio below the counter that is not recorded as used.
+		err = s.repository.MarkNextFolioUsed(ctx, *caf, folioToUse)
+		if err != nil && !errors.Is(err, ErrFolioAlreadyUsed) {
+			return 0, domain.CAF{}, fmt.Errorf("recording folio %d: %w", folioToUse, err)
+		}
+
+		if shouldClose {
+			slog.Info("CAF closed after using all folios", "caf", caf.ID, "finalFolio", caf.FinalFolios)
+		}
+
+		if err != nil {
+			slog.Info("skipping folio assigned explicitly", "company", companyID, "documentType", documentType, "folio", folioToUse)
+			continue
+		}
+
+		return folioToUse, *caf, nil
+	}
+}
+
+// useReleasedFolio stamps the lowest released folio. It returns the folio
+// with ErrFolioAlreadyUsed when a terminal reported it after it was released.
+func (s *SimpleCAFService) useReleasedFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, domain.CAF, error) {
+	folio, err := s.repository.TakeReleasedFolio(ctx, companyID, branchID, documentType)
 	if err != nil {
-		return 0, domain.CAF{}, fmt.Errorf("finding available CAF: %w", err)
+		if errors.Is(err, ErrNoReleasedFolio) {
+			return 0, domain.CAF{}, err
+		}
+		return 0, domain.CAF{}, fmt.Errorf("taking released folio: %w", err)
 	}
 
-	// Use the next folio
-	folioToUse, shouldClose := caf.UseNextFolio()
+	caf, err := s.repository.FindByFolio(ctx, companyID, documentType, folio)
+	if err != nil {
+		return folio, domain.CAF{}, fmt.Errorf("finding CAF of folio %d: %w", folio, err)
+	}
 
-	// Update the CAF in the database
-	err = s.repository.Update(ctx, *caf)
+	if err := s.repository.MarkFolioUsed(ctx, companyID, documentType, folio); err != nil {
+		return folio, domain.CAF{}, fmt.Errorf("recording folio %d: %w", folio, err)
+	}
+	return folio, *caf, nil
+}
+
+// UseAssignedFolio stamps folio if nothing took it yet. Every folio below
+// the counter of its CAF was handed out sequentially or reserved, so it is
+// used unless it was released since; this also holds for folios stamped
+// before used folios were recorded.
+func (s *SimpleCAFService) UseAssignedFolio(ctx context.Context, companyID string, documentType uint, folio int64) (domain.CAF, error) {
+	caf, err := s.repository.FindByFolio(ctx, companyID, documentType, folio)
+	if err != nil {
+		return domain.CAF{}, fmt.Errorf("finding CAF of folio %d: %w", folio, err)
+	}
+
+	if folio < caf.CurrentFolios {
+		err = s.repository.UseReleasedFolio(ctx, companyID, documentType, folio)
+	} else {
+		err = s.repository.MarkFolioUsed(ctx, companyID, documentType, folio)
+	}
 	if err != nil {
-		return 0, domain.CAF{}, fmt.Errorf("updating CAF after folio use: %w", err)
+		return domain.CAF{}, fmt.Errorf("recording folio %d: %w", folio, err)
+	}
+
+	return *caf, nil
+}
+
+func (s *SimpleCAFService) ReserveFolios(ctx context.Context, companyID string, documentType uint, count int64) (int64, int64, domain.CAF, error) {
+	for {
+		caf, err := s.repository.FindAvailableCAF(ctx, companyID, "", documentType)
+		if err != nil {
+			return 0, 0, domain.CAF{}, fmt.Errorf("finding available CAF: %w", err)
+		}
+
+		// Mark the block used folio by folio so neither sequential nor
+		// assigned stamping can take a reserved folio. The block stops at
+		// the first folio that was assigned explicitly, or starts after it.
+		from, to := caf.CurrentFolios, caf.CurrentFolios-1
+		for next := caf.CurrentFolios; next <= caf.FinalFolios && to-from+1 < count; next++ {
+			err := s.repository.MarkFolioUsed(ctx, companyID, documentType, next)
+			if errors.Is(err, ErrFolioAlreadyUsed) {
+				if to >= from {
+					break
+				}
+				from, to = next+1, next
+				continue
+			}
+			if err != nil {
+				return 0, 0, domain.CAF{}, fmt.Errorf("recording folio %d: %w", next, err)
+			}
+			to = next
+		}
+
+		caf.CurrentFolios = to + 1
+		if caf.CurrentFolios > caf.FinalFolios {
+			caf.Status = domain.CAFStatusClosed
+			slog.Info("CAF closed after reserving all folios", "caf", caf.ID, "finalFolio", caf.FinalFolios)
+		}
+		if err := s.repository.Update(ctx, *caf); err != nil {
+			return 0, 0, domain.CAF{}, fmt.Errorf("updating CAF after reserving folios: %w", err)
+		}
+
+		if to >= from {
+			return from, to, *caf, nil
+		}
 	}
+}
 
-	if shouldClose {
-		// Log that the CAF has been closed
-		fmt.Printf("CAF %s has been closed after using all folios (final folio: %d)\n", caf.ID, caf.FinalFolios)
+func (s *SimpleCAFService) ReleaseFolios(ctx context.Context, companyID string, documentType uint, folios []int64) error {
+	if len(folios) == 0 {
+		return nil
+	}
+	if err := s.repository.ReleaseFolios(ctx, companyID, documentType, folios); err != nil {
+		return fmt.Errorf("releasing folios: %w", err)
 	}
+	return nil
+}
 
-	return folioToUse, *caf, nil
+func (s *SimpleCAFService) FoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error) {
+	count, err := s.repository.CountFoliosUsedSince(ctx, companyID, documentType, since)
+	if err != nil {
+		return 0, fmt.Errorf("counting used folios: %w", err)
+	}
+	return count, nil
 }
@@ -0,0 +1,276 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type usedFolio struct {
+	companyID    string
+	documentType uint
+	folio        int64
+}
+
+type memoryCAFRepository struct {
+	cafs     []domain.CAF
+	used     map[usedFolio]time.Time
+	released map[usedFolio]bool
+	saves    int
+}
+
+func (m *memoryCAFRepository) Save(ctx context.Context, caf domain.CAF) error {
+	m.cafs = append(m.cafs, caf)
+	return nil
+}
+
+func (m *memoryCAFRepository) Update(ctx context.Context, caf domain.CAF) error {
+	for i := range m.cafs {
+		if m.cafs[i].ID == caf.ID {
+			m.cafs[i] = caf
+			m.saves++
+			return nil
+		}
+	}
+	return fmt.Errorf("caf %s not found", caf.ID)
+}
+
+func (m *memoryCAFRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.CAF, error) {
+	return m.cafs, nil
+}
+
+func (m *memoryCAFRepository) FindAvailableCAF(ctx context.Context, companyID, branchID string, documentType uint) (*domain.CAF, error) {
+	for _, pool := range []string{branchID, ""} {
+		for _, caf := range m.cafs {
+			if caf.CompanyID == companyID && caf.BranchID == pool && caf.DocumentType == documentType && caf.HasAvailableFolios() {
+				return &caf, nil
+			}
+		}
+	}
+	return nil, errors.New("no available CAF")
+}
+
+func (m *memoryCAFRepository) FindByFolio(ctx context.Context, companyID string, documentType uint, folio int64) (*domain.CAF, error) {
+	for _, caf := range m.cafs {
+		if caf.CompanyID == companyID && caf.DocumentType == documentType && caf.Contains(folio) {
+			return &caf, nil
+		}
+	}
+	return nil, ErrFolioOutOfRange
+}
+
+func (m *memoryCAFRepository) MarkFolioUsed(ctx context.Context, companyID string, documentType uint, folio int64) error {
+	key := usedFolio{companyID, documentType, folio}
+	if _, ok := m.used[key]; ok {
+		return ErrFolioAlreadyUsed
+	}
+	m.used[key] = time.Now()
+	return nil
+}
+
+func (m *memoryCAFRepository) MarkNextFolioUsed(ctx context.Context, caf domain.CAF, folio int64) error {
+	if err := m.Update(ctx, caf); err != nil {
+		return err
+	}
+	return m.MarkFolioUsed(ctx, caf.CompanyID, caf.DocumentType, folio)
+}
+
+func (m *memoryCAFRepository) UseReleasedFolio(ctx context.Context, companyID string, documentType uint, folio int64) error {
+	key := usedFolio{companyID, documentType, folio}
+	if !m.released[key] {
+		return ErrFolioAlreadyUsed
+	}
+	delete(m.released, key)
+	m.used[key] = time.Now()
+	return nil
+}
+
+func (m *memoryCAFRepository) CountFoliosUsedSince(ctx context.Context, companyID string, documentType uint, since time.Time) (int64, error) {
+	var count int64
+	for key, usedAt := range m.used {
+		if key.companyID == companyID && key.documentType == documentType && !usedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryCAFRepository) ReleaseFolios(ctx context.Context, companyID string, documentType uint, folios []int64) error {
+	for _, folio := range folios {
+		key := usedFolio{companyID, documentType, folio}
+		delete(m.used, key)
+		m.released[key] = true
+	}
+	return nil
+}
+
+func (m *memoryCAFRepository) TakeReleasedFolio(ctx context.Context, companyID, branchID string, documentType uint) (int64, error) {
+	var lowest *usedFolio
+	for key := range m.released {
+		if key.companyID != companyID || key.documentType != documentType || (lowest != nil && key.folio > lowest.folio) {
+			continue
+		}
+		if caf, err := m.FindByFolio(ctx, companyID, documentType, key.folio); err != nil || (caf.BranchID != "" && caf.BranchID != branchID) {
+			continue
+		}
+		lowest = &key
+	}
+	if lowest == nil {
+		return 0, ErrNoReleasedFolio
+	}
+	delete(m.released, *lowest)
+	return lowest.folio, nil
+}
+
+func newTestCAFService() (*SimpleCAFService, *memoryCAFRepository) {
+	repository := &memoryCAFRepository{
+		cafs: []domain.CAF{
+			{ID: "caf-1", CompanyID: "company-1", DocumentType: 33, InitialFolios: 10, CurrentFolios: 10, FinalFolios: 14, Status: domain.CAFStatusOpen},
+		},
+		used:     make(map[usedFolio]time.Time),
+		released: make(map[usedFolio]bool),
+	}
+	return NewCAFService(&memoryBlobStorage{blobs: make(map[string][]byte)}, repository), repository
+}
+
+func TestCAFService_UseAssignedFolio(t *testing.T) {
+	service, repository := newTestCAFService()
+	ctx := context.Background()
+
+	caf, err := service.UseAssignedFolio(ctx, "company-1", 33, 12)
+	if err != nil {
+		t.Fatalf("UseAssignedFolio failed: %v", err)
+	}
+	if caf.ID != "caf-1" {
+		t.Errorf("Expected the CAF holding folio 12, got %s", caf.ID)
+	}
+	if repository.saves != 0 || repository.cafs[0].CurrentFolios != 10 {
+		t.Errorf("Expected the sequential counter untouched, got %d after %d updates", repository.cafs[0].CurrentFolios, repository.saves)
+	}
+
+	tests := []struct {
+		name         string
+		documentType uint
+		folio        int64
+		want         error
+	}{
+		{"already used", 33, 12, ErrFolioAlreadyUsed},
+		{"below the range", 33, 9, ErrFolioOutOfRange},
+		{"above the range", 33, 15, ErrFolioOutOfRange},
+		{"other document type", 39, 11, ErrFolioOutOfRange},
+	}
+	for _, tt := range tests {
+		if _, err := service.UseAssignedFolio(ctx, "company-1", tt.documentType, tt.folio); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestCAFService_UseAssignedFolio_BelowCounter(t *testing.T) {
+	service, repository := newTestCAFService()
+	ctx := context.Background()
+
+	// Folios 10 and 11 were stamped before used folios were recorded.
+	repository.cafs[0].CurrentFolios = 12
+
+	for _, folio := range []int64{10, 11} {
+		if _, err := service.UseAssignedFolio(ctx, "company-1", 33, folio); !errors.Is(err, ErrFolioAlreadyUsed) {
+			t.Errorf("Expected folio %d below the counter to be used, got %v", folio, err)
+		}
+	}
+
+	if err := service.ReleaseFolios(ctx, "company-1", 33, []int64{11}); err != nil {
+		t.Fatalf("ReleaseFolios failed: %v", err)
+	}
+	if _, err := service.UseAssignedFolio(ctx, "company-1", 33, 11); err != nil {
+		t.Fatalf("Expected released folio 11 to be stamped, got %v", err)
+	}
+	if _, err := service.UseAssignedFolio(ctx, "company-1", 33, 11); !errors.Is(err, ErrFolioAlreadyUsed) {
+		t.Errorf("Expected folio 11 used once, got %v", err)
+	}
+	if folio, _, err := service.UseCAFFolio(ctx, "company-1", "", 33); err != nil || folio != 12 {
+		t.Errorf("Expected the next folio 12, got %d, %v", folio, err)
+	}
+}
+
+func TestCAFService_UseCAFFolio_SkipsAssignedFolios(t *testing.T) {
+	service, _ := newTestCAFService()
+	ctx := context.Background()
+
+	for _, folio := range []int64{11, 12} {
+		if _, err := service.UseAssignedFolio(ctx, "company-1", 33, folio); err != nil {
+			t.Fatalf("UseAssignedFolio(%d) failed: %v", folio, err)
+		}
+	}
+
+	var folios []int64
+	for i := 0; i < 3; i++ {
+		folio, _, err := service.UseCAFFolio(ctx, "company-1", "", 33)
+		if err != nil {
+			t.Fatalf("UseCAFFolio failed: %v", err)
+		}
+		folios = append(folios, folio)
+	}
+	if fmt.Sprint(folios) != "[10 13 14]" {
+		t.Errorf("Expected folios [10 13 14], got %v", folios)
+	}
+
+	if _, _, err := service.UseCAFFolio(ctx, "company-1", "", 33); err == nil {
+		t.Error("Expected the CAF to be exhausted")
+	}
+}
+
+func TestCAFService_UseCAFFolio_BranchPool(t *testing.T) {
+	service, repository := newTestCAFService()
+	repository.cafs = append(repository.cafs,
+		domain.CAF{ID: "caf-branch", CompanyID: "company-1", BranchID: "branch-1", DocumentType: 33, InitialFolios: 50, CurrentFolios: 50, FinalFolios: 50, Status: domain.CAFStatusOpen},
+	)
+	ctx := context.Background()
+
+	var folios []int64
+	for i := 0; i < 2; i++ {
+		folio, _, err := service.UseCAFFolio(ctx, "company-1", "branch-1", 33)
+		if err != nil {
+			t.Fatalf("UseCAFFolio failed: %v", err)
+		}
+		folios = append(folios, folio)
+	}
+	if fmt.Sprint(folios) != "[50 10]" {
+		t.Errorf("Expected the branch CAF first and then the shared pool, got %v", folios)
+	}
+
+	if folio, _, err := service.UseCAFFolio(ctx, "company-1", "", 33); err != nil || folio != 11 {
+		t.Errorf("Expected the casa matriz to skip branch CAFs, got folio %d, %v", folio, err)
+	}
+}
+
+func TestCAFService_Create_MatchesCompanyRUT(t *testing.T) {
+	service, repository := newTestCAFService()
+	ctx := context.Background()
+	company := domain.Company{ID: "company-1", Code: domain.MustParseRUT("76212889-6")}
+
+	caf := domain.CAF{ID: "caf-2", CompanyID: "company-1", CompanyCode: "76.212.889-6", DocumentType: 39, Raw: []byte("<AUTORIZACION/>")}
+	if err := service.Create(ctx, company, caf); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	other := caf
+	other.ID, other.CompanyCode = "caf-3", "77371419-3"
+	if err := service.Create(ctx, company, other); !errors.Is(err, ErrCAFCompanyMismatch) {
+		t.Errorf("Expected ErrCAFCompanyMismatch, got %v", err)
+	}
+
+	invalid := caf
+	invalid.ID, invalid.CompanyCode = "caf-4", "76212889-5"
+	if err := service.Create(ctx, company, invalid); !errors.Is(err, domain.ErrInvalidRUT) {
+		t.Errorf("Expected ErrInvalidRUT, got %v", err)
+	}
+
+	if len(repository.cafs) != 2 {
+		t.Errorf("Expected only the matching CAF to be saved, got %d CAFs", len(repository.cafs))
+	}
+}
@@ -11,7 +11,7 @@ type CompanyService interface {
 	FindAll(ctx context.Context) ([]domain.Company, error)
 	FindByNameFilter(ctx context.Context, nameFilter string) ([]domain.Company, error)
 	FindByID(ctx context.Context, id string) (*domain.Company, error)
-	FindByCode(ctx context.Context, code string) (*domain.Company, error)
+	FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error)
 	Update(ctx context.Context, company domain.Company) error
 	AddCommercialActivity(ctx context.Context, companyID string, activity domain.CommercialActivity) error
 	RemoveCommercialActivity(ctx context.Context, companyID string, activityID string) error
@@ -29,6 +29,9 @@ type SimpleCompanyService struct {
 }
 
 func (s *SimpleCompanyService) Save(ctx context.Context, company domain.Company) error {
+	if company.Code.IsZero() {
+		return fmt.Errorf("saving company: %w: RUT is required", domain.ErrInvalidRUT)
+	}
 	err := s.reponsitory.Save(ctx, company)
 	if err != nil {
 		return fmt.Errorf("saving company: %w", err)
@@ -64,7 +67,7 @@ func (s *SimpleCompanyService) FindByID(ctx context.Context, id string) (*domain
 	return company, nil
 }
 
-func (s *SimpleCompanyService) FindByCode(ctx context.Context, code string) (*domain.Company, error) {
+func (s *SimpleCompanyService) FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error) {
 	company, err := s.reponsitory.FindByCode(ctx, code)
 	if err != nil {
 		return nil, fmt.Errorf("finding company by code: %w", err)
@@ -74,6 +77,9 @@ func (s *SimpleCompanyService) FindByCode(ctx context.Context, code string) (*do
 }
 
 func (s *SimpleCompanyService) Update(ctx context.Context, company domain.Company) error {
+	if company.Code.IsZero() {
+		return fmt.Errorf("updating company: %w: RUT is required", domain.ErrInvalidRUT)
+	}
 	err := s.reponsitory.Save(ctx, company)
 	if err != nil {
 		return fmt.Errorf("updating company: %w", err)
@@ -114,7 +120,7 @@ type CompanyRepository interface {
 	FindAll(ctx context.Context) ([]domain.Company, error)
 	FindByNameFilter(ctx context.Context, nameFilter string) ([]domain.Company, error)
 	FindByID(ctx context.Context, id string) (*domain.Company, error)
-	FindByCode(ctx context.Context, code string) (*domain.Company, error)
+	FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error)
 	GetCommercialActivities(ctx context.Context, companyID string) ([]domain.CommercialActivity, error)
 	AddCommercialActivity(ctx context.Context, companyID string, activity domain.CommercialActivity) error
 	RemoveCommercialActivity(ctx context.Context, companyID string, activityID string) error
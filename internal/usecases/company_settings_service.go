@@ -0,0 +1,159 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // registers the JPEG decoder for logo uploads
+	"io"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// _maxLogoSize bounds uploaded logos; they are embedded in every PDF.
+const _maxLogoSize = 1 << 20
+
+var (
+	ErrCompanySettingsNotFound = errors.New("company settings not found")
+	ErrInvalidCompanySettings  = errors.New("invalid company settings")
+	ErrInvalidLogo             = errors.New("logo must be a PNG or JPEG image of at most 1 MB")
+)
+
+// CompanySettingsRepository persists company settings.
+type CompanySettingsRepository interface {
+	Find(ctx context.Context, companyID string) (*domain.CompanySettings, error)
+	Save(ctx context.Context, settings domain.CompanySettings) error
+}
+
+// CompanySettingsService manages the profile printed on a company's
+// documents. Logos are kept in blob storage.
+type CompanySettingsService interface {
+	// Get returns ErrCompanySettingsNotFound for companies without settings.
+	Get(ctx context.Context, companyID string) (domain.CompanySettings, error)
+	// Update replaces every setting but the logo.
+	Update(ctx context.Context, settings domain.CompanySettings) (domain.CompanySettings, error)
+	UploadLogo(ctx context.Context, companyID string, data []byte) (domain.CompanySettings, error)
+	Logo(ctx context.Context, settings domain.CompanySettings) ([]byte, error)
+}
+
+func NewCompanySettingsService(storage BlobStorageClient, repository CompanySettingsRepository) *SimpleCompanySettingsService {
+	return &SimpleCompanySettingsService{
+		storage:    storage,
+		repository: repository,
+	}
+}
+
+type SimpleCompanySettingsService struct {
+	storage    BlobStorageClient
+	repository CompanySettingsRepository
+}
+
+func (s *SimpleCompanySettingsService) Get(ctx context.Context, companyID string) (domain.CompanySettings, error) {
+	settings, err := s.repository.Find(ctx, companyID)
+	if err != nil {
+		if errors.Is(err, ErrCompanySettingsNotFound) {
+			return domain.CompanySettings{CompanyID: companyID}, err
+		}
+		return domain.CompanySettings{}, fmt.Errorf("finding company settings: %w", err)
+	}
+	return *settings, nil
+}
+
+func (s *SimpleCompanySettingsService) Update(ctx context.Context, settings domain.CompanySettings) (domain.CompanySettings, error) {
+	if err := validateCompanySettings(settings); err != nil {
+		return domain.CompanySettings{}, err
+	}
+
+	current, err := s.Get(ctx, settings.CompanyID)
+	if err != nil && !errors.Is(err, ErrCompanySettingsNotFound) {
+		return domain.CompanySettings{}, err
+	}
+	settings.LogoBlob = current.LogoBlob
+	settings.LogoContentType = current.LogoContentType
+	settings.UpdatedAt = time.Now()
+
+	if err := s.repository.Save(ctx, settings); err != nil {
+		return domain.CompanySettings{}, fmt.Errorf("saving company settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (s *SimpleCompanySettingsService) UploadLogo(ctx context.Context, companyID string, data []byte) (domain.CompanySettings, error) {
+	if len(data) > _maxLogoSize {
+		return domain.CompanySettings{}, ErrInvalidLogo
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg") {
+		return domain.CompanySettings{}, ErrInvalidLogo
+	}
+
+	settings, err := s.Get(ctx, companyID)
+	if err != nil && !errors.Is(err, ErrCompanySettingsNotFound) {
+		return domain.CompanySettings{}, err
+	}
+
+	blobName := fmt.Sprintf("logos/%s/logo.%s", companyID, format)
+	if err := s.storage.Upload(ctx, blobName, bytes.NewReader(data)); err != nil {
+		return domain.CompanySettings{}, fmt.Errorf("uploading logo to storage: %w", err)
+	}
+
+	settings.LogoBlob = blobName
+	settings.LogoContentType = "image/" + format
+	settings.UpdatedAt = time.Now()
+	if err := s.repository.Save(ctx, settings); err != nil {
+		return domain.CompanySettings{}, fmt.Errorf("saving company settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (s *SimpleCompanySettingsService) Logo(ctx context.Context, settings domain.CompanySettings) ([]byte, error) {
+	if settings.LogoBlob == "" {
+		return nil, nil
+	}
+	reader, err := s.storage.Download(ctx, settings.LogoBlob)
+	if err != nil {
+		return nil, fmt.Errorf("downloading logo from storage: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, _maxLogoSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading logo: %w", err)
+	}
+	return data, nil
+}
+
+func validateCompanySettings(settings domain.CompanySettings) error {
+	if settings.CompanyID == "" {
+		return fmt.Errorf("%w: company id is required", ErrInvalidCompanySettings)
+	}
+	if settings.Email != "" {
+		if _, err := mail.ParseAddress(settings.Email); err != nil {
+			return fmt.Errorf("%w: invalid email %q", ErrInvalidCompanySettings, settings.Email)
+		}
+	}
+	if settings.Website != "" {
+		website := settings.Website
+		if !strings.Contains(website, "://") {
+			website = "https://" + website
+		}
+		if u, err := url.Parse(website); err != nil || u.Host == "" {
+			return fmt.Errorf("%w: invalid website %q", ErrInvalidCompanySettings, settings.Website)
+		}
+	}
+	if settings.PrimaryColor != "" {
+		if _, err := parseColor(settings.PrimaryColor); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCompanySettings, err)
+		}
+	}
+	if settings.ResolutionNumber < 0 || (settings.ResolutionNumber > 0) != !settings.ResolutionDate.IsZero() {
+		return fmt.Errorf("%w: resolution number and date go together", ErrInvalidCompanySettings)
+	}
+	return nil
+}
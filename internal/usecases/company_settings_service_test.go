@@ -0,0 +1,185 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryBlobStorage struct {
+	blobs map[string][]byte
+}
+
+func (m *memoryBlobStorage) Upload(ctx context.Context, blobName string, data io.Reader) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.blobs[blobName] = content
+	return nil
+}
+
+func (m *memoryBlobStorage) Download(ctx context.Context, blobName string) (io.ReadCloser, error) {
+	content, ok := m.blobs[blobName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+type memoryCompanySettingsRepository struct {
+	settings map[string]domain.CompanySettings
+}
+
+func (m *memoryCompanySettingsRepository) Find(ctx context.Context, companyID string) (*domain.CompanySettings, error) {
+	settings, ok := m.settings[companyID]
+	if !ok {
+		return nil, ErrCompanySettingsNotFound
+	}
+	return &settings, nil
+}
+
+func (m *memoryCompanySettingsRepository) Save(ctx context.Context, settings domain.CompanySettings) error {
+	m.settings[settings.CompanyID] = settings
+	return nil
+}
+
+func newTestCompanySettingsService() *SimpleCompanySettingsService {
+	return NewCompanySettingsService(
+		&memoryBlobStorage{blobs: make(map[string][]byte)},
+		&memoryCompanySettingsRepository{settings: make(map[string]domain.CompanySettings)},
+	)
+}
+
+func testLogoPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatalf("Failed to encode logo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompanySettingsService_Update(t *testing.T) {
+	service := newTestCompanySettingsService()
+	ctx := context.Background()
+
+	invalid := []domain.CompanySettings{
+		{CompanyID: "company-1", Email: "not-an-email"},
+		{CompanyID: "company-1", Website: "http://"},
+		{CompanyID: "company-1", PrimaryColor: "red"},
+		{CompanyID: "company-1", ResolutionNumber: 80},
+		{CompanyID: "company-1", ResolutionDate: time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, settings := range invalid {
+		if _, err := service.Update(ctx, settings); !errors.Is(err, ErrInvalidCompanySettings) {
+			t.Errorf("%+v: expected ErrInvalidCompanySettings, got %v", settings, err)
+		}
+	}
+
+	if _, err := service.Get(ctx, "company-1"); !errors.Is(err, ErrCompanySettingsNotFound) {
+		t.Fatalf("Expected no settings before the first update, got %v", err)
+	}
+
+	if _, err := service.UploadLogo(ctx, "company-1", testLogoPNG(t)); err != nil {
+		t.Fatalf("UploadLogo failed: %v", err)
+	}
+	updated, err := service.Update(ctx, domain.CompanySettings{
+		CompanyID:        "company-1",
+		Phone:            "+56 2 2345 6789",
+		Email:            "ventas@example.cl",
+		Website:          "www.example.cl",
+		ResolutionNumber: 99,
+		ResolutionDate:   time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.LogoBlob != "logos/company-1/logo.png" || updated.LogoContentType != "image/png" {
+		t.Errorf("Expected the update to keep the uploaded logo, got %+v", updated)
+	}
+}
+
+func TestCompanySettingsService_UploadLogo(t *testing.T) {
+	service := newTestCompanySettingsService()
+	ctx := context.Background()
+
+	if _, err := service.UploadLogo(ctx, "company-1", []byte("GIF89a")); !errors.Is(err, ErrInvalidLogo) {
+		t.Errorf("Expected ErrInvalidLogo for a non image, got %v", err)
+	}
+	if _, err := service.UploadLogo(ctx, "company-1", make([]byte, _maxLogoSize+1)); !errors.Is(err, ErrInvalidLogo) {
+		t.Errorf("Expected ErrInvalidLogo for an oversized logo, got %v", err)
+	}
+
+	logo := testLogoPNG(t)
+	settings, err := service.UploadLogo(ctx, "company-1", logo)
+	if err != nil {
+		t.Fatalf("UploadLogo failed: %v", err)
+	}
+	stored, err := service.Logo(ctx, settings)
+	if err != nil || !bytes.Equal(stored, logo) {
+		t.Errorf("Expected the uploaded logo back, got %d bytes (%v)", len(stored), err)
+	}
+}
+
+func TestDocumentService_RenderInvoice_CompanySettings(t *testing.T) {
+	companyService := &mockCompanyService{
+		companies: map[string]*domain.Company{
+			"76212889-6": {ID: "company-1", Code: domain.MustParseRUT("76212889-6"), Name: "Panadería La Española Ltda."},
+		},
+	}
+	settingsService := newTestCompanySettingsService()
+	ctx := context.Background()
+	if _, err := settingsService.UploadLogo(ctx, "company-1", testLogoPNG(t)); err != nil {
+		t.Fatalf("UploadLogo failed: %v", err)
+	}
+	_, err := settingsService.Update(ctx, domain.CompanySettings{
+		CompanyID:        "company-1",
+		Phone:            "+56 2 2345 6789",
+		Email:            "ventas@laespanola.cl",
+		Website:          "www.laespanola.cl",
+		ResolutionNumber: 99,
+		ResolutionDate:   time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC),
+		FooterText:       "Gracias por su compra",
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	invoice := &domain.Invoice{
+		DocumentType: 39,
+		Folio:        10,
+		IssueDate:    time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+		Issuer:       domain.Company{Code: domain.MustParseRUT("76212889-6")},
+		Receiver:     &domain.Company{Code: domain.MustParseRUT("66666666-6"), Name: "Cliente"},
+		Details:      []domain.InvoiceDetail{{Description: "Pan amasado", Quantity: domain.NewDecimal(1), UnitPrice: domain.NewDecimal(3500), LineTotal: 3500}},
+		Totals:       domain.InvoiceTotals{TotalAmount: 3500},
+	}
+	documentService := NewDocumentService(&mockStampService{}, companyService, nil).WithCompanySettings(settingsService)
+	stampXML, err := documentService.StampInvoice(ctx, invoice, IdempotencyKey{})
+	if err != nil {
+		t.Fatalf("StampInvoice failed: %v", err)
+	}
+	result, err := documentService.RenderInvoice(ctx, invoice, stampXML)
+	if err != nil {
+		t.Fatalf("RenderInvoice failed: %v", err)
+	}
+
+	text := strings.Join(extractPDFText(t, result.PDF), "\n")
+	for _, want := range []string{"Tel: +56 2 2345 6789", "ventas@laespanola.cl", "www.laespanola.cl", "Res. 99 del 22-08-2014", "Gracias por su compra"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the PDF, got:\n%s", want, text)
+		}
+	}
+	if !bytes.Contains(result.PDF, []byte("/Subtype /Image")) {
+		t.Errorf("Expected the uploaded logo in the PDF")
+	}
+}
@@ -0,0 +1,203 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrCustomerExists   = errors.New("customer is already registered")
+	ErrInvalidCustomer  = errors.New("invalid customer")
+)
+
+// CustomerRepository persists the customer directory of companies.
+type CustomerRepository interface {
+	FindByCompanyID(ctx context.Context, companyID string) ([]domain.Customer, error)
+	// FindByCode returns ErrCustomerNotFound when the company has no customer
+	// with that RUT.
+	FindByCode(ctx context.Context, companyID string, code domain.RUT) (*domain.Customer, error)
+	// Create returns ErrCustomerExists when the RUT is taken.
+	Create(ctx context.Context, customer domain.Customer) error
+	Update(ctx context.Context, customer domain.Customer) error
+	Delete(ctx context.Context, companyID string, code domain.RUT) error
+}
+
+// CustomerService manages the customers of each company, so documents can
+// name their receiver by RUT alone.
+type CustomerService interface {
+	List(ctx context.Context, companyID string) ([]domain.Customer, error)
+	FindByCode(ctx context.Context, companyID string, code domain.RUT) (domain.Customer, error)
+	Create(ctx context.Context, customer domain.Customer) (domain.Customer, error)
+	Update(ctx context.Context, customer domain.Customer) (domain.Customer, error)
+	Delete(ctx context.Context, companyID string, code domain.RUT) error
+	// CompleteReceiver fills the fields the receiver is missing from the
+	// directory entry with its RUT. Receivers not in the directory are left
+	// as they are.
+	CompleteReceiver(ctx context.Context, companyID string, receiver *domain.Company) error
+	// Learn adds the receiver to the directory unless its RUT is already
+	// there, and reports whether it did. Existing entries are never changed.
+	Learn(ctx context.Context, companyID string, receiver domain.Company) (bool, error)
+}
+
+func NewCustomerService(repository CustomerRepository) *SimpleCustomerService {
+	return &SimpleCustomerService{
+		repository: repository,
+	}
+}
+
+type SimpleCustomerService struct {
+	repository CustomerRepository
+}
+
+func (s *SimpleCustomerService) List(ctx context.Context, companyID string) ([]domain.Customer, error) {
+	customers, err := s.repository.FindByCompanyID(ctx, companyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding customers: %w", err)
+	}
+	return customers, nil
+}
+
+func (s *SimpleCustomerService) FindByCode(ctx context.Context, companyID string, code domain.RUT) (domain.Customer, error) {
+	customer, err := s.repository.FindByCode(ctx, companyID, code)
+	if err != nil {
+		if errors.Is(err, ErrCustomerNotFound) {
+			return domain.Customer{}, fmt.Errorf("%w: %s", err, code)
+		}
+		return domain.Customer{}, fmt.Errorf("finding customer: %w", err)
+	}
+	return *customer, nil
+}
+
+func (s *SimpleCustomerService) Create(ctx context.Context, customer domain.Customer) (domain.Customer, error) {
+	if err := validateCustomer(customer); err != nil {
+		return domain.Customer{}, err
+	}
+
+	now := time.Now()
+	customer.ID = uuid.NewString()
+	customer.CreatedAt = now
+	customer.UpdatedAt = now
+	if err := s.repository.Create(ctx, customer); err != nil {
+		if errors.Is(err, ErrCustomerExists) {
+			return domain.Customer{}, fmt.Errorf("%w: %s", err, customer.Code)
+		}
+		return domain.Customer{}, fmt.Errorf("saving customer: %w", err)
+	}
+	return customer, nil
+}
+
+// Update replaces every field of the customer with that RUT but its id.
+func (s *SimpleCustomerService) Update(ctx context.Context, customer domain.Customer) (domain.Customer, error) {
+	if err := validateCustomer(customer); err != nil {
+		return domain.Customer{}, err
+	}
+
+	current, err := s.FindByCode(ctx, customer.CompanyID, customer.Code)
+	if err != nil {
+		return domain.Customer{}, err
+	}
+	customer.ID = current.ID
+	customer.CreatedAt = current.CreatedAt
+	customer.UpdatedAt = time.Now()
+	if err := s.repository.Update(ctx, customer); err != nil {
+		return domain.Customer{}, fmt.Errorf("saving customer: %w", err)
+	}
+	return customer, nil
+}
+
+func (s *SimpleCustomerService) Delete(ctx context.Context, companyID string, code domain.RUT) error {
+	if err := s.repository.Delete(ctx, companyID, code); err != nil {
+		if errors.Is(err, ErrCustomerNotFound) {
+			return fmt.Errorf("%w: %s", err, code)
+		}
+		return fmt.Errorf("deleting customer: %w", err)
+	}
+	return nil
+}
+
+func (s *SimpleCustomerService) CompleteReceiver(ctx context.Context, companyID string, receiver *domain.Company) error {
+	if receiver == nil || receiver.Code.IsZero() {
+		return nil
+	}
+	if receiver.Name != "" && receiver.Address != "" && receiver.BusinessLine != "" {
+		return nil
+	}
+
+	customer, err := s.repository.FindByCode(ctx, companyID, receiver.Code)
+	if err != nil {
+		if errors.Is(err, ErrCustomerNotFound) {
+			return nil
+		}
+		return fmt.Errorf("finding customer: %w", err)
+	}
+
+	known := customer.Receiver()
+	if receiver.Name == "" {
+		receiver.Name = known.Name
+	}
+	if receiver.Address == "" {
+		receiver.Address = known.Address
+	}
+	if receiver.BusinessLine == "" {
+		receiver.BusinessLine = known.BusinessLine
+	}
+	return nil
+}
+
+func (s *SimpleCustomerService) Learn(ctx context.Context, companyID string, receiver domain.Company) (bool, error) {
+	if receiver.Code.IsZero() || strings.TrimSpace(receiver.Name) == "" {
+		return false, nil
+	}
+
+	_, err := s.repository.FindByCode(ctx, companyID, receiver.Code)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrCustomerNotFound) {
+		return false, fmt.Errorf("finding customer: %w", err)
+	}
+
+	_, err = s.Create(ctx, domain.Customer{
+		CompanyID:    companyID,
+		Code:         receiver.Code,
+		Name:         receiver.Name,
+		BusinessLine: receiver.BusinessLine,
+		Address:      receiver.Address,
+	})
+	if errors.Is(err, ErrCustomerExists) {
+		// Learned from another document in the meantime
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// validateCustomer checks the customer.
+func validateCustomer(customer domain.Customer) error {
+	if customer.CompanyID == "" {
+		return fmt.Errorf("%w: company id is required", ErrInvalidCustomer)
+	}
+	if customer.Code.IsZero() {
+		return fmt.Errorf("%w: RUT is required", ErrInvalidCustomer)
+	}
+	if strings.TrimSpace(customer.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCustomer)
+	}
+	if customer.Email != "" {
+		if _, err := mail.ParseAddress(customer.Email); err != nil {
+			return fmt.Errorf("%w: invalid email %q", ErrInvalidCustomer, customer.Email)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryCustomerRepository struct {
+	customers map[string]domain.Customer
+}
+
+func (m *memoryCustomerRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Customer, error) {
+	var customers []domain.Customer
+	for _, customer := range m.customers {
+		if customer.CompanyID == companyID {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
+}
+
+func (m *memoryCustomerRepository) FindByCode(ctx context.Context, companyID string, code domain.RUT) (*domain.Customer, error) {
+	customer, ok := m.customers[companyID+"/"+code.String()]
+	if !ok {
+		return nil, ErrCustomerNotFound
+	}
+	return &customer, nil
+}
+
+func (m *memoryCustomerRepository) Create(ctx context.Context, customer domain.Customer) error {
+	key := customer.CompanyID + "/" + customer.Code.String()
+	if _, ok := m.customers[key]; ok {
+		return ErrCustomerExists
+	}
+	m.customers[key] = customer
+	return nil
+}
+
+func (m *memoryCustomerRepository) Update(ctx context.Context, customer domain.Customer) error {
+	m.customers[customer.CompanyID+"/"+customer.Code.String()] = customer
+	return nil
+}
+
+func (m *memoryCustomerRepository) Delete(ctx context.Context, companyID string, code domain.RUT) error {
+	if _, ok := m.customers[companyID+"/"+code.String()]; !ok {
+		return ErrCustomerNotFound
+	}
+	delete(m.customers, companyID+"/"+code.String())
+	return nil
+}
+
+func newTestCustomerService(t *testing.T) *SimpleCustomerService {
+	t.Helper()
+	service := NewCustomerService(&memoryCustomerRepository{customers: make(map[string]domain.Customer)})
+	_, err := service.Create(context.Background(), domain.Customer{
+		CompanyID:    "company-1",
+		Code:         domain.MustParseRUT("77.371.419-3"),
+		Name:         "AGRICOLA PAINE LTDA",
+		BusinessLine: "Agricola",
+		Address:      "AVDA. VITACURA 2771",
+		Commune:      "Las Condes",
+		City:         "Santiago",
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return service
+}
+
+func TestCustomerService_Create(t *testing.T) {
+	service := newTestCustomerService(t)
+	ctx := context.Background()
+
+	customer, err := service.FindByCode(ctx, "company-1", domain.MustParseRUT("773714193"))
+	if err != nil {
+		t.Fatalf("FindByCode failed: %v", err)
+	}
+	if customer.Code.String() != "77371419-3" {
+		t.Errorf("Expected the RUT to be stored normalised, got %q", customer.Code)
+	}
+	if _, err := service.Create(ctx, domain.Customer{CompanyID: "company-1", Code: domain.MustParseRUT("77371419-3"), Name: "Otra"}); !errors.Is(err, ErrCustomerExists) {
+		t.Errorf("Expected a taken RUT to be rejected, got %v", err)
+	}
+
+	invalid := map[string]domain.Customer{
+		"no rut":    {CompanyID: "company-1", Name: "Cliente"},
+		"no name":   {CompanyID: "company-1", Code: domain.MustParseRUT("11111111-1")},
+		"bad email": {CompanyID: "company-1", Code: domain.MustParseRUT("11111111-1"), Name: "Cliente", Email: "cliente"},
+	}
+	for name, customer := range invalid {
+		if _, err := service.Create(ctx, customer); !errors.Is(err, ErrInvalidCustomer) {
+			t.Errorf("%s: expected ErrInvalidCustomer, got %v", name, err)
+		}
+	}
+}
+
+func TestCustomerService_CompleteReceiver(t *testing.T) {
+	service := newTestCustomerService(t)
+	ctx := context.Background()
+
+	receiver := &domain.Company{Code: domain.MustParseRUT("77371419-3")}
+	if err := service.CompleteReceiver(ctx, "company-1", receiver); err != nil {
+		t.Fatalf("CompleteReceiver failed: %v", err)
+	}
+	if receiver.Name != "AGRICOLA PAINE LTDA" || receiver.BusinessLine != "Agricola" ||
+		receiver.Address != "AVDA. VITACURA 2771, Las Condes, Santiago" {
+		t.Errorf("Expected the receiver filled from the directory, got %+v", receiver)
+	}
+
+	receiver = &domain.Company{Code: domain.MustParseRUT("77371419-3"), Name: "Agrícola Paine"}
+	if err := service.CompleteReceiver(ctx, "company-1", receiver); err != nil {
+		t.Fatalf("CompleteReceiver failed: %v", err)
+	}
+	if receiver.Name != "Agrícola Paine" || receiver.BusinessLine != "Agricola" {
+		t.Errorf("Expected the given name to be kept, got %+v", receiver)
+	}
+
+	receiver = &domain.Company{Code: domain.MustParseRUT("77371419-3")}
+	if err := service.CompleteReceiver(ctx, "company-2", receiver); err != nil || receiver.Name != "" {
+		t.Errorf("Expected another company's directory to be ignored, got %+v, %v", receiver, err)
+	}
+}
+
+func TestCustomerService_Learn(t *testing.T) {
+	service := newTestCustomerService(t)
+	ctx := context.Background()
+
+	learned, err := service.Learn(ctx, "company-1", domain.Company{Code: domain.MustParseRUT("76.212.889-6"), Name: "FACTURA MOVIL SPA", Address: "Vicuña Mackenna 9705"})
+	if err != nil || !learned {
+		t.Fatalf("Expected a new receiver to be learned, got %v, %v", learned, err)
+	}
+	if customer, err := service.FindByCode(ctx, "company-1", domain.MustParseRUT("76212889-6")); err != nil || customer.Address != "Vicuña Mackenna 9705" {
+		t.Errorf("Expected the learned customer, got %+v, %v", customer, err)
+	}
+
+	learned, err = service.Learn(ctx, "company-1", domain.Company{Code: domain.MustParseRUT("77371419-3"), Name: "Otro nombre"})
+	if err != nil || learned {
+		t.Errorf("Expected a known receiver to be left alone, got %v, %v", learned, err)
+	}
+	if customer, _ := service.FindByCode(ctx, "company-1", domain.MustParseRUT("77371419-3")); customer.Name != "AGRICOLA PAINE LTDA" {
+		t.Errorf("Expected the directory entry unchanged, got %q", customer.Name)
+	}
+
+	if learned, _ := service.Learn(ctx, "company-1", domain.Company{Code: domain.MustParseRUT("11111111-1")}); learned {
+		t.Error("Expected a receiver without a name not to be learned")
+	}
+}
+
+func TestDocumentService_StampInvoice_Customers(t *testing.T) {
+	companyService := &mockCompanyService{
+		companies: map[string]*domain.Company{
+			"76212889-6": {ID: "company-1", Code: domain.MustParseRUT("76212889-6"), Name: "FACTURA MOVIL SPA"},
+		},
+	}
+	customers := newTestCustomerService(t)
+	documentService := NewDocumentService(&mockStampService{}, companyService, nil).
+		WithCustomers(customers).
+		WithCustomerLearning()
+	ctx := context.Background()
+
+	invoice := &domain.Invoice{
+		DocumentType: 33,
+		IssueDate:    time.Now(),
+		Issuer:       domain.Company{Code: domain.MustParseRUT("76212889-6")},
+		Receiver:     &domain.Company{Code: domain.MustParseRUT("77371419-3")},
+		Totals:       domain.InvoiceTotals{TotalAmount: 1190},
+	}
+	stampXML, err := documentService.StampInvoice(ctx, invoice, IdempotencyKey{})
+	if err != nil {
+		t.Fatalf("StampInvoice failed: %v", err)
+	}
+	if !bytes.Contains(stampXML, []byte("<RSR>AGRICOLA PAINE LTDA</RSR>")) {
+		t.Errorf("Expected the stamp to carry the receiver name from the directory, got %s", stampXML)
+	}
+
+	invoice.Receiver = &domain.Company{Code: domain.MustParseRUT("11111111-1"), Name: "Cliente Nuevo"}
+	if _, err := documentService.StampInvoice(ctx, invoice, IdempotencyKey{}); err != nil {
+		t.Fatalf("StampInvoice failed: %v", err)
+	}
+	if _, err := customers.FindByCode(ctx, "company-1", domain.MustParseRUT("11111111-1")); err != nil {
+		t.Errorf("Expected the new receiver to be learned, got %v", err)
+	}
+}
@@ -1,535 +1,488 @@
 package usecases
 
 import (
-	"bytes"
 	"context"
-	"encoding/xml"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
-	"image/png"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"factura-movil-gateway/internal/domain"
 	"factura-movil-gateway/internal/utils"
-
-	"github.com/jung-kurt/gofpdf/v2"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
+// _pdf417PNGModuleWidth is the width in pixels of each module in the PDF417
+// PNG written next to the documents.
+const _pdf417PNGModuleWidth = 2
+
 // DocumentService defines the interface for document processing operations
 type DocumentService interface {
-	ProcessInvoice(invoice *domain.Invoice) (ProcessingResult, error)
+	ProcessInvoice(ctx context.Context, invoice *domain.Invoice) (ProcessingResult, error)
+	StampInvoice(ctx context.Context, invoice *domain.Invoice, key IdempotencyKey) ([]byte, error)
+	RenderInvoice(ctx context.Context, invoice *domain.Invoice, stampXML []byte) (ProcessingResult, error)
+	RenderReceipt(ctx context.Context, invoice *domain.Invoice, stampXML []byte, options ReceiptOptions) ([]byte, error)
 }
 
 // ProcessingResult contains the results of document processing
 type ProcessingResult struct {
-	StampXML       []byte
-	PDF417Data     []byte
-	ThermalPDF     []byte
+	StampXML   []byte
+	PDF417Data []byte
+	PDF        []byte
+	PDFLayout  PDFLayout
+	// PDF417SVG is the timbre as a vector image, when the service was
+	// configured WithPDF417SVG.
+	PDF417SVG []byte
+	// Receipt holds the ESC/POS commands of thermal documents, when the
+	// service was configured WithReceipts.
+	Receipt        []byte
 	ProcessingTime time.Duration
 	Error          error
 }
 
 // SimpleDocumentService implements DocumentService
 type SimpleDocumentService struct {
-	stampService   StampService
-	companyService CompanyService
+	stampService       StampService
+	companyService     CompanyService
+	idempotencyService IdempotencyService
+	layouts            PDFLayoutPolicy
+	templates          *TemplateStore
+	settings           CompanySettingsService
+	branches           BranchService
+	customers          CustomerService
+	learnCustomers     bool
+	receipts           *ReceiptOptions
+	pdf417SVG          bool
 }
 
-// NewDocumentService creates a new document service
-func NewDocumentService(stampService StampService, companyService CompanyService) DocumentService {
+// NewDocumentService creates a new document service. idempotencyService may be
+// nil, in which case idempotency keys passed to StampInvoice are ignored.
+func NewDocumentService(stampService StampService, companyService CompanyService, idempotencyService IdempotencyService) *SimpleDocumentService {
 	return &SimpleDocumentService{
-		stampService:   stampService,
-		companyService: companyService,
+		stampService:       stampService,
+		companyService:     companyService,
+		idempotencyService: idempotencyService,
+		layouts:            DefaultPDFLayoutPolicy(),
+		templates:          NewTemplateStore(),
 	}
 }
 
+// WithPDFLayouts sets which PDF layout is rendered for each company and
+// document type.
+func (s *SimpleDocumentService) WithPDFLayouts(policy PDFLayoutPolicy) *SimpleDocumentService {
+	s.layouts = policy
+	return s
+}
+
+// WithTemplates sets where the templates and branding of each company are
+// read from.
+func (s *SimpleDocumentService) WithTemplates(store *TemplateStore) *SimpleDocumentService {
+	s.templates = store
+	return s
+}
+
+// WithCompanySettings makes rendered documents use the contact details, logo
+// and resolution each company configured, over the template store branding.
+func (s *SimpleDocumentService) WithCompanySettings(settings CompanySettingsService) *SimpleDocumentService {
+	s.settings = settings
+	return s
+}
+
+// WithBranches lets documents name the branch that issues them. Without it,
+// documents with a branch code are rejected.
+func (s *SimpleDocumentService) WithBranches(branches BranchService) *SimpleDocumentService {
+	s.branches = branches
+	return s
+}
+
+// WithCustomers fills what documents leave out of their receiver from the
+// issuer's customer directory.
+func (s *SimpleDocumentService) WithCustomers(customers CustomerService) *SimpleDocumentService {
+	s.customers = customers
+	return s
+}
+
+// WithCustomerLearning adds the receivers of stamped documents that are not
+// in the customer directory yet. It requires WithCustomers.
+func (s *SimpleDocumentService) WithCustomerLearning() *SimpleDocumentService {
+	s.learnCustomers = true
+	return s
+}
+
+// WithReceipts makes RenderInvoice also print ESC/POS receipts for the
+// documents rendered with the thermal layout.
+func (s *SimpleDocumentService) WithReceipts(options ReceiptOptions) *SimpleDocumentService {
+	s.receipts = &options
+	return s
+}
+
+// WithPDF417SVG makes RenderInvoice also draw the timbre as an SVG.
+func (s *SimpleDocumentService) WithPDF417SVG() *SimpleDocumentService {
+	s.pdf417SVG = true
+	return s
+}
+
 // ProcessInvoice processes a single document through the complete workflow
-func (s *SimpleDocumentService) ProcessInvoice(invoice *domain.Invoice) (ProcessingResult, error) {
+func (s *SimpleDocumentService) ProcessInvoice(ctx context.Context, invoice *domain.Invoice) (ProcessingResult, error) {
 	startTime := time.Now()
 
-	result := ProcessingResult{}
+	stampXML, err := s.StampInvoice(ctx, invoice, IdempotencyKey{})
+	if err != nil {
+		return ProcessingResult{Error: err}, err
+	}
 
-	stampXML, err := s.createStamp(invoice)
+	result, err := s.RenderInvoice(ctx, invoice, stampXML)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create stamp: %w", err)
+		return result, err
+	}
+
+	result.ProcessingTime = time.Since(startTime)
+	return result, nil
+}
+
+// StampInvoice assigns a folio to the invoice and returns the signed TED XML.
+// Every successful call consumes a folio from the issuer's CAF, unless key was
+// already stamped, in which case the original stamp is returned.
+func (s *SimpleDocumentService) StampInvoice(ctx context.Context, invoice *domain.Invoice, key IdempotencyKey) ([]byte, error) {
+	stampXML, err := s.createStamp(ctx, invoice, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stamp: %w", NewStageError(StageStamp, err))
+	}
+	return stampXML, nil
+}
+
+// RenderInvoice builds the PDF417 barcode and the PDF for an invoice that was
+// already stamped, without consuming a new folio. The PDF layout is chosen by
+// the service's PDFLayoutPolicy. The invoice takes the folio of its stamp.
+func (s *SimpleDocumentService) RenderInvoice(ctx context.Context, invoice *domain.Invoice, stampXML []byte) (ProcessingResult, error) {
+	startTime := time.Now()
+
+	result := ProcessingResult{
+		StampXML: stampXML,
+	}
+
+	if err := setStampedFolio(invoice, stampXML); err != nil {
+		result.Error = fmt.Errorf("failed to read stamp: %w", NewStageError(StagePDF, err))
 		return result, result.Error
 	}
-	result.StampXML = stampXML
 
-	pdf417Data, err := s.createPDF417(stampXML)
+	symbol, pdf417Data, err := s.createPDF417(stampXML)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create PDF417: %w", err)
+		result.Error = fmt.Errorf("failed to create PDF417: %w", NewStageError(StagePDF417, err))
 		return result, result.Error
 	}
 	result.PDF417Data = pdf417Data
+	if s.pdf417SVG {
+		result.PDF417SVG = symbol.SVG()
+	}
 
-	thermalPDF, err := s.createThermalPDF(invoice, stampXML)
+	layout := s.layouts.Layout(invoice.Issuer.Code.String(), invoice.DocumentType)
+	pdf, err := s.createPDF(ctx, invoice, symbol, layout)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create thermal PDF: %w", err)
+		result.Error = fmt.Errorf("failed to create %s PDF: %w", layout, NewStageError(StagePDF, err))
 		return result, result.Error
 	}
-	result.ThermalPDF = thermalPDF
+	result.PDF = pdf
+	result.PDFLayout = layout
+
+	if s.receipts != nil && layout == PDFLayoutThermal {
+		receipt, err := s.RenderReceipt(ctx, invoice, stampXML, BranchReceiptOptions(invoice, *s.receipts))
+		if err != nil {
+			result.Error = fmt.Errorf("failed to create receipt: %w", NewStageError(StagePDF, err))
+			return result, result.Error
+		}
+		result.Receipt = receipt
+	}
 
 	result.ProcessingTime = time.Since(startTime)
 	return result, nil
 }
 
-// createStamp creates a stamp for the invoice using StampService
-func (s *SimpleDocumentService) createStamp(invoice *domain.Invoice) ([]byte, error) {
-	ctx := context.Background()
+// setStampedFolio sets the folio of invoice to the one its stamp was signed
+// with. JSON and CSV inputs carry no folio; it is only known once stamped.
+func setStampedFolio(invoice *domain.Invoice, stampXML []byte) error {
+	stamp, err := utils.UnmarshalTED(stampXML)
+	if err != nil {
+		return err
+	}
+	invoice.Folio = int(stamp.DD.F)
+	return nil
+}
 
+// createStamp creates a stamp for the invoice using StampService
+func (s *SimpleDocumentService) createStamp(ctx context.Context, invoice *domain.Invoice, key IdempotencyKey) ([]byte, error) {
 	company, err := s.companyService.FindByCode(ctx, invoice.Issuer.Code)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find company with code %s: %w", invoice.Issuer.Code, err)
 	}
+	if err := s.resolveBranch(ctx, company, invoice); err != nil {
+		return nil, err
+	}
+	if err := s.completeReceiver(ctx, company, invoice); err != nil {
+		return nil, err
+	}
 
-	stamp, err := s.stampService.Generate(ctx, *company, *invoice)
+	var stamp domain.Stamp
+	if s.idempotencyService != nil && key.Key != "" {
+		var replayed bool
+		stamp, replayed, err = s.idempotencyService.GenerateOnce(ctx, key, *company, *invoice)
+		if replayed {
+			slog.Info("document already stamped, reusing its stamp", "key", key.Key, "folio", stamp.DD.F)
+		}
+	} else {
+		stamp, err = s.stampService.Generate(ctx, *company, *invoice)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate stamp: %w", err)
 	}
 
-	stampXML, err := s.convertStampToXML(stamp)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert stamp to XML: %w", err)
-	}
+	stampXML := utils.MarshalTED(stamp)
+	s.learnReceiver(ctx, company, invoice)
 
 	slog.Debug("Created stamp using StampService", "size", len(stampXML), "company", company.Name)
 	return stampXML, nil
 }
 
-// convertToCompany converts Invoice issuer to domain.Company
-func (s *SimpleDocumentService) convertToCompany(invoice *domain.Invoice) domain.Company {
-	return domain.Company{
-		ID:      "temp-company-id",
-		Code:    invoice.Issuer.Code,
-		Name:    invoice.Issuer.Name,
-		Address: invoice.Issuer.Address,
+// resolveBranch looks up the branch named by the invoice's branch code, so
+// its CAFs are used and its address is printed.
+func (s *SimpleDocumentService) resolveBranch(ctx context.Context, company *domain.Company, invoice *domain.Invoice) error {
+	if invoice.BranchCode == 0 || invoice.Branch != nil {
+		return nil
+	}
+	if s.branches == nil {
+		return fmt.Errorf("%w: code %d, branches are not configured", ErrBranchNotFound, invoice.BranchCode)
+	}
+	branch, err := s.branches.FindByCode(ctx, company.ID, invoice.BranchCode)
+	if err != nil {
+		return fmt.Errorf("failed to find branch of company %s: %w", company.Code, err)
+	}
+	invoice.Branch = &branch
+	return nil
+}
+
+// completeReceiver fills the receiver's missing name, address and giro from
+// the customer directory.
+func (s *SimpleDocumentService) completeReceiver(ctx context.Context, company *domain.Company, invoice *domain.Invoice) error {
+	if s.customers == nil {
+		return nil
+	}
+	if err := s.customers.CompleteReceiver(ctx, company.ID, invoice.Receiver); err != nil {
+		return fmt.Errorf("failed to complete receiver of company %s: %w", company.Code, err)
 	}
+	return nil
 }
 
-// convertStampToXML converts domain.Stamp to XML bytes
-func (s *SimpleDocumentService) convertStampToXML(stamp domain.Stamp) ([]byte, error) {
-	// Create TED structure for XML serialization
-	ted := struct {
-		XMLName xml.Name  `xml:"TED"`
-		Version string    `xml:"version,attr"`
-		DD      domain.DD `xml:"DD"`
-		FRMT    struct {
-			Algorithm string `xml:"algoritmo,attr"`
-			Value     string `xml:",chardata"`
-		} `xml:"FRMT"`
-	}{
-		Version: "1.0",
-		DD:      stamp.DD,
-		FRMT: struct {
-			Algorithm string `xml:"algoritmo,attr"`
-			Value     string `xml:",chardata"`
-		}{
-			Algorithm: "SHA1withRSA",
-			Value:     stamp.FRMT,
-		},
-	}
-
-	// Marshal to XML
-	xmlData, err := xml.MarshalIndent(ted, "", "  ")
+// learnReceiver adds the receiver of a stamped invoice to the customer
+// directory. The folio is already used, so failures are only logged.
+func (s *SimpleDocumentService) learnReceiver(ctx context.Context, company *domain.Company, invoice *domain.Invoice) {
+	if s.customers == nil || !s.learnCustomers || invoice.Receiver == nil {
+		return
+	}
+	learned, err := s.customers.Learn(ctx, company.ID, *invoice.Receiver)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal TED to XML: %w", err)
+		slog.Warn("failed to learn customer", "company", company.Code, "receiver", invoice.Receiver.Code, "error", err)
+		return
+	}
+	if learned {
+		slog.Info("learned customer from document", "company", company.Code, "receiver", invoice.Receiver.Code)
 	}
+}
 
-	// Add XML header
-	xmlHeader := []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
-	fullXML := append(xmlHeader, xmlData...)
+// createPDF417 encodes the stamp's PDF417 to the SII spec and returns the
+// symbol with its PNG.
+func (s *SimpleDocumentService) createPDF417(stampXML []byte) (*utils.PDF417Symbol, []byte, error) {
+	symbol, err := utils.EncodeTEDPDF417(stampXML)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate PDF417: %w", err)
+	}
+	pdf417Data, err := symbol.PNG(_pdf417PNGModuleWidth)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return fullXML, nil
+	slog.Debug("Created PDF417", "rows", symbol.Rows, "size", len(pdf417Data))
+	return symbol, pdf417Data, nil
 }
 
-// createPDF417 creates a PDF417 barcode image from stamp data
-func (s *SimpleDocumentService) createPDF417(stampXML []byte) ([]byte, error) {
-	// Generate PDF417 with auto-dimensions
-	_, pdf417Data, err := utils.GenerateStampPDF417FromXMLWithAutoDimensions(string(stampXML))
+// RenderReceipt prints an already stamped invoice as ESC/POS commands for a
+// thermal printer, with the same content as the thermal PDF.
+func (s *SimpleDocumentService) RenderReceipt(ctx context.Context, invoice *domain.Invoice, stampXML []byte, options ReceiptOptions) ([]byte, error) {
+	if err := setStampedFolio(invoice, stampXML); err != nil {
+		return nil, err
+	}
+	data, _, err := s.templateData(ctx, invoice, PDFLayoutThermal)
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := renderReceipt(data, stampXML, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate PDF417: %w", err)
+		return nil, err
 	}
 
-	slog.Debug("Created PDF417", "size", len(pdf417Data))
-	return pdf417Data, nil
+	slog.Debug("Created ESC/POS receipt", "paperWidth", options.PaperWidth, "rasterPDF417", options.RasterPDF417, "size", len(receipt))
+	return receipt, nil
 }
 
-// createThermalPDF creates a thermal printer optimized PDF document
-func (s *SimpleDocumentService) createThermalPDF(invoice *domain.Invoice, stampXML []byte) ([]byte, error) {
-	// Get company
-	company, err := s.companyService.FindByCode(context.Background(), invoice.Issuer.Code)
+// templateData gathers what documents of the invoice's issuer are rendered
+// with: its company, activities, branding and settings, and its logo, if any.
+func (s *SimpleDocumentService) templateData(ctx context.Context, invoice *domain.Invoice, layout PDFLayout) (TemplateData, *markupImage, error) {
+	company, err := s.companyService.FindByCode(ctx, invoice.Issuer.Code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find company with code %s: %w", invoice.Issuer.Code, err)
+		return TemplateData{}, nil, fmt.Errorf("failed to find company with code %s: %w", invoice.Issuer.Code, err)
+	}
+	if err := s.resolveBranch(ctx, company, invoice); err != nil {
+		return TemplateData{}, nil, err
+	}
+	if err := s.completeReceiver(ctx, company, invoice); err != nil {
+		return TemplateData{}, nil, err
 	}
 
-	// Get commercial activities
-	activities, err := s.companyService.GetCommercialActivities(context.Background(), company.ID)
+	activities, err := s.companyService.GetCommercialActivities(ctx, company.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get commercial activities for company %s: %w", company.ID, err)
-	}
-
-	// Create PDF with custom page size for 3-inch thermal printer (80mm width)
-	pdf := gofpdf.NewCustom(&gofpdf.InitType{
-		UnitStr: "mm",
-		Size: gofpdf.SizeType{
-			Wd: 80,  // 3 inch width
-			Ht: 200, // Initial height, will extend as needed
-		},
-		FontDirStr:     "",
-		OrientationStr: "P",
-	})
-
-	pdf.AddPage()
-
-	// Set smaller margins for thermal printer
-	pdf.SetMargins(3, 3, 3)
-	pdf.SetAutoPageBreak(true, 3)
-
-	// Calculate separator width for 80mm thermal printer (approximately 48 characters)
-	separatorWidth := 48
-
-	// Helper function to format currency with thousand separators
-	formatCurrency := func(amount float64) string {
-		// Format with thousand separators using dots (Chilean format)
-		formatted := fmt.Sprintf("%.0f", amount)
-
-		// Add thousand separators (dots)
-		if len(formatted) > 3 {
-			var result []rune
-			for i, digit := range []rune(formatted) {
-				if i > 0 && (len(formatted)-i)%3 == 0 {
-					result = append(result, '.')
-				}
-				result = append(result, digit)
-			}
-			formatted = string(result)
-		}
-
-		return "$" + formatted
-	}
-
-	// Helper function to convert Spanish characters to ASCII equivalents for PDF compatibility
-	encodeText := func(text string) string {
-		// Replace Spanish accented characters with ASCII equivalents
-		replacements := map[string]string{
-			"á": "a", "à": "a", "ä": "a", "â": "a",
-			"é": "e", "è": "e", "ë": "e", "ê": "e",
-			"í": "i", "ì": "i", "ï": "i", "î": "i",
-			"ó": "o", "ò": "o", "ö": "o", "ô": "o",
-			"ú": "u", "ù": "u", "ü": "u", "û": "u",
-			"ñ": "n",
-			"Á": "A", "À": "A", "Ä": "A", "Â": "A",
-			"É": "E", "È": "E", "Ë": "E", "Ê": "E",
-			"Í": "I", "Ì": "I", "Ï": "I", "Î": "I",
-			"Ó": "O", "Ò": "O", "Ö": "O", "Ô": "O",
-			"Ú": "U", "Ù": "U", "Ü": "U", "Û": "U",
-			"Ñ": "N",
-			// Special characters
-			"°": "o", // Degree symbol to lowercase 'o'
-			"º": "o", // Masculine ordinal indicator
-			"ª": "a", // Feminine ordinal indicator
-		}
-
-		result := text
-		for accented, ascii := range replacements {
-			result = strings.ReplaceAll(result, accented, ascii)
-		}
-		return result
-	}
-
-	// Company Header Section
-	pdf.SetFont("Arial", "B", 10)
-	// Get page width for centering
-	pageWidth, _ := pdf.GetPageSize()
-	// Calculate cell width (full width minus margins)
-	cellWidth := pageWidth - 6 // 3mm margin on each side
-	pdf.CellFormat(pageWidth, 5, encodeText(company.Name), "", 1, "C", false, 0, "")
-
-	pdf.SetFont("Arial", "", 8)
-	pdf.CellFormat(cellWidth, 4, fmt.Sprintf("RUT: %s", company.Code), "", 1, "C", false, 0, "")
-
-	pdf.CellFormat(0, 3, strings.Repeat("=", separatorWidth), "", 1, "C", false, 0, "")
-
-	// Document type and number
-	pdf.SetFont("Arial", "", 8)
-	docTypeName := getDocumentTypeName(invoice.DocumentType)
-	docTypeName = cases.Title(language.Spanish).String(strings.ToLower(docTypeName))
-	docTypeName = strings.Replace(docTypeName, "Electronico", "Electrónico", -1)
-	docTypeName = strings.Replace(docTypeName, "Electronica", "Electrónica", -1)
-	docTypeName = strings.Replace(docTypeName, "Sii", "SII", -1)
-	pdf.CellFormat(0, 4, fmt.Sprintf("%s N°: %d", docTypeName, invoice.Folio), "", 1, "L", false, 0, "")
-	pdf.CellFormat(0, 4, fmt.Sprintf("Fecha: %s", invoice.IssueDate.Format("02/01/2006")), "", 1, "L", false, 0, "")
-
-	// Commercial activities
-	if len(activities) > 0 {
-		for _, activity := range activities {
-			pdf.CellFormat(0, 4, activity.Description, "", 1, "L", false, 0, "")
-		}
-		pdf.Ln(2)
-	}
-
-	// Separator line
-	pdf.Line(0, pdf.GetY(), 200, pdf.GetY())
-	pdf.Ln(5)
-
-	pdf.SetFont("Arial", "", 8)
-
-	if company.Address != "" {
-		// Split long addresses
-		address := encodeText(company.Address)
-		if len(address) > 35 {
-			words := strings.Fields(address)
-			var line1, line2 string
-			for i, word := range words {
-				if len(line1+" "+word) <= 35 {
-					if line1 == "" {
-						line1 = word
-					} else {
-						line1 += " " + word
-					}
-				} else {
-					line2 = strings.Join(words[i:], " ")
-					break
-				}
-			}
-			pdf.CellFormat(cellWidth, 4, line1, "", 1, "C", false, 0, "")
-			if line2 != "" {
-				pdf.CellFormat(cellWidth, 4, line2, "", 1, "C", false, 0, "")
-			}
-		} else {
-			pdf.CellFormat(cellWidth, 4, address, "", 1, "C", false, 0, "")
-		}
+		return TemplateData{}, nil, fmt.Errorf("failed to get commercial activities for company %s: %w", company.ID, err)
 	}
 
-	pdf.Ln(2)
-
-	// Customer Information
-	if invoice.Receiver != nil {
-		pdf.SetFont("Arial", "", 8)
-		pdf.CellFormat(0, 4, "Cliente", "", 1, "L", false, 0, "")
-
-		pdf.SetFont("Arial", "", 7)
-		// Customer name with wrapping
-		customerName := encodeText(invoice.Receiver.Name)
-		if len(customerName) > 40 {
-			words := strings.Fields(customerName)
-			var line1, line2 string
-			for i, word := range words {
-				if len(line1+" "+word) <= 40 {
-					if line1 == "" {
-						line1 = word
-					} else {
-						line1 += " " + word
-					}
-				} else {
-					line2 = strings.Join(words[i:], " ")
-					break
-				}
-			}
-			pdf.CellFormat(0, 3, line1, "", 1, "L", false, 0, "")
-			if line2 != "" {
-				pdf.CellFormat(0, 3, line2, "", 1, "L", false, 0, "")
+	branding, err := s.templates.Branding(company.Code.String())
+	if err != nil {
+		return TemplateData{}, nil, err
+	}
+	logo, hasLogo := loadLogo(branding.Logo)
+
+	if s.settings != nil {
+		settings, err := s.settings.Get(ctx, company.ID)
+		switch {
+		case err == nil:
+			branding = branding.withSettings(settings)
+			if companyLogo, ok := s.companyLogo(ctx, settings); ok {
+				logo, hasLogo = companyLogo, true
 			}
-		} else {
-			pdf.CellFormat(0, 3, customerName, "", 1, "L", false, 0, "")
-		}
-
-		if invoice.Receiver.Code != "" {
-			pdf.CellFormat(0, 3, fmt.Sprintf("RUT: %s", invoice.Receiver.Code), "", 1, "L", false, 0, "")
+		case !errors.Is(err, ErrCompanySettingsNotFound):
+			return TemplateData{}, nil, fmt.Errorf("failed to get settings for company %s: %w", company.ID, err)
 		}
-
-		pdf.Ln(2)
-		pdf.CellFormat(0, 3, strings.Repeat("-", separatorWidth), "", 1, "C", false, 0, "")
-		pdf.Ln(2)
 	}
 
-	pdf.Line(0, pdf.GetY(), 200, pdf.GetY())
-	pdf.SetFont("Arial", "B", 7)
-	pdf.CellFormat(100, 3, "Artículo", "", 1, "L", false, 0, "")
-	pdf.CellFormat(100, 3, "Artículo", "", 1, "L", false, 0, "")
-	pdf.Ln(1)
-
-	// Items
-	pdf.SetFont("Arial", "", 7)
-	for _, item := range invoice.Details {
-		// Item description (truncate if too long)
-		desc := encodeText(item.Description)
-		if len(desc) > 38 {
-			desc = desc[:35] + "..."
-		}
-		pdf.CellFormat(0, 3, desc, "", 1, "L", false, 0, "")
-
-		// Quantity, unit price, and total on separate line
-		pdf.CellFormat(0, 3, fmt.Sprintf("%.0f x %s", item.Quantity, formatCurrency(item.UnitPrice)), "", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 3, formatCurrency(item.LineTotal), "", 1, "R", false, 0, "")
-		pdf.Ln(1)
+	data := newTemplateData(layout, *company, activities, invoice, branding)
+	data.HasLogo = hasLogo
+	if !hasLogo {
+		return data, nil, nil
 	}
+	return data, &logo, nil
+}
 
-	// Totals separator
-	pdf.Ln(1)
-	pdf.CellFormat(0, 3, strings.Repeat("-", separatorWidth), "", 1, "C", false, 0, "")
-	pdf.Ln(2)
-
-	// Totals Section
-	pdf.SetFont("Arial", "", 8)
-
-	// Add separator before totals
-	pdf.CellFormat(0, 3, strings.Repeat("-", separatorWidth), "", 1, "C", false, 0, "")
-	pdf.Ln(1)
-
-	if invoice.Totals.TaxableAmount > 0 {
-		pdf.CellFormat(0, 4, "Subtotal:", "", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 4, fmt.Sprintf("%s", formatCurrency(invoice.Totals.TaxableAmount)), "", 1, "R", false, 0, "")
+// createPDF renders the document with the company's template for layout,
+// with symbol drawn as its timbre.
+func (s *SimpleDocumentService) createPDF(ctx context.Context, invoice *domain.Invoice, symbol *utils.PDF417Symbol, layout PDFLayout) ([]byte, error) {
+	data, logo, err := s.templateData(ctx, invoice, layout)
+	if err != nil {
+		return nil, err
 	}
-
-	if invoice.Totals.TaxAmount > 0 {
-		pdf.CellFormat(0, 4, "IVA (19%):", "", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 4, fmt.Sprintf("%s", formatCurrency(invoice.Totals.TaxAmount)), "", 1, "R", false, 0, "")
+	tmpl, err := s.templates.Template(data.Company.Code.String(), layout)
+	if err != nil {
+		return nil, err
 	}
 
-	// Total amount (highlighted)
-	pdf.SetFont("Arial", "B", 9)
-	pdf.CellFormat(0, 5, "TOTAL:", "", 0, "L", false, 0, "")
-	pdf.CellFormat(0, 5, fmt.Sprintf("%s", formatCurrency(invoice.Totals.TotalAmount)), "", 1, "R", false, 0, "")
-
-	// Final separator
-	pdf.Ln(2)
-	pdf.SetFont("Arial", "", 8)
-	pdf.CellFormat(0, 3, strings.Repeat("=", separatorWidth), "", 1, "C", false, 0, "")
-	pdf.Ln(3)
-
-	// SII Electronic Stamp Section
-	pdf.SetFont("Arial", "B", 8)
-	pdf.CellFormat(0, 4, encodeText("TIMBRE ELECTRONICO SII"), "", 1, "C", false, 0, "")
-	pdf.SetFont("Arial", "", 7)
-	pdf.CellFormat(0, 3, "Res. 80 de 2014", "", 1, "C", false, 0, "")
-	pdf.CellFormat(0, 3, "Verifique en www.sii.cl", "", 1, "C", false, 0, "")
-
-	pdf.Ln(3)
+	images := map[string]markupImage{"timbre": timbreImage(symbol)}
+	if logo != nil {
+		images["logo"] = *logo
+	}
 
-	// Generate and embed PDF417 barcode
-	pdf417Image, _, err := utils.GenerateStampPDF417FromXMLWithAutoDimensions(string(stampXML))
+	markup, err := executeDocumentTemplate(tmpl, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate PDF417 for embedding: %w", err)
+		return nil, err
 	}
 
-	// Convert to 8-bit PNG for gofpdf compatibility
-	pdf417Data, err := convertTo8BitPNG(pdf417Image)
+	pdf, err := renderMarkup(markup, images)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert PDF417 to 8-bit PNG: %w", err)
+		return nil, fmt.Errorf("rendering template %s: %w", tmpl.Name(), err)
 	}
 
-	// Register the PDF417 image from memory
-	imageInfo := pdf.RegisterImageOptionsReader("pdf417", gofpdf.ImageOptions{
-		ImageType: "PNG",
-	}, bytes.NewReader(pdf417Data))
+	slog.Debug("Created PDF with embedded PDF417", "layout", layout, "template", tmpl.Name(), "size", len(pdf))
+	return pdf, nil
+}
 
-	if pdf.Error() != nil {
-		return nil, fmt.Errorf("failed to register PDF417 image: %w", pdf.Error())
+// loadLogo reads a PNG or JPEG logo. A missing or unsupported logo is logged
+// and left out rather than failing the document.
+func loadLogo(path string) (markupImage, bool) {
+	if path == "" {
+		return markupImage{}, false
 	}
 
-	// Calculate image dimensions for thermal printer - make it prominent
-	pageWidth, _ = pdf.GetPageSize()
-	margins := 6.0 // Margins for barcode
-	maxWidth := pageWidth - margins
-
-	// Make the barcode large and prominent
-	imgWidth := maxWidth * 0.9 // Use 90% of available width
-
-	// Calculate height maintaining aspect ratio
-	originalWidth := float64(imageInfo.Width())
-	originalHeight := float64(imageInfo.Height())
-	aspectRatio := originalHeight / originalWidth
-	imgHeight := imgWidth * aspectRatio
-
-	// Center the image horizontally
-	x := (pageWidth - imgWidth) / 2
-
-	// Add the PDF417 barcode image
-	pdf.ImageOptions("pdf417", x, pdf.GetY(), imgWidth, imgHeight, false, gofpdf.ImageOptions{}, 0, "")
-
-	// Move cursor below the image
-	pdf.SetY(pdf.GetY() + imgHeight + 3)
+	var imageType string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		imageType = "PNG"
+	case ".jpg", ".jpeg":
+		imageType = "JPG"
+	default:
+		slog.Warn("unsupported logo format, rendering without logo", "logo", path)
+		return markupImage{}, false
+	}
 
-	// Convert PDF to bytes
-	var buf strings.Builder
-	err = pdf.Output(&buf)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate PDF: %w", err)
+		slog.Warn("failed to read logo, rendering without logo", "logo", path, "error", err)
+		return markupImage{}, false
 	}
-
-	pdfBytes := []byte(buf.String())
-	slog.Debug("Created thermal receipt PDF with embedded PDF417", "size", len(pdfBytes))
-	return pdfBytes, nil
+	return markupImage{data: data, imageType: imageType}, true
 }
 
-// getDocumentTypeName returns the human-readable name for a document type
-func getDocumentTypeName(docType uint8) string {
-	switch docType {
-	case 33:
-		return "FACTURA ELECTRONICA"
-	case 34:
-		return "FACTURA NO AFECTA O EXENTA"
-	case 39:
-		return "BOLETA ELECTRONICA"
-	case 41:
-		return "BOLETA EXENTA ELECTRONICA"
-	case 43:
-		return "LIQUIDACION FACTURA"
-	case 46:
-		return "FACTURA DE COMPRA"
-	case 52:
-		return "GUIA DE DESPACHO"
-	case 56:
-		return "NOTA DE DEBITO"
-	case 61:
-		return "NOTA DE CREDITO"
-	case 110:
-		return "FACTURA DE EXPORTACION"
-	case 111:
-		return "NOTA DE DEBITO EXPORTACION"
-	case 112:
-		return "NOTA DE CREDITO EXPORTACION"
-	default:
-		return fmt.Sprintf("DOCUMENTO TIPO %d", docType)
+// companyLogo downloads the logo uploaded for the company. Like loadLogo,
+// failures are logged and the document is rendered without it.
+func (s *SimpleDocumentService) companyLogo(ctx context.Context, settings domain.CompanySettings) (markupImage, bool) {
+	if settings.LogoBlob == "" {
+		return markupImage{}, false
+	}
+	data, err := s.settings.Logo(ctx, settings)
+	if err != nil {
+		slog.Warn("failed to download company logo, rendering without logo", "companyID", settings.CompanyID, "error", err)
+		return markupImage{}, false
+	}
+	imageType := "PNG"
+	if settings.LogoContentType == "image/jpeg" {
+		imageType = "JPG"
 	}
+	return markupImage{data: data, imageType: imageType}, true
 }
 
-// convertTo8BitPNG converts an image to 8-bit PNG format for gofpdf compatibility
-func convertTo8BitPNG(img image.Image) ([]byte, error) {
-	bounds := img.Bounds()
-	paletted := image.NewPaletted(bounds, color.Palette{
-		color.RGBA{255, 255, 255, 255}, // White
-		color.RGBA{0, 0, 0, 255},       // Black
-	})
-
-	// Convert to paletted image (8-bit)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := img.At(x, y)
-			r, g, b, _ := originalColor.RGBA()
-
-			// Convert to grayscale and then to black/white
-			gray := (r + g + b) / 3
-			if gray > 32768 { // Threshold for white vs black
-				paletted.Set(x, y, color.RGBA{255, 255, 255, 255})
-			} else {
-				paletted.Set(x, y, color.RGBA{0, 0, 0, 255})
+// formatCLP formats an amount in pesos with dots as thousands separators.
+func formatCLP(amount domain.Amount) string {
+	formatted := amount.String()
+	negative := strings.HasPrefix(formatted, "-")
+	formatted = strings.TrimPrefix(formatted, "-")
+
+	if len(formatted) > 3 {
+		var result []rune
+		for i, digit := range []rune(formatted) {
+			if i > 0 && (len(formatted)-i)%3 == 0 {
+				result = append(result, '.')
 			}
+			result = append(result, digit)
 		}
+		formatted = string(result)
 	}
 
-	var buf bytes.Buffer
-	err := png.Encode(&buf, paletted)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode 8-bit PNG: %w", err)
+	if negative {
+		return "-$" + formatted
 	}
+	return "$" + formatted
+}
+
+// formatPrice formats a unit price like formatCLP, keeping its decimals after
+// a comma, as in "$39.107,9".
+func formatPrice(price domain.Decimal) string {
+	if price.IsInteger() {
+		return formatCLP(price.Round())
+	}
+	integer, fraction, _ := strings.Cut(price.String(), ".")
+	whole, _ := strconv.ParseInt(integer, 10, 64)
+	return formatCLP(domain.Amount(whole)) + "," + fraction
+}
 
-	return buf.Bytes(), nil
+// timbreImage draws the stamp's PDF417 symbol as vectors at its printed size.
+func timbreImage(symbol *utils.PDF417Symbol) markupImage {
+	width, _ := symbol.Size()
+	return markupImage{width: width, symbol: symbol}
 }
@@ -1,6 +1,7 @@
 package usecases
 
 import (
+	"bytes"
 	"context"
 	"factura-movil-gateway/internal/domain"
 	"strings"
@@ -29,11 +30,11 @@ func (m *mockCompanyService) FindByID(ctx context.Context, id string) (*domain.C
 	return nil, nil
 }
 
-func (m *mockCompanyService) FindByCode(ctx context.Context, code string) (*domain.Company, error) {
-	if company, exists := m.companies[code]; exists {
+func (m *mockCompanyService) FindByCode(ctx context.Context, code domain.RUT) (*domain.Company, error) {
+	if company, exists := m.companies[code.String()]; exists {
 		return company, nil
 	}
-	return nil, &CompanyNotFoundError{Code: code}
+	return nil, &CompanyNotFoundError{Code: code.String()}
 }
 
 func (m *mockCompanyService) GetCommercialActivities(ctx context.Context, companyID string) ([]domain.CommercialActivity, error) {
@@ -87,18 +88,18 @@ func TestDocumentService_ProcessInvoice_CompanyLookup(t *testing.T) {
 	// Setup mock services
 	testCompany := &domain.Company{
 		ID:   "test-id",
-		Code: "12345678-9",
+		Code: domain.MustParseRUT("12345678-5"),
 		Name: "Test Company",
 	}
 
 	companyService := &mockCompanyService{
 		companies: map[string]*domain.Company{
-			"12345678-9": testCompany,
+			"12345678-5": testCompany,
 		},
 	}
 
 	stampService := &mockStampService{}
-	documentService := NewDocumentService(stampService, companyService)
+	documentService := NewDocumentService(stampService, companyService, nil)
 
 	// Create test invoice
 	invoice := &domain.Invoice{
@@ -106,18 +107,18 @@ func TestDocumentService_ProcessInvoice_CompanyLookup(t *testing.T) {
 		Folio:        123,
 		IssueDate:    time.Now(),
 		Issuer: domain.Company{
-			Code: "12345678-9",
+			Code: domain.MustParseRUT("12345678-5"),
 			Name: "Test Company",
 		},
 		Receiver: &domain.Company{
-			Code: "87654321-0",
+			Code: domain.MustParseRUT("87654321-4"),
 			Name: "Test Receiver",
 		},
 		Details: []domain.InvoiceDetail{
 			{
 				Description: "Test Item",
-				Quantity:    1,
-				UnitPrice:   1000,
+				Quantity:    domain.NewDecimal(1),
+				UnitPrice:   domain.NewDecimal(1000),
 				LineTotal:   1000,
 			},
 		},
@@ -129,7 +130,7 @@ func TestDocumentService_ProcessInvoice_CompanyLookup(t *testing.T) {
 	}
 
 	// Test processing
-	result, err := documentService.ProcessInvoice(invoice)
+	result, err := documentService.ProcessInvoice(context.Background(), invoice)
 
 	// Verify results
 	if err != nil {
@@ -144,7 +145,7 @@ func TestDocumentService_ProcessInvoice_CompanyLookup(t *testing.T) {
 		t.Error("Expected PDF417 data to be generated")
 	}
 
-	if len(result.ThermalPDF) == 0 {
+	if len(result.PDF) == 0 {
 		t.Error("Expected thermal PDF to be generated")
 	}
 
@@ -160,7 +161,7 @@ func TestDocumentService_ProcessInvoice_CompanyNotFound(t *testing.T) {
 	}
 
 	stampService := &mockStampService{}
-	documentService := NewDocumentService(stampService, companyService)
+	documentService := NewDocumentService(stampService, companyService, nil)
 
 	// Create test invoice with non-existent company
 	invoice := &domain.Invoice{
@@ -168,18 +169,18 @@ func TestDocumentService_ProcessInvoice_CompanyNotFound(t *testing.T) {
 		Folio:        123,
 		IssueDate:    time.Now(),
 		Issuer: domain.Company{
-			Code: "99999999-9", // Non-existent company
+			Code: domain.MustParseRUT("99999999-9"), // Non-existent company
 			Name: "Non-existent Company",
 		},
 		Receiver: &domain.Company{
-			Code: "87654321-0",
+			Code: domain.MustParseRUT("87654321-4"),
 			Name: "Test Receiver",
 		},
 		Details: []domain.InvoiceDetail{
 			{
 				Description: "Test Item",
-				Quantity:    1,
-				UnitPrice:   1000,
+				Quantity:    domain.NewDecimal(1),
+				UnitPrice:   domain.NewDecimal(1000),
 				LineTotal:   1000,
 			},
 		},
@@ -191,7 +192,7 @@ func TestDocumentService_ProcessInvoice_CompanyNotFound(t *testing.T) {
 	}
 
 	// Test processing
-	result, err := documentService.ProcessInvoice(invoice)
+	result, err := documentService.ProcessInvoice(context.Background(), invoice)
 
 	// Verify error handling
 	if err == nil {
@@ -207,3 +208,64 @@ func TestDocumentService_ProcessInvoice_CompanyNotFound(t *testing.T) {
 		t.Errorf("Expected error message to contain company code, got: %v", err)
 	}
 }
+
+func TestDocumentService_RenderInvoice_LetterLayout(t *testing.T) {
+	companyService := &mockCompanyService{
+		companies: map[string]*domain.Company{
+			"12345678-5": {ID: "company-1", Code: domain.MustParseRUT("12345678-5"), Name: "Comercial Ñandú Ltda.", Address: "Av. Providencia 123, Providencia"},
+		},
+	}
+	policy := DefaultPDFLayoutPolicy()
+	policy.Companies = map[string]CompanyPDFLayouts{"12.345.678-5": {DocumentTypes: map[uint8]PDFLayout{33: PDFLayoutLetter}}}
+	documentService := NewDocumentService(&mockStampService{}, companyService, nil).WithPDFLayouts(policy)
+
+	details := make([]domain.InvoiceDetail, 60)
+	for i := range details {
+		details[i] = domain.InvoiceDetail{Description: "Servicio de mantención con una descripción lo bastante larga para ocupar dos líneas de la tabla", Quantity: domain.MustParseDecimal("1.5"), UnitPrice: domain.NewDecimal(1000), LineTotal: 1500}
+	}
+	invoice := &domain.Invoice{
+		DocumentType: 33,
+		Folio:        123,
+		IssueDate:    time.Now(),
+		Issuer:       domain.Company{Code: domain.MustParseRUT("12345678-5"), BusinessLine: "Servicios de ingeniería"},
+		Receiver:     &domain.Company{Code: domain.MustParseRUT("87654321-4"), Name: "Cliente", BusinessLine: "Comercio", Address: "Calle 1, Ñuñoa"},
+		Details:      details,
+		References:   []domain.InvoiceReference{{DocumentType: "801", Folio: "4500012", Date: "2024-04-01", Reason: "Orden de compra"}},
+		Totals:       domain.InvoiceTotals{TaxableAmount: 90000, TaxAmount: 17100, TotalAmount: 107100},
+	}
+
+	stampXML, err := documentService.StampInvoice(context.Background(), invoice, IdempotencyKey{})
+	if err != nil {
+		t.Fatalf("StampInvoice failed: %v", err)
+	}
+	result, err := documentService.RenderInvoice(context.Background(), invoice, stampXML)
+	if err != nil {
+		t.Fatalf("RenderInvoice failed: %v", err)
+	}
+	if result.PDFLayout != PDFLayoutLetter {
+		t.Errorf("Expected letter layout, got %s", result.PDFLayout)
+	}
+	if !strings.HasPrefix(string(result.PDF), "%PDF") {
+		t.Error("Expected a PDF document")
+	}
+
+	// The timbre is drawn as vectors, not embedded as an image.
+	if bytes.Contains(result.PDF, []byte("/Subtype /Image")) {
+		t.Error("Expected no images in a PDF without logo")
+	}
+
+	// Other document types of the company keep the default.
+	invoice.DocumentType = 39
+	result, err = documentService.RenderInvoice(context.Background(), invoice, stampXML)
+	if err != nil || result.PDFLayout != PDFLayoutThermal {
+		t.Errorf("Expected thermal layout for boletas, got %s (%v)", result.PDFLayout, err)
+	}
+	if result.PDF417SVG != nil {
+		t.Error("Expected no SVG unless configured")
+	}
+
+	result, err = documentService.WithPDF417SVG().RenderInvoice(context.Background(), invoice, stampXML)
+	if err != nil || !bytes.HasPrefix(result.PDF417SVG, []byte("<svg ")) {
+		t.Errorf("Expected the timbre as SVG, got %.20q (%v)", result.PDF417SVG, err)
+	}
+}
@@ -0,0 +1,389 @@
+package usecases
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+//go:embed templates/*.tmpl
+var _builtinTemplates embed.FS
+
+const _brandingFile = "branding.json"
+
+// Branding holds the per-company values templates use besides the document
+// itself.
+type Branding struct {
+	// Logo is a PNG or JPEG file, relative to the branding.json declaring it.
+	Logo         string     `json:"logo,omitempty"`
+	PrimaryColor string     `json:"primaryColor,omitempty"`
+	FooterText   string     `json:"footerText,omitempty"`
+	Phone        string     `json:"phone,omitempty"`
+	Email        string     `json:"email,omitempty"`
+	Website      string     `json:"website,omitempty"`
+	Resolution   Resolution `json:"resolution"`
+	// Sections turns optional sections off, for example
+	// {"references": false}. Sections not listed are shown.
+	Sections map[string]bool `json:"sections,omitempty"`
+}
+
+// Resolution is the SII resolution that authorises the issuer, printed under
+// the timbre.
+type Resolution struct {
+	Number int `json:"number"`
+	// Date is the year ("2014") or the full date ("2014-08-22").
+	Date string `json:"date"`
+}
+
+func (r Resolution) String() string {
+	if date, err := time.Parse("2006-01-02", r.Date); err == nil {
+		return fmt.Sprintf("Res. %d del %s", r.Number, date.Format("02-01-2006"))
+	}
+	return fmt.Sprintf("Res. %d de %s", r.Number, r.Date)
+}
+
+// DefaultBranding is used for companies without a branding.json.
+func DefaultBranding() Branding {
+	return Branding{
+		PrimaryColor: "#C8102E",
+		Resolution:   Resolution{Number: 80, Date: "2014"},
+	}
+}
+
+// Validate rejects colours that are not "#RRGGBB" and incomplete resolutions.
+func (b Branding) Validate() error {
+	if _, err := parseColor(b.PrimaryColor); err != nil {
+		return fmt.Errorf("primary colour: %w", err)
+	}
+	if b.Resolution.Number <= 0 || b.Resolution.Date == "" {
+		return fmt.Errorf("resolution number and date are required")
+	}
+	return nil
+}
+
+// withSettings overrides the branding with the settings the company
+// configured through the API. The logo is handled by the caller, as it lives
+// in blob storage.
+func (b Branding) withSettings(settings domain.CompanySettings) Branding {
+	if settings.Phone != "" {
+		b.Phone = settings.Phone
+	}
+	if settings.Email != "" {
+		b.Email = settings.Email
+	}
+	if settings.Website != "" {
+		b.Website = settings.Website
+	}
+	if settings.FooterText != "" {
+		b.FooterText = settings.FooterText
+	}
+	if settings.PrimaryColor != "" {
+		b.PrimaryColor = settings.PrimaryColor
+	}
+	if settings.ResolutionNumber > 0 {
+		b.Resolution = Resolution{Number: settings.ResolutionNumber, Date: settings.ResolutionDate.Format("2006-01-02")}
+	}
+	return b
+}
+
+// TemplateStore finds the template and branding of each company. Files are
+// read from a directory laid out as
+//
+//	{dir}/thermal.tmpl, letter.tmpl, a4.tmpl, branding.json   shared
+//	{dir}/{rut}/thermal.tmpl, …, branding.json, logo.png      per company
+//
+// and read again on every render, so a company is onboarded by adding its
+// directory. Layouts without a template fall back to the built-in ones; a4
+// also falls back to the letter template.
+type TemplateStore struct {
+	directory string
+}
+
+// NewTemplateStore returns a store with only the built-in templates.
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{}
+}
+
+// LoadTemplateStore returns a store reading from directory, after checking
+// every template and branding file in it.
+func LoadTemplateStore(directory string) (*TemplateStore, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading template directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("template directory %s is not a directory", directory)
+	}
+
+	store := &TemplateStore{directory: directory}
+	err = filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		switch {
+		case filepath.Ext(path) == ".tmpl":
+			_, err = parseDocumentTemplate(filepath.Base(path), os.ReadFile, path)
+		case entry.Name() == _brandingFile:
+			company, _ := filepath.Rel(directory, filepath.Dir(path))
+			if company == "." {
+				company = ""
+			}
+			_, err = store.Branding(company)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *TemplateStore) companyDirectory(companyCode string) string {
+	if s.directory == "" || companyCode == "" {
+		return ""
+	}
+	return filepath.Join(s.directory, layoutCompanyKey(companyCode))
+}
+
+// Branding returns the default branding overridden by the shared
+// branding.json and then by the company's.
+func (s *TemplateStore) Branding(companyCode string) (Branding, error) {
+	branding := DefaultBranding()
+	if s.directory == "" {
+		return branding, nil
+	}
+
+	for _, dir := range []string{s.directory, s.companyDirectory(companyCode)} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, _brandingFile)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Branding{}, fmt.Errorf("reading branding: %w", err)
+		}
+
+		logo := branding.Logo
+		branding.Logo = ""
+		if err := json.Unmarshal(data, &branding); err != nil {
+			return Branding{}, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		if branding.Logo == "" {
+			branding.Logo = logo
+		} else if !filepath.IsAbs(branding.Logo) {
+			branding.Logo = filepath.Join(dir, branding.Logo)
+		}
+	}
+
+	if err := branding.Validate(); err != nil {
+		return Branding{}, fmt.Errorf("invalid branding for %s: %w", companyCode, err)
+	}
+	return branding, nil
+}
+
+// Template returns the template rendering layout for the company.
+func (s *TemplateStore) Template(companyCode string, layout PDFLayout) (*template.Template, error) {
+	names := []string{string(layout) + ".tmpl"}
+	if layout == PDFLayoutA4 {
+		names = append(names, string(PDFLayoutLetter)+".tmpl")
+	}
+
+	for _, dir := range []string{s.companyDirectory(companyCode), s.directory} {
+		if dir == "" {
+			continue
+		}
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return parseDocumentTemplate(name, os.ReadFile, path)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if _, err := fs.Stat(_builtinTemplates, "templates/"+name); err == nil {
+			return parseDocumentTemplate(name, _builtinTemplates.ReadFile, "templates/"+name)
+		}
+	}
+	return nil, fmt.Errorf("no template for layout %s", layout)
+}
+
+func parseDocumentTemplate(name string, read func(string) ([]byte, error), path string) (*template.Template, error) {
+	data, err := read(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading template %s: %w", path, err)
+	}
+	tmpl, err := template.New(name).Funcs(_templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// _templateFuncs are the helpers available to document templates.
+var _templateFuncs = template.FuncMap{
+	"clp":      formatMoney,
+	"qty":      formatQuantity,
+	"rut":      formatRUT,
+	"date":     func(t time.Time) string { return t.Format("02/01/2006") },
+	"docName":  documentTypeName,
+	"docTitle": func(docType uint8) string { return strings.ToUpper(documentTypeName(docType)) },
+	"refType":  referenceTypeName,
+}
+
+// TemplateData is what document templates are executed with.
+type TemplateData struct {
+	// PageSize is the layout being rendered: thermal, letter or a4.
+	PageSize   PDFLayout
+	Company    domain.Company
+	Activities []domain.CommercialActivity
+	Invoice    *domain.Invoice
+	Branding   Branding
+	HasLogo    bool
+
+	// BusinessLine is the issuer's giro from the document, or its commercial
+	// activities when the document has none.
+	BusinessLine string
+	// Address is the issuer's address from the document, or the registered
+	// one when the document has none.
+	Address string
+	// Branch is the sucursal that issued the document, nil for the casa
+	// matriz. Its address is printed besides Address.
+	Branch *domain.Branch
+	// Exempt is the part of the total that is not subject to IVA.
+	Exempt domain.Amount
+	// HasItemCodes reports whether any line has an item code, so the code
+	// column is only printed when it holds something.
+	HasItemCodes bool
+}
+
+func newTemplateData(layout PDFLayout, company domain.Company, activities []domain.CommercialActivity, invoice *domain.Invoice, branding Branding) TemplateData {
+	data := TemplateData{
+		PageSize:     layout,
+		Company:      company,
+		Activities:   activities,
+		Invoice:      invoice,
+		Branding:     branding,
+		BusinessLine: invoice.Issuer.BusinessLine,
+		Address:      invoice.Issuer.Address,
+		Branch:       invoice.Branch,
+		Exempt:       invoice.Totals.TotalAmount - invoice.Totals.TaxableAmount - invoice.Totals.TaxAmount,
+		HasItemCodes: slices.ContainsFunc(invoice.Details, func(d domain.InvoiceDetail) bool { return d.Code != "" }),
+	}
+	if data.BusinessLine == "" {
+		descriptions := make([]string, 0, len(activities))
+		for _, activity := range activities {
+			descriptions = append(descriptions, activity.Description)
+		}
+		data.BusinessLine = strings.Join(descriptions, " / ")
+	}
+	if data.Address == "" {
+		data.Address = company.Address
+	}
+	return data
+}
+
+// Section reports whether the optional section name is shown.
+func (d TemplateData) Section(name string) bool {
+	shown, ok := d.Branding.Sections[name]
+	return !ok || shown
+}
+
+// executeDocumentTemplate returns the layout markup of a document.
+func executeDocumentTemplate(tmpl *template.Template, data TemplateData) ([]byte, error) {
+	var markup bytes.Buffer
+	if err := tmpl.Execute(&markup, data); err != nil {
+		return nil, fmt.Errorf("executing template %s: %w", tmpl.Name(), err)
+	}
+	return markup.Bytes(), nil
+}
+
+// documentTypeName returns the name of an SII document type.
+func documentTypeName(docType uint8) string {
+	switch docType {
+	case 33:
+		return "Factura Electrónica"
+	case 34:
+		return "Factura No Afecta o Exenta Electrónica"
+	case 39:
+		return "Boleta Electrónica"
+	case 41:
+		return "Boleta Exenta Electrónica"
+	case 43:
+		return "Liquidación Factura Electrónica"
+	case 46:
+		return "Factura de Compra Electrónica"
+	case 52:
+		return "Guía de Despacho Electrónica"
+	case 56:
+		return "Nota de Débito Electrónica"
+	case 61:
+		return "Nota de Crédito Electrónica"
+	case 110:
+		return "Factura de Exportación Electrónica"
+	case 111:
+		return "Nota de Débito de Exportación Electrónica"
+	case 112:
+		return "Nota de Crédito de Exportación Electrónica"
+	default:
+		return fmt.Sprintf("Documento Tipo %d", docType)
+	}
+}
+
+// referenceTypeName names the TpoDocRef of a reference, which is either a
+// numeric SII document type or a code such as "801" (orden de compra).
+func referenceTypeName(docType string) string {
+	switch docType {
+	case "801":
+		return "Orden de Compra"
+	case "802":
+		return "Nota de Pedido"
+	case "803":
+		return "Contrato"
+	case "HES":
+		return "Hoja de Entrada de Servicio"
+	}
+	var td uint8
+	if _, err := fmt.Sscanf(docType, "%d", &td); err == nil {
+		return documentTypeName(td)
+	}
+	return "Documento " + docType
+}
+
+// formatMoney is the clp template function: amounts print as whole pesos and
+// unit prices keep their decimals.
+func formatMoney(value any) (string, error) {
+	switch v := value.(type) {
+	case domain.Amount:
+		return formatCLP(v), nil
+	case domain.Decimal:
+		return formatPrice(v), nil
+	}
+	return "", fmt.Errorf("clp: expected an amount or a price, got %T", value)
+}
+
+// formatRUT prints a RUT with thousands separators, as in "76.212.889-6",
+// and the zero RUT as "".
+func formatRUT(rut domain.RUT) string {
+	return rut.Format()
+}
+
+// formatQuantity prints whole quantities without decimals and the rest with
+// a decimal comma, as in "1,5".
+func formatQuantity(quantity domain.Decimal) string {
+	return strings.Replace(quantity.String(), ".", ",", 1)
+}
@@ -0,0 +1,135 @@
+package usecases
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+func writeTemplateFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestTemplateStore_CompanyOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFile(t, filepath.Join(dir, "branding.json"), `{"footerText": "Gracias por su compra"}`)
+	writeTemplateFile(t, filepath.Join(dir, "76212889-6", "branding.json"),
+		`{"logo": "logo.png", "primaryColor": "#003366", "resolution": {"number": 99, "date": "2020-03-01"}, "sections": {"references": false}}`)
+	writeTemplateFile(t, filepath.Join(dir, "76212889-6", "thermal.tmpl"),
+		`<document size="thermal"><text color="{{.Branding.PrimaryColor}}">{{.Company.Name}} {{.Branding.Resolution}}</text></document>`)
+
+	store, err := LoadTemplateStore(dir)
+	if err != nil {
+		t.Fatalf("LoadTemplateStore failed: %v", err)
+	}
+
+	branding, err := store.Branding("76.212.889-6")
+	if err != nil {
+		t.Fatalf("Branding failed: %v", err)
+	}
+	if branding.Logo != filepath.Join(dir, "76212889-6", "logo.png") || branding.FooterText != "Gracias por su compra" {
+		t.Errorf("Expected company branding over the shared one, got %+v", branding)
+	}
+
+	tmpl, err := store.Template("76212889-6", PDFLayoutThermal)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	company := domain.Company{Code: domain.MustParseRUT("76212889-6"), Name: "Pérez & Cía."}
+	invoice := &domain.Invoice{DocumentType: 33, IssueDate: time.Now(), Issuer: company}
+	markup, err := executeDocumentTemplate(tmpl, newTemplateData(PDFLayoutThermal, company, nil, invoice, branding))
+	if err != nil {
+		t.Fatalf("executeDocumentTemplate failed: %v", err)
+	}
+	if want := `<text color="#003366">Pérez &amp; Cía. Res. 99 del 01-03-2020</text>`; !strings.Contains(string(markup), want) {
+		t.Errorf("Expected %s in %s", want, markup)
+	}
+	if _, err := renderMarkup(markup, nil); err != nil {
+		t.Errorf("renderMarkup failed: %v", err)
+	}
+
+	// Other companies get the built-in template, a4 falls back to letter,
+	// and disabled sections are left out.
+	invoice.References = []domain.InvoiceReference{{DocumentType: "801", Folio: "4500012"}}
+	tmpl, err = store.Template("11111111-1", PDFLayoutA4)
+	if err != nil || tmpl.Name() != "letter.tmpl" {
+		t.Fatalf("Expected built-in letter template, got %v (%v)", tmpl, err)
+	}
+	data := newTemplateData(PDFLayoutA4, company, nil, invoice, branding)
+	markup, err = executeDocumentTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("executeDocumentTemplate failed: %v", err)
+	}
+	if !strings.Contains(string(markup), `size="a4"`) || strings.Contains(string(markup), "Referencias") {
+		t.Errorf("Unexpected markup: %s", markup)
+	}
+}
+
+func TestLoadTemplateStore_RejectsInvalidFiles(t *testing.T) {
+	invalid := map[string]string{
+		"thermal.tmpl":       `<document>{{.Company.Name</document>`,
+		"branding.json":      `{"primaryColor": "red"}`,
+		"1-9/branding.json":  `{"resolution": {"number": 0}}`,
+		"1-9/letter.tmpl":    `{{if .HasLogo}}`,
+		"1-9/branding.json ": `{`,
+	}
+	for name, data := range invalid {
+		dir := t.TempDir()
+		writeTemplateFile(t, filepath.Join(dir, strings.TrimSpace(name)), data)
+		if _, err := LoadTemplateStore(dir); err == nil {
+			t.Errorf("%s: expected %q to be rejected", name, data)
+		}
+	}
+}
+
+func TestRenderMarkup_Errors(t *testing.T) {
+	invalid := []string{
+		`<page></page>`,
+		`<document size="legal"></document>`,
+		`<document><table/></document>`,
+		`<document><text color="red">x</text></document>`,
+		`<document><row><text>x</text></row></document>`,
+		`<document><columns><column width="abc"/></columns></document>`,
+	}
+	for _, markup := range invalid {
+		if _, err := renderMarkup([]byte(markup), nil); err == nil {
+			t.Errorf("Expected %s to be rejected", markup)
+		}
+	}
+}
+
+func TestTemplateFormatting(t *testing.T) {
+	money := map[any]string{
+		domain.Amount(41884):                "$41.884",
+		domain.Amount(-1500):                "-$1.500",
+		domain.NewDecimal(3500):             "$3.500",
+		domain.MustParseDecimal("39107.9"):  "$39.107,9",
+		domain.MustParseDecimal("0.123456"): "$0,123456",
+	}
+	for value, want := range money {
+		got, err := formatMoney(value)
+		if err != nil || got != want {
+			t.Errorf("clp %v: expected %q, got %q (%v)", value, want, got, err)
+		}
+	}
+	if _, err := formatMoney(1.5); err == nil {
+		t.Error("Expected clp to reject floats")
+	}
+
+	if got := formatQuantity(domain.MustParseDecimal("0.90")); got != "0,9" {
+		t.Errorf("Expected quantity 0,9, got %q", got)
+	}
+	if got := formatQuantity(domain.NewDecimal(2)); got != "2" {
+		t.Errorf("Expected quantity 2, got %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// TransientError marks a failure that may succeed if the operation is retried
+// later, such as a dropped database connection.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// NewTransientError wraps err as a TransientError. A nil err stays nil.
+func NewTransientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+// IsTransient reports whether err, or any error it wraps, is worth retrying.
+func IsTransient(err error) bool {
+	var transientErr *TransientError
+	if errors.As(err, &transientErr) {
+		return true
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Document processing stages reported by StageError.
+const (
+	StageClaim  = "claim"
+	StageParse  = "parse"
+	StageStamp  = "stamp"
+	StagePDF417 = "pdf417"
+	StagePDF    = "pdf"
+	StageSave   = "save"
+)
+
+// StageError records the processing stage in which a document failed.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// NewStageError wraps err as a StageError for stage. A nil err stays nil.
+func NewStageError(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// FailedStage returns the processing stage recorded in err, or "unknown"
+// when err carries no stage.
+func FailedStage(err error) string {
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return stageErr.Stage
+	}
+	return "unknown"
+}
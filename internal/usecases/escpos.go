@@ -0,0 +1,378 @@
+package usecases
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"factura-movil-gateway/internal/domain"
+	"factura-movil-gateway/internal/utils"
+)
+
+// ReceiptOptions configures the ESC/POS receipts sent straight to
+// Epson-compatible thermal printers.
+type ReceiptOptions struct {
+	// PaperWidth is the roll width in millimetres, 58 or 80.
+	PaperWidth int `json:"paperWidth"`
+	// RasterPDF417 prints the timbre as a bitmap (GS v 0) for printers
+	// without the native PDF417 commands (GS ( k).
+	RasterPDF417 bool `json:"rasterPdf417"`
+}
+
+// DefaultReceiptOptions prints on 80mm paper with native PDF417.
+func DefaultReceiptOptions() ReceiptOptions {
+	return ReceiptOptions{PaperWidth: 80}
+}
+
+// Validate rejects paper widths the printers we support do not take.
+func (o ReceiptOptions) Validate() error {
+	if o.PaperWidth != 58 && o.PaperWidth != 80 {
+		return fmt.Errorf("receipt paper width must be 58 or 80, got %d", o.PaperWidth)
+	}
+	return nil
+}
+
+// columns is the number of font A characters per line.
+func (o ReceiptOptions) columns() int {
+	if o.PaperWidth == 58 {
+		return 32
+	}
+	return 48
+}
+
+// dots is the printable width at 203 dpi.
+func (o ReceiptOptions) dots() int {
+	if o.PaperWidth == 58 {
+		return 384
+	}
+	return 576
+}
+
+const (
+	_escposModuleWidth  = 2 // dots, 0.25 mm at 203 dpi
+	_escposCodePage1252 = 16
+)
+
+// renderReceipt prints the same content as the thermal template as ESC/POS
+// commands. Text is sent in code page 1252, like the PDF fonts.
+func renderReceipt(data TemplateData, stampXML []byte, options ReceiptOptions) ([]byte, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	p := &escposWriter{columns: options.columns()}
+	invoice := data.Invoice
+
+	p.init()
+	p.align(escposCenter)
+	p.style(true, true)
+	p.text(data.Company.Name)
+	p.style(false, false)
+	p.text("RUT: " + formatRUT(data.Company.Code))
+	p.text(data.Address)
+	if branch := data.Branch; branch != nil {
+		p.text(branchLine(branch))
+	}
+	if data.Branding.Phone != "" {
+		p.text("Tel: " + data.Branding.Phone)
+	}
+	p.text(data.Branding.Email)
+	p.text(data.Branding.Website)
+	p.rule('=')
+
+	p.align(escposLeft)
+	p.style(true, false)
+	p.text(fmt.Sprintf("%s N° %d", documentTypeName(invoice.DocumentType), invoice.Folio))
+	p.style(false, false)
+	p.text("Fecha: " + invoice.IssueDate.Format("02/01/2006"))
+	if data.Section("activities") {
+		for _, activity := range data.Activities {
+			p.text(activity.Description)
+		}
+	}
+
+	if receiver := invoice.Receiver; receiver != nil && data.Section("receiver") {
+		p.rule('-')
+		p.text("Cliente")
+		p.text(receiver.Name)
+		if !receiver.Code.IsZero() {
+			p.text("RUT: " + formatRUT(receiver.Code))
+		}
+	}
+
+	p.rule('-')
+	p.style(true, false)
+	p.pair("Artículo", "Total")
+	p.style(false, false)
+	for _, detail := range invoice.Details {
+		description := detail.Description
+		if detail.Code != "" {
+			description = detail.Code + " " + description
+		}
+		if detail.Exempt {
+			description += " (Exento)"
+		}
+		quantity := formatQuantity(detail.Quantity)
+		if detail.Unit != "" {
+			quantity += " " + detail.Unit
+		}
+		p.text(description)
+		p.pair(fmt.Sprintf("%s x %s", quantity, formatPrice(detail.UnitPrice)), formatCLP(detail.LineTotal))
+	}
+
+	if len(invoice.References) > 0 && data.Section("references") {
+		p.rule('-')
+		p.style(true, false)
+		p.text("Referencias")
+		p.style(false, false)
+		for _, reference := range invoice.References {
+			line := fmt.Sprintf("%s N° %s", referenceTypeName(reference.DocumentType), reference.Folio)
+			if reference.Date != "" {
+				line += " del " + reference.Date
+			}
+			if reference.Reason != "" {
+				line += ": " + reference.Reason
+			}
+			p.text(line)
+		}
+	}
+
+	p.rule('-')
+	if invoice.Totals.TaxableAmount > 0 {
+		p.pair("Neto:", formatCLP(invoice.Totals.TaxableAmount))
+	}
+	if data.Exempt > 0 {
+		p.pair("Exento:", formatCLP(data.Exempt))
+	}
+	if invoice.Totals.TaxAmount > 0 {
+		p.pair("IVA (19%):", formatCLP(invoice.Totals.TaxAmount))
+	}
+	p.style(true, false)
+	p.pair("TOTAL:", formatCLP(invoice.Totals.TotalAmount))
+	p.style(false, false)
+	p.rule('=')
+
+	p.feed(1)
+	p.align(escposCenter)
+	if options.RasterPDF417 {
+		if err := p.rasterPDF417(stampXML, options.dots()); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := p.pdf417(stampXML); err != nil {
+			return nil, err
+		}
+	}
+	p.style(true, false)
+	p.text("Timbre Electrónico SII")
+	p.style(false, false)
+	p.text(data.Branding.Resolution.String())
+	p.text("Verifique documento: www.sii.cl")
+	if data.Branding.FooterText != "" {
+		p.feed(1)
+		p.text(data.Branding.FooterText)
+	}
+	p.cut()
+
+	return p.buf.Bytes(), nil
+}
+
+const (
+	escposLeft   byte = 0
+	escposCenter byte = 1
+)
+
+// branchLine is the sucursal line printed under the casa matriz address, the
+// same as in the templates.
+func branchLine(branch *domain.Branch) string {
+	if branch.Name == "" {
+		return "Sucursal: " + branch.FullAddress()
+	}
+	return "Sucursal " + branch.Name + ": " + branch.FullAddress()
+}
+
+// escposWriter accumulates ESC/POS commands for a printer with columns font
+// A characters per line.
+type escposWriter struct {
+	buf     bytes.Buffer
+	columns int
+	double  bool
+}
+
+// init resets the printer and selects code page 1252 (ESC @, ESC t).
+func (p *escposWriter) init() {
+	p.buf.Write([]byte{0x1B, '@', 0x1B, 't', _escposCodePage1252})
+}
+
+// align sets the justification of the following lines (ESC a).
+func (p *escposWriter) align(alignment byte) {
+	p.buf.Write([]byte{0x1B, 'a', alignment})
+}
+
+// style turns emphasis (ESC E) and double width and height (GS !) on or off.
+func (p *escposWriter) style(bold, double bool) {
+	var emphasis, size byte
+	if bold {
+		emphasis = 1
+	}
+	if double {
+		size = 0x11
+	}
+	p.double = double
+	p.buf.Write([]byte{0x1B, 'E', emphasis, 0x1D, '!', size})
+}
+
+// width is the number of characters that fit in a line with the current
+// character size.
+func (p *escposWriter) width() int {
+	if p.double {
+		return p.columns / 2
+	}
+	return p.columns
+}
+
+// text prints s word wrapped to the line width; empty text prints nothing.
+func (p *escposWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	for _, line := range wrapReceiptText(pdfText(s), p.width()) {
+		p.buf.WriteString(line)
+		p.buf.WriteByte('\n')
+	}
+}
+
+// pair prints left and right on the edges of a line, wrapping left when both
+// do not fit.
+func (p *escposWriter) pair(left, right string) {
+	left, right = pdfText(left), pdfText(right)
+	width := p.width()
+	lines := wrapReceiptText(left, width-len(right)-1)
+	for _, line := range lines[:len(lines)-1] {
+		p.buf.WriteString(line)
+		p.buf.WriteByte('\n')
+	}
+	last := lines[len(lines)-1]
+	p.buf.WriteString(last)
+	p.buf.WriteString(strings.Repeat(" ", max(width-len(last)-len(right), 1)))
+	p.buf.WriteString(right)
+	p.buf.WriteByte('\n')
+}
+
+func (p *escposWriter) rule(char byte) {
+	p.buf.WriteString(strings.Repeat(string(char), p.width()))
+	p.buf.WriteByte('\n')
+}
+
+// feed prints and feeds n lines (ESC d).
+func (p *escposWriter) feed(lines byte) {
+	p.buf.Write([]byte{0x1B, 'd', lines})
+}
+
+// cut feeds the paper past the cutter and cuts it partially (GS V 66).
+func (p *escposWriter) cut() {
+	p.buf.Write([]byte{0x1D, 'V', 66, 3})
+}
+
+// pdf417 prints data with the printer's own PDF417 encoder (GS ( k, cn 48)
+// using the columns, error correction level and row to module ratio of the
+// SII spec. The printer derives the rows from the length of the data, which
+// is sent in ISO-8859-1 like the PNG and PDF timbres.
+func (p *escposWriter) pdf417(ted []byte) error {
+	data, err := utils.TEDLatin1(ted)
+	if err != nil {
+		return err
+	}
+	spec := utils.SIIPDF417Spec()
+	rowHeight := byte(math.Round(spec.RowHeight / spec.ModuleWidth))
+	p.pdf417Command(65, byte(spec.Columns))                // columns
+	p.pdf417Command(66, 0)                                 // rows: automatic
+	p.pdf417Command(67, _escposModuleWidth)                // module width
+	p.pdf417Command(68, rowHeight)                         // row height
+	p.pdf417Command(69, '0', '0'+byte(spec.SecurityLevel)) // error correction level
+	p.pdf417Command(70, 0)                                 // standard PDF417
+
+	store := len(data) + 3
+	p.buf.Write([]byte{0x1D, '(', 'k', byte(store), byte(store >> 8), '0', 80, '0'})
+	p.buf.Write(data)
+	p.pdf417Command(81, '0') // print the stored symbol
+	p.buf.WriteByte('\n')
+	return nil
+}
+
+func (p *escposWriter) pdf417Command(function byte, params ...byte) {
+	length := len(params) + 2
+	p.buf.Write([]byte{0x1D, '(', 'k', byte(length), byte(length >> 8), '0', function})
+	p.buf.Write(params)
+}
+
+// rasterPDF417 encodes the TED to the SII spec and prints it as a bitmap
+// (GS v 0) at two dots per module. Symbols wider than the paper, as on 58mm
+// rolls, are printed rotated 90 degrees; scanners read them in any
+// orientation.
+func (p *escposWriter) rasterPDF417(ted []byte, dots int) error {
+	symbol, err := utils.EncodeTEDPDF417(ted)
+	if err != nil {
+		return fmt.Errorf("failed to encode PDF417: %w", err)
+	}
+	img := symbol.Image(_escposModuleWidth)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if width <= dots {
+		p.raster(width, height, img.At)
+	} else {
+		if height > dots {
+			return fmt.Errorf("PDF417 of %d by %d dots does not fit %d dots of paper", width, height, dots)
+		}
+		p.raster(height, width, func(x, y int) color.Color {
+			return img.At(y, height-1-x)
+		})
+	}
+	p.buf.WriteByte('\n')
+	return nil
+}
+
+// raster prints a width by height monochrome bitmap; at returns the colour of
+// each dot.
+func (p *escposWriter) raster(width, height int, at func(x, y int) color.Color) {
+	rowBytes := (width + 7) / 8
+	p.buf.Write([]byte{0x1D, 'v', '0', 0, byte(rowBytes), byte(rowBytes >> 8), byte(height), byte(height >> 8)})
+	for y := 0; y < height; y++ {
+		row := make([]byte, rowBytes)
+		for x := 0; x < width; x++ {
+			if gray := color.GrayModel.Convert(at(x, y)).(color.Gray); gray.Y < 128 {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		p.buf.Write(row)
+	}
+}
+
+// wrapReceiptText splits cp1252 text into lines of at most width characters,
+// breaking between words where possible. It always returns at least one line.
+func wrapReceiptText(s string, width int) []string {
+	width = max(width, 1)
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	return append(lines, line)
+}
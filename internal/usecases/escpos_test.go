@@ -0,0 +1,145 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestWrapReceiptText(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 10, []string{""}},
+		{"Pan amasado (docena)", 10, []string{"Pan", "amasado", "(docena)"}},
+		{"Café en grano", 32, []string{"Café en grano"}},
+		{"abcdefghijkl xy", 5, []string{"abcde", "fghij", "kl xy"}},
+	}
+	for _, tt := range tests {
+		got := wrapReceiptText(tt.text, tt.width)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrapReceiptText(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentService_RenderReceipt(t *testing.T) {
+	companyService := &mockCompanyService{
+		companies: map[string]*domain.Company{
+			"76212889-6": {ID: "company-1", Code: domain.MustParseRUT("76212889-6"), Name: "Panadería La Española Ltda.", Address: "Av. Vicuña Mackenna 4860, Ñuñoa"},
+		},
+	}
+	invoice := &domain.Invoice{
+		DocumentType: 39,
+		Folio:        2404,
+		IssueDate:    time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+		Issuer:       domain.Company{Code: domain.MustParseRUT("76212889-6")},
+		Receiver:     &domain.Company{Code: domain.MustParseRUT("66666666-6"), Name: "Cliente"},
+		Details: []domain.InvoiceDetail{
+			{Description: "Pan amasado (docena)", Quantity: domain.NewDecimal(2), UnitPrice: domain.NewDecimal(3500), LineTotal: 7000},
+			{Description: "Café en grano 1º calidad, origen Perú", Quantity: domain.MustParseDecimal("1.5"), UnitPrice: domain.NewDecimal(12000), LineTotal: 18000},
+		},
+		Totals: domain.InvoiceTotals{TaxableAmount: 21008, TaxAmount: 3992, TotalAmount: 25000},
+	}
+	documentService := NewDocumentService(&mockStampService{}, companyService, nil).WithReceipts(DefaultReceiptOptions())
+	ctx := context.Background()
+	stampXML, err := documentService.StampInvoice(ctx, invoice, IdempotencyKey{})
+	if err != nil {
+		t.Fatalf("StampInvoice failed: %v", err)
+	}
+
+	result, err := documentService.RenderInvoice(ctx, invoice, stampXML)
+	if err != nil {
+		t.Fatalf("RenderInvoice failed: %v", err)
+	}
+	if result.Receipt == nil {
+		t.Fatal("Expected a receipt for a thermal document")
+	}
+
+	for _, options := range []ReceiptOptions{{PaperWidth: 80}, {PaperWidth: 58}, {PaperWidth: 80, RasterPDF417: true}, {PaperWidth: 58, RasterPDF417: true}} {
+		receipt, err := documentService.RenderReceipt(ctx, invoice, stampXML, options)
+		if err != nil {
+			t.Fatalf("RenderReceipt(%+v) failed: %v", options, err)
+		}
+
+		if !bytes.HasPrefix(receipt, []byte{0x1B, '@', 0x1B, 't', 16}) {
+			t.Errorf("%+v: expected the receipt to reset the printer and select code page 1252", options)
+		}
+		if !bytes.HasSuffix(receipt, []byte{0x1D, 'V', 66, 3}) {
+			t.Errorf("%+v: expected the receipt to end with a cut", options)
+		}
+		address, _ := charmap.Windows1252.NewEncoder().String("Ñuñoa")
+		if !bytes.Contains(receipt, []byte(address)) {
+			t.Errorf("%+v: expected the address encoded in cp1252", options)
+		}
+		rule := strings.Repeat("=", options.columns()) + "\n"
+		if !bytes.Contains(receipt, []byte("\n"+rule)) || bytes.Contains(receipt, []byte(rule+"=")) {
+			t.Errorf("%+v: expected rules %d characters wide", options, options.columns())
+		}
+
+		if options.RasterPDF417 {
+			start := bytes.Index(receipt, []byte{0x1D, 'v', '0', 0})
+			if start < 0 {
+				t.Fatalf("%+v: expected a raster image", options)
+			}
+			widthBytes := int(binary.LittleEndian.Uint16(receipt[start+4:]))
+			if widthBytes == 0 || widthBytes*8 > options.dots() {
+				t.Errorf("%+v: raster of %d bytes per row does not fit the paper", options, widthBytes)
+			}
+			continue
+		}
+
+		store := append([]byte{0x1D, '(', 'k'}, byte(len(stampXML)+3), byte((len(stampXML)+3)>>8), '0', 80, '0')
+		if !bytes.Contains(receipt, append(store, stampXML...)) {
+			t.Errorf("%+v: expected the TED stored with GS ( k", options)
+		}
+		if !bytes.Contains(receipt, []byte{0x1D, '(', 'k', 3, 0, '0', 81, '0'}) {
+			t.Errorf("%+v: expected the PDF417 print command", options)
+		}
+	}
+
+	if _, err := documentService.RenderReceipt(ctx, invoice, stampXML, ReceiptOptions{PaperWidth: 76}); err == nil {
+		t.Error("Expected an error for an unsupported paper width")
+	}
+}
+
+func TestRenderReceipt_RasterRotatesWideSymbols(t *testing.T) {
+	example, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+	start, end := bytes.Index(example, []byte("<TED")), bytes.Index(example, []byte("</TED>"))
+	stampXML := example[start : end+len("</TED>")]
+
+	invoice := &domain.Invoice{DocumentType: 33, Folio: 2404}
+	data := newTemplateData(PDFLayoutThermal, domain.Company{Code: domain.MustParseRUT("76212889-6")}, nil, invoice, DefaultBranding())
+	for _, paperWidth := range []int{58, 80} {
+		options := ReceiptOptions{PaperWidth: paperWidth, RasterPDF417: true}
+		receipt, err := renderReceipt(data, stampXML, options)
+		if err != nil {
+			t.Fatalf("renderReceipt(%+v) failed: %v", options, err)
+		}
+		raster := bytes.Index(receipt, []byte{0x1D, 'v', '0', 0})
+		width := int(binary.LittleEndian.Uint16(receipt[raster+4:])) * 8
+		height := int(binary.LittleEndian.Uint16(receipt[raster+6:]))
+		if width > options.dots() {
+			t.Errorf("%dmm: raster %d dots wide does not fit the paper", paperWidth, width)
+		}
+		// At two dots per module a full TED fits 80mm paper upright but has
+		// to be rotated on 58mm rolls.
+		if rotated := height > width; rotated != (paperWidth == 58) {
+			t.Errorf("%dmm: unexpected orientation of a %dx%d raster", paperWidth, width, height)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultFolioLease is how long a terminal may stamp reserved folios
+	// when the request does not say.
+	DefaultFolioLease = 24 * time.Hour
+	// MaxFolioLease bounds leases; folios of a lost terminal stay out of
+	// circulation until the lease ends.
+	MaxFolioLease = 7 * 24 * time.Hour
+	// MaxFolioReservation is the largest block a terminal can reserve.
+	MaxFolioReservation = 1000
+)
+
+var (
+	ErrFolioReservationNotFound = errors.New("folio reservation not found")
+	ErrFolioReservationClosed   = errors.New("folio reservation was already reconciled")
+	ErrInvalidFolioReservation  = errors.New("invalid folio reservation")
+)
+
+// FolioReservationRepository persists folio reservations.
+type FolioReservationRepository interface {
+	Create(ctx context.Context, reservation domain.FolioReservation) error
+	// Find returns ErrFolioReservationNotFound when the company has no
+	// reservation with that id.
+	Find(ctx context.Context, companyID, id string) (*domain.FolioReservation, error)
+	// Update saves reservation if its stored status is still fromStatus, or
+	// returns ErrFolioReservationClosed when another request changed it
+	// first.
+	Update(ctx context.Context, reservation domain.FolioReservation, fromStatus string) error
+	// FindExpired returns the active reservations whose lease ended
+	// before now.
+	FindExpired(ctx context.Context, now time.Time) ([]domain.FolioReservation, error)
+}
+
+// FolioReservationService leases blocks of folios to offline terminals.
+// Reserved folios count as used until the terminal reconciles; then the
+// ones it did not stamp are released for sequential stamping.
+type FolioReservationService interface {
+	// Reserve leases up to count folios and returns the CAF the terminal
+	// needs to stamp them. A lease of zero means DefaultFolioLease.
+	Reserve(ctx context.Context, companyID, terminalID string, documentType uint, count int64, lease time.Duration) (domain.FolioReservation, domain.CAF, error)
+	Find(ctx context.Context, companyID, id string) (domain.FolioReservation, error)
+	// Reconcile records the folios the terminal stamped and releases the
+	// rest. On an expired reservation the folios were already released and
+	// those stamped again since are reported as conflicts.
+	Reconcile(ctx context.Context, companyID, id string, usedFolios []int64) (domain.FolioReservation, error)
+	// ExpireLeases releases the folios of the reservations whose lease
+	// ended before now and returns how many it expired.
+	ExpireLeases(ctx context.Context, now time.Time) (int, error)
+}
+
+func NewFolioReservationService(cafService CAFService, repository FolioReservationRepository) *SimpleFolioReservationService {
+	return &SimpleFolioReservationService{
+		cafService: cafService,
+		repository: repository,
+	}
+}
+
+type SimpleFolioReservationService struct {
+	cafService CAFService
+	repository FolioReservationRepository
+}
+
+func (s *SimpleFolioReservationService) Reserve(ctx context.Context, companyID, terminalID string, documentType uint, count int64, lease time.Duration) (domain.FolioReservation, domain.CAF, error) {
+	if lease == 0 {
+		lease = DefaultFolioLease
+	}
+	switch {
+	case terminalID == "":
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("%w: terminal id is required", ErrInvalidFolioReservation)
+	case documentType == 0:
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("%w: document type is required", ErrInvalidFolioReservation)
+	case count < 1 || count > MaxFolioReservation:
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidFolioReservation, MaxFolioReservation)
+	case lease < 0 || lease > MaxFolioLease:
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("%w: lease must be at most %s", ErrInvalidFolioReservation, MaxFolioLease)
+	}
+
+	from, to, caf, err := s.cafService.ReserveFolios(ctx, companyID, documentType, count)
+	if err != nil {
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("reserving folios: %w", err)
+	}
+
+	now := time.Now()
+	reservation := domain.FolioReservation{
+		ID:           uuid.NewString(),
+		CompanyID:    companyID,
+		TerminalID:   terminalID,
+		DocumentType: documentType,
+		CAFID:        caf.ID,
+		FromFolio:    from,
+		ToFolio:      to,
+		Status:       domain.FolioReservationActive,
+		ExpiresAt:    now.Add(lease),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.repository.Create(ctx, reservation); err != nil {
+		if releaseErr := s.cafService.ReleaseFolios(ctx, companyID, documentType, reservation.Folios()); releaseErr != nil {
+			slog.Error("failed to release folios of an unsaved reservation", "company", companyID, "from", from, "to", to, "error", releaseErr)
+		}
+		return domain.FolioReservation{}, domain.CAF{}, fmt.Errorf("saving folio reservation: %w", err)
+	}
+
+	slog.Info("folios reserved", "company", companyID, "terminal", terminalID, "documentType", documentType, "from", from, "to", to)
+	return reservation, caf, nil
+}
+
+func (s *SimpleFolioReservationService) Find(ctx context.Context, companyID, id string) (domain.FolioReservation, error) {
+	reservation, err := s.repository.Find(ctx, companyID, id)
+	if err != nil {
+		if errors.Is(err, ErrFolioReservationNotFound) {
+			return domain.FolioReservation{}, err
+		}
+		return domain.FolioReservation{}, fmt.Errorf("finding folio reservation: %w", err)
+	}
+	return *reservation, nil
+}
+
+// Reconcile moves the reservation out of its current status only if nothing
+// else did first. An active reservation that expires meanwhile is reconciled
+// as expired.
+func (s *SimpleFolioReservationService) Reconcile(ctx context.Context, companyID, id string, usedFolios []int64) (domain.FolioReservation, error) {
+	for {
+		reservation, err := s.Find(ctx, companyID, id)
+		if err != nil {
+			return domain.FolioReservation{}, err
+		}
+		if reservation.Status == domain.FolioReservationReconciled {
+			return domain.FolioReservation{}, ErrFolioReservationClosed
+		}
+
+		used := slices.Clone(usedFolios)
+		slices.Sort(used)
+		for i, folio := range used {
+			if !reservation.Contains(folio) {
+				return domain.FolioReservation{}, fmt.Errorf("%w: folio %d is not in %d-%d", ErrInvalidFolioReservation, folio, reservation.FromFolio, reservation.ToFolio)
+			}
+			if i > 0 && used[i-1] == folio {
+				return domain.FolioReservation{}, fmt.Errorf("%w: folio %d is reported twice", ErrInvalidFolioReservation, folio)
+			}
+		}
+
+		if reservation.Status == domain.FolioReservationExpired {
+			reservation, err = s.reconcileExpired(ctx, reservation, used)
+		} else {
+			reservation, err = s.reconcileActive(ctx, reservation, used)
+			if errors.Is(err, ErrFolioReservationClosed) {
+				continue
+			}
+		}
+		if err != nil {
+			return domain.FolioReservation{}, err
+		}
+
+		slog.Info("folio reservation reconciled", "company", companyID, "reservation", id, "used", len(used), "returned", len(reservation.ReturnedFolios), "conflicts", len(reservation.ConflictFolios))
+		return reservation, nil
+	}
+}
+
+// reconcileActive closes the reservation and then releases the folios of the
+// block the terminal did not report; the reported ones stay marked as used
+// since the reservation. Closing first keeps ExpireLeases from releasing the
+// reported folios too.
+func (s *SimpleFolioReservationService) reconcileActive(ctx context.Context, reservation domain.FolioReservation, used []int64) (domain.FolioReservation, error) {
+	var returned []int64
+	for _, folio := range reservation.Folios() {
+		if _, found := slices.BinarySearch(used, folio); !found {
+			returned = append(returned, folio)
+		}
+	}
+
+	reservation.UsedFolios = used
+	reservation.ReturnedFolios = returned
+	if err := s.close(ctx, reservation, domain.FolioReservationActive); err != nil {
+		return domain.FolioReservation{}, err
+	}
+	reservation.Status = domain.FolioReservationReconciled
+
+	if err := s.cafService.ReleaseFolios(ctx, reservation.CompanyID, reservation.DocumentType, returned); err != nil {
+		return domain.FolioReservation{}, err
+	}
+	return reservation, nil
+}
+
+// reconcileExpired takes back the reported folios that were released at
+// expiry and not stamped since; the others are conflicts. Each folio is taken
+// only once, so a concurrent reconciliation cannot stamp it again.
+func (s *SimpleFolioReservationService) reconcileExpired(ctx context.Context, reservation domain.FolioReservation, used []int64) (domain.FolioReservation, error) {
+	var conflicts []int64
+	for _, folio := range used {
+		_, err := s.cafService.UseAssignedFolio(ctx, reservation.CompanyID, reservation.DocumentType, folio)
+		if errors.Is(err, ErrFolioAlreadyUsed) {
+			slog.Warn("folio reported after its lease expired was stamped again", "company", reservation.CompanyID, "reservation", reservation.ID, "folio", folio)
+			conflicts = append(conflicts, folio)
+			continue
+		}
+		if err != nil {
+			return domain.FolioReservation{}, fmt.Errorf("recording folio %d: %w", folio, err)
+		}
+	}
+
+	reservation.UsedFolios = used
+	reservation.ReturnedFolios = slices.DeleteFunc(reservation.ReturnedFolios, func(folio int64) bool {
+		_, found := slices.BinarySearch(used, folio)
+		return found
+	})
+	reservation.ConflictFolios = conflicts
+	if err := s.close(ctx, reservation, domain.FolioReservationExpired); err != nil {
+		return domain.FolioReservation{}, err
+	}
+	reservation.Status = domain.FolioReservationReconciled
+	return reservation, nil
+}
+
+// close marks the reservation reconciled if it is still in fromStatus.
+func (s *SimpleFolioReservationService) close(ctx context.Context, reservation domain.FolioReservation, fromStatus string) error {
+	reservation.Status = domain.FolioReservationReconciled
+	reservation.UpdatedAt = time.Now()
+	if err := s.repository.Update(ctx, reservation, fromStatus); err != nil {
+		if errors.Is(err, ErrFolioReservationClosed) {
+			return err
+		}
+		return fmt.Errorf("updating folio reservation: %w", err)
+	}
+	return nil
+}
+
+func (s *SimpleFolioReservationService) ExpireLeases(ctx context.Context, now time.Time) (int, error) {
+	reservations, err := s.repository.FindExpired(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("finding expired folio reservations: %w", err)
+	}
+
+	expired := 0
+	for _, reservation := range reservations {
+		// Expire before releasing, so a reconciliation that wins the race
+		// keeps the folios the terminal reported.
+		folios := reservation.Folios()
+		reservation.ReturnedFolios = folios
+		reservation.Status = domain.FolioReservationExpired
+		reservation.UpdatedAt = now
+		err := s.repository.Update(ctx, reservation, domain.FolioReservationActive)
+		if errors.Is(err, ErrFolioReservationClosed) {
+			slog.Info("folio reservation reconciled before its lease was expired", "company", reservation.CompanyID, "reservation", reservation.ID)
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("updating folio reservation: %w", err)
+		}
+
+		if err := s.cafService.ReleaseFolios(ctx, reservation.CompanyID, reservation.DocumentType, folios); err != nil {
+			return expired, err
+		}
+		expired++
+		slog.Warn("folio reservation expired before reconciliation", "company", reservation.CompanyID, "reservation", reservation.ID, "terminal", reservation.TerminalID, "from", reservation.FromFolio, "to", reservation.ToFolio)
+	}
+
+	return expired, nil
+}
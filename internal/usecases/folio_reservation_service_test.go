@@ -0,0 +1,235 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryFolioReservationRepository struct {
+	reservations map[string]domain.FolioReservation
+}
+
+func (m *memoryFolioReservationRepository) Create(ctx context.Context, reservation domain.FolioReservation) error {
+	m.reservations[reservation.ID] = reservation
+	return nil
+}
+
+func (m *memoryFolioReservationRepository) Find(ctx context.Context, companyID, id string) (*domain.FolioReservation, error) {
+	reservation, ok := m.reservations[id]
+	if !ok || reservation.CompanyID != companyID {
+		return nil, ErrFolioReservationNotFound
+	}
+	return &reservation, nil
+}
+
+func (m *memoryFolioReservationRepository) Update(ctx context.Context, reservation domain.FolioReservation, fromStatus string) error {
+	if m.reservations[reservation.ID].Status != fromStatus {
+		return ErrFolioReservationClosed
+	}
+	m.reservations[reservation.ID] = reservation
+	return nil
+}
+
+// staleFolioReservationRepository finds the expired reservations as they were
+// before a concurrent reconciliation.
+type staleFolioReservationRepository struct {
+	*memoryFolioReservationRepository
+	expired []domain.FolioReservation
+}
+
+func (r *staleFolioReservationRepository) FindExpired(ctx context.Context, now time.Time) ([]domain.FolioReservation, error) {
+	return r.expired, nil
+}
+
+func (m *memoryFolioReservationRepository) FindExpired(ctx context.Context, now time.Time) ([]domain.FolioReservation, error) {
+	var expired []domain.FolioReservation
+	for _, reservation := range m.reservations {
+		if reservation.Status == domain.FolioReservationActive && reservation.ExpiresAt.Before(now) {
+			expired = append(expired, reservation)
+		}
+	}
+	return expired, nil
+}
+
+func newTestFolioReservationService() (*SimpleFolioReservationService, *SimpleCAFService, *memoryCAFRepository) {
+	cafService, cafRepository := newTestCAFService()
+	repository := &memoryFolioReservationRepository{reservations: make(map[string]domain.FolioReservation)}
+	return NewFolioReservationService(cafService, repository), cafService, cafRepository
+}
+
+// useFolios stamps count folios sequentially and returns them.
+func useFolios(t *testing.T, cafService *SimpleCAFService, count int) []int64 {
+	t.Helper()
+	var folios []int64
+	for i := 0; i < count; i++ {
+		folio, _, err := cafService.UseCAFFolio(context.Background(), "company-1", "", 33)
+		if err != nil {
+			t.Fatalf("UseCAFFolio failed: %v", err)
+		}
+		folios = append(folios, folio)
+	}
+	return folios
+}
+
+func TestFolioReservationService_Reserve(t *testing.T) {
+	service, cafService, cafRepository := newTestFolioReservationService()
+	ctx := context.Background()
+
+	if _, err := cafService.UseAssignedFolio(ctx, "company-1", 33, 12); err != nil {
+		t.Fatalf("UseAssignedFolio failed: %v", err)
+	}
+
+	reservation, caf, err := service.Reserve(ctx, "company-1", "pos-1", 33, 5, 0)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if reservation.FromFolio != 10 || reservation.ToFolio != 11 {
+		t.Errorf("Expected the block to stop before assigned folio 12, got %d-%d", reservation.FromFolio, reservation.ToFolio)
+	}
+	if caf.ID != "caf-1" || reservation.CAFID != "caf-1" {
+		t.Errorf("Expected the CAF of the block, got %s", caf.ID)
+	}
+	if time.Until(reservation.ExpiresAt) < DefaultFolioLease-time.Minute {
+		t.Errorf("Expected the default lease, expires at %s", reservation.ExpiresAt)
+	}
+
+	reservation, _, err = service.Reserve(ctx, "company-1", "pos-2", 33, 5, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if reservation.FromFolio != 13 || reservation.ToFolio != 14 {
+		t.Errorf("Expected the block to start after assigned folio 12, got %d-%d", reservation.FromFolio, reservation.ToFolio)
+	}
+	if cafRepository.cafs[0].IsOpen() {
+		t.Error("Expected the CAF to be closed once every folio is reserved")
+	}
+	if _, err := cafService.UseAssignedFolio(ctx, "company-1", 33, 13); !errors.Is(err, ErrFolioAlreadyUsed) {
+		t.Errorf("Expected a reserved folio to count as used, got %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		terminalID   string
+		documentType uint
+		count        int64
+		lease        time.Duration
+	}{
+		{"no terminal", "", 33, 5, 0},
+		{"no document type", "pos-1", 0, 5, 0},
+		{"empty block", "pos-1", 33, 0, 0},
+		{"block too large", "pos-1", 33, MaxFolioReservation + 1, 0},
+		{"lease too long", "pos-1", 33, 5, MaxFolioLease + time.Hour},
+	}
+	for _, tt := range tests {
+		if _, _, err := service.Reserve(ctx, "company-1", tt.terminalID, tt.documentType, tt.count, tt.lease); !errors.Is(err, ErrInvalidFolioReservation) {
+			t.Errorf("%s: expected ErrInvalidFolioReservation, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestFolioReservationService_Reconcile(t *testing.T) {
+	service, cafService, _ := newTestFolioReservationService()
+	ctx := context.Background()
+
+	reservation, _, err := service.Reserve(ctx, "company-1", "pos-1", 33, 5, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+
+	if _, err := service.Reconcile(ctx, "company-1", reservation.ID, []int64{11, 20}); !errors.Is(err, ErrInvalidFolioReservation) {
+		t.Errorf("Expected a folio outside the block to be rejected, got %v", err)
+	}
+	if _, err := service.Reconcile(ctx, "company-1", reservation.ID, []int64{11, 11}); !errors.Is(err, ErrInvalidFolioReservation) {
+		t.Errorf("Expected a folio reported twice to be rejected, got %v", err)
+	}
+	if _, err := service.Reconcile(ctx, "company-2", reservation.ID, nil); !errors.Is(err, ErrFolioReservationNotFound) {
+		t.Errorf("Expected another company's reservation to be hidden, got %v", err)
+	}
+
+	reservation, err = service.Reconcile(ctx, "company-1", reservation.ID, []int64{13, 11})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if reservation.Status != domain.FolioReservationReconciled {
+		t.Errorf("Expected status %s, got %s", domain.FolioReservationReconciled, reservation.Status)
+	}
+	if fmt.Sprint(reservation.UsedFolios) != "[11 13]" || fmt.Sprint(reservation.ReturnedFolios) != "[10 12 14]" {
+		t.Errorf("Expected used [11 13] and returned [10 12 14], got %v and %v", reservation.UsedFolios, reservation.ReturnedFolios)
+	}
+
+	if folios := useFolios(t, cafService, 3); fmt.Sprint(folios) != "[10 12 14]" {
+		t.Errorf("Expected the returned folios to be stamped first, got %v", folios)
+	}
+	if _, _, err := cafService.UseCAFFolio(ctx, "company-1", "", 33); err == nil {
+		t.Error("Expected the CAF to be exhausted")
+	}
+
+	if _, err := service.Reconcile(ctx, "company-1", reservation.ID, nil); !errors.Is(err, ErrFolioReservationClosed) {
+		t.Errorf("Expected a second reconciliation to fail, got %v", err)
+	}
+}
+
+func TestFolioReservationService_ExpireLeases(t *testing.T) {
+	service, cafService, _ := newTestFolioReservationService()
+	ctx := context.Background()
+
+	reservation, _, err := service.Reserve(ctx, "company-1", "pos-1", 33, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+
+	if expired, err := service.ExpireLeases(ctx, time.Now()); err != nil || expired != 0 {
+		t.Fatalf("Expected no expired lease yet, got %d, %v", expired, err)
+	}
+	expired, err := service.ExpireLeases(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected one expired lease, got %d, %v", expired, err)
+	}
+
+	// Folio 10 goes to another document before the terminal reports back.
+	if folios := useFolios(t, cafService, 1); fmt.Sprint(folios) != "[10]" {
+		t.Fatalf("Expected the expired block to be stamped first, got %v", folios)
+	}
+
+	reservation, err = service.Reconcile(ctx, "company-1", reservation.ID, []int64{10, 11})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if fmt.Sprint(reservation.ConflictFolios) != "[10]" || fmt.Sprint(reservation.ReturnedFolios) != "[12]" {
+		t.Errorf("Expected conflicts [10] and returned [12], got %v and %v", reservation.ConflictFolios, reservation.ReturnedFolios)
+	}
+
+	if folios := useFolios(t, cafService, 2); fmt.Sprint(folios) != "[12 13]" {
+		t.Errorf("Expected folio 11 reported late to be skipped, got %v", folios)
+	}
+}
+
+func TestFolioReservationService_ExpireLeases_AfterReconcile(t *testing.T) {
+	service, cafService, _ := newTestFolioReservationService()
+	ctx := context.Background()
+
+	reservation, _, err := service.Reserve(ctx, "company-1", "pos-1", 33, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if _, err := service.Reconcile(ctx, "company-1", reservation.ID, []int64{10, 11}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	memory := service.repository.(*memoryFolioReservationRepository)
+	expiring := NewFolioReservationService(cafService, &staleFolioReservationRepository{memory, []domain.FolioReservation{reservation}})
+	if expired, err := expiring.ExpireLeases(ctx, time.Now().Add(2*time.Hour)); err != nil || expired != 0 {
+		t.Fatalf("Expected the reconciled reservation to be left alone, got %d, %v", expired, err)
+	}
+	if status := memory.reservations[reservation.ID].Status; status != domain.FolioReservationReconciled {
+		t.Errorf("Expected status %s, got %s", domain.FolioReservationReconciled, status)
+	}
+	if folios := useFolios(t, cafService, 3); fmt.Sprint(folios) != "[12 13 14]" {
+		t.Errorf("Expected the reported folios to stay used, got %v", folios)
+	}
+}
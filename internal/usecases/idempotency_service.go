@@ -0,0 +1,173 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+var (
+	// ErrIdempotencyRecordNotFound is returned by IdempotencyRepository.Find
+	// when the key was never used.
+	ErrIdempotencyRecordNotFound = errors.New("idempotency record not found")
+	// ErrIdempotencyKeyExists is returned by IdempotencyRepository.Create when
+	// another request already holds the key.
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+	// ErrIdempotencyKeyReused means the key was first used for a different
+	// request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyInProgress means a request with the same key has not
+	// finished yet. It is returned as a TransientError: once that request
+	// finishes or its lease ends, a retry succeeds.
+	ErrIdempotencyInProgress = errors.New("request with the same idempotency key is in progress")
+
+	// errIdempotencyLeaseEnded means the key is pending but its lease ended.
+	errIdempotencyLeaseEnded = errors.New("idempotency key lease ended")
+)
+
+// DefaultIdempotencyLease is how long a pending key is held for the request
+// stamping it, well over the time a stamp takes.
+const DefaultIdempotencyLease = 2 * time.Minute
+
+// IdempotencyKey identifies a stamping request. Key scopes the request and
+// RequestHash fingerprints its content, so reusing a key for a different
+// document is detected.
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+}
+
+// IdempotencyRepository persists idempotency records.
+type IdempotencyRepository interface {
+	Find(ctx context.Context, key string) (*domain.IdempotencyRecord, error)
+	Create(ctx context.Context, record domain.IdempotencyRecord) error
+	Update(ctx context.Context, record domain.IdempotencyRecord) error
+	Delete(ctx context.Context, key string) error
+	// Reclaim replaces the pending record of record.Key with record if its
+	// lease ended before now. It returns ErrIdempotencyInProgress when the
+	// record is no longer pending or was reclaimed first by another request.
+	Reclaim(ctx context.Context, record domain.IdempotencyRecord, now time.Time) error
+}
+
+// IdempotencyService generates a stamp at most once per idempotency key.
+type IdempotencyService interface {
+	// GenerateOnce returns the stamp of key, generating it only the first
+	// time. replayed is true when the stamp comes from an earlier request.
+	GenerateOnce(ctx context.Context, key IdempotencyKey, company domain.Company, invoice domain.Invoice) (stamp domain.Stamp, replayed bool, err error)
+}
+
+func NewIdempotencyService(stampService StampService, repository IdempotencyRepository) *SimpleIdempotencyService {
+	return &SimpleIdempotencyService{
+		stampService: stampService,
+		repository:   repository,
+		lease:        DefaultIdempotencyLease,
+		now:          time.Now,
+	}
+}
+
+type SimpleIdempotencyService struct {
+	stampService StampService
+	repository   IdempotencyRepository
+	lease        time.Duration
+	now          func() time.Time
+}
+
+// WithPendingLease sets how long a pending key is held before a retry may
+// take it over.
+func (s *SimpleIdempotencyService) WithPendingLease(lease time.Duration) *SimpleIdempotencyService {
+	s.lease = lease
+	return s
+}
+
+func (s *SimpleIdempotencyService) GenerateOnce(ctx context.Context, key IdempotencyKey, company domain.Company, invoice domain.Invoice) (domain.Stamp, bool, error) {
+	now := s.now()
+	record := domain.IdempotencyRecord{
+		Key:         key.Key,
+		RequestHash: key.RequestHash,
+		CompanyID:   company.ID,
+		Status:      domain.IdempotencyPending,
+		ExpiresAt:   now.Add(s.lease),
+	}
+
+	stamp, found, err := s.replay(ctx, key, now)
+	switch {
+	case errors.Is(err, errIdempotencyLeaseEnded):
+		if err := s.reclaim(ctx, record, now); err != nil {
+			return domain.Stamp{}, false, err
+		}
+	case err != nil || found:
+		return stamp, found, err
+	default:
+		if err := s.repository.Create(ctx, record); err != nil {
+			if errors.Is(err, ErrIdempotencyKeyExists) {
+				// Another request took the key since the lookup.
+				stamp, found, err := s.replay(ctx, key, now)
+				if err == nil && !found || errors.Is(err, errIdempotencyLeaseEnded) {
+					err = NewTransientError(ErrIdempotencyInProgress)
+				}
+				return stamp, found, err
+			}
+			return domain.Stamp{}, false, fmt.Errorf("reserving idempotency key: %w", err)
+		}
+	}
+
+	stamp, err = s.stampService.Generate(ctx, company, invoice)
+	if err != nil {
+		if err := s.repository.Delete(ctx, key.Key); err != nil {
+			slog.Error("failed to release idempotency key", "key", key.Key, "error", err)
+		}
+		return domain.Stamp{}, false, err
+	}
+
+	record.Status = domain.IdempotencyCompleted
+	record.Stamp = stamp
+	if err := s.repository.Update(ctx, record); err != nil {
+		// The folio is consumed either way, so the stamp is still returned.
+		// The key stays pending until its lease ends; a retry after that
+		// takes a new folio and this one has to be annulled.
+		slog.Error("failed to record stamp of idempotency key", "key", key.Key, "folio", stamp.DD.F, "expiresAt", record.ExpiresAt, "error", err)
+	}
+
+	return stamp, false, nil
+}
+
+// reclaim takes over a pending key whose request died or could not record
+// its stamp.
+func (s *SimpleIdempotencyService) reclaim(ctx context.Context, record domain.IdempotencyRecord, now time.Time) error {
+	if err := s.repository.Reclaim(ctx, record, now); err != nil {
+		if errors.Is(err, ErrIdempotencyInProgress) {
+			return NewTransientError(ErrIdempotencyInProgress)
+		}
+		return fmt.Errorf("reclaiming idempotency key: %w", err)
+	}
+	slog.Warn("reclaimed idempotency key whose lease ended, a folio of the earlier request may be left unused", "key", record.Key, "companyID", record.CompanyID)
+	return nil
+}
+
+// replay returns the stamp recorded for key, if the request already completed.
+// A pending key fails with ErrIdempotencyInProgress while its lease lasts and
+// with errIdempotencyLeaseEnded after.
+func (s *SimpleIdempotencyService) replay(ctx context.Context, key IdempotencyKey, now time.Time) (domain.Stamp, bool, error) {
+	record, err := s.repository.Find(ctx, key.Key)
+	if errors.Is(err, ErrIdempotencyRecordNotFound) {
+		return domain.Stamp{}, false, nil
+	}
+	if err != nil {
+		return domain.Stamp{}, false, fmt.Errorf("finding idempotency record: %w", err)
+	}
+
+	if record.RequestHash != key.RequestHash {
+		return domain.Stamp{}, false, ErrIdempotencyKeyReused
+	}
+	if record.Status != domain.IdempotencyCompleted {
+		if record.ExpiresAt.Before(now) {
+			return domain.Stamp{}, false, errIdempotencyLeaseEnded
+		}
+		return domain.Stamp{}, false, NewTransientError(ErrIdempotencyInProgress)
+	}
+	return record.Stamp, true, nil
+}
@@ -0,0 +1,156 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryIdempotencyRepository struct {
+	records   map[string]domain.IdempotencyRecord
+	updateErr error
+}
+
+func newMemoryIdempotencyRepository() *memoryIdempotencyRepository {
+	return &memoryIdempotencyRepository{records: make(map[string]domain.IdempotencyRecord)}
+}
+
+func (m *memoryIdempotencyRepository) Find(ctx context.Context, key string) (*domain.IdempotencyRecord, error) {
+	record, ok := m.records[key]
+	if !ok {
+		return nil, ErrIdempotencyRecordNotFound
+	}
+	return &record, nil
+}
+
+func (m *memoryIdempotencyRepository) Create(ctx context.Context, record domain.IdempotencyRecord) error {
+	if _, ok := m.records[record.Key]; ok {
+		return ErrIdempotencyKeyExists
+	}
+	m.records[record.Key] = record
+	return nil
+}
+
+func (m *memoryIdempotencyRepository) Update(ctx context.Context, record domain.IdempotencyRecord) error {
+	if m.updateErr != nil {
+		return m.updateErr
+	}
+	m.records[record.Key] = record
+	return nil
+}
+
+func (m *memoryIdempotencyRepository) Reclaim(ctx context.Context, record domain.IdempotencyRecord, now time.Time) error {
+	stored, ok := m.records[record.Key]
+	if !ok || stored.Status != domain.IdempotencyPending || !stored.ExpiresAt.Before(now) {
+		return ErrIdempotencyInProgress
+	}
+	m.records[record.Key] = record
+	return nil
+}
+
+func (m *memoryIdempotencyRepository) Delete(ctx context.Context, key string) error {
+	delete(m.records, key)
+	return nil
+}
+
+type countingStampService struct {
+	folio int64
+	err   error
+}
+
+func (c *countingStampService) Generate(ctx context.Context, company domain.Company, invoice domain.Invoice) (domain.Stamp, error) {
+	if c.err != nil {
+		return domain.Stamp{}, c.err
+	}
+	c.folio++
+	return domain.Stamp{DD: domain.DD{F: c.folio}}, nil
+}
+
+func TestIdempotencyService_ReplaysCompletedKey(t *testing.T) {
+	stamps := &countingStampService{}
+	service := NewIdempotencyService(stamps, newMemoryIdempotencyRepository())
+	key := IdempotencyKey{Key: "http:company-1:abc", RequestHash: "hash-1"}
+	company := domain.Company{ID: "company-1"}
+
+	first, replayed, err := service.GenerateOnce(context.Background(), key, company, domain.Invoice{})
+	if err != nil || replayed {
+		t.Fatalf("Expected a new stamp, got replayed=%v err=%v", replayed, err)
+	}
+
+	second, replayed, err := service.GenerateOnce(context.Background(), key, company, domain.Invoice{})
+	if err != nil || !replayed {
+		t.Fatalf("Expected the stamp to be replayed, got replayed=%v err=%v", replayed, err)
+	}
+	if second.DD.F != first.DD.F || stamps.folio != 1 {
+		t.Errorf("Expected folio %d to be reused, got %d after %d folios", first.DD.F, second.DD.F, stamps.folio)
+	}
+
+	_, _, err = service.GenerateOnce(context.Background(), IdempotencyKey{Key: key.Key, RequestHash: "hash-2"}, company, domain.Invoice{})
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused, got %v", err)
+	}
+}
+
+func TestIdempotencyService_PendingAndFailedKeys(t *testing.T) {
+	repository := newMemoryIdempotencyRepository()
+	stamps := &countingStampService{err: errors.New("no CAF available")}
+	service := NewIdempotencyService(stamps, repository)
+	key := IdempotencyKey{Key: "file:76212889-6:id:F2404T33", RequestHash: "hash-1"}
+
+	if _, _, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{}); err == nil {
+		t.Fatal("Expected the stamp error to be returned")
+	}
+	if _, err := repository.Find(context.Background(), key.Key); !errors.Is(err, ErrIdempotencyRecordNotFound) {
+		t.Errorf("Expected a failed request to release its key, got %v", err)
+	}
+
+	repository.records[key.Key] = domain.IdempotencyRecord{Key: key.Key, RequestHash: key.RequestHash, Status: domain.IdempotencyPending, ExpiresAt: time.Now().Add(time.Minute)}
+	stamps.err = nil
+	_, _, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{})
+	if !errors.Is(err, ErrIdempotencyInProgress) || !IsTransient(err) {
+		t.Errorf("Expected a transient ErrIdempotencyInProgress, got %v", err)
+	}
+	if stamps.folio != 0 {
+		t.Errorf("Expected no folio to be consumed, got %d", stamps.folio)
+	}
+}
+
+func TestIdempotencyService_ReclaimsExpiredLease(t *testing.T) {
+	repository := newMemoryIdempotencyRepository()
+	stamps := &countingStampService{}
+	service := NewIdempotencyService(stamps, repository).WithPendingLease(time.Minute)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	key := IdempotencyKey{Key: "file:76212889-6:id:F2404T33", RequestHash: "hash-1"}
+
+	// The stamp is generated but recording it fails, as if the process died
+	// between the folio request and the update.
+	repository.updateErr = errors.New("connection reset")
+	first, _, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{})
+	if err != nil {
+		t.Fatalf("Expected the stamp to be returned, got %v", err)
+	}
+	repository.updateErr = nil
+
+	now = now.Add(30 * time.Second)
+	if _, _, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{}); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Fatalf("Expected ErrIdempotencyInProgress within the lease, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	second, replayed, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{})
+	if err != nil || replayed {
+		t.Fatalf("Expected the key to be reclaimed, got replayed=%v err=%v", replayed, err)
+	}
+	if second.DD.F == first.DD.F {
+		t.Errorf("Expected a new folio after reclaiming, got %d again", second.DD.F)
+	}
+
+	third, replayed, err := service.GenerateOnce(context.Background(), key, domain.Company{}, domain.Invoice{})
+	if err != nil || !replayed || third.DD.F != second.DD.F {
+		t.Errorf("Expected folio %d to be replayed, got %d replayed=%v err=%v", second.DD.F, third.DD.F, replayed, err)
+	}
+}
@@ -0,0 +1,652 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"factura-movil-gateway/internal/utils"
+
+	"github.com/jung-kurt/gofpdf/v2"
+)
+
+// Document templates produce a small XML layout markup that is rendered with
+// gofpdf. Blocks are stacked top to bottom:
+//
+//	<document size="thermal|letter|a4" margin="3" font="Arial" fontsize="8">
+//	  <text align="C" bold="true">…</text>        wrapped paragraph
+//	  <row><cell width="20%">…</cell><cell align="R">…</cell></row>
+//	  <columns><column width="85">…blocks…</column><column>…</column></columns>
+//	  <box border="#C8102E" width="70" align="R">…blocks…</box>
+//	  <image name="logo" width="30" align="C"/>
+//	  <timbre align="C"/>
+//	  <hr style="dashed"/> <space height="2"/>
+//	</document>
+//
+// size, bold, italic, color and leading are inherited by nested blocks.
+// Widths are millimetres or a percentage of the enclosing block, and a cell
+// or column without a width shares what is left. Any block accepts keep="mm"
+// to start a new page unless that much room is left.
+
+const (
+	_thermalPageWidth     = 80.0
+	_thermalMeasureHeight = 2000.0
+)
+
+type markupNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Children []markupNode `xml:",any"`
+	Text     string       `xml:",chardata"`
+}
+
+func (n markupNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// content returns the text of the node with its whitespace collapsed, so
+// template indentation does not end up in the PDF.
+func (n markupNode) content() string {
+	return strings.Join(strings.Fields(n.Text), " ")
+}
+
+// markupImage is an image a template can place by name.
+type markupImage struct {
+	data      []byte
+	imageType string
+	// width is the printed width in millimetres of images that must not be
+	// scaled up, such as the timbre; width attributes only make them
+	// narrower.
+	width float64
+	// symbol, when set, is drawn as filled rectangles instead of embedding
+	// data, so barcodes stay sharp at any resolution.
+	symbol *utils.PDF417Symbol
+}
+
+type markupStyle struct {
+	size    float64
+	bold    bool
+	italic  bool
+	color   [3]int
+	leading float64
+}
+
+func (s markupStyle) fontStyle() string {
+	style := ""
+	if s.bold {
+		style += "B"
+	}
+	if s.italic {
+		style += "I"
+	}
+	return style
+}
+
+// lineHeight is the explicit leading, or 1.2 times the font size in mm.
+func (s markupStyle) lineHeight() float64 {
+	if s.leading > 0 {
+		return s.leading
+	}
+	return s.size * 0.3528 * 1.2
+}
+
+// with returns the style of n, inheriting what n does not override.
+func (s markupStyle) with(n markupNode) (markupStyle, error) {
+	var err error
+	if v := n.attr("size"); v != "" {
+		if s.size, err = strconv.ParseFloat(v, 64); err != nil || s.size <= 0 {
+			return s, fmt.Errorf("invalid size %q on <%s>", v, n.XMLName.Local)
+		}
+	}
+	if v := n.attr("leading"); v != "" {
+		if s.leading, err = strconv.ParseFloat(v, 64); err != nil {
+			return s, fmt.Errorf("invalid leading %q on <%s>", v, n.XMLName.Local)
+		}
+	}
+	if v := n.attr("bold"); v != "" {
+		s.bold = v == "true"
+	}
+	if v := n.attr("italic"); v != "" {
+		s.italic = v == "true"
+	}
+	if v := n.attr("color"); v != "" {
+		if s.color, err = parseColor(v); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// parseColor reads a "#RRGGBB" colour.
+func parseColor(value string) ([3]int, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return [3]int{}, fmt.Errorf("invalid colour %q, expected #RRGGBB", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return [3]int{}, fmt.Errorf("invalid colour %q, expected #RRGGBB", value)
+	}
+	return [3]int{int(rgb >> 16 & 0xff), int(rgb >> 8 & 0xff), int(rgb & 0xff)}, nil
+}
+
+// parseLength reads a length in mm, or a percentage of total.
+func parseLength(value string, total float64) (float64, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid length %q", value)
+		}
+		return total * p / 100, nil
+	}
+	length, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length %q", value)
+	}
+	return length, nil
+}
+
+// lengthAttr reads the length attribute name of n, or fallback when unset.
+func lengthAttr(n markupNode, name string, total, fallback float64) (float64, error) {
+	value := n.attr(name)
+	if value == "" {
+		return fallback, nil
+	}
+	length, err := parseLength(value, total)
+	if err != nil {
+		return 0, fmt.Errorf("%s on <%s>: %w", name, n.XMLName.Local, err)
+	}
+	return length, nil
+}
+
+// splitWidths distributes width among nodes: fixed widths first, the rest in
+// equal parts among nodes without one.
+func splitWidths(nodes []markupNode, width, gap float64) ([]float64, error) {
+	widths := make([]float64, len(nodes))
+	available := width - gap*float64(max(len(nodes)-1, 0))
+	remaining := available
+	flexible := 0
+	for i, node := range nodes {
+		if node.attr("width") == "" {
+			flexible++
+			continue
+		}
+		w, err := lengthAttr(node, "width", available, 0)
+		if err != nil {
+			return nil, err
+		}
+		widths[i] = w
+		remaining -= w
+	}
+	for i, node := range nodes {
+		if node.attr("width") == "" {
+			widths[i] = max(remaining, 0) / float64(flexible)
+		}
+	}
+	return widths, nil
+}
+
+func alignOffset(align string, outer, inner float64) float64 {
+	switch align {
+	case "C":
+		return (outer - inner) / 2
+	case "R":
+		return outer - inner
+	default:
+		return 0
+	}
+}
+
+func parseMarkup(markup []byte) (markupNode, error) {
+	var root markupNode
+	if err := xml.Unmarshal(markup, &root); err != nil {
+		return markupNode{}, fmt.Errorf("parsing layout markup: %w", err)
+	}
+	if root.XMLName.Local != "document" {
+		return markupNode{}, fmt.Errorf("layout markup must start with <document>, got <%s>", root.XMLName.Local)
+	}
+	return root, nil
+}
+
+// renderMarkup lays out a document and returns the PDF. Thermal documents are
+// laid out twice: once on a very long page to measure them, then on a single
+// page cut to their length.
+func renderMarkup(markup []byte, images map[string]markupImage) ([]byte, error) {
+	root, err := parseMarkup(markup)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.attr("size") != string(PDFLayoutThermal) {
+		pdf, err := renderMarkupPages(root, images, 0)
+		if err != nil {
+			return nil, err
+		}
+		return outputPDF(pdf)
+	}
+
+	measured, err := renderMarkupPages(root, images, _thermalMeasureHeight)
+	if err != nil {
+		return nil, err
+	}
+	_, bottomMargin := measured.GetAutoPageBreak()
+	height := max(measured.GetY()+bottomMargin, _thermalPageWidth)
+
+	pdf, err := renderMarkupPages(root, images, height)
+	if err != nil {
+		return nil, err
+	}
+	return outputPDF(pdf)
+}
+
+func outputPDF(pdf *gofpdf.Fpdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to generate PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderMarkupPages renders root on a new PDF. thermalHeight is the page
+// height of thermal documents.
+func renderMarkupPages(root markupNode, images map[string]markupImage, thermalHeight float64) (*gofpdf.Fpdf, error) {
+	var pdf *gofpdf.Fpdf
+	switch size := root.attr("size"); size {
+	case string(PDFLayoutThermal):
+		pdf = gofpdf.NewCustom(&gofpdf.InitType{
+			UnitStr:        "mm",
+			Size:           gofpdf.SizeType{Wd: _thermalPageWidth, Ht: thermalHeight},
+			OrientationStr: "P",
+		})
+	case string(PDFLayoutLetter), "":
+		pdf = gofpdf.New("P", "mm", "Letter", "")
+	case string(PDFLayoutA4):
+		pdf = gofpdf.New("P", "mm", "A4", "")
+	default:
+		return nil, fmt.Errorf("unknown document size %q", size)
+	}
+
+	margin, err := lengthAttr(root, "margin", 0, 10)
+	if err != nil {
+		return nil, err
+	}
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, margin)
+
+	font := root.attr("font")
+	if font == "" {
+		font = "Arial"
+	}
+	// On <document>, size is the page size and fontsize the base font size.
+	style := markupStyle{size: 9}
+	if v := root.attr("fontsize"); v != "" {
+		if style.size, err = strconv.ParseFloat(v, 64); err != nil || style.size <= 0 {
+			return nil, fmt.Errorf("invalid fontsize %q on <document>", v)
+		}
+	}
+	if v := root.attr("color"); v != "" {
+		if style.color, err = parseColor(v); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &markupRenderer{
+		pdf:     pdf,
+		font:    font,
+		tr:      pdfText,
+		images:  make(map[string]*gofpdf.ImageInfoType),
+		widths:  make(map[string]float64),
+		symbols: make(map[string]*utils.PDF417Symbol),
+	}
+	for name, image := range images {
+		if image.symbol != nil {
+			r.symbols[name] = image.symbol
+			continue
+		}
+		info := pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: image.imageType}, bytes.NewReader(image.data))
+		if pdf.Error() != nil {
+			return nil, fmt.Errorf("failed to register %s image: %w", name, pdf.Error())
+		}
+		r.images[name] = info
+		r.widths[name] = image.width
+	}
+
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	if err := r.blocks(root.Children, margin, pageWidth-2*margin, style); err != nil {
+		return nil, err
+	}
+	if pdf.Error() != nil {
+		return nil, fmt.Errorf("failed to lay out PDF: %w", pdf.Error())
+	}
+	return pdf, nil
+}
+
+type markupRenderer struct {
+	pdf     *gofpdf.Fpdf
+	font    string
+	tr      func(string) string
+	images  map[string]*gofpdf.ImageInfoType
+	widths  map[string]float64
+	symbols map[string]*utils.PDF417Symbol
+}
+
+func (r *markupRenderer) apply(style markupStyle) {
+	r.pdf.SetFont(r.font, style.fontStyle(), style.size)
+	r.pdf.SetTextColor(style.color[0], style.color[1], style.color[2])
+}
+
+// ensureRoom starts a new page unless height fits before the bottom margin.
+func (r *markupRenderer) ensureRoom(height float64) {
+	_, pageHeight := r.pdf.GetPageSize()
+	_, bottom := r.pdf.GetAutoPageBreak()
+	if r.pdf.GetY()+height > pageHeight-bottom {
+		r.pdf.AddPage()
+	}
+}
+
+func (r *markupRenderer) blocks(nodes []markupNode, x, width float64, parent markupStyle) error {
+	for _, node := range nodes {
+		if err := r.block(node, x, width, parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *markupRenderer) block(n markupNode, x, width float64, parent markupStyle) error {
+	style, err := parent.with(n)
+	if err != nil {
+		return err
+	}
+	keep, err := lengthAttr(n, "keep", 0, 0)
+	if err != nil {
+		return err
+	}
+	if keep > 0 {
+		r.ensureRoom(keep)
+	}
+
+	switch n.XMLName.Local {
+	case "text":
+		r.apply(style)
+		r.pdf.SetX(x)
+		r.pdf.MultiCell(width, style.lineHeight(), r.tr(n.content()), "", alignOf(n), false)
+		return nil
+	case "row":
+		return r.row(n, x, width, style)
+	case "columns":
+		return r.columns(n, x, width, style)
+	case "box":
+		return r.box(n, x, width, style)
+	case "image", "timbre":
+		return r.image(n, x, width)
+	case "hr":
+		return r.rule(n, x, width, style)
+	case "space":
+		height, err := lengthAttr(n, "height", 0, 2)
+		if err != nil {
+			return err
+		}
+		r.pdf.SetY(r.pdf.GetY() + height)
+		return nil
+	default:
+		return fmt.Errorf("unknown layout element <%s>", n.XMLName.Local)
+	}
+}
+
+func alignOf(n markupNode) string {
+	switch align := n.attr("align"); align {
+	case "C", "R":
+		return align
+	default:
+		return "L"
+	}
+}
+
+// row lays out cells side by side. Every cell wraps its text and the row is
+// as tall as its tallest cell.
+func (r *markupRenderer) row(n markupNode, x, width float64, style markupStyle) error {
+	widths, err := splitWidths(n.Children, width, 0)
+	if err != nil {
+		return err
+	}
+
+	styles := make([]markupStyle, len(n.Children))
+	height, err := lengthAttr(n, "height", 0, 0)
+	if err != nil {
+		return err
+	}
+	for i, cell := range n.Children {
+		if cell.XMLName.Local != "cell" {
+			return fmt.Errorf("unexpected <%s> in <row>, expected <cell>", cell.XMLName.Local)
+		}
+		if styles[i], err = style.with(cell); err != nil {
+			return err
+		}
+		r.apply(styles[i])
+		lines := max(len(r.pdf.SplitLines([]byte(r.tr(cell.content())), widths[i])), 1)
+		height = max(height, float64(lines)*styles[i].lineHeight())
+	}
+
+	r.ensureRoom(height)
+	y := r.pdf.GetY()
+	cx := x
+	for i, cell := range n.Children {
+		if fill := cellAttr(n, cell, "fill"); fill != "" {
+			rgb, err := parseColor(fill)
+			if err != nil {
+				return err
+			}
+			r.pdf.SetFillColor(rgb[0], rgb[1], rgb[2])
+			r.pdf.Rect(cx, y, widths[i], height, "F")
+		}
+		r.apply(styles[i])
+		r.pdf.SetXY(cx, y)
+		r.pdf.MultiCell(widths[i], styles[i].lineHeight(), r.tr(cell.content()), "", alignOf(cell), false)
+		r.border(cellAttr(n, cell, "border"), cx, y, widths[i], height)
+		cx += widths[i]
+	}
+	r.pdf.SetY(y + height)
+	return nil
+}
+
+// cellAttr reads an attribute of cell, falling back to its row.
+func cellAttr(row, cell markupNode, name string) string {
+	if v := cell.attr(name); v != "" {
+		return v
+	}
+	return row.attr(name)
+}
+
+// border draws the sides of a rectangle named in spec: "1" for all of them,
+// or any of "L", "T", "R" and "B".
+func (r *markupRenderer) border(spec string, x, y, w, h float64) {
+	if spec == "" {
+		return
+	}
+	r.pdf.SetDrawColor(0, 0, 0)
+	if spec == "1" {
+		r.pdf.Rect(x, y, w, h, "D")
+		return
+	}
+	if strings.Contains(spec, "L") {
+		r.pdf.Line(x, y, x, y+h)
+	}
+	if strings.Contains(spec, "T") {
+		r.pdf.Line(x, y, x+w, y)
+	}
+	if strings.Contains(spec, "R") {
+		r.pdf.Line(x+w, y, x+w, y+h)
+	}
+	if strings.Contains(spec, "B") {
+		r.pdf.Line(x, y+h, x+w, y+h)
+	}
+}
+
+// columns lays out each <column> from the same top and continues below the
+// longest one.
+func (r *markupRenderer) columns(n markupNode, x, width float64, style markupStyle) error {
+	gap, err := lengthAttr(n, "gap", width, 4)
+	if err != nil {
+		return err
+	}
+	widths, err := splitWidths(n.Children, width, gap)
+	if err != nil {
+		return err
+	}
+
+	top := r.pdf.GetY()
+	bottom := top
+	cx := x
+	for i, column := range n.Children {
+		if column.XMLName.Local != "column" {
+			return fmt.Errorf("unexpected <%s> in <columns>, expected <column>", column.XMLName.Local)
+		}
+		columnStyle, err := style.with(column)
+		if err != nil {
+			return err
+		}
+		r.pdf.SetY(top)
+		if err := r.blocks(column.Children, cx, widths[i], columnStyle); err != nil {
+			return err
+		}
+		bottom = max(bottom, r.pdf.GetY())
+		cx += widths[i] + gap
+	}
+	r.pdf.SetY(bottom)
+	return nil
+}
+
+// box lays out its blocks inside a padded rectangle.
+func (r *markupRenderer) box(n markupNode, x, width float64, style markupStyle) error {
+	boxWidth, err := lengthAttr(n, "width", width, width)
+	if err != nil {
+		return err
+	}
+	padding, err := lengthAttr(n, "padding", boxWidth, 2)
+	if err != nil {
+		return err
+	}
+	lineWidth, err := lengthAttr(n, "linewidth", 0, 0.2)
+	if err != nil {
+		return err
+	}
+	boxX := x + alignOffset(n.attr("align"), width, boxWidth)
+
+	top := r.pdf.GetY()
+	r.pdf.SetY(top + padding)
+	if err := r.blocks(n.Children, boxX+padding, boxWidth-2*padding, style); err != nil {
+		return err
+	}
+	bottom := r.pdf.GetY() + padding
+
+	if border := n.attr("border"); border != "" && border != "none" {
+		rgb := [3]int{}
+		if border != "1" {
+			if rgb, err = parseColor(border); err != nil {
+				return err
+			}
+		}
+		r.pdf.SetDrawColor(rgb[0], rgb[1], rgb[2])
+		r.pdf.SetLineWidth(lineWidth)
+		r.pdf.Rect(boxX, top, boxWidth, bottom-top, "D")
+		r.pdf.SetLineWidth(0.2)
+		r.pdf.SetDrawColor(0, 0, 0)
+	}
+	r.pdf.SetY(bottom)
+	return nil
+}
+
+// image places a registered image, keeping its aspect ratio. <timbre/> is
+// the PDF417 of the stamp, printed at its SII size unless the block is
+// narrower; images that were not provided are skipped.
+func (r *markupRenderer) image(n markupNode, x, width float64) error {
+	name := n.XMLName.Local
+	if name == "image" {
+		name = n.attr("name")
+	}
+	if symbol, ok := r.symbols[name]; ok {
+		return r.symbol(n, x, width, symbol)
+	}
+	info, ok := r.images[name]
+	if !ok {
+		return nil
+	}
+
+	fallback := min(width, 40)
+	if natural := r.widths[name]; natural > 0 {
+		fallback = min(width, natural)
+	}
+	imageWidth, err := lengthAttr(n, "width", width, fallback)
+	if err != nil {
+		return err
+	}
+	if h := n.attr("height"); h != "" {
+		imageHeight, err := parseLength(h, 0)
+		if err != nil {
+			return fmt.Errorf("height on <%s>: %w", n.XMLName.Local, err)
+		}
+		imageWidth = imageHeight * info.Width() / info.Height()
+	}
+	if natural := r.widths[name]; natural > 0 {
+		imageWidth = min(imageWidth, natural)
+	}
+	imageHeight := imageWidth * info.Height() / info.Width()
+
+	r.ensureRoom(imageHeight)
+	y := r.pdf.GetY()
+	r.pdf.ImageOptions(name, x+alignOffset(n.attr("align"), width, imageWidth), y, imageWidth, imageHeight, false, gofpdf.ImageOptions{}, 0, "")
+	r.pdf.SetY(y + imageHeight)
+	return nil
+}
+
+// symbol draws a PDF417 as one black rectangle per run of bars, at its
+// printed size unless the block or a width attribute is narrower.
+func (r *markupRenderer) symbol(n markupNode, x, width float64, symbol *utils.PDF417Symbol) error {
+	naturalWidth, naturalHeight := symbol.Size()
+	symbolWidth, err := lengthAttr(n, "width", width, min(width, naturalWidth))
+	if err != nil {
+		return err
+	}
+	symbolWidth = min(symbolWidth, naturalWidth)
+	scale := symbolWidth / naturalWidth
+
+	r.ensureRoom(naturalHeight * scale)
+	left := x + alignOffset(n.attr("align"), width, symbolWidth)
+	top := r.pdf.GetY()
+	moduleWidth, rowHeight := symbol.Spec.ModuleWidth*scale, symbol.Spec.RowHeight*scale
+	quiet := float64(symbol.Spec.QuietZone) * moduleWidth
+
+	r.pdf.SetFillColor(0, 0, 0)
+	for _, bar := range symbol.Bars() {
+		r.pdf.Rect(left+float64(bar.X)*moduleWidth, top+quiet+float64(bar.Row)*rowHeight, float64(bar.Width)*moduleWidth, rowHeight, "F")
+	}
+	r.pdf.SetY(top + naturalHeight*scale)
+	return nil
+}
+
+// rule draws a horizontal line with spacing above and below.
+func (r *markupRenderer) rule(n markupNode, x, width float64, style markupStyle) error {
+	spacing, err := lengthAttr(n, "spacing", 0, 1)
+	if err != nil {
+		return err
+	}
+	y := r.pdf.GetY() + spacing
+	r.pdf.SetDrawColor(style.color[0], style.color[1], style.color[2])
+	if n.attr("style") == "dashed" {
+		r.pdf.SetDashPattern([]float64{1, 1}, 0)
+	}
+	r.pdf.Line(x, y, x+width, y)
+	r.pdf.SetDashPattern(nil, 0)
+	r.pdf.SetDrawColor(0, 0, 0)
+	r.pdf.SetY(y + spacing)
+	return nil
+}
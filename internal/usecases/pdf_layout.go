@@ -0,0 +1,116 @@
+package usecases
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PDFLayout selects how the printable representation of a document is
+// rendered.
+type PDFLayout string
+
+const (
+	PDFLayoutThermal PDFLayout = "thermal" // 80mm receipt
+	PDFLayoutLetter  PDFLayout = "letter"  // US letter (carta)
+	PDFLayoutA4      PDFLayout = "a4"
+)
+
+func (l PDFLayout) valid() bool {
+	switch l {
+	case PDFLayoutThermal, PDFLayoutLetter, PDFLayoutA4:
+		return true
+	default:
+		return false
+	}
+}
+
+// PDFLayoutPolicy chooses the layout of each document. The most specific
+// setting wins: company and document type, company default, document type,
+// and finally the global default.
+type PDFLayoutPolicy struct {
+	Default       PDFLayout                    `json:"default"`
+	DocumentTypes map[uint8]PDFLayout          `json:"documentTypes,omitempty"`
+	Companies     map[string]CompanyPDFLayouts `json:"companies,omitempty"`
+}
+
+// CompanyPDFLayouts overrides the layout for the documents of one company,
+// keyed by RUT in PDFLayoutPolicy.Companies.
+type CompanyPDFLayouts struct {
+	Default       PDFLayout           `json:"default,omitempty"`
+	DocumentTypes map[uint8]PDFLayout `json:"documentTypes,omitempty"`
+}
+
+// DefaultPDFLayoutPolicy renders every document as a thermal receipt.
+func DefaultPDFLayoutPolicy() PDFLayoutPolicy {
+	return PDFLayoutPolicy{Default: PDFLayoutThermal}
+}
+
+// LoadPDFLayoutPolicy reads a policy from a JSON file.
+func LoadPDFLayoutPolicy(path string) (PDFLayoutPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PDFLayoutPolicy{}, fmt.Errorf("reading PDF layout policy: %w", err)
+	}
+
+	policy := DefaultPDFLayoutPolicy()
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return PDFLayoutPolicy{}, fmt.Errorf("decoding PDF layout policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return PDFLayoutPolicy{}, err
+	}
+	return policy, nil
+}
+
+// Validate rejects unknown layouts.
+func (p PDFLayoutPolicy) Validate() error {
+	if !p.Default.valid() {
+		return fmt.Errorf("unknown default PDF layout %q", p.Default)
+	}
+	for documentType, layout := range p.DocumentTypes {
+		if !layout.valid() {
+			return fmt.Errorf("unknown PDF layout %q for document type %d", layout, documentType)
+		}
+	}
+	for code, company := range p.Companies {
+		if company.Default != "" && !company.Default.valid() {
+			return fmt.Errorf("unknown default PDF layout %q for company %s", company.Default, code)
+		}
+		for documentType, layout := range company.DocumentTypes {
+			if !layout.valid() {
+				return fmt.Errorf("unknown PDF layout %q for company %s and document type %d", layout, code, documentType)
+			}
+		}
+	}
+	return nil
+}
+
+// Layout returns the layout of a document of documentType issued by the
+// company with RUT companyCode.
+func (p PDFLayoutPolicy) Layout(companyCode string, documentType uint8) PDFLayout {
+	for code, company := range p.Companies {
+		if layoutCompanyKey(code) != layoutCompanyKey(companyCode) {
+			continue
+		}
+		if layout, ok := company.DocumentTypes[documentType]; ok {
+			return layout
+		}
+		if company.Default != "" {
+			return company.Default
+		}
+	}
+
+	if layout, ok := p.DocumentTypes[documentType]; ok {
+		return layout
+	}
+	if p.Default == "" {
+		return PDFLayoutThermal
+	}
+	return p.Default
+}
+
+func layoutCompanyKey(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), ".", ""))
+}
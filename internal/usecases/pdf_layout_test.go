@@ -0,0 +1,58 @@
+package usecases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPDFLayoutPolicy_Layout(t *testing.T) {
+	policy := PDFLayoutPolicy{
+		Default:       PDFLayoutThermal,
+		DocumentTypes: map[uint8]PDFLayout{33: PDFLayoutLetter},
+		Companies: map[string]CompanyPDFLayouts{
+			"76.212.889-6": {Default: PDFLayoutA4, DocumentTypes: map[uint8]PDFLayout{39: PDFLayoutThermal}},
+			"11111111-1":   {DocumentTypes: map[uint8]PDFLayout{61: PDFLayoutA4}},
+		},
+	}
+
+	tests := []struct {
+		company      string
+		documentType uint8
+		want         PDFLayout
+	}{
+		{"76212889-6", 39, PDFLayoutThermal},
+		{"76212889-6", 33, PDFLayoutA4},
+		{"11.111.111-1", 61, PDFLayoutA4},
+		{"11111111-1", 33, PDFLayoutLetter},
+		{"99999999-9", 39, PDFLayoutThermal},
+	}
+	for _, tt := range tests {
+		if got := policy.Layout(tt.company, tt.documentType); got != tt.want {
+			t.Errorf("Layout(%s, %d) = %s, want %s", tt.company, tt.documentType, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPDFLayoutPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layouts.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write policy: %v", err)
+		}
+	}
+
+	write(`{"documentTypes": {"33": "letter"}}`)
+	policy, err := LoadPDFLayoutPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPDFLayoutPolicy failed: %v", err)
+	}
+	if policy.Default != PDFLayoutThermal || policy.Layout("76212889-6", 33) != PDFLayoutLetter {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+
+	write(`{"companies": {"76212889-6": {"default": "legal"}}}`)
+	if _, err := LoadPDFLayoutPolicy(path); err == nil {
+		t.Error("Expected unknown layout to be rejected")
+	}
+}
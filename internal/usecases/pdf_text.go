@@ -0,0 +1,47 @@
+package usecases
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// pdfText encodes s in cp1252, the encoding of the core PDF fonts, which
+// covers Spanish: ñ, vowels with tilde or diaeresis, º, ª, ° and ¿¡.
+//
+// Input is normalised to NFC first, so "n" followed by a combining tilde, as
+// some systems write it, still prints as "ñ". Letters outside cp1252 lose
+// their accents ("ő" prints as "o") and anything else prints as "?".
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFC.String(s) {
+		if c, ok := charmap.Windows1252.EncodeRune(r); ok {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte(transliterate(r))
+	}
+	return b.String()
+}
+
+// transliterate returns the cp1252 base letter of r, or '?'.
+func transliterate(r rune) byte {
+	switch r {
+	case '\u2002', '\u2003', '\u2009', '\u202f': // en, em, thin and narrow no-break spaces
+		return ' '
+	case '\u2010', '\u2011', '\u2012': // hyphens and figure dash
+		return '-'
+	}
+	for _, base := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, base) {
+			continue
+		}
+		if c, ok := charmap.Windows1252.EncodeRune(base); ok {
+			return c
+		}
+		break
+	}
+	return '?'
+}
@@ -0,0 +1,175 @@
+package usecases
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"factura-movil-gateway/internal/domain"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+func TestPDFText(t *testing.T) {
+	tests := map[string]string{
+		"Vicuña Mackenna 4860, Ñuñoa": "Vicuña Mackenna 4860, Ñuñoa",
+		"Nun\u0303oa":                 "Nuñoa",
+		"N° 123, 2º piso, 1ª":         "N° 123, 2º piso, 1ª",
+		"¿Crédito? ¡Sí! Pingüino €":   "¿Crédito? ¡Sí! Pingüino €",
+		"Łódź\u2009Ωmega":             "?ódz ?mega",
+	}
+	for input, want := range tests {
+		got, err := charmap.Windows1252.NewDecoder().String(pdfText(input))
+		if err != nil {
+			t.Fatalf("decoding %q: %v", input, err)
+		}
+		if got != want {
+			t.Errorf("pdfText(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestRenderInvoice_GoldenText(t *testing.T) {
+	companyService := &mockCompanyService{
+		companies: map[string]*domain.Company{
+			"76212889-6": {ID: "company-1", Code: domain.MustParseRUT("76212889-6"), Name: "Panadería La Española Ltda.", Address: "Av. Vicuña Mackenna 4860, Ñuñoa"},
+		},
+	}
+	invoice := &domain.Invoice{
+		DocumentType: 61,
+		Folio:        2404,
+		IssueDate:    time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+		Issuer:       domain.Company{Code: domain.MustParseRUT("76212889-6"), BusinessLine: "Elaboración de pan, pastelería y café"},
+		Receiver:     &domain.Company{Code: domain.MustParseRUT("77371419-3"), Name: "Comercial Peñalolén SpA", BusinessLine: "Compraventa de artículos de oficina", Address: "José Pedro Alessandri 1234, Macul"},
+		Details: []domain.InvoiceDetail{
+			{Description: "Pan amasado (docena)", Quantity: domain.NewDecimal(2), UnitPrice: domain.NewDecimal(3500), LineTotal: 7000, CodeType: "INT1", Code: "PAN-12", Unit: "DOC"},
+			{Description: "Café en grano 1º calidad, origen Perú", Quantity: domain.MustParseDecimal("1.5"), UnitPrice: domain.NewDecimal(12000), LineTotal: 18000},
+		},
+		References: []domain.InvoiceReference{{DocumentType: "33", Folio: "2398", Date: "2024-04-10", Code: 3, Reason: "Corrige montos: descuento ñandú"}},
+		Totals:     domain.InvoiceTotals{TaxableAmount: 25000, TaxAmount: 4750, TotalAmount: 29750},
+	}
+
+	for _, layout := range []PDFLayout{PDFLayoutThermal, PDFLayoutLetter} {
+		t.Run(string(layout), func(t *testing.T) {
+			policy := PDFLayoutPolicy{Default: layout}
+			documentService := NewDocumentService(&mockStampService{}, companyService, nil).WithPDFLayouts(policy)
+
+			stampXML, err := documentService.StampInvoice(context.Background(), invoice, IdempotencyKey{})
+			if err != nil {
+				t.Fatalf("StampInvoice failed: %v", err)
+			}
+			result, err := documentService.RenderInvoice(context.Background(), invoice, stampXML)
+			if err != nil {
+				t.Fatalf("RenderInvoice failed: %v", err)
+			}
+
+			got := strings.Join(extractPDFText(t, result.PDF), "\n") + "\n"
+			golden := filepath.Join("testdata", "golden", string(layout)+".txt")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatalf("Failed to create golden directory: %v", err)
+				}
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf("Failed to update %s: %v", golden, err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read %s (run with -update to create it): %v", golden, err)
+			}
+			if got != string(want) {
+				t.Errorf("Text of the %s PDF differs from %s:\n--- got\n%s--- want\n%s", layout, golden, got, want)
+			}
+		})
+	}
+}
+
+// extractPDFText returns the strings shown with Tj in the page content
+// streams of pdf, decoded from cp1252.
+func extractPDFText(t *testing.T, pdf []byte) []string {
+	t.Helper()
+
+	var lines []string
+	rest := pdf
+	for {
+		start := bytes.Index(rest, []byte("stream\n"))
+		if start < 0 {
+			break
+		}
+		dict := rest[:start]
+		if obj := bytes.LastIndex(dict, []byte(" obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		rest = rest[start+len("stream\n"):]
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			t.Fatal("Unterminated stream in PDF")
+		}
+		data := rest[:end]
+		rest = rest[end+len("endstream"):]
+
+		if bytes.Contains(dict, []byte("/Subtype /Image")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			if data, err = io.ReadAll(reader); err != nil {
+				t.Fatalf("Failed to inflate content stream: %v", err)
+			}
+		}
+		lines = append(lines, showStrings(t, data)...)
+	}
+	return lines
+}
+
+// showStrings returns the literal strings followed by Tj in a content stream.
+func showStrings(t *testing.T, content []byte) []string {
+	var shown []string
+	for i := 0; i < len(content); i++ {
+		if content[i] != '(' {
+			continue
+		}
+		var text []byte
+		j := i + 1
+		for ; j < len(content) && content[j] != ')'; j++ {
+			if content[j] == '\\' && j+1 < len(content) {
+				j++
+				switch content[j] {
+				case 'n':
+					text = append(text, '\n')
+				case 'r':
+					text = append(text, '\r')
+				default:
+					text = append(text, content[j])
+				}
+				continue
+			}
+			text = append(text, content[j])
+		}
+		if j >= len(content) {
+			break
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(content[j+1:], " "), []byte("Tj")) {
+			decoded, err := charmap.Windows1252.NewDecoder().Bytes(text)
+			if err != nil {
+				t.Fatalf("Failed to decode %q: %v", text, err)
+			}
+			shown = append(shown, string(decoded))
+		}
+		i = j
+	}
+	return shown
+}
@@ -0,0 +1,216 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"factura-movil-gateway/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// SII field limits of the item fields a product fills.
+const (
+	_maxItemCodeLength     = 35 // VlrCodigo
+	_maxItemCodeTypeLength = 10 // TpoCodigo
+	_maxItemNameLength     = 80 // NmbItem
+	_maxItemUnitLength     = 4  // UnmdItem
+	_maxAdditionalTaxCodes = 2  // CodImpAdic per line
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductExists   = errors.New("product code is already registered")
+	ErrInvalidProduct  = errors.New("invalid product")
+)
+
+// ProductRepository persists the product catalogue of companies.
+type ProductRepository interface {
+	FindByCompanyID(ctx context.Context, companyID string) ([]domain.Product, error)
+	// FindByCode returns ErrProductNotFound when the company has no product
+	// with that code.
+	FindByCode(ctx context.Context, companyID, code string) (*domain.Product, error)
+	// Create returns ErrProductExists when the code is taken.
+	Create(ctx context.Context, product domain.Product) error
+	Update(ctx context.Context, product domain.Product) error
+	Delete(ctx context.Context, companyID, code string) error
+}
+
+// ProductService manages the product catalogue of each company, so document
+// lines can name their item by code.
+type ProductService interface {
+	List(ctx context.Context, companyID string) ([]domain.Product, error)
+	FindByCode(ctx context.Context, companyID, code string) (domain.Product, error)
+	Create(ctx context.Context, product domain.Product) (domain.Product, error)
+	Update(ctx context.Context, product domain.Product) (domain.Product, error)
+	Delete(ctx context.Context, companyID, code string) error
+	// CompleteDetails fills what the lines with a catalogue code leave out
+	// and reports whether an amount changed, in which case the invoice
+	// totals must be computed again. A line with an unknown code and no
+	// description fails with ErrProductNotFound.
+	CompleteDetails(ctx context.Context, companyID string, invoice *domain.Invoice) (bool, error)
+}
+
+func NewProductService(repository ProductRepository) *SimpleProductService {
+	return &SimpleProductService{
+		repository: repository,
+	}
+}
+
+type SimpleProductService struct {
+	repository ProductRepository
+}
+
+func (s *SimpleProductService) List(ctx context.Context, companyID string) ([]domain.Product, error) {
+	products, err := s.repository.FindByCompanyID(ctx, companyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding products: %w", err)
+	}
+	return products, nil
+}
+
+func (s *SimpleProductService) FindByCode(ctx context.Context, companyID, code string) (domain.Product, error) {
+	product, err := s.repository.FindByCode(ctx, companyID, strings.TrimSpace(code))
+	if err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			return domain.Product{}, fmt.Errorf("%w: %s", err, code)
+		}
+		return domain.Product{}, fmt.Errorf("finding product: %w", err)
+	}
+	return *product, nil
+}
+
+func (s *SimpleProductService) Create(ctx context.Context, product domain.Product) (domain.Product, error) {
+	product, err := validateProduct(product)
+	if err != nil {
+		return domain.Product{}, err
+	}
+
+	now := time.Now()
+	product.ID = uuid.NewString()
+	product.CreatedAt = now
+	product.UpdatedAt = now
+	if err := s.repository.Create(ctx, product); err != nil {
+		if errors.Is(err, ErrProductExists) {
+			return domain.Product{}, fmt.Errorf("%w: %s", err, product.Code)
+		}
+		return domain.Product{}, fmt.Errorf("saving product: %w", err)
+	}
+	return product, nil
+}
+
+// Update replaces every field of the product with that code but its id.
+func (s *SimpleProductService) Update(ctx context.Context, product domain.Product) (domain.Product, error) {
+	product, err := validateProduct(product)
+	if err != nil {
+		return domain.Product{}, err
+	}
+
+	current, err := s.FindByCode(ctx, product.CompanyID, product.Code)
+	if err != nil {
+		return domain.Product{}, err
+	}
+	product.ID = current.ID
+	product.CreatedAt = current.CreatedAt
+	product.UpdatedAt = time.Now()
+	if err := s.repository.Update(ctx, product); err != nil {
+		return domain.Product{}, fmt.Errorf("saving product: %w", err)
+	}
+	return product, nil
+}
+
+func (s *SimpleProductService) Delete(ctx context.Context, companyID, code string) error {
+	if err := s.repository.Delete(ctx, companyID, strings.TrimSpace(code)); err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			return fmt.Errorf("%w: %s", err, code)
+		}
+		return fmt.Errorf("deleting product: %w", err)
+	}
+	return nil
+}
+
+func (s *SimpleProductService) CompleteDetails(ctx context.Context, companyID string, invoice *domain.Invoice) (bool, error) {
+	var changed bool
+	for n := range invoice.Details {
+		detail := &invoice.Details[n]
+		if detail.Code == "" {
+			continue
+		}
+
+		product, err := s.repository.FindByCode(ctx, companyID, detail.Code)
+		if errors.Is(err, ErrProductNotFound) {
+			if strings.TrimSpace(detail.Description) == "" {
+				return false, fmt.Errorf("%w: detail %d: code %s", ErrProductNotFound, n+1, detail.Code)
+			}
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("finding product: %w", err)
+		}
+
+		if strings.TrimSpace(detail.Description) == "" {
+			detail.Description = product.Name
+		}
+		if detail.CodeType == "" {
+			detail.CodeType = product.CodeType
+		}
+		if detail.Unit == "" {
+			detail.Unit = product.Unit
+		}
+		if len(detail.AdditionalTaxCodes) == 0 {
+			detail.AdditionalTaxCodes = product.AdditionalTaxCodes
+		}
+		if detail.UnitPrice == 0 && product.Price != 0 {
+			lineTotal, err := detail.Quantity.Mul(product.Price)
+			if err != nil {
+				return false, fmt.Errorf("%w: detail %d: %w", domain.ErrInvalidInvoice, n+1, err)
+			}
+			detail.UnitPrice = product.Price
+			detail.LineTotal = lineTotal
+			changed = true
+		}
+		if product.Exempt && !detail.Exempt {
+			detail.Exempt = true
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// validateProduct checks the product against the SII item limits and returns
+// it with its code type defaulted.
+func validateProduct(product domain.Product) (domain.Product, error) {
+	product.Code = strings.TrimSpace(product.Code)
+	if product.CodeType == "" {
+		product.CodeType = domain.DefaultItemCodeType
+	}
+
+	if product.CompanyID == "" {
+		return product, fmt.Errorf("%w: company id is required", ErrInvalidProduct)
+	}
+	if product.Code == "" || utf8.RuneCountInString(product.Code) > _maxItemCodeLength {
+		return product, fmt.Errorf("%w: code must have 1 to %d characters", ErrInvalidProduct, _maxItemCodeLength)
+	}
+	if utf8.RuneCountInString(product.CodeType) > _maxItemCodeTypeLength {
+		return product, fmt.Errorf("%w: code type must have at most %d characters", ErrInvalidProduct, _maxItemCodeTypeLength)
+	}
+	if strings.TrimSpace(product.Name) == "" || utf8.RuneCountInString(product.Name) > _maxItemNameLength {
+		return product, fmt.Errorf("%w: name must have 1 to %d characters", ErrInvalidProduct, _maxItemNameLength)
+	}
+	if utf8.RuneCountInString(product.Unit) > _maxItemUnitLength {
+		return product, fmt.Errorf("%w: unit must have at most %d characters", ErrInvalidProduct, _maxItemUnitLength)
+	}
+	if len(product.AdditionalTaxCodes) > _maxAdditionalTaxCodes {
+		return product, fmt.Errorf("%w: at most %d additional tax codes", ErrInvalidProduct, _maxAdditionalTaxCodes)
+	}
+	for _, code := range product.AdditionalTaxCodes {
+		if code == "" || len(code) > 6 || strings.Trim(code, "0123456789") != "" {
+			return product, fmt.Errorf("%w: additional tax code %q is not an SII code", ErrInvalidProduct, code)
+		}
+	}
+	return product, nil
+}
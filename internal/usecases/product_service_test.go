@@ -0,0 +1,152 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+type memoryProductRepository struct {
+	products map[string]domain.Product
+}
+
+func (m *memoryProductRepository) FindByCompanyID(ctx context.Context, companyID string) ([]domain.Product, error) {
+	var products []domain.Product
+	for _, product := range m.products {
+		if product.CompanyID == companyID {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+func (m *memoryProductRepository) FindByCode(ctx context.Context, companyID, code string) (*domain.Product, error) {
+	product, ok := m.products[companyID+"/"+code]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	return &product, nil
+}
+
+func (m *memoryProductRepository) Create(ctx context.Context, product domain.Product) error {
+	key := product.CompanyID + "/" + product.Code
+	if _, ok := m.products[key]; ok {
+		return ErrProductExists
+	}
+	m.products[key] = product
+	return nil
+}
+
+func (m *memoryProductRepository) Update(ctx context.Context, product domain.Product) error {
+	m.products[product.CompanyID+"/"+product.Code] = product
+	return nil
+}
+
+func (m *memoryProductRepository) Delete(ctx context.Context, companyID, code string) error {
+	if _, ok := m.products[companyID+"/"+code]; !ok {
+		return ErrProductNotFound
+	}
+	delete(m.products, companyID+"/"+code)
+	return nil
+}
+
+func newTestProductService(t *testing.T) *SimpleProductService {
+	t.Helper()
+	service := NewProductService(&memoryProductRepository{products: make(map[string]domain.Product)})
+	products := []domain.Product{
+		{CompanyID: "company-1", Code: "PAN-12", Name: "Pan amasado (docena)", Unit: "DOC", Price: domain.NewDecimal(3500)},
+		{CompanyID: "company-1", Code: "LIB-01", Name: "Libro", Price: domain.NewDecimal(12000), Exempt: true},
+	}
+	for _, product := range products {
+		if _, err := service.Create(context.Background(), product); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	return service
+}
+
+func TestProductService_Create(t *testing.T) {
+	service := newTestProductService(t)
+	ctx := context.Background()
+
+	product, err := service.FindByCode(ctx, "company-1", " PAN-12 ")
+	if err != nil {
+		t.Fatalf("FindByCode failed: %v", err)
+	}
+	if product.CodeType != domain.DefaultItemCodeType {
+		t.Errorf("Expected code type %s, got %q", domain.DefaultItemCodeType, product.CodeType)
+	}
+
+	_, err = service.Create(ctx, domain.Product{CompanyID: "company-1", Code: "PAN-12", Name: "Otro"})
+	if !errors.Is(err, ErrProductExists) {
+		t.Errorf("Expected ErrProductExists, got %v", err)
+	}
+
+	invalid := map[string]domain.Product{
+		"no code":         {CompanyID: "company-1", Name: "Sin código"},
+		"no name":         {CompanyID: "company-1", Code: "X"},
+		"long unit":       {CompanyID: "company-1", Code: "X", Name: "X", Unit: "CAJAS"},
+		"bad tax code":    {CompanyID: "company-1", Code: "X", Name: "X", AdditionalTaxCodes: []string{"ILA"}},
+		"too many taxes":  {CompanyID: "company-1", Code: "X", Name: "X", AdditionalTaxCodes: []string{"24", "25", "26"}},
+		"long code type":  {CompanyID: "company-1", Code: "X", Name: "X", CodeType: "INTERNO-LARGO"},
+		"missing company": {Code: "X", Name: "X"},
+	}
+	for name, product := range invalid {
+		if _, err := service.Create(ctx, product); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: expected ErrInvalidProduct, got %v", name, err)
+		}
+	}
+}
+
+func TestProductService_CompleteDetails(t *testing.T) {
+	service := newTestProductService(t)
+	ctx := context.Background()
+
+	invoice := domain.Invoice{
+		DocumentType: 33,
+		Details: []domain.InvoiceDetail{
+			{Code: "PAN-12", Quantity: domain.NewDecimal(2)},
+			{Code: "LIB-01", Description: "Libro de cuentos", Quantity: domain.NewDecimal(1), UnitPrice: domain.NewDecimal(10000), LineTotal: 10000},
+			{Code: "OTRO", Description: "Fuera de catálogo", Quantity: domain.NewDecimal(1), UnitPrice: domain.NewDecimal(500), LineTotal: 500},
+		},
+	}
+	changed, err := service.CompleteDetails(ctx, "company-1", &invoice)
+	if err != nil {
+		t.Fatalf("CompleteDetails failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected the amounts to change")
+	}
+
+	pan := invoice.Details[0]
+	if pan.Description != "Pan amasado (docena)" || pan.Unit != "DOC" || pan.CodeType != "INT1" {
+		t.Errorf("Expected the line to be completed from the catalogue, got %+v", pan)
+	}
+	if pan.UnitPrice != domain.NewDecimal(3500) || pan.LineTotal != 7000 {
+		t.Errorf("Expected catalogue price 3500 x 2, got %v / %v", pan.UnitPrice, pan.LineTotal)
+	}
+
+	book := invoice.Details[1]
+	if book.Description != "Libro de cuentos" || book.UnitPrice != domain.NewDecimal(10000) {
+		t.Errorf("Expected the line's own description and price to win, got %+v", book)
+	}
+	if !book.Exempt {
+		t.Error("Expected the catalogue to mark the line exempt")
+	}
+
+	if invoice.Details[2].Description != "Fuera de catálogo" {
+		t.Errorf("Expected lines outside the catalogue to be left alone, got %+v", invoice.Details[2])
+	}
+
+	unknown := domain.Invoice{Details: []domain.InvoiceDetail{{Code: "NADA", Quantity: domain.NewDecimal(1)}}}
+	if _, err := service.CompleteDetails(ctx, "company-1", &unknown); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("Expected ErrProductNotFound, got %v", err)
+	}
+
+	plain := domain.Invoice{Details: []domain.InvoiceDetail{{Description: "Item", Quantity: domain.NewDecimal(1), UnitPrice: domain.NewDecimal(100), LineTotal: 100}}}
+	if changed, err := service.CompleteDetails(ctx, "company-1", &plain); err != nil || changed {
+		t.Errorf("Expected lines without code to be left alone, got %v, %v", changed, err)
+	}
+}
@@ -23,13 +23,23 @@ type SimpleStampService struct {
 }
 
 func (s *SimpleStampService) Generate(ctx context.Context, company domain.Company, invoice domain.Invoice) (domain.Stamp, error) {
-	folio, caf, err := s.cafService.UseCAFFolio(ctx, company.ID, uint(invoice.DocumentType))
+	// Build the DD first so an invalid RUT does not consume a folio
+	dd, err := domain.NewDDBuilder().
+		WithIssuerRUT(company.Code).
+		WithInvoice(invoice).
+		WithStampedAt(time.Now()).
+		Build()
 	if err != nil {
-		return domain.Stamp{}, fmt.Errorf("getting next folio from CAF: %w", err)
+		return domain.Stamp{}, fmt.Errorf("building stamp data: %w", err)
 	}
 
-	// Create StampCAF from domain CAF
-	stampCAF := domain.StampCAF{
+	folio, caf, err := s.useFolio(ctx, company, invoice)
+	if err != nil {
+		return domain.Stamp{}, err
+	}
+
+	dd.F = folio
+	dd.CAF = domain.StampCAF{
 		Version: "1.0",
 		DA: domain.StampDA{
 			RE: caf.CompanyCode,
@@ -52,42 +62,12 @@ func (s *SimpleStampService) Generate(ctx context.Context, company domain.Compan
 		},
 	}
 
-	// Create DD structure
-	dd := domain.DD{
-		RE: company.Code,
-		TD: invoice.DocumentType,
-		F:  folio,
-		FE: invoice.IssueDate.Format("2006-01-02"),
-		RR: func() string {
-			if invoice.Receiver != nil {
-				return invoice.Receiver.Code
-			}
-			return ""
-		}(),
-		RSR: func() string {
-			if invoice.Receiver != nil {
-				return invoice.Receiver.Name
-			}
-			return ""
-		}(),
-		MNT: invoice.CalculateTotal(),
-		IT1: func() string {
-			if len(invoice.Details) > 0 {
-				return invoice.Details[0].Description
-			}
-			return "Producto"
-		}(),
-		CAF:   stampCAF,
-		TSTED: time.Now().Format("2006-01-02T15:04:05"),
-	}
-
-	// Serialize DD to XML without newlines
-	ddXML, err := utils.SerializeToXMLWithoutNewlines(dd)
+	// Sign the canonical DD in ISO-8859-1, the bytes the SII verifies
+	ddXML, err := utils.TEDLatin1(utils.MarshalDD(dd))
 	if err != nil {
 		return domain.Stamp{}, fmt.Errorf("serializing DD to XML: %w", err)
 	}
 
-	// Sign the DD XML with the CAF private key
 	frmt, err := utils.SignSHA1WithRSA(ddXML, caf.PrivateKey)
 	if err != nil {
 		return domain.Stamp{}, fmt.Errorf("signing DD with private key (key length: %d): %w", len(caf.PrivateKey), err)
@@ -100,3 +80,25 @@ func (s *SimpleStampService) Generate(ctx context.Context, company domain.Compan
 
 	return result, nil
 }
+
+// useFolio consumes the folio assigned to the invoice, if any, or the next
+// one of the company's CAFs.
+func (s *SimpleStampService) useFolio(ctx context.Context, company domain.Company, invoice domain.Invoice) (int64, domain.CAF, error) {
+	if invoice.AssignedFolio > 0 {
+		caf, err := s.cafService.UseAssignedFolio(ctx, company.ID, uint(invoice.DocumentType), invoice.AssignedFolio)
+		if err != nil {
+			return 0, domain.CAF{}, fmt.Errorf("using assigned folio: %w", err)
+		}
+		return invoice.AssignedFolio, caf, nil
+	}
+
+	var branchID string
+	if invoice.Branch != nil {
+		branchID = invoice.Branch.ID
+	}
+	folio, caf, err := s.cafService.UseCAFFolio(ctx, company.ID, branchID, uint(invoice.DocumentType))
+	if err != nil {
+		return 0, domain.CAF{}, fmt.Errorf("getting next folio from CAF: %w", err)
+	}
+	return folio, caf, nil
+}
@@ -80,6 +80,10 @@ func SignSHA1WithRSA(data []byte, privateKeyPEM string) (string, error) {
 }
 
 // SerializeToXMLWithoutNewlines serializes a struct to XML and removes all newlines
+//
+// Deprecated: it strips whitespace inside values and escapes quotes as
+// character references, which is not the SII canonical form. Use MarshalDD
+// and MarshalTED.
 func SerializeToXMLWithoutNewlines(v interface{}) ([]byte, error) {
 	xmlData, err := xml.Marshal(v)
 	if err != nil {
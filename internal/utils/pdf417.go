@@ -71,6 +71,9 @@ type PDF417FRMT struct {
 
 // GenerateStampPDF417 creates a PDF417 barcode from stamp data
 // Returns the barcode as an image and as raw bytes
+//
+// Deprecated: the symbol is scaled to fit a box and uses security level 2.
+// Use EncodePDF417 with SIIPDF417Spec.
 func GenerateStampPDF417(stampData StampPDF417Data, width, height int) (image.Image, []byte, error) {
 	// Serialize the stamp data to XML
 	xmlData, err := xml.Marshal(stampData)
@@ -102,6 +105,9 @@ func GenerateStampPDF417(stampData StampPDF417Data, width, height int) (image.Im
 
 // GenerateStampPDF417FromXML creates a PDF417 barcode directly from XML string
 // This is useful when you already have the TED XML data
+//
+// Deprecated: the symbol is scaled to fit a box and uses security level 2.
+// Use EncodePDF417 with SIIPDF417Spec.
 func GenerateStampPDF417FromXML(xmlData string, width, height int) (image.Image, []byte, error) {
 	// Generate PDF417 barcode directly from XML string with security level 2
 	qrCode, err := pdf417.Encode(xmlData, 2)
@@ -183,6 +189,9 @@ func CalculateSafePDF417Dimensions(dataLength int) (width, height int) {
 
 // GenerateStampPDF417WithAutoDimensions creates a PDF417 barcode with automatically calculated dimensions
 // This is the recommended function to use as it prevents scaling errors
+//
+// Deprecated: the symbol is scaled to fit a box and uses security level 2.
+// Use EncodePDF417 with SIIPDF417Spec.
 func GenerateStampPDF417WithAutoDimensions(stampData StampPDF417Data) (image.Image, []byte, error) {
 	// Serialize the stamp data to XML to calculate size
 	xmlData, err := xml.Marshal(stampData)
@@ -199,6 +208,9 @@ func GenerateStampPDF417WithAutoDimensions(stampData StampPDF417Data) (image.Ima
 
 // GenerateStampPDF417FromXMLWithAutoDimensions creates a PDF417 barcode with automatically calculated dimensions
 // This is the recommended function to use as it prevents scaling errors
+//
+// Deprecated: the symbol is scaled to fit a box and uses security level 2.
+// Use EncodePDF417 with SIIPDF417Spec.
 func GenerateStampPDF417FromXMLWithAutoDimensions(xmlData string) (image.Image, []byte, error) {
 	// Calculate safe dimensions based on XML data length
 	width, height := CalculateSafePDF417Dimensions(len(xmlData))
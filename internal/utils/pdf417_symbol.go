@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+)
+
+const (
+	_pdf417StartPattern = 0x1fea8 // 17 modules
+	_pdf417StopPattern  = 0x3fa29 // 18 modules
+	_pdf417Modulus      = 929
+	_pdf417MaxCodewords = 928
+	_pdf417MinRows      = 3
+	_pdf417MaxRows      = 90
+	_pdf417MaxColumns   = 30
+
+	_pdf417PadCodeword = 900
+	_pdf417ByteLatch   = 901 // byte compaction, length not a multiple of 6
+	_pdf417ByteLatch6  = 924 // byte compaction, length a multiple of 6
+)
+
+// ErrPDF417TooLarge is returned when the data does not fit a symbol with the
+// requested columns.
+var ErrPDF417TooLarge = errors.New("data does not fit in a PDF417 symbol")
+
+// PDF417Spec sets how a PDF417 symbol is encoded and its printed size.
+type PDF417Spec struct {
+	// SecurityLevel is the error correction level, 0 to 8. Level n adds
+	// 2^(n+1) error correction codewords.
+	SecurityLevel int
+	// Columns is the number of data columns, 1 to 30. The number of rows
+	// follows from the length of the data.
+	Columns int
+	// ModuleWidth is the width X of the narrowest bar, in millimetres.
+	ModuleWidth float64
+	// RowHeight is the height of each row, in millimetres.
+	RowHeight float64
+	// QuietZone is the blank margin around the symbol, in modules.
+	QuietZone int
+}
+
+// SIIPDF417Spec returns the parameters the SII sets for the timbre
+// electrónico: error correction level 5, a module at least 0.17 mm
+// (0.0067") wide and rows three modules high. Twelve columns keep the
+// symbol of a full TED under 50 mm wide, and within an 80 mm roll at two
+// printer dots per module.
+func SIIPDF417Spec() PDF417Spec {
+	return PDF417Spec{
+		SecurityLevel: 5,
+		Columns:       12,
+		ModuleWidth:   0.17,
+		RowHeight:     0.51,
+		QuietZone:     2,
+	}
+}
+
+// Validate checks that the spec describes a symbol that can be built.
+func (s PDF417Spec) Validate() error {
+	if s.SecurityLevel < 0 || s.SecurityLevel > 8 {
+		return fmt.Errorf("PDF417 security level must be between 0 and 8, got %d", s.SecurityLevel)
+	}
+	if s.Columns < 1 || s.Columns > _pdf417MaxColumns {
+		return fmt.Errorf("PDF417 columns must be between 1 and %d, got %d", _pdf417MaxColumns, s.Columns)
+	}
+	if s.ModuleWidth <= 0 || s.RowHeight < s.ModuleWidth {
+		return fmt.Errorf("PDF417 module width must be positive and rows at least one module high")
+	}
+	if s.QuietZone < 0 {
+		return fmt.Errorf("PDF417 quiet zone must not be negative")
+	}
+	return nil
+}
+
+// PDF417Symbol is an encoded PDF417 symbol: a grid of rows, each a line of
+// modules, that can be drawn at any scale without resampling.
+type PDF417Symbol struct {
+	Spec    PDF417Spec
+	Rows    int
+	modules [][]bool
+}
+
+// EncodePDF417 encodes data in byte compaction mode with the columns and
+// error correction level of spec.
+func EncodePDF417(data []byte, spec PDF417Spec) (*PDF417Symbol, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	eccCount := 2 << spec.SecurityLevel
+	dataWords := pdf417ByteCompaction(data)
+	// The symbol length descriptor counts itself, the data and the padding.
+	total := 1 + len(dataWords) + eccCount
+	rows := max((total+spec.Columns-1)/spec.Columns, _pdf417MinRows)
+	if rows > _pdf417MaxRows || rows*spec.Columns > _pdf417MaxCodewords {
+		return nil, fmt.Errorf("%w: %d bytes need %d codewords in %d columns", ErrPDF417TooLarge, len(data), total, spec.Columns)
+	}
+
+	codewords := make([]int, 0, rows*spec.Columns)
+	codewords = append(codewords, rows*spec.Columns-eccCount)
+	codewords = append(codewords, dataWords...)
+	for len(codewords) < rows*spec.Columns-eccCount {
+		codewords = append(codewords, _pdf417PadCodeword)
+	}
+	codewords = append(codewords, pdf417ErrorCorrection(codewords, eccCount)...)
+
+	symbol := &PDF417Symbol{Spec: spec, Rows: rows, modules: make([][]bool, rows)}
+	for row := 0; row < rows; row++ {
+		cluster := row % 3
+		left, right := pdf417RowIndicators(row, rows, spec.Columns, spec.SecurityLevel)
+
+		line := make([]bool, 0, symbol.Width())
+		line = appendPDF417Pattern(line, spec.QuietZone, 0)
+		line = appendPDF417Pattern(line, 17, _pdf417StartPattern)
+		line = appendPDF417Pattern(line, 17, _pdf417Patterns[cluster][left])
+		for _, codeword := range codewords[row*spec.Columns : (row+1)*spec.Columns] {
+			line = appendPDF417Pattern(line, 17, _pdf417Patterns[cluster][codeword])
+		}
+		line = appendPDF417Pattern(line, 17, _pdf417Patterns[cluster][right])
+		line = appendPDF417Pattern(line, 18, _pdf417StopPattern)
+		line = appendPDF417Pattern(line, spec.QuietZone, 0)
+		symbol.modules[row] = line
+	}
+	return symbol, nil
+}
+
+// Width is the number of modules in each row, quiet zone included.
+func (s *PDF417Symbol) Width() int {
+	return 17*(s.Spec.Columns+3) + 18 + 2*s.Spec.QuietZone
+}
+
+// Module reports whether the module in column x of row y is a bar.
+func (s *PDF417Symbol) Module(x, row int) bool {
+	return s.modules[row][x]
+}
+
+// Size is the printed width and height of the symbol in millimetres, quiet
+// zone included.
+func (s *PDF417Symbol) Size() (width, height float64) {
+	quiet := float64(2*s.Spec.QuietZone) * s.Spec.ModuleWidth
+	return float64(s.Width()) * s.Spec.ModuleWidth, float64(s.Rows)*s.Spec.RowHeight + quiet
+}
+
+// Image draws the symbol with each module moduleWidth pixels wide. Rows are
+// as many pixels high as the spec's row height to module width ratio asks
+// for, so the image has the printed proportions.
+func (s *PDF417Symbol) Image(moduleWidth int) *image.Gray {
+	rowHeight := s.rowPixels(moduleWidth)
+	quiet := s.Spec.QuietZone * moduleWidth
+	width, height := s.Width()*moduleWidth, s.Rows*rowHeight+2*quiet
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for row, line := range s.modules {
+		for x, bar := range line {
+			if !bar {
+				continue
+			}
+			for dy := 0; dy < rowHeight; dy++ {
+				for dx := 0; dx < moduleWidth; dx++ {
+					img.SetGray(x*moduleWidth+dx, quiet+row*rowHeight+dy, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes Image(moduleWidth) as an 8-bit grayscale PNG.
+func (s *PDF417Symbol) PNG(moduleWidth int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, s.Image(moduleWidth)); err != nil {
+		return nil, fmt.Errorf("failed to encode PDF417 PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// PDF417Bar is a run of adjacent bar modules in one row of a symbol. X and
+// Width are in modules, X counting the quiet zone.
+type PDF417Bar struct {
+	X, Row, Width int
+}
+
+// Bars returns the runs of bars of every row, top to bottom and left to
+// right, for drawing the symbol as vector rectangles.
+func (s *PDF417Symbol) Bars() []PDF417Bar {
+	var bars []PDF417Bar
+	for row, line := range s.modules {
+		for x := 0; x < len(line); x++ {
+			if !line[x] {
+				continue
+			}
+			start := x
+			for x+1 < len(line) && line[x+1] {
+				x++
+			}
+			bars = append(bars, PDF417Bar{X: start, Row: row, Width: x - start + 1})
+		}
+	}
+	return bars
+}
+
+// SVG draws the symbol as a vector image of its printed size, a single path
+// with a rectangle per run of bars.
+func (s *PDF417Symbol) SVG() []byte {
+	width, height := s.Size()
+	quiet := float64(s.Spec.QuietZone) * s.Spec.ModuleWidth
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%smm" height="%smm" viewBox="0 0 %s %s">`,
+		svgLength(width), svgLength(height), svgLength(width), svgLength(height))
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for _, bar := range s.Bars() {
+		x := float64(bar.X) * s.Spec.ModuleWidth
+		y := quiet + float64(bar.Row)*s.Spec.RowHeight
+		w := float64(bar.Width) * s.Spec.ModuleWidth
+		fmt.Fprintf(&buf, "M%s %sh%sv%sh-%sz", svgLength(x), svgLength(y), svgLength(w), svgLength(s.Spec.RowHeight), svgLength(w))
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+// svgLength formats millimetres to the micrometre, which hides the rounding
+// errors of multiplying module sizes.
+func svgLength(mm float64) string {
+	return strconv.FormatFloat(math.Round(mm*1000)/1000, 'f', -1, 64)
+}
+
+func (s *PDF417Symbol) rowPixels(moduleWidth int) int {
+	return max(int(float64(moduleWidth)*s.Spec.RowHeight/s.Spec.ModuleWidth+0.5), 1)
+}
+
+func appendPDF417Pattern(line []bool, modules int, pattern uint32) []bool {
+	for i := modules - 1; i >= 0; i-- {
+		line = append(line, pattern&(1<<i) != 0)
+	}
+	return line
+}
+
+// pdf417ByteCompaction encodes data as a byte compaction latch followed by
+// five codewords for every six bytes and one per remaining byte.
+func pdf417ByteCompaction(data []byte) []int {
+	latch := _pdf417ByteLatch
+	if len(data)%6 == 0 {
+		latch = _pdf417ByteLatch6
+	}
+	words := []int{latch}
+
+	i := 0
+	for ; i+6 <= len(data); i += 6 {
+		var value uint64
+		for _, b := range data[i : i+6] {
+			value = value<<8 | uint64(b)
+		}
+		var group [5]int
+		for j := 4; j >= 0; j-- {
+			group[j] = int(value % 900)
+			value /= 900
+		}
+		words = append(words, group[:]...)
+	}
+	for ; i < len(data); i++ {
+		words = append(words, int(data[i]))
+	}
+	return words
+}
+
+// pdf417RowIndicators returns the left and right row indicator codewords,
+// which tell a reader the rows, columns and security level of the symbol.
+func pdf417RowIndicators(row, rows, columns, level int) (left, right int) {
+	base := 30 * (row / 3)
+	rowsInfo := (rows - 1) / 3
+	levelInfo := level*3 + (rows-1)%3
+	columnsInfo := columns - 1
+	switch row % 3 {
+	case 0:
+		return base + rowsInfo, base + columnsInfo
+	case 1:
+		return base + levelInfo, base + rowsInfo
+	default:
+		return base + columnsInfo, base + levelInfo
+	}
+}
+
+// pdf417ErrorCorrection returns count Reed-Solomon codewords over GF(929)
+// for the generator polynomial (x - 3)(x - 3^2)…(x - 3^count).
+func pdf417ErrorCorrection(codewords []int, count int) []int {
+	generator := pdf417Generator(count)
+	ecc := make([]int, count)
+	for _, codeword := range codewords {
+		feedback := (codeword + ecc[0]) % _pdf417Modulus
+		for j := 0; j < count; j++ {
+			next := 0
+			if j+1 < count {
+				next = ecc[j+1]
+			}
+			ecc[j] = (next + _pdf417Modulus - feedback*generator[j+1]%_pdf417Modulus) % _pdf417Modulus
+		}
+	}
+	for j := range ecc {
+		if ecc[j] != 0 {
+			ecc[j] = _pdf417Modulus - ecc[j]
+		}
+	}
+	return ecc
+}
+
+// pdf417Generator returns the coefficients of the generator polynomial of
+// degree count, highest degree first.
+func pdf417Generator(count int) []int {
+	generator := []int{1}
+	root := 1
+	for i := 0; i < count; i++ {
+		root = root * 3 % _pdf417Modulus
+		next := make([]int, len(generator)+1)
+		for j, coefficient := range generator {
+			next[j] = (next[j] + coefficient) % _pdf417Modulus
+			next[j+1] = (next[j+1] + _pdf417Modulus - coefficient*root%_pdf417Modulus) % _pdf417Modulus
+		}
+		generator = next
+	}
+	return generator
+}
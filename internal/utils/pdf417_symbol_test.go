@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestEncodePDF417_DecodesBack(t *testing.T) {
+	example, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+	start, end := bytes.Index(example, []byte("<TED")), bytes.Index(example, []byte("</TED>"))
+	ted := example[start : end+len("</TED>")]
+
+	payloads := map[string][]byte{
+		"ted":        ted,
+		"multiple-6": []byte("123456"),
+		"short":      []byte("<F>1</F>"),
+		"latin1":     {'<', 'R', 'S', 'R', '>', 0xD1, 'U', 0xD1, 'O', 'A'},
+	}
+	for name, payload := range payloads {
+		t.Run(name, func(t *testing.T) {
+			spec := SIIPDF417Spec()
+			symbol, err := EncodePDF417(payload, spec)
+			if err != nil {
+				t.Fatalf("EncodePDF417 failed: %v", err)
+			}
+
+			decoded, err := decodePDF417Image(symbol.Image(2), 2, spec)
+			if err != nil {
+				t.Fatalf("Failed to decode the symbol: %v", err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Errorf("Decoded payload differs:\n got %q\nwant %q", decoded, payload)
+			}
+		})
+	}
+}
+
+func TestEncodePDF417_SIISize(t *testing.T) {
+	spec := SIIPDF417Spec()
+	symbol, err := EncodePDF417(bytes.Repeat([]byte("x"), 750), spec)
+	if err != nil {
+		t.Fatalf("EncodePDF417 failed: %v", err)
+	}
+
+	// 12 data columns plus start, row indicators and stop, and the quiet zone.
+	if symbol.Width() != 17*15+18+4 {
+		t.Errorf("Expected %d modules per row, got %d", 17*15+18+4, symbol.Width())
+	}
+	width, height := symbol.Size()
+	if width != float64(symbol.Width())*0.17 || height != float64(symbol.Rows)*0.51+4*0.17 {
+		t.Errorf("Unexpected printed size %.2fx%.2f mm for %d rows", width, height, symbol.Rows)
+	}
+
+	img := symbol.Image(1)
+	if img.Bounds().Dx() != symbol.Width() || img.Bounds().Dy() != symbol.Rows*3+4 {
+		t.Errorf("Expected one pixel per module and three per row, got %v", img.Bounds())
+	}
+
+	if _, err := EncodePDF417(bytes.Repeat([]byte("x"), 2000), spec); !errors.Is(err, ErrPDF417TooLarge) {
+		t.Errorf("Expected ErrPDF417TooLarge, got %v", err)
+	}
+	if _, err := EncodePDF417(nil, PDF417Spec{SecurityLevel: 9, Columns: 12, ModuleWidth: 1, RowHeight: 3}); err == nil {
+		t.Error("Expected an error for security level 9")
+	}
+}
+
+func TestPDF417Symbol_SVG(t *testing.T) {
+	spec := SIIPDF417Spec()
+	symbol, err := EncodePDF417([]byte("<TED version=\"1.0\"><DD><F>2404</F></DD></TED>"), spec)
+	if err != nil {
+		t.Fatalf("EncodePDF417 failed: %v", err)
+	}
+	svg := symbol.SVG()
+
+	width, height := symbol.Size()
+	header := fmt.Sprintf(`width="%smm" height="%smm"`, svgLength(width), svgLength(height))
+	if !bytes.Contains(svg, []byte(header)) {
+		t.Errorf("Expected the printed size %s in %.80s", header, svg)
+	}
+
+	// Paint every rectangle of the path back onto a grid of modules.
+	drawn := make([][]bool, symbol.Rows)
+	for row := range drawn {
+		drawn[row] = make([]bool, symbol.Width())
+	}
+	quiet := float64(spec.QuietZone) * spec.ModuleWidth
+	rects := regexp.MustCompile(`M([\d.]+) ([\d.]+)h([\d.]+)v[\d.]+h-[\d.]+z`).FindAllSubmatch(svg, -1)
+	if len(rects) != len(symbol.Bars()) {
+		t.Fatalf("Expected %d rectangles, got %d", len(symbol.Bars()), len(rects))
+	}
+	for _, rect := range rects {
+		x, _ := strconv.ParseFloat(string(rect[1]), 64)
+		y, _ := strconv.ParseFloat(string(rect[2]), 64)
+		w, _ := strconv.ParseFloat(string(rect[3]), 64)
+		row := int((y-quiet)/spec.RowHeight + 0.5)
+		start := int(x/spec.ModuleWidth + 0.5)
+		for module := start; module < start+int(w/spec.ModuleWidth+0.5); module++ {
+			drawn[row][module] = true
+		}
+	}
+	for row := 0; row < symbol.Rows; row++ {
+		for x := 0; x < symbol.Width(); x++ {
+			if drawn[row][x] != symbol.Module(x, row) {
+				t.Fatalf("SVG differs from the symbol at module %d of row %d", x, row)
+			}
+		}
+	}
+}
+
+// decodePDF417Image reads a symbol drawn by PDF417Symbol.Image: it samples
+// the centre of every module, maps each 17-module pattern back to its
+// codeword, checks the row indicators and the error correction, and undoes
+// byte compaction.
+func decodePDF417Image(img *image.Gray, moduleWidth int, spec PDF417Spec) ([]byte, error) {
+	patterns := make([]map[uint32]int, 3)
+	for cluster := range patterns {
+		patterns[cluster] = make(map[uint32]int, _pdf417Modulus)
+		for value, pattern := range _pdf417Patterns[cluster] {
+			patterns[cluster][pattern] = value
+		}
+	}
+
+	rowHeight := int(float64(moduleWidth)*spec.RowHeight/spec.ModuleWidth + 0.5)
+	quiet := spec.QuietZone * moduleWidth
+	rows := (img.Bounds().Dy() - 2*quiet) / rowHeight
+	readPattern := func(y, module, count int) uint32 {
+		var pattern uint32
+		for i := 0; i < count; i++ {
+			pattern <<= 1
+			if img.GrayAt((module+i)*moduleWidth+moduleWidth/2, y).Y < 128 {
+				pattern |= 1
+			}
+		}
+		return pattern
+	}
+
+	var codewords []int
+	for row := 0; row < rows; row++ {
+		y := quiet + row*rowHeight + rowHeight/2
+		module := spec.QuietZone
+		if readPattern(y, module, 17) != _pdf417StartPattern {
+			return nil, fmt.Errorf("row %d: missing start pattern", row)
+		}
+		module += 17
+
+		var words []int
+		for i := 0; i < spec.Columns+2; i++ {
+			value, ok := patterns[row%3][readPattern(y, module, 17)]
+			if !ok {
+				return nil, fmt.Errorf("row %d: invalid codeword pattern at module %d", row, module)
+			}
+			words = append(words, value)
+			module += 17
+		}
+		if readPattern(y, module, 18) != _pdf417StopPattern {
+			return nil, fmt.Errorf("row %d: missing stop pattern", row)
+		}
+
+		left, right := pdf417RowIndicators(row, rows, spec.Columns, spec.SecurityLevel)
+		if words[0] != left || words[len(words)-1] != right {
+			return nil, fmt.Errorf("row %d: row indicators %d/%d, want %d/%d", row, words[0], words[len(words)-1], left, right)
+		}
+		codewords = append(codewords, words[1:len(words)-1]...)
+	}
+
+	// Every root 3^i of the generator polynomial must be a root of the
+	// codeword polynomial.
+	root := 1
+	for i := 1; i <= 2<<spec.SecurityLevel; i++ {
+		root = root * 3 % _pdf417Modulus
+		syndrome := 0
+		for _, codeword := range codewords {
+			syndrome = (syndrome*root + codeword) % _pdf417Modulus
+		}
+		if syndrome != 0 {
+			return nil, fmt.Errorf("error correction syndrome %d is %d", i, syndrome)
+		}
+	}
+
+	length := codewords[0]
+	data := codewords[1:length]
+	for len(data) > 0 && data[len(data)-1] == _pdf417PadCodeword {
+		data = data[:len(data)-1]
+	}
+	if len(data) == 0 || (data[0] != _pdf417ByteLatch && data[0] != _pdf417ByteLatch6) {
+		return nil, fmt.Errorf("expected byte compaction")
+	}
+	data = data[1:]
+
+	var decoded []byte
+	for len(data) >= 5 && (codewords[1] == _pdf417ByteLatch6 || len(data) > 5) {
+		var value uint64
+		for _, codeword := range data[:5] {
+			value = value*900 + uint64(codeword)
+		}
+		for shift := 40; shift >= 0; shift -= 8 {
+			decoded = append(decoded, byte(value>>shift))
+		}
+		data = data[5:]
+	}
+	for _, codeword := range data {
+		decoded = append(decoded, byte(codeword))
+	}
+	return decoded, nil
+}
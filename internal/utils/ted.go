@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"factura-movil-gateway/internal/domain"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The timbre electrónico is signed, encoded in the PDF417 and stored in the
+// SII canonical form: elements in schema order with nothing between them, no
+// XML declaration, and values escaped with the five predefined entities
+// (&amp; &lt; &gt; &quot; &apos;). Whitespace inside values is kept as is.
+// The signature and the barcode carry the ISO-8859-1 encoding of that text,
+// so values are normalised to NFC and characters outside Latin-1 are
+// replaced with '?' before serialising.
+
+var _tedEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+	"'", "&apos;",
+)
+
+// MarshalDD serializes the document data of a stamp in canonical form. Its
+// ISO-8859-1 encoding is what the FRMT signs.
+func MarshalDD(dd domain.DD) []byte {
+	var b tedBuilder
+	b.dd(dd)
+	return b.Bytes()
+}
+
+// MarshalTED serializes a signed stamp in canonical form.
+func MarshalTED(stamp domain.Stamp) []byte {
+	var b tedBuilder
+	b.open("TED", "version", "1.0")
+	b.dd(stamp.DD)
+	b.open("FRMT", "algoritmo", "SHA1withRSA")
+	b.text(stamp.FRMT)
+	b.close("FRMT")
+	b.close("TED")
+	return b.Bytes()
+}
+
+// UnmarshalTED reads a signed stamp serialized by MarshalTED.
+func UnmarshalTED(ted []byte) (domain.Stamp, error) {
+	var parsed struct {
+		DD   domain.DD `xml:"DD"`
+		FRMT string    `xml:"FRMT"`
+	}
+	if err := xml.Unmarshal(ted, &parsed); err != nil {
+		return domain.Stamp{}, fmt.Errorf("decoding TED: %w", err)
+	}
+	return domain.Stamp{DD: parsed.DD, FRMT: parsed.FRMT}, nil
+}
+
+// TEDLatin1 converts a canonical TED or DD to the ISO-8859-1 bytes that are
+// signed and encoded in the PDF417.
+func TEDLatin1(ted []byte) ([]byte, error) {
+	latin1, err := charmap.ISO8859_1.NewEncoder().Bytes(ted)
+	if err != nil {
+		return nil, fmt.Errorf("TED is not representable in ISO-8859-1: %w", err)
+	}
+	return latin1, nil
+}
+
+// EncodeTEDPDF417 encodes a canonical TED as the PDF417 of the timbre, in
+// ISO-8859-1 and to the SII spec.
+func EncodeTEDPDF417(ted []byte) (*PDF417Symbol, error) {
+	latin1, err := TEDLatin1(ted)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePDF417(latin1, SIIPDF417Spec())
+}
+
+type tedBuilder struct {
+	bytes.Buffer
+}
+
+func (b *tedBuilder) dd(dd domain.DD) {
+	b.open("DD")
+	b.element("RE", dd.RE.String())
+	b.element("TD", strconv.Itoa(int(dd.TD)))
+	b.element("F", strconv.FormatInt(dd.F, 10))
+	b.element("FE", dd.FE)
+	b.element("RR", dd.RR.String())
+	b.element("RSR", dd.RSR)
+	b.element("MNT", strconv.FormatUint(dd.MNT, 10))
+	b.element("IT1", dd.IT1)
+
+	caf := dd.CAF
+	b.open("CAF", "version", caf.Version)
+	b.open("DA")
+	b.element("RE", caf.DA.RE)
+	b.element("RS", caf.DA.RS)
+	b.element("TD", strconv.Itoa(int(caf.DA.TD)))
+	b.open("RNG")
+	b.element("D", strconv.FormatInt(caf.DA.RNG.D, 10))
+	b.element("H", strconv.FormatInt(caf.DA.RNG.H, 10))
+	b.close("RNG")
+	b.element("FA", caf.DA.FA)
+	b.open("RSAPK")
+	b.element("M", caf.DA.RSAPK.M)
+	b.element("E", caf.DA.RSAPK.E)
+	b.close("RSAPK")
+	b.element("IDK", caf.DA.IDK)
+	b.close("DA")
+	b.open("FRMA", "algoritmo", caf.FRMA.Algorithm)
+	b.text(caf.FRMA.Value)
+	b.close("FRMA")
+	b.close("CAF")
+
+	b.element("TSTED", dd.TSTED)
+	b.close("DD")
+}
+
+// open writes a start tag with attributes given as name, value pairs.
+func (b *tedBuilder) open(name string, attrs ...string) {
+	b.WriteByte('<')
+	b.WriteString(name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(attrs[i])
+		b.WriteString(`="`)
+		b.text(attrs[i+1])
+		b.WriteByte('"')
+	}
+	b.WriteByte('>')
+}
+
+func (b *tedBuilder) close(name string) {
+	b.WriteString("</")
+	b.WriteString(name)
+	b.WriteByte('>')
+}
+
+func (b *tedBuilder) element(name, value string) {
+	b.open(name)
+	b.text(value)
+	b.close(name)
+}
+
+func (b *tedBuilder) text(value string) {
+	b.WriteString(_tedEscaper.Replace(tedText(value)))
+}
+
+// tedText normalises a value to NFC and replaces what ISO-8859-1 or XML
+// cannot carry: characters outside Latin-1 become '?' and control
+// characters other than tab and line breaks become spaces.
+func tedText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r > 0xFF:
+			return '?'
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r', r >= 0x7F && r < 0xA0:
+			return ' '
+		default:
+			return r
+		}
+	}, norm.NFC.String(s))
+}
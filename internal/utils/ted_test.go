@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"factura-movil-gateway/internal/domain"
+)
+
+// exampleTED returns the timbre of examples/invoice_2404.xml, a document
+// accepted by the SII, as it appears in the file.
+func exampleTED(t *testing.T) []byte {
+	example, err := os.ReadFile(filepath.Join("..", "..", "examples", "invoice_2404.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read example invoice: %v", err)
+	}
+	start, end := bytes.Index(example, []byte("<TED")), bytes.Index(example, []byte("</TED>"))
+	return example[start : end+len("</TED>")]
+}
+
+func TestMarshalTED_MatchesSIITimbre(t *testing.T) {
+	ted := exampleTED(t)
+	stamp, err := UnmarshalTED(ted)
+	if err != nil {
+		t.Fatalf("Failed to parse example TED: %v", err)
+	}
+	if stamp.DD.F != 2404 || stamp.DD.RE.String() != "76212889-6" {
+		t.Errorf("Unexpected document data %+v", stamp.DD)
+	}
+
+	if got := MarshalTED(stamp); !bytes.Equal(got, ted) {
+		t.Errorf("Canonical TED differs from the SII timbre:\n got %s\nwant %s", got, ted)
+	}
+
+	// The FRMT must verify against the canonical DD with the CAF's public
+	// key, which is what the SII checks.
+	dd, err := TEDLatin1(MarshalDD(stamp.DD))
+	if err != nil {
+		t.Fatalf("TEDLatin1 failed: %v", err)
+	}
+	modulus, _ := base64.StdEncoding.DecodeString(stamp.DD.CAF.DA.RSAPK.M)
+	exponent, _ := base64.StdEncoding.DecodeString(stamp.DD.CAF.DA.RSAPK.E)
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+	signature, _ := base64.StdEncoding.DecodeString(stamp.FRMT)
+	hash := sha1.Sum(dd)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, hash[:], signature); err != nil {
+		t.Errorf("FRMT does not verify against the canonical DD: %v", err)
+	}
+}
+
+func TestMarshalDD_Escaping(t *testing.T) {
+	dd := domain.DD{
+		RE:  domain.MustParseRUT("76212889-6"),
+		TD:  33,
+		F:   10,
+		RSR: `Soc. "Pérez" & O'Higgins <Ltda>`,
+		IT1: "Café\ten grano €\x01",
+	}
+	got := string(MarshalDD(dd))
+	for _, want := range []string{
+		"<RSR>Soc. &quot;Pérez&quot; &amp; O&apos;Higgins &lt;Ltda&gt;</RSR>",
+		"<IT1>Café\ten grano ? </IT1>",
+		"<DD><RE>76212889-6</RE><TD>33</TD><F>10</F><FE></FE>",
+	} {
+		if !bytes.Contains([]byte(got), []byte(want)) {
+			t.Errorf("Expected %q in %s", want, got)
+		}
+	}
+
+	// NFD input signs the same as NFC, and é is a single Latin-1 byte.
+	dd.RSR = "Pe\u0301rez"
+	latin1, err := TEDLatin1(MarshalDD(dd))
+	if err != nil {
+		t.Fatalf("TEDLatin1 failed: %v", err)
+	}
+	if !bytes.Contains(latin1, []byte{'<', 'R', 'S', 'R', '>', 'P', 0xE9, 'r', 'e', 'z'}) {
+		t.Errorf("Expected Pérez in ISO-8859-1, got %q", latin1)
+	}
+}